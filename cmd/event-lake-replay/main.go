@@ -0,0 +1,105 @@
+// event-lake-replay re-publishes events recorded in one fundraiser's event lake into the topics of another, so
+// its Firestore documents can be rebuilt by the (possibly fixed) controllers:
+//
+//	go run ./cmd/event-lake-replay -from fishfry-030824 -to fishfry-030824-rebuild -since 2024-03-08T16:00:00-05:00
+//
+// The target fundraiser must already be deployed (see terraform/fundraisers), and the caller needs read access
+// to Firestore and publish access to Pub/Sub in the project
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/eventlake"
+)
+
+func main() {
+	project := flag.String("project", os.Getenv("GCP_PROJECT"), "GCP project holding both fundraisers")
+	from := flag.String("from", "", "fundraiser ID whose event lake is read")
+	to := flag.String("to", "", "fundraiser ID whose topics the events are published to")
+	types := flag.String("types", "", "comma-separated event types to replay (default: Square webhooks and API responses)")
+	subject := flag.String("subject", "", "only replay events with this subject")
+	since := flag.String("since", "", "only replay events created at or after this RFC 3339 time")
+	until := flag.String("until", "", "only replay events created before this RFC 3339 time")
+	interval := flag.Duration("interval", 0, "pause between published events")
+	dryRun := flag.Bool("dry-run", false, "list the events which would be replayed without publishing them")
+	flag.Parse()
+
+	if *project == "" || *from == "" || (*to == "" && !*dryRun) {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *from == *to {
+		log.Fatal("replaying into the same fundraiser would apply its events twice; create a fresh fundraiser to replay into")
+	}
+
+	q := eventlake.Query{}
+	if *subject != "" {
+		q.Subjects = []string{*subject}
+	}
+	if *types != "" {
+		q.Types = strings.Split(*types, ",")
+	}
+	var err error
+	if q.Since, err = parseTime(*since); err != nil {
+		log.Fatalf("-since: %v", err)
+	}
+	if q.Until, err = parseTime(*until); err != nil {
+		log.Fatalf("-until: %v", err)
+	}
+
+	ctx := context.Background()
+	firestoreClient, err := firestore.NewClient(ctx, *project)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer firestoreClient.Close()
+	lake := eventlake.New(firestoreClient, *from)
+
+	sink := func(ctx context.Context, e *event.Event) error {
+		fmt.Printf("%s %s %s %s\n", e.ID(), e.Time().Format(time.RFC3339), e.Type(), e.Subject())
+		return nil
+	}
+	if !*dryRun {
+		psClient, err := pubsub.NewClient(ctx, *project)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer psClient.Close()
+
+		publisher := eventlake.NewPublisher(psClient, *to)
+		defer publisher.Stop()
+
+		list := sink
+		sink = func(ctx context.Context, e *event.Event) error {
+			if err := publisher.Publish(ctx, e); err != nil {
+				return err
+			}
+			time.Sleep(*interval)
+			return list(ctx, e)
+		}
+	}
+
+	replayed, err := lake.Replay(ctx, q, sink)
+	log.Printf("replayed %d events from %s", replayed, *from)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,108 @@
+// fundraiser-archive exports a fundraiser's Firestore documents to an archive, verifies an archive, or imports
+// one back into Firestore:
+//
+//	go run ./cmd/fundraiser-archive export -fundraiser fishfry-030824 -bucket gs://fishfry-030824-archive
+//	go run ./cmd/fundraiser-archive verify -bucket gs://fishfry-030824-archive -prefix fishfry-030824/20240309T120000Z/
+//	go run ./cmd/fundraiser-archive import -bucket gs://fishfry-030824-archive -prefix fishfry-030824/20240309T120000Z/ \
+//		-to fishfry-030824-restored -expiration 2025-01-01T00:00:00Z
+//
+// -bucket is either a gs:// URL or a local directory; archive-controller exports automatically ahead of the
+// fundraiser's EXPIRATION_TIME
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/archive"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	project := flags.String("project", os.Getenv("GCP_PROJECT"), "GCP project holding the fundraiser (export and import)")
+	bucketLocation := flags.String("bucket", "", "gs://<bucket> or local directory holding archives")
+	fundraiserID := flags.String("fundraiser", "", "fundraiser ID to export (export)")
+	prefix := flags.String("prefix", "", "prefix of the archive within the bucket (verify and import)")
+	to := flags.String("to", "", "fundraiser ID to import into (import)")
+	expiration := flags.String("expiration", "", "RFC 3339 time replacing the expiration of imported documents; if empty the archived expirations are kept (import)")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		log.Fatal(err)
+	}
+	if *bucketLocation == "" {
+		usage()
+	}
+
+	ctx := context.Background()
+	bucket, err := archive.OpenBucket(ctx, *bucketLocation)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var manifest *archive.Manifest
+	switch os.Args[1] {
+	case "export":
+		if *fundraiserID == "" {
+			usage()
+		}
+		archivePrefix := archive.Prefix(*fundraiserID, time.Now())
+		manifest, err = archive.Export(ctx, firestoreClient(ctx, *project), bucket, *fundraiserID, archivePrefix)
+		if err == nil {
+			log.Printf("exported %s to %s", *fundraiserID, archivePrefix)
+		}
+	case "verify":
+		if *prefix == "" {
+			usage()
+		}
+		manifest, err = archive.Verify(ctx, bucket, *prefix)
+	case "import":
+		if *prefix == "" || *to == "" {
+			usage()
+		}
+		var expirationTime time.Time
+		if *expiration != "" {
+			if expirationTime, err = time.Parse(time.RFC3339, *expiration); err != nil {
+				log.Fatalf("-expiration: %v", err)
+			}
+		}
+		manifest, err = archive.Import(ctx, firestoreClient(ctx, *project), bucket, *prefix, *to, expirationTime)
+		if err == nil {
+			log.Printf("imported %s into %s", *prefix, *to)
+		}
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, bundle := range manifest.Bundles {
+		fmt.Printf("%-10s %6d documents %10d bytes sha256:%s\n", bundle.Collection, bundle.Documents, bundle.Size, bundle.SHA256)
+	}
+}
+
+func firestoreClient(ctx context.Context, project string) *firestore.Client {
+	if project == "" {
+		usage()
+	}
+	client, err := firestore.NewClient(ctx, project)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return client
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: fundraiser-archive export|verify|import -bucket <gs://bucket or dir> [flags]")
+	fmt.Fprintln(os.Stderr, "run 'fundraiser-archive <command> -h' for the flags of each command")
+	os.Exit(2)
+}
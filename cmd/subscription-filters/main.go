@@ -0,0 +1,205 @@
+// subscription-filters generates the filters of the Pub/Sub subscriptions of a function package from the
+// filter.CloudEvent declarations of its handlers, writing them to subscription_filters.tf as a local map from
+// entry point to filter for the package's terraform to apply; it is run by go generate in the package:
+//
+//	//go:generate go run github.com/kofc7186/fundraiser-manager/cmd/subscription-filters
+//
+// Declarations must be made where the function is registered, with the expression given as a string literal or
+// a constant of the package:
+//
+//	functions.CloudEvent("CustomerWatcher", deadletter.CloudEvent("CustomerWatcher", filter.CloudEvent(customerWatcherFilter, ...)))
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/event/filter"
+)
+
+const (
+	filterImportPath    = "github.com/kofc7186/fundraiser-manager/pkg/event/filter"
+	functionsImportPath = "github.com/GoogleCloudPlatform/functions-framework-go/functions"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "directory of the function package")
+	out := flag.String("out", "subscription_filters.tf", "file to write, relative to -dir")
+	flag.Parse()
+
+	declarations, err := declarations(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(declarations) == 0 {
+		log.Fatalf("no filter.CloudEvent declarations found in %s", *dir)
+	}
+
+	filters := make(map[string]string, len(declarations))
+	for entryPoint, expression := range declarations {
+		x, err := filter.Parse(expression)
+		if err != nil {
+			log.Fatalf("%s: %v", entryPoint, err)
+		}
+		if filters[entryPoint], err = x.PubSubFilter(); err != nil {
+			log.Fatalf("%s: %v", entryPoint, err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(*dir, *out), render(filters), 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// declarations returns the filter expression declared for each entry point registered by the package in dir
+func declarations(dir string) (map[string]string, error) {
+	fset := token.NewFileSet()
+	packages, err := parser.ParseDir(fset, dir, func(info fs.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]string)
+	for _, pkg := range packages {
+		constants := stringConstants(pkg)
+		for _, file := range pkg.Files {
+			functionsName := importName(file, functionsImportPath)
+			filterName := importName(file, filterImportPath)
+			if functionsName == "" || filterName == "" {
+				continue
+			}
+
+			var inspectErr error
+			ast.Inspect(file, func(n ast.Node) bool {
+				registration, ok := n.(*ast.CallExpr)
+				if !ok || !isCall(registration, functionsName, "CloudEvent") || len(registration.Args) != 2 {
+					return true
+				}
+				entryPoint, ok := stringLiteral(registration.Args[0])
+				if !ok {
+					return true
+				}
+				ast.Inspect(registration.Args[1], func(n ast.Node) bool {
+					declaration, ok := n.(*ast.CallExpr)
+					if !ok || !isCall(declaration, filterName, "CloudEvent") || len(declaration.Args) != 2 {
+						return true
+					}
+					expression, ok := stringLiteral(declaration.Args[0])
+					if ident, isIdent := declaration.Args[0].(*ast.Ident); isIdent {
+						expression, ok = constants[ident.Name]
+					}
+					if !ok {
+						inspectErr = fmt.Errorf("%s: the filter of %s is neither a string literal nor a constant", fset.Position(declaration.Pos()), entryPoint)
+						return false
+					}
+					found[entryPoint] = expression
+					return false
+				})
+				return false
+			})
+			if inspectErr != nil {
+				return nil, inspectErr
+			}
+		}
+	}
+	return found, nil
+}
+
+// stringConstants returns the string constants declared at the top level of pkg
+func stringConstants(pkg *ast.Package) map[string]string {
+	constants := make(map[string]string)
+	for _, file := range pkg.Files {
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				valueSpec := spec.(*ast.ValueSpec)
+				for i, name := range valueSpec.Names {
+					if i >= len(valueSpec.Values) {
+						break
+					}
+					if value, ok := stringLiteral(valueSpec.Values[i]); ok {
+						constants[name.Name] = value
+					}
+				}
+			}
+		}
+	}
+	return constants
+}
+
+// importName returns the name by which file refers to the package at path, or "" if it does not import it
+func importName(file *ast.File, path string) string {
+	for _, spec := range file.Imports {
+		if importPath, _ := strconv.Unquote(spec.Path.Value); importPath == path {
+			if spec.Name != nil {
+				return spec.Name.Name
+			}
+			return path[strings.LastIndex(path, "/")+1:]
+		}
+	}
+	return ""
+}
+
+func isCall(call *ast.CallExpr, pkg, name string) bool {
+	selector, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || selector.Sel.Name != name {
+		return false
+	}
+	ident, ok := selector.X.(*ast.Ident)
+	return ok && ident.Name == pkg
+}
+
+func stringLiteral(expr ast.Expr) (string, bool) {
+	literal, ok := expr.(*ast.BasicLit)
+	if !ok || literal.Kind != token.STRING {
+		return "", false
+	}
+	value, err := strconv.Unquote(literal.Value)
+	return value, err == nil
+}
+
+// render writes the filters as a terraform local, aligned as terraform fmt would
+func render(filters map[string]string) []byte {
+	entryPoints := make([]string, 0, len(filters))
+	width := 0
+	for entryPoint := range filters {
+		entryPoints = append(entryPoints, entryPoint)
+		width = max(width, len(entryPoint))
+	}
+	sort.Strings(entryPoints)
+
+	var b bytes.Buffer
+	b.WriteString("# Code generated by subscription-filters from the filter.CloudEvent declarations of the package; DO NOT EDIT.\n\n")
+	b.WriteString("locals {\n")
+	b.WriteString("  # the filter of the Pub/Sub subscription of each function, by entry point\n")
+	b.WriteString("  subscription_filters = {\n")
+	for _, entryPoint := range entryPoints {
+		fmt.Fprintf(&b, "    %-*s = %s\n", width, entryPoint, hclString(filters[entryPoint]))
+	}
+	b.WriteString("  }\n")
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// hclString quotes s as a terraform string, escaping the sequences which would otherwise begin a template
+func hclString(s string) string {
+	quoted := strconv.Quote(s)
+	quoted = strings.ReplaceAll(quoted, "${", "$${")
+	return strings.ReplaceAll(quoted, "%{", "%%{")
+}
@@ -0,0 +1,160 @@
+package archivecontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/archive"
+	"github.com/kofc7186/fundraiser-manager/pkg/config"
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
+	"github.com/kofc7186/fundraiser-manager/pkg/httpjson"
+	"github.com/kofc7186/fundraiser-manager/pkg/logging"
+	"github.com/kofc7186/fundraiser-manager/pkg/metrics"
+	"github.com/kofc7186/fundraiser-manager/pkg/tracing"
+	fundraiserType "github.com/kofc7186/fundraiser-manager/pkg/types/fundraiser"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/funcframework"
+)
+
+const FUNCTION_NAME = "archive-controller"
+
+// DefaultLeadTime applies when ARCHIVE_LEAD_TIME is not set
+const DefaultLeadTime = 72 * time.Hour
+
+var firestoreClient *firestore.Client
+var archiveBucket archive.Bucket
+var fundraiserStore *fundraiser.Store
+var router *fundraiser.Router
+
+var leadTime = DefaultLeadTime
+
+func init() {
+	slog.SetDefault(logging.FunctionLogger(FUNCTION_NAME))
+
+	if err := tracing.Init(FUNCTION_NAME); err != nil {
+		panic(err)
+	}
+	if err := metrics.Init(FUNCTION_NAME); err != nil {
+		panic(err)
+	}
+
+	cfg := struct {
+		config.Fundraiser
+		ArchiveBucket string `env:"ARCHIVE_BUCKET" validate:"required"`
+		// how long before EXPIRATION_TIME archiving begins
+		LeadTime time.Duration `env:"ARCHIVE_LEAD_TIME" validate:"positive"`
+	}{LeadTime: DefaultLeadTime}
+	config.MustLoad(&cfg)
+
+	var err error
+	firestoreClient, err = firestore.NewClient(context.Background(), cfg.GCPProject)
+	if err != nil {
+		panic(err)
+	}
+
+	fundraiserStore = fundraiser.NewStore(firestoreClient)
+
+	router, err = fundraiser.Load()
+	if err != nil {
+		panic(err)
+	}
+	if err := router.Watch(context.Background(), firestoreClient); err != nil {
+		panic(err)
+	}
+
+	archiveBucket, err = archive.OpenBucket(context.Background(), cfg.ArchiveBucket)
+	if err != nil {
+		panic(err)
+	}
+
+	leadTime = cfg.LeadTime
+
+	// do this last so we are ensured to have all the required clients established above
+	functions.HTTP("ArchiveFundraiser", tracing.HTTP("ArchiveFundraiser", metrics.HTTP("ArchiveFundraiser", ArchiveFundraiser)))
+}
+
+// ArchiveFundraiser exports the documents of each fundraiser of the deployment to ARCHIVE_BUCKET once their
+// expiration is within ARCHIVE_LEAD_TIME; Cloud Scheduler calls it daily, so the final days each produce an archive,
+// the last of which is the most complete. The retention policy of a fundraiser may skip archiving, and a closed
+// fundraiser is marked as archived. POST ?force=true archives regardless of the expiration time or policy
+func ArchiveFundraiser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		httpjson.Error(ctx, w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+		return
+	}
+	force := r.URL.Query().Get("force") == "true"
+
+	archived := map[string]interface{}{}
+	var errs []error
+	for _, fundraiserID := range router.IDs() {
+		ctx := fundraiser.NewContext(ctx, fundraiserID)
+		result, err := archiveFundraiser(ctx, fundraiserID, force)
+		if err != nil {
+			// the other fundraisers are still archived; this one is retried on the next call
+			slog.ErrorContext(ctx, err.Error())
+			errs = append(errs, fmt.Errorf("fundraiser %s: %w", fundraiserID, err))
+		} else if result != nil {
+			archived[fundraiserID] = result
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		httpjson.Error(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(archived) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	httpjson.Write(ctx, w, http.StatusOK, map[string]interface{}{"archived": archived})
+}
+
+// archiveFundraiser archives the documents of the fundraiser if they are due to be, returning the prefix and manifest
+// of the archive, or nil if it isn't due
+func archiveFundraiser(ctx context.Context, fundraiserID string, force bool) (map[string]interface{}, error) {
+	// fundraisers which were not set up through the admin API have no retention policy
+	f, err := fundraiserStore.Get(ctx, fundraiserID)
+	if err != nil && !errors.Is(err, fundraiser.ErrNotFound) {
+		return nil, err
+	}
+	if f != nil && f.Retention.SkipArchive && !force {
+		slog.InfoContext(ctx, "not archiving, as the retention policy of the fundraiser skips archiving")
+		return nil, nil
+	}
+
+	// the router already applies the expiration of the fundraiser's retention policy
+	expiration := router.Expiration(fundraiserID)
+
+	untilExpiration := time.Until(expiration)
+	if untilExpiration > leadTime && !force {
+		slog.InfoContext(ctx, fmt.Sprintf("not archiving until %s before expiration at %s", leadTime, expiration))
+		return nil, nil
+	}
+	if untilExpiration <= 0 {
+		slog.WarnContext(ctx, fmt.Sprintf("archiving after expiration at %s; documents may already have been deleted", expiration))
+	}
+
+	prefix := archive.Prefix(fundraiserID, time.Now())
+	manifest, err := archive.Export(ctx, firestoreClient, archiveBucket, fundraiserID, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("archiving to %s: %w", prefix, err)
+	}
+	slog.InfoContext(ctx, fmt.Sprintf("archived %s to %s", fundraiserID, prefix), "manifest", manifest)
+
+	if f != nil && f.Status == fundraiserType.STATUS_CLOSED {
+		if _, err := fundraiserStore.Transition(ctx, fundraiserID, fundraiserType.STATUS_ARCHIVED); err != nil {
+			// the archive was written, so the fundraiser's status is merely stale
+			slog.ErrorContext(ctx, fmt.Sprintf("archived to %s but unable to mark as archived: %v", prefix, err))
+		}
+	}
+	return map[string]interface{}{"prefix": prefix, "manifest": manifest}, nil
+}
@@ -0,0 +1,135 @@
+locals {
+  function_group = "archive-controller"
+  function_exclude_list = setunion(
+    ["go.sum"],
+    fileset("${path.module}", "cmd/**"),         # main harness
+    fileset("${path.module}", "**_test.go"),     # go test files
+    fileset("${path.module}", "**.tf*"),         # terraform files
+    fileset("${path.module}", "**terraform*"),   # terraform files
+    fileset("${path.module}", "**terraform/**"), # terraform files
+    fileset("${path.module}", "*source-*.zip")   # other source zips
+  )
+}
+
+resource "google_project_service" "service" {
+  for_each = toset([
+    "run.googleapis.com",
+    "cloudtrace.googleapis.com",
+    "monitoring.googleapis.com",
+    "storage.googleapis.com",
+    "cloudscheduler.googleapis.com",
+  ])
+
+  project = var.gcp_project_id
+  service = each.key
+
+  disable_on_destroy = false
+}
+
+# this runs 'go mod vendor' from ${path.module} for inclusion in the source zip file
+data "external" "go_mod_vendor" {
+  program = ["bash", "../../../go-mod-vendor.sh", "${path.module}"]
+}
+
+# random_string.r is to ensure that the zip file gets recreated upon
+# running "terraform apply"
+resource "random_string" "r" {
+  length  = 16
+  special = false
+}
+
+data "archive_file" "function_source_zip" {
+  type = "zip"
+
+  # *source.zip is in .gitignore so should never be committed
+  output_path = "${path.module}/${local.function_group}-source.zip"
+  source_dir  = path.module
+
+  # this is computed once for all functions
+  excludes = local.function_exclude_list
+
+  # this ensures that 'go mod vendor' is run before creating the zip file
+  # the dependency on random_string.r is to ensure that the zip file gets
+  # recreated upon running "terraform apply"
+  depends_on = [data.external.go_mod_vendor, random_string.r]
+}
+
+resource "google_storage_bucket_object" "function_source_object" {
+  name   = "${local.function_group}/${var.fundraiser_id}-${data.archive_file.function_source_zip.output_md5}-source.zip"
+  bucket = var.gcs_function_source_bucket
+  source = data.archive_file.function_source_zip.output_path
+}
+
+
+resource "google_cloudfunctions2_function" "archive_fundraiser" {
+  name     = "${local.function_group}-${var.fundraiser_id}"
+  location = var.gcp_region
+
+  build_config {
+    runtime     = "go121"
+    entry_point = "ArchiveFundraiser"
+    source {
+      storage_source {
+        bucket = var.gcs_function_source_bucket
+        object = google_storage_bucket_object.function_source_object.name
+      }
+    }
+  }
+
+  service_config {
+    available_memory = "256Mi"
+    timeout_seconds  = 540
+
+    environment_variables = {
+      GCP_PROJECT       = var.gcp_project_id
+      FUNDRAISER_ID     = var.fundraiser_id
+      EXPIRATION_TIME   = var.expiration_time
+      FUNDRAISERS       = var.fundraisers
+      ARCHIVE_BUCKET    = "gs://${var.archive_bucket}"
+      ARCHIVE_LEAD_TIME = var.archive_lead_time
+    }
+  }
+}
+
+# the function, and Cloud Scheduler on its behalf, run as the default compute service account
+data "google_compute_default_service_account" "default" {
+  project = var.gcp_project_id
+}
+
+resource "google_storage_bucket_iam_member" "archive_writer" {
+  bucket = var.archive_bucket
+  role   = "roles/storage.objectAdmin"
+  member = "serviceAccount:${data.google_compute_default_service_account.default.email}"
+}
+
+# this endpoint is not granted to allUsers; only the scheduler job below (and operators given roles/run.invoker)
+# may call it
+resource "google_cloud_run_service_iam_member" "scheduler_invoker" {
+  location = google_cloudfunctions2_function.archive_fundraiser.location
+  service  = lower(google_cloudfunctions2_function.archive_fundraiser.name)
+  role     = "roles/run.invoker"
+  member   = "serviceAccount:${data.google_compute_default_service_account.default.email}"
+}
+
+# the function only archives within ARCHIVE_LEAD_TIME of the expiration time, so it is safe to call daily
+# throughout the fundraiser
+resource "google_cloud_scheduler_job" "archive_fundraiser" {
+  name        = "${var.fundraiser_id}-archive_fundraiser"
+  description = "Archiving within ${var.archive_lead_time} of ${var.expiration_time}"
+
+  schedule = var.archive_schedule
+
+  retry_config {
+    retry_count = 3
+  }
+
+  http_target {
+    http_method = "POST"
+    uri         = google_cloudfunctions2_function.archive_fundraiser.service_config[0].uri
+
+    oidc_token {
+      service_account_email = data.google_compute_default_service_account.default.email
+      audience              = google_cloudfunctions2_function.archive_fundraiser.service_config[0].uri
+    }
+  }
+}
@@ -0,0 +1,54 @@
+variable "gcp_project_id" {
+  type = string
+  validation {
+    condition     = length(var.gcp_project_id) > 0
+    error_message = "Must specify gcp_project_id variable."
+  }
+}
+
+variable "gcp_region" {
+  description = "The GCP region in which to create the various cloud resources"
+  type        = string
+}
+
+variable "gcs_function_source_bucket" {
+  description = "The name of the GCS bucket where this function's source code should be uploaded"
+  type        = string
+  validation {
+    condition     = length(var.gcs_function_source_bucket) > 0
+    error_message = "Must specify gcs_function_source_bucket variable."
+  }
+}
+
+variable "fundraiser_id" {
+  description = "The unique ID for the specific fundraiser; example is 'fishfry-022324'"
+  type        = string
+}
+
+variable "expiration_time" {
+  description = "The expiration value for all Firestore documents created for the fundraiser, as expressed in a UTC timestamp string in RFC 3339 format; example is '2024-02-25T00:00:00Z'"
+  type        = string
+}
+
+variable "fundraisers" {
+  description = "How Square objects are routed to the fundraisers served by this deployment, as YAML (see pkg/fundraiser); if empty, every object belongs to fundraiser_id"
+  type        = string
+  default     = ""
+}
+
+variable "archive_bucket" {
+  description = "The name of the GCS bucket which archives of the fundraiser are written to"
+  type        = string
+}
+
+variable "archive_lead_time" {
+  description = "How long before expiration_time archiving begins, as a Go duration string; example is '72h'"
+  type        = string
+  default     = "72h"
+}
+
+variable "archive_schedule" {
+  description = "How often the archive job runs, as expressed in unix cron format; it only archives within archive_lead_time of expiration_time"
+  type        = string
+  default     = "0 6 * * *"
+}
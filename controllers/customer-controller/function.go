@@ -4,280 +4,156 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"time"
 
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/pubsub"
 	"google.golang.org/api/iterator"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
-	"google.golang.org/protobuf/proto"
 
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 	"github.com/cloudevents/sdk-go/v2/event"
 
-	"github.com/googleapis/google-cloudevents-go/cloud/firestoredata"
-
+	"github.com/kofc7186/fundraiser-manager/pkg/claimcheck"
+	"github.com/kofc7186/fundraiser-manager/pkg/config"
+	"github.com/kofc7186/fundraiser-manager/pkg/controller"
+	"github.com/kofc7186/fundraiser-manager/pkg/deadletter"
+	"github.com/kofc7186/fundraiser-manager/pkg/event/filter"
 	eventschemas "github.com/kofc7186/fundraiser-manager/pkg/event/schemas"
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
 	"github.com/kofc7186/fundraiser-manager/pkg/logging"
+	"github.com/kofc7186/fundraiser-manager/pkg/metrics"
+	"github.com/kofc7186/fundraiser-manager/pkg/tracing"
 	customerType "github.com/kofc7186/fundraiser-manager/pkg/types/customer"
-	"github.com/kofc7186/fundraiser-manager/pkg/util"
 
 	_ "github.com/GoogleCloudPlatform/functions-framework-go/funcframework"
 )
 
+const FUNCTION_NAME = "customer-controller"
+
+// the events each watcher handles, from which go generate writes the filters of their subscriptions
 const (
-	FUNCTION_NAME       = "customer-controller"
-	PUBLISH_TIMEOUT_SEC = 2 * time.Second
+	orderWatcherFilter   = "type IN ('org.kofc7186.fundraiserManager.order.created', 'org.kofc7186.fundraiserManager.order.updated')"
+	paymentWatcherFilter = "type IN ('org.kofc7186.fundraiserManager.payment.created', 'org.kofc7186.fundraiserManager.payment.updated')"
 )
 
+//go:generate go run github.com/kofc7186/fundraiser-manager/cmd/subscription-filters
+
 var firestoreClient *firestore.Client
-var customerDocPath string
+var router *fundraiser.Router
 
-var customerEventsTopic *pubsub.Topic
 var squareCustomerRequestTopic *pubsub.Topic
 
-var expirationTime time.Time
+var customerWriter *controller.SquareWriter[customerType.Customer, *customerType.Customer]
+var customerPublisher *controller.CDCPublisher[customerType.Customer]
 
 func init() {
 	slog.SetDefault(logging.FunctionLogger(FUNCTION_NAME))
 
-	psClient, err := pubsub.NewClient(context.Background(), util.GetEnvOrPanic("GCP_PROJECT"))
+	if err := tracing.Init(FUNCTION_NAME); err != nil {
+		panic(err)
+	}
+	if err := metrics.Init(FUNCTION_NAME); err != nil {
+		panic(err)
+	}
+	if err := deadletter.Init(); err != nil {
+		panic(err)
+	}
+	if err := eventschemas.Init(); err != nil {
+		panic(err)
+	}
+
+	var cfg struct {
+		config.Fundraiser
+		CustomerEventsTopic        string `env:"CUSTOMER_EVENTS_TOPIC" validate:"required,topic"`
+		SquareCustomerRequestTopic string `env:"SQUARE_CUSTOMER_REQUEST_TOPIC" validate:"required,topic"`
+	}
+	config.MustLoad(&cfg)
+
+	psClient, err := pubsub.NewClient(context.Background(), cfg.GCPProject)
 	if err != nil {
 		panic(err)
 	}
 
-	CUSTOMER_EVENTS_TOPIC := util.GetEnvOrPanic("CUSTOMER_EVENTS_TOPIC")
-	customerEventsTopic = psClient.Topic(CUSTOMER_EVENTS_TOPIC)
+	customerEventsTopic := psClient.Topic(cfg.CustomerEventsTopic)
 	if ok, err := customerEventsTopic.Exists(context.Background()); !ok || err != nil {
-		panic(fmt.Sprintf("existence check for %s failed: %v", CUSTOMER_EVENTS_TOPIC, err))
+		panic(fmt.Sprintf("existence check for %s failed: %v", cfg.CustomerEventsTopic, err))
 	}
 
-	SQUARE_CUSTOMER_REQUEST_TOPIC := util.GetEnvOrPanic("SQUARE_CUSTOMER_REQUEST_TOPIC")
-	squareCustomerRequestTopic = psClient.Topic(SQUARE_CUSTOMER_REQUEST_TOPIC)
+	squareCustomerRequestTopic = psClient.Topic(cfg.SquareCustomerRequestTopic)
 	if ok, err := squareCustomerRequestTopic.Exists(context.Background()); !ok || err != nil {
-		panic(fmt.Sprintf("existence check for %s failed: %v", SQUARE_CUSTOMER_REQUEST_TOPIC, err))
+		panic(fmt.Sprintf("existence check for %s failed: %v", cfg.SquareCustomerRequestTopic, err))
 	}
 
-	firestoreClient, err = firestore.NewClient(context.Background(), util.GetEnvOrPanic("GCP_PROJECT"))
+	firestoreClient, err = firestore.NewClient(context.Background(), cfg.GCPProject)
 	if err != nil {
 		panic(err)
 	}
 
-	customerDocPath = fmt.Sprintf("fundraisers/%s/customers", util.GetEnvOrPanic("FUNDRAISER_ID"))
-
-	expirationTime, err = time.Parse(time.RFC3339, util.GetEnvOrPanic("EXPIRATION_TIME"))
+	router, err = fundraiser.Load()
 	if err != nil {
 		panic(err)
 	}
+	if err := router.Watch(context.Background(), firestoreClient); err != nil {
+		panic(err)
+	}
+
+	customerWriter = controller.NewSquareWriter[customerType.Customer]("customer", firestoreClient, "customers", router, customerDecoders)
+	customerPublisher = &controller.CDCPublisher[customerType.Customer]{
+		EntityName: "customer",
+		Topic:      customerEventsTopic,
+		NewCreated: eventschemas.NewCustomerCreated,
+		NewUpdated: eventschemas.NewCustomerUpdated,
+		NewDeleted: eventschemas.NewCustomerDeleted,
+	}
 
 	// do this last so we are ensured to have all the required clients established above
-	functions.CloudEvent("ProcessSquareCustomerWebhookEvent", ProcessSquareCustomerWebhookEvent)
-	functions.CloudEvent("ProcessSquareCustomerResponse", ProcessSquareCustomerWebhookEvent) // Square API responses just get written like inbound webhooks
-	functions.CloudEvent("ProcessCDCEvent", ProcessCDCEvent)
-	functions.CloudEvent("OrderWatcher", OrderAndPaymentWatcher)
-	functions.CloudEvent("PaymentWatcher", OrderAndPaymentWatcher)
+	functions.CloudEvent("ProcessSquareCustomerWebhookEvent", deadletter.CloudEvent("ProcessSquareCustomerWebhookEvent", tracing.CloudEvent("ProcessSquareCustomerWebhookEvent", metrics.CloudEvent("ProcessSquareCustomerWebhookEvent", claimcheck.CloudEvent(ProcessSquareCustomerWebhookEvent)))))
+	functions.CloudEvent("ProcessSquareCustomerResponse", deadletter.CloudEvent("ProcessSquareCustomerResponse", tracing.CloudEvent("ProcessSquareCustomerResponse", metrics.CloudEvent("ProcessSquareCustomerResponse", claimcheck.CloudEvent(ProcessSquareCustomerWebhookEvent))))) // Square API responses just get written like inbound webhooks
+	functions.CloudEvent("ProcessCDCEvent", deadletter.CloudEvent("ProcessCDCEvent", tracing.CloudEvent("ProcessCDCEvent", metrics.CloudEvent("ProcessCDCEvent", ProcessCDCEvent))))
+	functions.CloudEvent("OrderWatcher", deadletter.CloudEvent("OrderWatcher", filter.CloudEvent(orderWatcherFilter, tracing.CloudEvent("OrderWatcher", metrics.CloudEvent("OrderWatcher", OrderAndPaymentWatcher)))))
+	functions.CloudEvent("PaymentWatcher", deadletter.CloudEvent("PaymentWatcher", filter.CloudEvent(paymentWatcherFilter, tracing.CloudEvent("PaymentWatcher", metrics.CloudEvent("PaymentWatcher", OrderAndPaymentWatcher)))))
 }
 
 // ProcessSquareCustomerWebhookEvent
 func ProcessSquareCustomerWebhookEvent(ctx context.Context, e event.Event) error {
-	// there are two CloudEvents - one for the pubsub message "event", and then the data within
-	var msg eventschemas.MessagePublishedData
-	if err := e.DataAs(&msg); err != nil {
-		slog.ErrorContext(ctx, err.Error(), "event", e)
-		return err
-	}
-
-	nestedEvent := &event.Event{}
-	if err := nestedEvent.UnmarshalJSON(msg.Message.Data); err != nil {
-		return err
-	}
-
-	return writeSquareCustomerToFirestore(ctx, nestedEvent)
+	return customerWriter.ProcessEvent(ctx, e)
 }
 
-func writeSquareCustomerToFirestore(ctx context.Context, e *event.Event) error {
-	customerCreateRequest := false
-	attemptedWrite := false
-
-	var idempotencyKey string
-	var proposedCustomer *customerType.Customer
-	switch e.Type() {
-	case eventschemas.CustomerCreatedFromSquareType:
-		customerCreateRequest = true
-
+var customerDecoders = map[string]controller.Decoder[customerType.Customer]{
+	eventschemas.CustomerCreatedFromSquareType: func(ctx context.Context, e *event.Event) (*controller.Proposal[customerType.Customer], error) {
 		cr := &eventschemas.CustomerCreatedFromSquare{}
-		if err := e.DataAs(cr); err != nil {
-			return err
+		if err := eventschemas.DataAs(e, cr); err != nil {
+			return nil, failure.Poison(err)
 		}
-		idempotencyKey = cr.BaseCustomer.IdempotencyKey
-		proposedCustomer = cr.BaseCustomer.Customer
-	case eventschemas.CustomerUpdatedFromSquareType:
+		return &controller.Proposal[customerType.Customer]{Entity: cr.BaseCustomer.Customer, IdempotencyKey: cr.BaseCustomer.IdempotencyKey, CreateOnly: true}, nil
+	},
+	eventschemas.CustomerUpdatedFromSquareType: func(ctx context.Context, e *event.Event) (*controller.Proposal[customerType.Customer], error) {
 		cu := &eventschemas.CustomerUpdatedFromSquare{}
-		if err := e.DataAs(cu); err != nil {
-			return err
+		if err := eventschemas.DataAs(e, cu); err != nil {
+			return nil, failure.Poison(err)
 		}
-		idempotencyKey = cu.BaseCustomer.IdempotencyKey
-		proposedCustomer = cu.BaseCustomer.Customer
-	case eventschemas.SquareRetrieveCustomerResponseType:
+		return &controller.Proposal[customerType.Customer]{Entity: cu.BaseCustomer.Customer, IdempotencyKey: cu.BaseCustomer.IdempotencyKey}, nil
+	},
+	eventschemas.SquareRetrieveCustomerResponseType: func(ctx context.Context, e *event.Event) (*controller.Proposal[customerType.Customer], error) {
 		sgcc := &eventschemas.SquareRetrieveCustomerResponse{}
-		if err := e.DataAs(sgcc); err != nil {
-			return err
-		}
-		idempotencyKey = sgcc.BaseCustomer.IdempotencyKey
-		proposedCustomer = sgcc.BaseCustomer.Customer
-	default:
-		return nil // TODO: is this really what we want to do?
-	}
-
-	// make sure to update the map to denote that we've processed this event already
-	//
-	// the boolean here is only to allow Firestore to map back to Go struct; the important
-	// thing is that the key is put into the map
-	proposedCustomer.IdempotencyKeys = make(map[string]bool, 1)
-	if idempotencyKey != "" {
-		proposedCustomer.IdempotencyKeys[idempotencyKey] = true
-	}
-
-	// ensure the firestore expiration timestamp is written in the appropriate field
-	proposedCustomer.Expiration = expirationTime
-
-	docRef := firestoreClient.Doc(fmt.Sprintf("%s/%s", customerDocPath, proposedCustomer.ID))
-	transaction := func(ctx context.Context, t *firestore.Transaction) error {
-		docSnap, err := t.Get(docRef)
-		if err != nil {
-			if status.Code(err) == codes.NotFound {
-				// document doesn't yet exist, so just write it
-				attemptedWrite = true
-				return t.Set(docRef, proposedCustomer)
-			}
-			// document exists but there was some error, bail
-			return err
-		}
-
-		if customerCreateRequest {
-			// if we get a create request and the doc already exists via an out of order event, just squelch it
-			// TODO: test this case
-			slog.DebugContext(ctx, "skipping creation since doc already exists", "idempotencyKey", idempotencyKey, "event", e)
-			return nil
-		}
-
-		// since the document already exists and we have an update event, let's make sure
-		// we really should update it
-		persistedCustomer := &customerType.Customer{}
-		if err := docSnap.DataTo(persistedCustomer); err != nil {
-			return err
+		if err := eventschemas.DataAs(e, sgcc); err != nil {
+			return nil, failure.Poison(err)
 		}
-		// search the map to see if we've observed the idempotency key before
-		if _, ok := persistedCustomer.IdempotencyKeys[idempotencyKey]; ok {
-			// we've already processed this update from square, so ignore it
-			slog.DebugContext(ctx, "skipped duplicate event seen", "idempotencyKey", idempotencyKey, "event", e)
-			return nil
-		}
-
-		// check to see if this square event is out of order
-		if persistedCustomer.SquareUpdatedTime.After(proposedCustomer.SquareUpdatedTime) || persistedCustomer.Version >= proposedCustomer.Version {
-			// we've already processed a newer update from square, so ignore it
-			slog.DebugContext(ctx, "skipped out of order event seen from Square", "idempotencyKey", idempotencyKey, "event", e)
-			return nil
-		}
-
-		// copy over idempotency keys from what we've seen before
-		for key, val := range persistedCustomer.IdempotencyKeys {
-			proposedCustomer.IdempotencyKeys[key] = val
-		}
-
-		// if we get here, we have a newer proposal for customer so let's write it
-		attemptedWrite = true
-		return t.Set(docRef, proposedCustomer)
-	}
-
-	if err := firestoreClient.RunTransaction(ctx, transaction); err != nil {
-		return err
-	}
-
-	// if we got here and attemptedWrite is true, then we wrote the document successfully
-	if attemptedWrite {
-		slog.InfoContext(ctx, fmt.Sprintf("customer %v written at %v", docRef.ID, docRef.Path))
-	}
-	return nil
+		return &controller.Proposal[customerType.Customer]{Entity: sgcc.BaseCustomer.Customer, IdempotencyKey: sgcc.BaseCustomer.IdempotencyKey}, nil
+	},
 }
 
 // ProcessCDCEvent generates internal domain events from changes to firestore customers collection
 func ProcessCDCEvent(ctx context.Context, e event.Event) error {
-	var data firestoredata.DocumentEventData
-	if err := proto.Unmarshal(e.Data(), &data); err != nil {
-		return fmt.Errorf("proto.Unmarshal: %w", err)
-	}
-
-	var internalEvent *event.Event
-	if data.GetValue() == nil {
-		// the customer document was deleted
-		customer := &customerType.Customer{}
-		err := util.ParseFirebaseDocument(data.OldValue, customer)
-		if err != nil {
-			return err
-		}
-		internalEvent, err = eventschemas.NewCustomerDeleted(customer)
-		if err != nil {
-			return err
-		}
-	} else if data.GetOldValue() == nil {
-		// the customer document was created
-		customer := &customerType.Customer{}
-		err := util.ParseFirebaseDocument(data.Value, customer)
-		if err != nil {
-			return err
-		}
-		internalEvent, err = eventschemas.NewCustomerCreated(customer)
-		if err != nil {
-			return err
-		}
-	} else {
-		// the customer document was updated
-		customer := &customerType.Customer{}
-		err := util.ParseFirebaseDocument(data.Value, customer)
-		if err != nil {
-			return err
-		}
-		oldCustomer := &customerType.Customer{}
-		if err = util.ParseFirebaseDocument(data.OldValue, oldCustomer); err != nil {
-			return err
-		}
-		internalEvent, err = eventschemas.NewCustomerUpdated(oldCustomer, customer, data.UpdateMask.FieldPaths)
-		if err != nil {
-			return err
-		}
-	}
-
-	eventJSON, err := internalEvent.MarshalJSON()
-	if err != nil {
-		return err
-	}
-	timeoutContext, cancel := context.WithTimeout(context.Background(), PUBLISH_TIMEOUT_SEC)
-	defer cancel()
-
-	publishResult := customerEventsTopic.Publish(timeoutContext, &pubsub.Message{Data: eventJSON})
-	messageID, err := publishResult.Get(timeoutContext) // this call blocks until complete or timeout occurs
-	if err != nil {
-		return err
-	}
-	slog.InfoContext(ctx, fmt.Sprintf("published %s", internalEvent.Type()), "messageID", messageID, "customerID", internalEvent.Subject())
-	return nil
+	return customerPublisher.ProcessCDCEvent(ctx, e)
 }
 
 // OrderAndPaymentWatcher listens to internal order/payment events; it should scan and see if they have a customer ID that we don't know about
 // if we don't have it, we should fetch and create the event upon getting the value from Square
 func OrderAndPaymentWatcher(ctx context.Context, e event.Event) error {
-	// there are two CloudEvents - one for the pubsub message "event", and then the data within
-	var msg eventschemas.MessagePublishedData
-	if err := e.DataAs(&msg); err != nil {
-		slog.Error(err.Error(), "event", e)
-		return err
-	}
-
-	nestedEvent := &event.Event{}
-	if err := nestedEvent.UnmarshalJSON(msg.Message.Data); err != nil {
+	nestedEvent, err := eventschemas.NestedEvent(e)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error(), "event", e)
 		return err
 	}
 
@@ -285,26 +161,26 @@ func OrderAndPaymentWatcher(ctx context.Context, e event.Event) error {
 	switch nestedEvent.Type() {
 	case eventschemas.PaymentCreatedType:
 		pc := &eventschemas.PaymentCreated{}
-		if err := nestedEvent.DataAs(pc); err != nil {
-			return err
+		if err := eventschemas.DataAs(nestedEvent, pc); err != nil {
+			return failure.Poison(err)
 		}
 		squareCustomerID = pc.Payment.SquareCustomerID
 	case eventschemas.PaymentUpdatedType:
 		pu := &eventschemas.PaymentUpdated{}
-		if err := nestedEvent.DataAs(pu); err != nil {
-			return err
+		if err := eventschemas.DataAs(nestedEvent, pu); err != nil {
+			return failure.Poison(err)
 		}
 		squareCustomerID = pu.Payment.SquareCustomerID
 	case eventschemas.OrderCreatedType:
 		oc := &eventschemas.OrderCreated{}
-		if err := nestedEvent.DataAs(oc); err != nil {
-			return err
+		if err := eventschemas.DataAs(nestedEvent, oc); err != nil {
+			return failure.Poison(err)
 		}
 		squareCustomerID = oc.Order.SquareCustomerID
 	case eventschemas.OrderUpdatedType:
 		ou := &eventschemas.OrderUpdated{}
-		if err := nestedEvent.DataAs(ou); err != nil {
-			return err
+		if err := eventschemas.DataAs(nestedEvent, ou); err != nil {
+			return failure.Poison(err)
 		}
 		squareCustomerID = ou.Order.SquareCustomerID
 	default:
@@ -317,7 +193,11 @@ func OrderAndPaymentWatcher(ctx context.Context, e event.Event) error {
 		return nil
 	}
 
-	customerRecordRef := firestoreClient.Collection(customerDocPath).Where("id", "==", squareCustomerID)
+	fundraiserID := router.Of(nestedEvent)
+	ctx = fundraiser.NewContext(ctx, fundraiserID)
+	ctx = logging.WithLabel(ctx, logging.LabelCustomerID, squareCustomerID)
+
+	customerRecordRef := firestoreClient.Collection(fundraiser.Path(fundraiserID, "customers")).Where("id", "==", squareCustomerID)
 	return firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
 		customerIterator := tx.Documents(customerRecordRef)
 		defer customerIterator.Stop()
@@ -325,19 +205,12 @@ func OrderAndPaymentWatcher(ctx context.Context, e event.Event) error {
 			// if we're here, we don't have an entry in the customer table for the order we just observed
 			// send a message to egress-square-gateway to fetch the customer object for us, and the order will update later
 			getCustomerEvent := eventschemas.NewSquareRetrieveCustomerRequest(squareCustomerID)
-			eventJSON, err := getCustomerEvent.MarshalJSON()
-			if err != nil {
-				return err
-			}
-			timeoutContext, cancel := context.WithTimeout(context.Background(), PUBLISH_TIMEOUT_SEC)
-			defer cancel()
-
-			publishResult := squareCustomerRequestTopic.Publish(timeoutContext, &pubsub.Message{Data: eventJSON})
-			messageID, err := publishResult.Get(timeoutContext) // this call blocks until complete or timeout occurs
+			fundraiser.Set(getCustomerEvent, fundraiserID)
+			messageID, err := controller.Publish(ctx, squareCustomerRequestTopic, getCustomerEvent)
 			if err != nil {
 				return err
 			}
-			slog.InfoContext(ctx, "published RetrieveCustomerRequest", "messageID", messageID, "customerID", squareCustomerID)
+			slog.InfoContext(ctx, "published RetrieveCustomerRequest", "messageID", messageID)
 		}
 		// if we're here, the customer object already exists in our collection, so there is nothing for us to do
 		return nil
@@ -9,11 +9,15 @@ locals {
     fileset("${path.module}", "**terraform/**"), # terraform files
     fileset("${path.module}", "*source-*.zip")   # other source zips
   )
+  # when routing to several fundraisers, changes to the documents of each of them must be published
+  fundraiser_path_segment = var.fundraisers == "" ? var.fundraiser_id : "{fundraiser}"
 }
 
 resource "google_project_service" "service" {
   for_each = toset([
     "run.googleapis.com",
+    "cloudtrace.googleapis.com",
+    "monitoring.googleapis.com",
     "eventarc.googleapis.com",
     "storage.googleapis.com",
   ])
@@ -82,6 +86,7 @@ resource "google_cloudfunctions2_function" "customer-controller-square-webhook"
       GCP_PROJECT                   = var.gcp_project_id
       EXPIRATION_TIME               = var.expiration_time
       FUNDRAISER_ID                 = var.fundraiser_id
+      FUNDRAISERS                   = var.fundraisers
       CUSTOMER_EVENTS_TOPIC         = var.customer_events_topic
       SQUARE_CUSTOMER_WEBHOOK_TOPIC = var.square_customer_webhook_topic
       SQUARE_CUSTOMER_REQUEST_TOPIC = var.square_customer_request_topic
@@ -120,6 +125,7 @@ resource "google_cloudfunctions2_function" "customer-controller-cdc" {
       GCP_PROJECT                   = var.gcp_project_id
       EXPIRATION_TIME               = var.expiration_time
       FUNDRAISER_ID                 = var.fundraiser_id
+      FUNDRAISERS                   = var.fundraisers
       CUSTOMER_EVENTS_TOPIC         = var.customer_events_topic
       SQUARE_CUSTOMER_WEBHOOK_TOPIC = var.square_customer_webhook_topic
       SQUARE_CUSTOMER_REQUEST_TOPIC = var.square_customer_request_topic
@@ -136,7 +142,7 @@ resource "google_cloudfunctions2_function" "customer-controller-cdc" {
     event_filters {
       operator  = "match-path-pattern"
       attribute = "document"
-      value     = "fundraisers/${var.fundraiser_id}/customers/{customer}"
+      value     = "fundraisers/${local.fundraiser_path_segment}/customers/{customer}"
     }
     retry_policy   = "RETRY_POLICY_RETRY"
   }
@@ -166,18 +172,12 @@ resource "google_cloudfunctions2_function" "customer-controller-order-watcher" {
       GCP_PROJECT                   = var.gcp_project_id
       EXPIRATION_TIME               = var.expiration_time
       FUNDRAISER_ID                 = var.fundraiser_id
+      FUNDRAISERS                   = var.fundraisers
       CUSTOMER_EVENTS_TOPIC         = var.customer_events_topic
       SQUARE_CUSTOMER_WEBHOOK_TOPIC = var.square_customer_webhook_topic
       SQUARE_CUSTOMER_REQUEST_TOPIC = var.square_customer_request_topic
     }
   }
-
-  event_trigger {
-    trigger_region = var.gcp_region
-    event_type     = "google.cloud.pubsub.topic.v1.messagePublished"
-    pubsub_topic   = "projects/${var.gcp_project_id}/topics/${var.order_events_topic}"
-    retry_policy   = "RETRY_POLICY_RETRY"
-  }
 }
 
 resource "google_cloudfunctions2_function" "customer-controller-payment-watcher" {
@@ -204,18 +204,12 @@ resource "google_cloudfunctions2_function" "customer-controller-payment-watcher"
       GCP_PROJECT                   = var.gcp_project_id
       EXPIRATION_TIME               = var.expiration_time
       FUNDRAISER_ID                 = var.fundraiser_id
+      FUNDRAISERS                   = var.fundraisers
       CUSTOMER_EVENTS_TOPIC         = var.customer_events_topic
       SQUARE_CUSTOMER_WEBHOOK_TOPIC = var.square_customer_webhook_topic
       SQUARE_CUSTOMER_REQUEST_TOPIC = var.square_customer_request_topic
     }
   }
-
-  event_trigger {
-    trigger_region = var.gcp_region
-    event_type     = "google.cloud.pubsub.topic.v1.messagePublished"
-    pubsub_topic   = "projects/${var.gcp_project_id}/topics/${var.payment_events_topic}"
-    retry_policy   = "RETRY_POLICY_RETRY"
-  }
 }
 
 resource "google_cloudfunctions2_function" "customer-controller-square-customer-response" {
@@ -242,6 +236,7 @@ resource "google_cloudfunctions2_function" "customer-controller-square-customer-
       GCP_PROJECT                   = var.gcp_project_id
       EXPIRATION_TIME               = var.expiration_time
       FUNDRAISER_ID                 = var.fundraiser_id
+      FUNDRAISERS                   = var.fundraisers
       CUSTOMER_EVENTS_TOPIC         = var.customer_events_topic
       SQUARE_CUSTOMER_WEBHOOK_TOPIC = var.square_customer_webhook_topic
       SQUARE_CUSTOMER_REQUEST_TOPIC = var.square_customer_request_topic
@@ -255,3 +250,77 @@ resource "google_cloudfunctions2_function" "customer-controller-square-customer-
     retry_policy   = "RETRY_POLICY_RETRY"
   }
 }
+
+# the watchers below are subscribed to their topics with push subscriptions rather than event triggers, as only the
+# former can be filtered; their filters are generated from the handlers' declarations into subscription_filters.tf,
+# and the functions framework turns each pushed message into the CloudEvent an event trigger would deliver, taking
+# the topic from the path of the endpoint
+
+# Pub/Sub pushes to the watchers as the default compute service account
+data "google_compute_default_service_account" "default" {
+  project = var.gcp_project_id
+}
+
+resource "google_pubsub_subscription" "order_watcher" {
+  name   = google_cloudfunctions2_function.customer-controller-order-watcher.name
+  topic  = var.order_events_topic
+  filter = local.subscription_filters["OrderWatcher"]
+
+  ack_deadline_seconds = google_cloudfunctions2_function.customer-controller-order-watcher.service_config[0].timeout_seconds
+
+  retry_policy {
+    minimum_backoff = "10s"
+    maximum_backoff = "600s"
+  }
+
+  expiration_policy {
+    ttl = ""
+  }
+
+  push_config {
+    push_endpoint = "${google_cloudfunctions2_function.customer-controller-order-watcher.service_config[0].uri}/projects/${var.gcp_project_id}/topics/${var.order_events_topic}"
+
+    oidc_token {
+      service_account_email = data.google_compute_default_service_account.default.email
+    }
+  }
+}
+
+resource "google_cloud_run_service_iam_member" "order_watcher_invoker" {
+  location = google_cloudfunctions2_function.customer-controller-order-watcher.location
+  service  = lower(google_cloudfunctions2_function.customer-controller-order-watcher.name)
+  role     = "roles/run.invoker"
+  member   = "serviceAccount:${data.google_compute_default_service_account.default.email}"
+}
+
+resource "google_pubsub_subscription" "payment_watcher" {
+  name   = google_cloudfunctions2_function.customer-controller-payment-watcher.name
+  topic  = var.payment_events_topic
+  filter = local.subscription_filters["PaymentWatcher"]
+
+  ack_deadline_seconds = google_cloudfunctions2_function.customer-controller-payment-watcher.service_config[0].timeout_seconds
+
+  retry_policy {
+    minimum_backoff = "10s"
+    maximum_backoff = "600s"
+  }
+
+  expiration_policy {
+    ttl = ""
+  }
+
+  push_config {
+    push_endpoint = "${google_cloudfunctions2_function.customer-controller-payment-watcher.service_config[0].uri}/projects/${var.gcp_project_id}/topics/${var.payment_events_topic}"
+
+    oidc_token {
+      service_account_email = data.google_compute_default_service_account.default.email
+    }
+  }
+}
+
+resource "google_cloud_run_service_iam_member" "payment_watcher_invoker" {
+  location = google_cloudfunctions2_function.customer-controller-payment-watcher.location
+  service  = lower(google_cloudfunctions2_function.customer-controller-payment-watcher.name)
+  role     = "roles/run.invoker"
+  member   = "serviceAccount:${data.google_compute_default_service_account.default.email}"
+}
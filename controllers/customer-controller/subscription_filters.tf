@@ -0,0 +1,9 @@
+# Code generated by subscription-filters from the filter.CloudEvent declarations of the package; DO NOT EDIT.
+
+locals {
+  # the filter of the Pub/Sub subscription of each function, by entry point
+  subscription_filters = {
+    OrderWatcher   = "NOT attributes:type OR (attributes.type = \"org.kofc7186.fundraiserManager.order.created\" OR attributes.type = \"org.kofc7186.fundraiserManager.order.updated\")"
+    PaymentWatcher = "NOT attributes:type OR (attributes.type = \"org.kofc7186.fundraiserManager.payment.created\" OR attributes.type = \"org.kofc7186.fundraiserManager.payment.updated\")"
+  }
+}
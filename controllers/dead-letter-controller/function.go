@@ -0,0 +1,251 @@
+package deadlettercontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
+
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/config"
+	"github.com/kofc7186/fundraiser-manager/pkg/controller"
+	"github.com/kofc7186/fundraiser-manager/pkg/deadletter"
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
+	"github.com/kofc7186/fundraiser-manager/pkg/httpjson"
+	"github.com/kofc7186/fundraiser-manager/pkg/logging"
+	"github.com/kofc7186/fundraiser-manager/pkg/metrics"
+	"github.com/kofc7186/fundraiser-manager/pkg/tracing"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/funcframework"
+)
+
+const FUNCTION_NAME = "dead-letter-controller"
+
+// maxMessageBytes bounds the size of a replacement message, well above any event we publish
+const maxMessageBytes = 1 << 20
+
+var psClient *pubsub.Client
+var firestoreClient *firestore.Client
+var router *fundraiser.Router
+
+func init() {
+	slog.SetDefault(logging.FunctionLogger(FUNCTION_NAME))
+
+	if err := tracing.Init(FUNCTION_NAME); err != nil {
+		panic(err)
+	}
+	if err := metrics.Init(FUNCTION_NAME); err != nil {
+		panic(err)
+	}
+
+	var cfg config.Fundraiser
+	config.MustLoad(&cfg)
+
+	var err error
+	psClient, err = pubsub.NewClient(context.Background(), cfg.GCPProject)
+	if err != nil {
+		panic(err)
+	}
+
+	firestoreClient, err = firestore.NewClient(context.Background(), cfg.GCPProject)
+	if err != nil {
+		panic(err)
+	}
+
+	router, err = fundraiser.Load()
+	if err != nil {
+		panic(err)
+	}
+	if err := router.Watch(context.Background(), firestoreClient); err != nil {
+		panic(err)
+	}
+
+	// do this last so we are ensured to have all the required clients established above
+	functions.HTTP("DeadLetterAdmin", tracing.HTTP("DeadLetterAdmin", metrics.HTTP("DeadLetterAdmin", DeadLetterAdmin)))
+}
+
+// DeadLetterAdmin is the operator API for messages which were dead-lettered by the other functions:
+//
+//	GET  /letters?status=DEAD      list letters in a status (DEAD if not specified)
+//	GET  /letters/{id}             inspect a letter
+//	PUT  /letters/{id}/message     replace the CloudEvent carried by a dead letter
+//	POST /letters/{id}/replay      re-publish a dead letter to its original topic
+//	POST /letters/{id}/discard     mark a dead letter as not to be replayed
+//
+// letters are filed under the fundraiser of their event; each request acts on those of the fundraiser named by the
+// fundraiser query parameter, FUNDRAISER_ID if it is not set
+func DeadLetterAdmin(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if segments[0] != "letters" || len(segments) > 3 {
+		httpjson.Error(r.Context(), w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+		return
+	}
+
+	fundraiserID, ok := requestedFundraiser(w, r)
+	if !ok {
+		return
+	}
+	r = r.WithContext(fundraiser.NewContext(r.Context(), fundraiserID))
+	letterStore := deadletter.NewStore(firestoreClient, fundraiserID, router.Expiration(fundraiserID))
+
+	if len(segments) == 1 {
+		if r.Method != http.MethodGet {
+			httpjson.Error(r.Context(), w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+			return
+		}
+		listLetters(w, r, letterStore)
+		return
+	}
+
+	id := segments[1]
+	ctx := logging.WithLabel(r.Context(), logging.LabelDeadLetterID, id)
+	r = r.WithContext(ctx)
+
+	action := ""
+	if len(segments) == 3 {
+		action = segments[2]
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		getLetter(w, r, letterStore, id)
+	case action == "message" && r.Method == http.MethodPut:
+		setMessage(w, r, letterStore, id)
+	case action == "replay" && r.Method == http.MethodPost:
+		replayLetter(w, r, letterStore, id)
+	case action == "discard" && r.Method == http.MethodPost:
+		discardLetter(w, r, letterStore, id)
+	default:
+		httpjson.Error(ctx, w, http.StatusNotFound, fmt.Errorf("%s %s not found", r.Method, r.URL.Path))
+	}
+}
+
+// requestedFundraiser returns the fundraiser named by the fundraiser query parameter of r, or FUNDRAISER_ID if it is
+// not given; if the name is not valid, a 400 is written and false is returned
+func requestedFundraiser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	fundraiserID := r.URL.Query().Get("fundraiser")
+	if fundraiserID == "" {
+		return router.Default, true
+	}
+	if err := config.Check("fundraiserID", fundraiserID); err != nil {
+		httpjson.Error(r.Context(), w, http.StatusBadRequest, fmt.Errorf("fundraiser: %w", err))
+		return "", false
+	}
+	return fundraiserID, true
+}
+
+func listLetters(w http.ResponseWriter, r *http.Request, letterStore *deadletter.Store) {
+	letterStatus := deadletter.STATUS_DEAD
+	if value := r.URL.Query().Get("status"); value != "" {
+		var err error
+		if letterStatus, err = deadletter.ParseStatus(value); err != nil {
+			httpjson.Error(r.Context(), w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	letters, err := letterStore.List(r.Context(), letterStatus)
+	if err != nil {
+		writeStoreError(r.Context(), w, err)
+		return
+	}
+	httpjson.Write(r.Context(), w, http.StatusOK, letters)
+}
+
+func getLetter(w http.ResponseWriter, r *http.Request, letterStore *deadletter.Store, id string) {
+	letter, err := letterStore.Get(r.Context(), id)
+	if err != nil {
+		writeStoreError(r.Context(), w, err)
+		return
+	}
+	httpjson.Write(r.Context(), w, http.StatusOK, letter)
+}
+
+func setMessage(w http.ResponseWriter, r *http.Request, letterStore *deadletter.Store, id string) {
+	message, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageBytes))
+	if err != nil {
+		httpjson.Error(r.Context(), w, http.StatusBadRequest, err)
+		return
+	}
+
+	e := &event.Event{}
+	if err := e.UnmarshalJSON(message); err != nil {
+		httpjson.Error(r.Context(), w, http.StatusBadRequest, fmt.Errorf("message is not a valid CloudEvent: %w", err))
+		return
+	}
+
+	letter, err := letterStore.SetMessage(r.Context(), id, message)
+	if err != nil {
+		writeStoreError(r.Context(), w, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "dead letter message replaced", "event", e)
+	httpjson.Write(r.Context(), w, http.StatusOK, letter)
+}
+
+// replayLetter re-publishes the message as-is, so the event keeps its ID and the handlers' idempotency checks
+// still apply should the original delivery have partially succeeded
+func replayLetter(w http.ResponseWriter, r *http.Request, letterStore *deadletter.Store, id string) {
+	ctx := r.Context()
+
+	letter, err := letterStore.Get(ctx, id)
+	if err != nil {
+		writeStoreError(ctx, w, err)
+		return
+	}
+	if letter.Status != deadletter.STATUS_DEAD {
+		writeStoreError(ctx, w, deadletter.ErrNotDead)
+		return
+	}
+
+	e, err := letter.CloudEvent()
+	if err != nil {
+		httpjson.Error(ctx, w, http.StatusUnprocessableEntity, fmt.Errorf("message is not a valid CloudEvent; replace it before replaying: %w", err))
+		return
+	}
+
+	messageID, err := controller.Publish(ctx, psClient.Topic(letter.Topic), e)
+	if err != nil {
+		httpjson.Error(ctx, w, http.StatusBadGateway, fmt.Errorf("publishing to %s: %w", letter.Topic, err))
+		return
+	}
+
+	letter, err = letterStore.MarkReplayed(ctx, id, messageID)
+	if err != nil {
+		// the message is already re-published, so the letter's status is merely stale
+		slog.ErrorContext(ctx, fmt.Sprintf("replayed as %s but unable to mark as replayed: %v", messageID, err))
+		writeStoreError(ctx, w, err)
+		return
+	}
+	slog.InfoContext(ctx, fmt.Sprintf("dead letter replayed to %s as %s", letter.Topic, messageID))
+	httpjson.Write(ctx, w, http.StatusOK, letter)
+}
+
+func discardLetter(w http.ResponseWriter, r *http.Request, letterStore *deadletter.Store, id string) {
+	letter, err := letterStore.Discard(r.Context(), id)
+	if err != nil {
+		writeStoreError(r.Context(), w, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "dead letter discarded")
+	httpjson.Write(r.Context(), w, http.StatusOK, letter)
+}
+
+func writeStoreError(ctx context.Context, w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, deadletter.ErrNotFound):
+		httpjson.Error(ctx, w, http.StatusNotFound, err)
+	case errors.Is(err, deadletter.ErrNotDead):
+		httpjson.Error(ctx, w, http.StatusConflict, err)
+	default:
+		httpjson.Error(ctx, w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,98 @@
+locals {
+  function_group = "dead-letter-controller"
+  function_exclude_list = setunion(
+    ["go.sum"],
+    fileset("${path.module}", "cmd/**"),         # main harness
+    fileset("${path.module}", "**_test.go"),     # go test files
+    fileset("${path.module}", "**.tf*"),         # terraform files
+    fileset("${path.module}", "**terraform*"),   # terraform files
+    fileset("${path.module}", "**terraform/**"), # terraform files
+    fileset("${path.module}", "*source-*.zip")   # other source zips
+  )
+}
+
+resource "google_project_service" "service" {
+  for_each = toset([
+    "run.googleapis.com",
+    "cloudtrace.googleapis.com",
+    "monitoring.googleapis.com",
+    "storage.googleapis.com",
+    "pubsub.googleapis.com",
+  ])
+
+  project = var.gcp_project_id
+  service = each.key
+
+  disable_on_destroy = false
+}
+
+# this runs 'go mod vendor' from ${path.module} for inclusion in the source zip file
+data "external" "go_mod_vendor" {
+  program = ["bash", "../../../go-mod-vendor.sh", "${path.module}"]
+}
+
+# random_string.r is to ensure that the zip file gets recreated upon
+# running "terraform apply"
+resource "random_string" "r" {
+  length  = 16
+  special = false
+}
+
+data "archive_file" "function_source_zip" {
+  type = "zip"
+
+  # *source.zip is in .gitignore so should never be committed
+  output_path = "${path.module}/${local.function_group}-source.zip"
+  source_dir  = path.module
+
+  # this is computed once for all functions
+  excludes = local.function_exclude_list
+
+  # this ensures that 'go mod vendor' is run before creating the zip file
+  # the dependency on random_string.r is to ensure that the zip file gets
+  # recreated upon running "terraform apply"
+  depends_on = [data.external.go_mod_vendor, random_string.r]
+}
+
+resource "google_storage_bucket_object" "function_source_object" {
+  name   = "${local.function_group}/${var.fundraiser_id}-${data.archive_file.function_source_zip.output_md5}-source.zip"
+  bucket = var.gcs_function_source_bucket
+  source = data.archive_file.function_source_zip.output_path
+}
+
+
+resource "google_cloudfunctions2_function" "dead_letter_admin" {
+  name     = "${local.function_group}-${var.fundraiser_id}"
+  location = var.gcp_region
+
+  build_config {
+    runtime     = "go121"
+    entry_point = "DeadLetterAdmin"
+    source {
+      storage_source {
+        bucket = var.gcs_function_source_bucket
+        object = google_storage_bucket_object.function_source_object.name
+      }
+    }
+  }
+
+  service_config {
+    available_memory   = "128Mi"
+    timeout_seconds    = 60
+    min_instance_count = var.min_instance_count
+
+    environment_variables = {
+      GCP_PROJECT     = var.gcp_project_id
+      FUNDRAISER_ID   = var.fundraiser_id
+      EXPIRATION_TIME = var.expiration_time
+      FUNDRAISERS     = var.fundraisers
+    }
+  }
+}
+
+# unlike square-webhook-ingress, this endpoint is not granted to allUsers; operators must be given
+# roles/run.invoker on the service and call it with an identity token, e.g.
+#   curl -H "Authorization: Bearer $(gcloud auth print-identity-token)" <uri>/letters
+output "dead_letter_admin_uri" {
+  value = google_cloudfunctions2_function.dead_letter_admin.service_config[0].uri
+}
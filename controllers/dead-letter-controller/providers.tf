@@ -0,0 +1,4 @@
+provider "google" {
+  project = var.gcp_project_id
+  region  = var.gcp_region
+}
@@ -5,15 +5,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"net/http"
 
 	"cloud.google.com/go/firestore"
 
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 	"github.com/cloudevents/sdk-go/v2/event"
 	"github.com/google/uuid"
+	"github.com/kofc7186/fundraiser-manager/pkg/config"
+	"github.com/kofc7186/fundraiser-manager/pkg/deadletter"
 	eventschemas "github.com/kofc7186/fundraiser-manager/pkg/event/schemas"
+	"github.com/kofc7186/fundraiser-manager/pkg/eventlake"
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
 	"github.com/kofc7186/fundraiser-manager/pkg/logging"
-	"github.com/kofc7186/fundraiser-manager/pkg/util"
+	"github.com/kofc7186/fundraiser-manager/pkg/metrics"
+	"github.com/kofc7186/fundraiser-manager/pkg/tracing"
 
 	_ "github.com/GoogleCloudPlatform/functions-framework-go/funcframework"
 )
@@ -21,22 +28,43 @@ import (
 const FUNCTION_NAME = "event-lake-controller"
 
 var firestoreClient *firestore.Client
-
-var eventLakePath string
+var router *fundraiser.Router
 
 func init() {
 	slog.SetDefault(logging.FunctionLogger(FUNCTION_NAME))
 
+	if err := tracing.Init(FUNCTION_NAME); err != nil {
+		panic(err)
+	}
+	if err := metrics.Init(FUNCTION_NAME); err != nil {
+		panic(err)
+	}
+	if err := deadletter.Init(); err != nil {
+		panic(err)
+	}
+
+	var cfg struct {
+		GCPProject string `env:"GCP_PROJECT" validate:"required"`
+	}
+	config.MustLoad(&cfg)
+
 	var err error
-	firestoreClient, err = firestore.NewClient(context.Background(), util.GetEnvOrPanic("GCP_PROJECT"))
+	firestoreClient, err = firestore.NewClient(context.Background(), cfg.GCPProject)
 	if err != nil {
 		panic(err)
 	}
 
-	eventLakePath = fmt.Sprintf("fundraisers/%s/events", util.GetEnvOrPanic("FUNDRAISER_ID"))
+	router, err = fundraiser.Load()
+	if err != nil {
+		panic(err)
+	}
+	if err := router.Watch(context.Background(), firestoreClient); err != nil {
+		panic(err)
+	}
 
 	// do this last so we are ensured to have all the required clients established above
-	functions.CloudEvent("EventLakeCapture", EventLakeCapture)
+	functions.CloudEvent("EventLakeCapture", deadletter.CloudEvent("EventLakeCapture", tracing.CloudEvent("EventLakeCapture", metrics.CloudEvent("EventLakeCapture", EventLakeCapture))))
+	functions.HTTP("EventLakeQuery", tracing.HTTP("EventLakeQuery", metrics.HTTP("EventLakeQuery", EventLakeQuery)))
 }
 
 // EventLakeCapture puts an entry in the backing Firestore DB for each observed event
@@ -45,14 +73,14 @@ func EventLakeCapture(ctx context.Context, e event.Event) error {
 	var msg eventschemas.MessagePublishedData
 	if err := e.DataAs(&msg); err != nil {
 		slog.ErrorContext(ctx, err.Error(), "event", e)
-		return err
+		return failure.Poison(err)
 	}
 
 	// extract nested CloudEvent contents as arbitrary JSON to store in event lake
 	eventMap := make(map[string]interface{})
 	if err := json.Unmarshal(msg.Message.Data, &eventMap); err != nil {
 		slog.ErrorContext(ctx, err.Error(), "data", msg.Message.Data)
-		return err
+		return failure.Poison(err)
 	}
 
 	var idString string
@@ -64,8 +92,17 @@ func EventLakeCapture(ctx context.Context, e event.Event) error {
 		idString = id.(string)
 	}
 
+	// each fundraiser has its own lake; events published before they were routed belong to the default
+	fundraiserID, _ := eventMap[fundraiser.EXTENSION].(string)
+	if fundraiserID == "" {
+		fundraiserID = router.Default
+	}
+	ctx = fundraiser.NewContext(ctx, fundraiserID)
+	// the Firestore TTL policy needs a timestamp, and the fundraiser's retention policy may have changed since publishing
+	eventMap["expiration"] = router.Expiration(fundraiserID)
+
 	// set the document ID to be the event UUID
-	docRef := firestoreClient.Doc(fmt.Sprintf("%s/%s", eventLakePath, idString))
+	docRef := firestoreClient.Doc(fmt.Sprintf("%s/%s", eventlake.CollectionPath(fundraiserID), idString))
 	wr, err := docRef.Set(ctx, eventMap)
 	if err != nil {
 		return err
@@ -74,3 +111,16 @@ func EventLakeCapture(ctx context.Context, e event.Event) error {
 	slog.DebugContext(ctx, fmt.Sprintf("%v written to event lake at %v", docRef.ID, docRef.Path), "written_at", wr.UpdateTime)
 	return nil
 }
+
+// EventLakeQuery serves the read API (see eventlake.Handler) over the lake of the fundraiser named by the fundraiser
+// query parameter, or FUNDRAISER_ID if it is not given
+func EventLakeQuery(w http.ResponseWriter, r *http.Request) {
+	fundraiserID := r.URL.Query().Get("fundraiser")
+	if fundraiserID == "" {
+		fundraiserID = router.Default
+	} else if err := config.Check("fundraiserID", fundraiserID); err != nil {
+		http.Error(w, fmt.Sprintf("fundraiser: %v", err), http.StatusBadRequest)
+		return
+	}
+	eventlake.Handler(eventlake.New(firestoreClient, fundraiserID))(w, r)
+}
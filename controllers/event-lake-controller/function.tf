@@ -14,6 +14,8 @@ locals {
 resource "google_project_service" "service" {
   for_each = toset([
     "run.googleapis.com",
+    "cloudtrace.googleapis.com",
+    "monitoring.googleapis.com",
     "eventarc.googleapis.com",
     "storage.googleapis.com",
   ])
@@ -85,6 +87,7 @@ resource "google_cloudfunctions2_function" "event_lake_capture" {
     environment_variables = {
       GCP_PROJECT     = var.gcp_project_id
       FUNDRAISER_ID   = var.fundraiser_id
+      FUNDRAISERS     = var.fundraisers
       EXPIRATION_TIME = var.expiration_time
     }
   }
@@ -96,3 +99,39 @@ resource "google_cloudfunctions2_function" "event_lake_capture" {
     retry_policy   = "RETRY_POLICY_RETRY"
   }
 }
+
+# serves eventlake.Handler: GET /events lists events and GET /timeline renders an entity's history
+resource "google_cloudfunctions2_function" "event_lake_query" {
+  name     = "event-lake-query-${var.fundraiser_id}"
+  location = var.gcp_region
+
+  build_config {
+    runtime     = "go121"
+    entry_point = "EventLakeQuery"
+    source {
+      storage_source {
+        bucket = var.gcs_function_source_bucket
+        object = google_storage_bucket_object.function_source_object.name
+      }
+    }
+  }
+
+  service_config {
+    available_memory   = "256Mi"
+    timeout_seconds    = 60
+    min_instance_count = var.min_instance_count
+
+    environment_variables = {
+      GCP_PROJECT     = var.gcp_project_id
+      FUNDRAISER_ID   = var.fundraiser_id
+      FUNDRAISERS     = var.fundraisers
+      EXPIRATION_TIME = var.expiration_time
+    }
+  }
+}
+
+# like dead-letter-controller, this endpoint is not granted to allUsers; callers need roles/run.invoker on
+# the service and an identity token, e.g. eventlake.Client with an idtoken HTTP client
+output "event_lake_query_uri" {
+  value = google_cloudfunctions2_function.event_lake_query.service_config[0].uri
+}
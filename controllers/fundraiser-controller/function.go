@@ -0,0 +1,174 @@
+package fundraisercontroller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/config"
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
+	"github.com/kofc7186/fundraiser-manager/pkg/httpjson"
+	"github.com/kofc7186/fundraiser-manager/pkg/logging"
+	"github.com/kofc7186/fundraiser-manager/pkg/metrics"
+	"github.com/kofc7186/fundraiser-manager/pkg/tracing"
+	fundraiserType "github.com/kofc7186/fundraiser-manager/pkg/types/fundraiser"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/funcframework"
+)
+
+const FUNCTION_NAME = "fundraiser-controller"
+
+// maxBodyBytes bounds the size of the fundraiser metadata in a request
+const maxBodyBytes = 64 << 10
+
+var fundraiserStore *fundraiser.Store
+
+func init() {
+	slog.SetDefault(logging.FunctionLogger(FUNCTION_NAME))
+
+	if err := tracing.Init(FUNCTION_NAME); err != nil {
+		panic(err)
+	}
+	if err := metrics.Init(FUNCTION_NAME); err != nil {
+		panic(err)
+	}
+
+	var cfg config.Fundraiser
+	config.MustLoad(&cfg)
+
+	firestoreClient, err := firestore.NewClient(context.Background(), cfg.GCPProject)
+	if err != nil {
+		panic(err)
+	}
+
+	fundraiserStore = fundraiser.NewStore(firestoreClient)
+
+	// do this last so we are ensured to have all the required clients established above
+	functions.HTTP("FundraiserAdmin", tracing.HTTP("FundraiserAdmin", metrics.HTTP("FundraiserAdmin", FundraiserAdmin)))
+}
+
+// FundraiserAdmin is the operator API for the metadata and lifecycle of fundraisers:
+//
+//	GET  /fundraisers                list fundraisers
+//	GET  /fundraisers/{id}           inspect a fundraiser
+//	PUT  /fundraisers/{id}           create or replace the metadata of a fundraiser; its status and order numbers are kept
+//	POST /fundraisers/{id}/status    move a fundraiser to {"status": "OPEN"|"CLOSED"|"ARCHIVED"}
+func FundraiserAdmin(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if segments[0] != "fundraisers" || len(segments) > 3 {
+		httpjson.Error(r.Context(), w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+		return
+	}
+
+	if len(segments) == 1 {
+		if r.Method != http.MethodGet {
+			httpjson.Error(r.Context(), w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+			return
+		}
+		listFundraisers(w, r)
+		return
+	}
+
+	id := segments[1]
+	if err := config.Check("fundraiserID", id); err != nil {
+		httpjson.Error(r.Context(), w, http.StatusBadRequest, fmt.Errorf("fundraiser ID: %w", err))
+		return
+	}
+	ctx := fundraiser.NewContext(r.Context(), id)
+	r = r.WithContext(ctx)
+
+	action := ""
+	if len(segments) == 3 {
+		action = segments[2]
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		getFundraiser(w, r, id)
+	case action == "" && r.Method == http.MethodPut:
+		putFundraiser(w, r, id)
+	case action == "status" && r.Method == http.MethodPost:
+		transitionFundraiser(w, r, id)
+	default:
+		httpjson.Error(ctx, w, http.StatusNotFound, fmt.Errorf("%s %s not found", r.Method, r.URL.Path))
+	}
+}
+
+func listFundraisers(w http.ResponseWriter, r *http.Request) {
+	fundraisers, err := fundraiserStore.List(r.Context())
+	if err != nil {
+		writeStoreError(r.Context(), w, err)
+		return
+	}
+	httpjson.Write(r.Context(), w, http.StatusOK, fundraisers)
+}
+
+func getFundraiser(w http.ResponseWriter, r *http.Request, id string) {
+	f, err := fundraiserStore.Get(r.Context(), id)
+	if err != nil {
+		writeStoreError(r.Context(), w, err)
+		return
+	}
+	httpjson.Write(r.Context(), w, http.StatusOK, f)
+}
+
+func putFundraiser(w http.ResponseWriter, r *http.Request, id string) {
+	f := &fundraiserType.Fundraiser{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(f); err != nil {
+		httpjson.Error(r.Context(), w, http.StatusBadRequest, fmt.Errorf("body is not a valid fundraiser: %w", err))
+		return
+	}
+	f.ID = id
+
+	f, err := fundraiserStore.Put(r.Context(), f)
+	if err != nil {
+		writeStoreError(r.Context(), w, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "fundraiser metadata updated", "fundraiser", f)
+	httpjson.Write(r.Context(), w, http.StatusOK, f)
+}
+
+func transitionFundraiser(w http.ResponseWriter, r *http.Request, id string) {
+	var body struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&body); err != nil {
+		httpjson.Error(r.Context(), w, http.StatusBadRequest, fmt.Errorf("body is not a valid status: %w", err))
+		return
+	}
+	next, err := fundraiserType.ParseStatus(body.Status)
+	if err != nil {
+		httpjson.Error(r.Context(), w, http.StatusBadRequest, err)
+		return
+	}
+
+	f, err := fundraiserStore.Transition(r.Context(), id, next)
+	if err != nil {
+		writeStoreError(r.Context(), w, err)
+		return
+	}
+	slog.InfoContext(r.Context(), fmt.Sprintf("fundraiser moved to %s", f.Status))
+	httpjson.Write(r.Context(), w, http.StatusOK, f)
+}
+
+func writeStoreError(ctx context.Context, w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, fundraiser.ErrNotFound):
+		httpjson.Error(ctx, w, http.StatusNotFound, err)
+	case errors.Is(err, fundraiser.ErrInvalid):
+		httpjson.Error(ctx, w, http.StatusBadRequest, err)
+	case errors.Is(err, fundraiserType.ErrInvalidTransition):
+		httpjson.Error(ctx, w, http.StatusConflict, err)
+	default:
+		httpjson.Error(ctx, w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,286 @@
+package notificationcontroller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"gopkg.in/yaml.v3"
+
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/config"
+	"github.com/kofc7186/fundraiser-manager/pkg/deadletter"
+	"github.com/kofc7186/fundraiser-manager/pkg/event/filter"
+	eventschemas "github.com/kofc7186/fundraiser-manager/pkg/event/schemas"
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
+	"github.com/kofc7186/fundraiser-manager/pkg/httpjson"
+	"github.com/kofc7186/fundraiser-manager/pkg/logging"
+	"github.com/kofc7186/fundraiser-manager/pkg/metrics"
+	"github.com/kofc7186/fundraiser-manager/pkg/notification"
+	"github.com/kofc7186/fundraiser-manager/pkg/secrets"
+	"github.com/kofc7186/fundraiser-manager/pkg/tracing"
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+
+	_ "github.com/GoogleCloudPlatform/functions-framework-go/funcframework"
+)
+
+const FUNCTION_NAME = "notification-controller"
+
+// the events each watcher handles, from which go generate writes the filters of their subscriptions
+const (
+	orderWatcherFilter = "type IN ('org.kofc7186.fundraiserManager.order.created', 'org.kofc7186.fundraiserManager.order.updated')"
+)
+
+//go:generate go run github.com/kofc7186/fundraiser-manager/cmd/subscription-filters
+
+var firestoreClient *firestore.Client
+var router *fundraiser.Router
+var fundraiserStore *fundraiser.Store
+
+var templates *notification.Templates
+var providers map[notification.Channel]notification.Provider
+var pickupInstructions string
+var delayThreshold time.Duration
+
+func init() {
+	slog.SetDefault(logging.FunctionLogger(FUNCTION_NAME))
+
+	if err := tracing.Init(FUNCTION_NAME); err != nil {
+		panic(err)
+	}
+	if err := metrics.Init(FUNCTION_NAME); err != nil {
+		panic(err)
+	}
+	if err := deadletter.Init(); err != nil {
+		panic(err)
+	}
+	if err := eventschemas.Init(); err != nil {
+		panic(err)
+	}
+
+	var cfg struct {
+		config.Fundraiser
+		// how long a customer waits for their order before they are told it is delayed
+		DelayThreshold time.Duration `env:"NOTIFICATION_DELAY_THRESHOLD" default:"20m" validate:"positive"`
+		// what customers are told about picking up their orders, e.g. "Please come to the pickup table in the gym."
+		PickupInstructions string `env:"PICKUP_INSTRUCTIONS"`
+		// replaces any of notification.DEFAULT_TEMPLATES, as YAML keyed like them
+		Templates string `env:"NOTIFICATION_TEMPLATES"`
+		// messages are kept by a notification.Sink rather than sent, e.g. when running locally
+		UseSink bool `env:"NOTIFICATION_SINK" default:"false"`
+
+		// email is sent if SMTP_ADDR is set
+		SMTPAddr     string `env:"SMTP_ADDR"`
+		SMTPFrom     string `env:"SMTP_FROM"`
+		SMTPUsername string `env:"SMTP_USERNAME"`
+		SMTPPassword string `env:"SMTP_PASSWORD,secret"`
+
+		// text messages are sent if SMS_URL is set
+		SMSURL   string `env:"SMS_URL"`
+		SMSFrom  string `env:"SMS_FROM"`
+		SMSToken string `env:"SMS_TOKEN,secret"`
+	}
+	// the SMTP password and SMS token are read from Secret Manager, and re-read as they are rotated
+	secretProvider := secrets.Open()
+	if err := (&config.Loader{Secrets: secretProvider}).Load(context.Background(), &cfg); err != nil {
+		panic(fmt.Sprintf("invalid configuration:\n%v", err))
+	}
+
+	// the providers are optional, but complete if they are enabled
+	if cfg.SMTPAddr != "" && cfg.SMTPFrom == "" {
+		panic(fmt.Sprintf("invalid configuration:\n%v", &config.Error{Name: "SMTP_FROM", Err: config.ErrMissing}))
+	}
+	if cfg.SMSURL != "" {
+		if err := config.Check("url", cfg.SMSURL); err != nil {
+			panic(fmt.Sprintf("invalid configuration:\n%v", &config.Error{Name: "SMS_URL", Err: err}))
+		}
+	}
+
+	var overrides map[string]string
+	if err := yaml.Unmarshal([]byte(cfg.Templates), &overrides); err != nil {
+		panic(fmt.Sprintf("NOTIFICATION_TEMPLATES: %v", err))
+	}
+	var err error
+	templates, err = notification.ParseTemplates(overrides)
+	if err != nil {
+		panic(err)
+	}
+	pickupInstructions, delayThreshold = cfg.PickupInstructions, cfg.DelayThreshold
+
+	providers = map[notification.Channel]notification.Provider{}
+	switch {
+	case cfg.UseSink:
+		sink := &notification.Sink{}
+		providers[notification.CHANNEL_EMAIL], providers[notification.CHANNEL_SMS] = sink, sink
+	default:
+		if cfg.SMTPAddr != "" {
+			providers[notification.CHANNEL_EMAIL] = &notification.SMTPProvider{
+				Addr:     cfg.SMTPAddr,
+				From:     cfg.SMTPFrom,
+				Username: cfg.SMTPUsername,
+				Password: secrets.NewValue(secretProvider, "SMTP_PASSWORD", cfg.SMTPPassword),
+			}
+		}
+		if cfg.SMSURL != "" {
+			providers[notification.CHANNEL_SMS] = &notification.HTTPSMSProvider{
+				URL:    cfg.SMSURL,
+				From:   cfg.SMSFrom,
+				Token:  secrets.NewValue(secretProvider, "SMS_TOKEN", cfg.SMSToken),
+				Client: &http.Client{Timeout: 10 * time.Second},
+			}
+		}
+	}
+	if len(providers) == 0 {
+		slog.Warn("neither SMTP_ADDR nor SMS_URL is set, so no notifications will be sent")
+	}
+
+	firestoreClient, err = firestore.NewClient(context.Background(), cfg.GCPProject)
+	if err != nil {
+		panic(err)
+	}
+	fundraiserStore = fundraiser.NewStore(firestoreClient)
+
+	router, err = fundraiser.Load()
+	if err != nil {
+		panic(err)
+	}
+	if err := router.Watch(context.Background(), firestoreClient); err != nil {
+		panic(err)
+	}
+
+	// do this last so we are ensured to have all the required clients established above
+	functions.CloudEvent("OrderWatcher", deadletter.CloudEvent("OrderWatcher", filter.CloudEvent(orderWatcherFilter, tracing.CloudEvent("OrderWatcher", metrics.CloudEvent("OrderWatcher", OrderWatcher)))))
+	functions.HTTP("NotifyDelayed", tracing.HTTP("NotifyDelayed", metrics.HTTP("NotifyDelayed", NotifyDelayed)))
+}
+
+// notifier returns the Notifier of the fundraiser, whose ledger is kept with the fundraiser's documents
+func notifier(fundraiserID string) *notification.Notifier {
+	return &notification.Notifier{
+		Templates: templates,
+		Providers: providers,
+		Ledger:    notification.NewFirestoreLedger(firestoreClient, fundraiserID, router.Expiration(fundraiserID)),
+	}
+}
+
+// data returns what the messages about o are rendered with
+func data(ctx context.Context, fundraiserID string, o *orderType.Order) (notification.Data, error) {
+	// fundraisers which were not set up through the admin API have no name
+	f, err := fundraiserStore.Get(ctx, fundraiserID)
+	if err != nil && !errors.Is(err, fundraiser.ErrNotFound) {
+		return notification.Data{}, err
+	}
+	var name string
+	if f != nil {
+		name = f.Name
+	}
+	return notification.NewData(o, name, pickupInstructions), nil
+}
+
+// OrderWatcher tells customers when their orders become READY or are CANCELED
+func OrderWatcher(ctx context.Context, e event.Event) error {
+	nestedEvent, err := eventschemas.NestedEvent(e)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error(), "event", e)
+		return err
+	}
+
+	var oldOrder, newOrder *orderType.Order
+	switch nestedEvent.Type() {
+	case eventschemas.OrderCreatedType:
+		oc := &eventschemas.OrderCreated{}
+		if err := eventschemas.DataAs(nestedEvent, oc); err != nil {
+			return failure.Poison(err)
+		}
+		newOrder = oc.Order
+	case eventschemas.OrderUpdatedType:
+		ou := &eventschemas.OrderUpdated{}
+		if err := eventschemas.DataAs(nestedEvent, ou); err != nil {
+			return failure.Poison(err)
+		}
+		oldOrder, newOrder = ou.OldOrder, ou.Order
+	default:
+		slog.DebugContext(ctx, fmt.Sprintf("squelching %q event", e.Type()), "event", nestedEvent)
+		return nil
+	}
+
+	kind, ok := notification.KindOf(oldOrder, newOrder)
+	if !ok {
+		return nil
+	}
+
+	fundraiserID := router.Of(nestedEvent)
+	ctx = fundraiser.NewContext(ctx, fundraiserID)
+	ctx = logging.WithOrderNumber(logging.WithLabel(ctx, logging.LabelOrderID, newOrder.ID), newOrder.Number)
+
+	d, err := data(ctx, fundraiserID, newOrder)
+	if err != nil {
+		return err
+	}
+	_, err = notifier(fundraiserID).Notify(ctx, kind, d)
+	return err
+}
+
+// NotifyDelayed tells the customers who have waited for their orders for longer than NOTIFICATION_DELAY_THRESHOLD that
+// they are delayed; Cloud Scheduler calls it every few minutes, and each customer is only told once
+func NotifyDelayed(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		httpjson.Error(ctx, w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+		return
+	}
+
+	now := time.Now()
+	notified := map[string]int{}
+	var errs []error
+	for _, fundraiserID := range router.IDs() {
+		ctx := fundraiser.NewContext(ctx, fundraiserID)
+		docSnaps, err := firestoreClient.Collection(fundraiser.Path(fundraiserID, "orders")).
+			Where("status", "in", []orderType.OrderStatus{orderType.ORDER_STATUS_PRESENT, orderType.ORDER_STATUS_LABELED}).
+			Documents(ctx).GetAll()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("fundraiser %s: %w", fundraiserID, err))
+			continue
+		}
+
+		n := notifier(fundraiserID)
+		for _, docSnap := range docSnaps {
+			o := &orderType.Order{}
+			if err := docSnap.DataTo(o); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if !notification.IsDelayed(o, now, delayThreshold) {
+				continue
+			}
+
+			ctx := logging.WithOrderNumber(logging.WithLabel(ctx, logging.LabelOrderID, o.ID), o.Number)
+			sent := false
+			d, err := data(ctx, fundraiserID, o)
+			if err == nil {
+				sent, err = n.Notify(ctx, notification.KIND_DELAYED, d)
+			}
+			if err != nil {
+				// the other orders are still notified; this one is retried on the next call
+				slog.ErrorContext(ctx, err.Error())
+				errs = append(errs, fmt.Errorf("order %s: %w", o.ID, err))
+				continue
+			}
+			if sent {
+				notified[fundraiserID]++
+			}
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		httpjson.Error(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+	httpjson.Write(ctx, w, http.StatusOK, map[string]interface{}{"delayed": notified})
+}
@@ -0,0 +1,244 @@
+locals {
+  function_group = "notification-controller"
+  function_exclude_list = setunion(
+    ["go.sum"],
+    fileset("${path.module}", "cmd/**"),         # main harness
+    fileset("${path.module}", "**_test.go"),     # go test files
+    fileset("${path.module}", "**.tf*"),         # terraform files
+    fileset("${path.module}", "**terraform*"),   # terraform files
+    fileset("${path.module}", "**terraform/**"), # terraform files
+    fileset("${path.module}", "*source-*.zip")   # other source zips
+  )
+}
+
+resource "google_project_service" "service" {
+  for_each = toset([
+    "run.googleapis.com",
+    "cloudtrace.googleapis.com",
+    "monitoring.googleapis.com",
+    "storage.googleapis.com",
+    "cloudscheduler.googleapis.com",
+    "secretmanager.googleapis.com",
+  ])
+
+  project = var.gcp_project_id
+  service = each.key
+
+  disable_on_destroy = false
+}
+
+# this runs 'go mod vendor' from ${path.module} for inclusion in the source zip file
+data "external" "go_mod_vendor" {
+  program = ["bash", "../../../go-mod-vendor.sh", "${path.module}"]
+}
+
+# random_string.r is to ensure that the zip file gets recreated upon
+# running "terraform apply"
+resource "random_string" "r" {
+  length  = 16
+  special = false
+}
+
+data "archive_file" "function_source_zip" {
+  type = "zip"
+
+  # *source.zip is in .gitignore so should never be committed
+  output_path = "${path.module}/${local.function_group}-source.zip"
+  source_dir  = path.module
+
+  # this is computed once for all functions
+  excludes = local.function_exclude_list
+
+  # this ensures that 'go mod vendor' is run before creating the zip file
+  # the dependency on random_string.r is to ensure that the zip file gets
+  # recreated upon running "terraform apply"
+  depends_on = [data.external.go_mod_vendor, random_string.r]
+}
+
+resource "google_storage_bucket_object" "function_source_object" {
+  name   = "${local.function_group}/${var.fundraiser_id}-${data.archive_file.function_source_zip.output_md5}-source.zip"
+  bucket = var.gcs_function_source_bucket
+  source = data.archive_file.function_source_zip.output_path
+}
+
+resource "google_cloudfunctions2_function" "notification-controller-order-watcher" {
+  name     = "${local.function_group}-${var.fundraiser_id}-order-watcher"
+  location = var.gcp_region
+
+  build_config {
+    runtime     = "go121"
+    entry_point = "OrderWatcher"
+    source {
+      storage_source {
+        bucket = var.gcs_function_source_bucket
+        object = google_storage_bucket_object.function_source_object.name
+      }
+    }
+  }
+
+  service_config {
+    available_memory   = "128Mi"
+    timeout_seconds    = 60
+    min_instance_count = var.min_instance_count
+
+    environment_variables = {
+      GCP_PROJECT                  = var.gcp_project_id
+      EXPIRATION_TIME              = var.expiration_time
+      FUNDRAISER_ID                = var.fundraiser_id
+      FUNDRAISERS                  = var.fundraisers
+      NOTIFICATION_DELAY_THRESHOLD = var.notification_delay_threshold
+      NOTIFICATION_TEMPLATES       = var.notification_templates
+      PICKUP_INSTRUCTIONS          = var.pickup_instructions
+      SMTP_ADDR                    = var.smtp_addr
+      SMTP_FROM                    = var.smtp_from
+      SMTP_USERNAME                = var.smtp_username
+      SMS_URL                      = var.sms_url
+      SMS_FROM                     = var.sms_from
+
+      # read from Secret Manager when the function starts and again as they are rotated, if their providers are used
+      SMTP_PASSWORD_SECRET = var.smtp_addr != "" && var.smtp_username != "" ? google_secret_manager_secret.smtp_password.id : ""
+      SMS_TOKEN_SECRET     = var.sms_url != "" ? google_secret_manager_secret.sms_token.id : ""
+    }
+  }
+}
+
+resource "google_cloudfunctions2_function" "notification-controller-notify-delayed" {
+  name     = "${local.function_group}-${var.fundraiser_id}-notify-delayed"
+  location = var.gcp_region
+
+  build_config {
+    runtime     = "go121"
+    entry_point = "NotifyDelayed"
+    source {
+      storage_source {
+        bucket = var.gcs_function_source_bucket
+        object = google_storage_bucket_object.function_source_object.name
+      }
+    }
+  }
+
+  service_config {
+    available_memory   = "128Mi"
+    timeout_seconds    = 60
+    min_instance_count = var.min_instance_count
+
+    environment_variables = {
+      GCP_PROJECT                  = var.gcp_project_id
+      EXPIRATION_TIME              = var.expiration_time
+      FUNDRAISER_ID                = var.fundraiser_id
+      FUNDRAISERS                  = var.fundraisers
+      NOTIFICATION_DELAY_THRESHOLD = var.notification_delay_threshold
+      NOTIFICATION_TEMPLATES       = var.notification_templates
+      PICKUP_INSTRUCTIONS          = var.pickup_instructions
+      SMTP_ADDR                    = var.smtp_addr
+      SMTP_FROM                    = var.smtp_from
+      SMTP_USERNAME                = var.smtp_username
+      SMS_URL                      = var.sms_url
+      SMS_FROM                     = var.sms_from
+
+      # read from Secret Manager when the function starts and again as they are rotated, if their providers are used
+      SMTP_PASSWORD_SECRET = var.smtp_addr != "" && var.smtp_username != "" ? google_secret_manager_secret.smtp_password.id : ""
+      SMS_TOKEN_SECRET     = var.sms_url != "" ? google_secret_manager_secret.sms_token.id : ""
+    }
+  }
+}
+
+# these are manually entered into GCP Secret Manager after creating the objects, if the providers are used
+resource "google_secret_manager_secret" "smtp_password" {
+  secret_id = "${var.fundraiser_id}-smtp_password"
+
+  replication {
+    auto {}
+  }
+}
+
+resource "google_secret_manager_secret" "sms_token" {
+  secret_id = "${var.fundraiser_id}-sms_token"
+
+  replication {
+    auto {}
+  }
+}
+
+# the functions, Pub/Sub and Cloud Scheduler on their behalf, run as the default compute service account
+data "google_compute_default_service_account" "default" {
+  project = var.gcp_project_id
+}
+
+# allows the SA that the functions run as to access the secret values
+resource "google_secret_manager_secret_iam_member" "secret_accessor" {
+  for_each = {
+    smtp_password = google_secret_manager_secret.smtp_password
+    sms_token     = google_secret_manager_secret.sms_token
+  }
+
+  project   = each.value.project
+  secret_id = each.value.secret_id
+  role      = "roles/secretmanager.secretAccessor"
+  member    = "serviceAccount:${data.google_compute_default_service_account.default.email}"
+}
+
+# the watcher below is subscribed to its topic with a push subscription rather than an event trigger, as only the
+# former can be filtered (see order-controller)
+resource "google_pubsub_subscription" "order_watcher" {
+  name   = google_cloudfunctions2_function.notification-controller-order-watcher.name
+  topic  = var.order_events_topic
+  filter = local.subscription_filters["OrderWatcher"]
+
+  ack_deadline_seconds = google_cloudfunctions2_function.notification-controller-order-watcher.service_config[0].timeout_seconds
+
+  retry_policy {
+    minimum_backoff = "10s"
+    maximum_backoff = "600s"
+  }
+
+  expiration_policy {
+    ttl = ""
+  }
+
+  push_config {
+    push_endpoint = "${google_cloudfunctions2_function.notification-controller-order-watcher.service_config[0].uri}/projects/${var.gcp_project_id}/topics/${var.order_events_topic}"
+
+    oidc_token {
+      service_account_email = data.google_compute_default_service_account.default.email
+    }
+  }
+}
+
+resource "google_cloud_run_service_iam_member" "order_watcher_invoker" {
+  location = google_cloudfunctions2_function.notification-controller-order-watcher.location
+  service  = lower(google_cloudfunctions2_function.notification-controller-order-watcher.name)
+  role     = "roles/run.invoker"
+  member   = "serviceAccount:${data.google_compute_default_service_account.default.email}"
+}
+
+# this endpoint is not granted to allUsers; only the scheduler job below (and operators given roles/run.invoker)
+# may call it
+resource "google_cloud_run_service_iam_member" "scheduler_invoker" {
+  location = google_cloudfunctions2_function.notification-controller-notify-delayed.location
+  service  = lower(google_cloudfunctions2_function.notification-controller-notify-delayed.name)
+  role     = "roles/run.invoker"
+  member   = "serviceAccount:${data.google_compute_default_service_account.default.email}"
+}
+
+# each customer is only told once that their order is delayed, so it is safe to check often
+resource "google_cloud_scheduler_job" "notify_delayed" {
+  name        = "${var.fundraiser_id}-notify_delayed"
+  description = "Telling customers waiting longer than ${var.notification_delay_threshold} that their orders are delayed"
+
+  schedule = var.notify_delayed_schedule
+
+  retry_config {
+    retry_count = 1
+  }
+
+  http_target {
+    http_method = "POST"
+    uri         = google_cloudfunctions2_function.notification-controller-notify-delayed.service_config[0].uri
+
+    oidc_token {
+      service_account_email = data.google_compute_default_service_account.default.email
+      audience              = google_cloudfunctions2_function.notification-controller-notify-delayed.service_config[0].uri
+    }
+  }
+}
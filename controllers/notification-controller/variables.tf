@@ -0,0 +1,102 @@
+variable "gcp_project_id" {
+  type = string
+  validation {
+    condition     = length(var.gcp_project_id) > 0
+    error_message = "Must specify gcp_project_id variable."
+  }
+}
+
+variable "gcp_region" {
+  description = "The GCP region in which to create the various cloud resources"
+  type        = string
+}
+
+variable "gcs_function_source_bucket" {
+  description = "The name of the GCS bucket where this function's source code should be uploaded"
+  type        = string
+  validation {
+    condition     = length(var.gcs_function_source_bucket) > 0
+    error_message = "Must specify gcs_function_source_bucket variable."
+  }
+}
+
+variable "fundraiser_id" {
+  description = "The unique ID for the specific fundraiser; example is 'fishfry-022324'"
+  type        = string
+}
+
+variable "expiration_time" {
+  description = "The expiration value for all Firestore documents created for the fundraiser, as expressed in a UTC timestamp string in RFC 3339 format; example is '2024-02-25T00:00:00Z'"
+  type        = string
+}
+
+variable "fundraisers" {
+  description = "How Square objects are routed to the fundraisers served by this deployment, as YAML (see pkg/fundraiser); if empty, every object belongs to fundraiser_id"
+  type        = string
+  default     = ""
+}
+
+variable "min_instance_count" {
+  description = "The limit on the minimum number of function instances that may coexist at a given time"
+  type        = number
+  default     = 0
+}
+
+variable "order_events_topic" {
+  description = "The pubsub topic where internal order events are published"
+  type        = string
+}
+
+variable "pickup_instructions" {
+  description = "What customers are told about picking up their orders when they are ready"
+  type        = string
+  default     = ""
+}
+
+variable "notification_templates" {
+  description = "Replacements for the default templates of the notifications, as YAML keyed like pkg/notification.DEFAULT_TEMPLATES, e.g. 'ready.sms'"
+  type        = string
+  default     = ""
+}
+
+variable "notification_delay_threshold" {
+  description = "How long a customer waits for their order before they are told it is delayed, as a Go duration"
+  type        = string
+  default     = "20m"
+}
+
+variable "notify_delayed_schedule" {
+  description = "How often delayed orders are checked for, as expressed in unix cron format"
+  type        = string
+  default     = "*/5 * * * *"
+}
+
+variable "smtp_addr" {
+  description = "The host:port of the SMTP relay notifications are emailed through; if empty, no email is sent"
+  type        = string
+  default     = ""
+}
+
+variable "smtp_from" {
+  description = "The sender of notification emails, e.g. 'Fish Fry <fishfry@example.com>'"
+  type        = string
+  default     = ""
+}
+
+variable "smtp_username" {
+  description = "The username to authenticate with the SMTP relay as, with the password in the smtp_password secret; if empty, no authentication is used"
+  type        = string
+  default     = ""
+}
+
+variable "sms_url" {
+  description = "The URL of the SMS gateway notifications are texted through, authenticated with the token in the sms_token secret; if empty, no text is sent"
+  type        = string
+  default     = ""
+}
+
+variable "sms_from" {
+  description = "The number (or sender ID) notification texts are sent from"
+  type        = string
+  default     = ""
+}
@@ -2,304 +2,336 @@ package ordercontroller
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"strings"
 	"time"
 
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/pubsub"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
-	"google.golang.org/protobuf/proto"
 
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 	"github.com/cloudevents/sdk-go/v2/event"
 
-	"github.com/googleapis/google-cloudevents-go/cloud/firestoredata"
+	"github.com/kofc7186/fundraiser-manager/pkg/claimcheck"
+	"github.com/kofc7186/fundraiser-manager/pkg/config"
+	"github.com/kofc7186/fundraiser-manager/pkg/controller"
+	"github.com/kofc7186/fundraiser-manager/pkg/deadletter"
+	"github.com/kofc7186/fundraiser-manager/pkg/event/filter"
 	eventschemas "github.com/kofc7186/fundraiser-manager/pkg/event/schemas"
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
+	"github.com/kofc7186/fundraiser-manager/pkg/kitchen"
 	"github.com/kofc7186/fundraiser-manager/pkg/logging"
+	"github.com/kofc7186/fundraiser-manager/pkg/metrics"
+	"github.com/kofc7186/fundraiser-manager/pkg/ordernumber"
+	"github.com/kofc7186/fundraiser-manager/pkg/orders"
+	"github.com/kofc7186/fundraiser-manager/pkg/pickup"
 	squarewebhooktype "github.com/kofc7186/fundraiser-manager/pkg/square/types/webhooks"
+	"github.com/kofc7186/fundraiser-manager/pkg/tracing"
 	customerType "github.com/kofc7186/fundraiser-manager/pkg/types/customer"
+	fundraiserType "github.com/kofc7186/fundraiser-manager/pkg/types/fundraiser"
 	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
 	paymentType "github.com/kofc7186/fundraiser-manager/pkg/types/payment"
-	"github.com/kofc7186/fundraiser-manager/pkg/util"
 
 	_ "github.com/GoogleCloudPlatform/functions-framework-go/funcframework"
 )
 
+const FUNCTION_NAME = "order-controller"
+
+// the events each watcher handles, from which go generate writes the filters of their subscriptions
 const (
-	FUNCTION_NAME       = "order-controller"
-	PUBLISH_TIMEOUT_SEC = 2 * time.Second
+	customerWatcherFilter = "type IN ('org.kofc7186.fundraiserManager.customer.created', 'org.kofc7186.fundraiserManager.customer.updated')"
+	paymentWatcherFilter  = "type IN ('org.kofc7186.fundraiserManager.payment.created', 'org.kofc7186.fundraiserManager.payment.updated')"
 )
 
+//go:generate go run github.com/kofc7186/fundraiser-manager/cmd/subscription-filters
+
 var firestoreClient *firestore.Client
-var fundraiserDocPath string
-var orderDocPath string
+var router *fundraiser.Router
 
-var orderEventsTopic *pubsub.Topic
 var squareOrderRequestTopic *pubsub.Topic
 
-var expirationTime time.Time
+var orderNumbers *ordernumber.Allocator
+var orderWriter *controller.SquareWriter[orderType.Order, *orderType.Order]
+var orderPublisher *controller.CDCPublisher[orderType.Order]
 
 func init() {
 	slog.SetDefault(logging.FunctionLogger(FUNCTION_NAME))
 
-	psClient, err := pubsub.NewClient(context.Background(), util.GetEnvOrPanic("GCP_PROJECT"))
+	if err := tracing.Init(FUNCTION_NAME); err != nil {
+		panic(err)
+	}
+	if err := metrics.Init(FUNCTION_NAME); err != nil {
+		panic(err)
+	}
+	if err := deadletter.Init(); err != nil {
+		panic(err)
+	}
+	if err := eventschemas.Init(); err != nil {
+		panic(err)
+	}
+
+	var cfg struct {
+		config.Fundraiser
+		OrderEventsTopic        string        `env:"ORDER_EVENTS_TOPIC" validate:"required,topic"`
+		OrderNumberBlockSize    int           `env:"ORDER_NUMBER_BLOCK_SIZE" default:"10" validate:"positive"`
+		OrderNumberLeaseTTL     time.Duration `env:"ORDER_NUMBER_LEASE_TTL" default:"1m" validate:"positive"`
+		SquareOrderRequestTopic string        `env:"SQUARE_ORDER_REQUEST_TOPIC" validate:"required,topic"`
+	}
+	config.MustLoad(&cfg)
+
+	psClient, err := pubsub.NewClient(context.Background(), cfg.GCPProject)
 	if err != nil {
 		panic(err)
 	}
 
-	firestoreClient, err = firestore.NewClient(context.Background(), util.GetEnvOrPanic("GCP_PROJECT"))
+	firestoreClient, err = firestore.NewClient(context.Background(), cfg.GCPProject)
 	if err != nil {
 		panic(err)
 	}
 
-	ORDER_EVENTS_TOPIC := util.GetEnvOrPanic("ORDER_EVENTS_TOPIC")
-	orderEventsTopic = psClient.Topic(ORDER_EVENTS_TOPIC)
+	orderEventsTopic := psClient.Topic(cfg.OrderEventsTopic)
 	if ok, err := orderEventsTopic.Exists(context.Background()); !ok || err != nil {
-		panic(fmt.Sprintf("existence check for %s failed: %v", ORDER_EVENTS_TOPIC, err))
+		panic(fmt.Sprintf("existence check for %s failed: %v", cfg.OrderEventsTopic, err))
 	}
 
-	SQUARE_ORDER_REQUEST_TOPIC := util.GetEnvOrPanic("SQUARE_ORDER_REQUEST_TOPIC")
-	squareOrderRequestTopic = psClient.Topic(SQUARE_ORDER_REQUEST_TOPIC)
+	squareOrderRequestTopic = psClient.Topic(cfg.SquareOrderRequestTopic)
 	if ok, err := squareOrderRequestTopic.Exists(context.Background()); !ok || err != nil {
-		panic(fmt.Sprintf("existence check for %s failed: %v", SQUARE_ORDER_REQUEST_TOPIC, err))
+		panic(fmt.Sprintf("existence check for %s failed: %v", cfg.SquareOrderRequestTopic, err))
 	}
 
-	fundraiserDocPath = fmt.Sprintf("fundraisers/%s", util.GetEnvOrPanic("FUNDRAISER_ID"))
-	orderDocPath = fmt.Sprintf("%s/orders", fundraiserDocPath)
-
-	expirationTime, err = time.Parse(time.RFC3339, util.GetEnvOrPanic("EXPIRATION_TIME"))
+	router, err = fundraiser.Load()
 	if err != nil {
 		panic(err)
 	}
+	if err := router.Watch(context.Background(), firestoreClient); err != nil {
+		panic(err)
+	}
+
+	if err := metrics.ObserveOrdersByStatus(router.IDs(), countOrders); err != nil {
+		panic(err)
+	}
+
+	// order numbers are leased in blocks, so that orders don't all contend on the document of their fundraiser
+	orderNumbers = ordernumber.NewAllocator(fundraiser.NewStore(firestoreClient), cfg.OrderNumberBlockSize, cfg.OrderNumberLeaseTTL)
+	orderWriter = controller.NewSquareWriter[orderType.Order]("order", firestoreClient, "orders", router, orderDecoders)
+	orderWriter.OnCreate = assignOrderNumber
+	orderWriter.OnUpdate = keepOrderState
+	orderPublisher = &controller.CDCPublisher[orderType.Order]{
+		EntityName: "order",
+		Topic:      orderEventsTopic,
+		NewCreated: eventschemas.NewOrderCreated,
+		NewUpdated: eventschemas.NewOrderUpdated,
+		NewDeleted: eventschemas.NewOrderDeleted,
+		OnChange:   recordOrderMetrics,
+	}
 
 	// do this last so we are ensured to have all the required clients established above
-	functions.CloudEvent("ProcessSquareRetrieveOrderResponse", ProcessSquareRetrieveOrderResponse) // Square API responses are how we process order.created/order.updated webhooks
-	functions.CloudEvent("ProcessCDCEvent", ProcessCDCEvent)
-	functions.CloudEvent("CustomerWatcher", CustomerWatcher)
-	functions.CloudEvent("PaymentWatcher", PaymentWatcher)
+	functions.CloudEvent("ProcessSquareRetrieveOrderResponse", deadletter.CloudEvent("ProcessSquareRetrieveOrderResponse", tracing.CloudEvent("ProcessSquareRetrieveOrderResponse", metrics.CloudEvent("ProcessSquareRetrieveOrderResponse", claimcheck.CloudEvent(ProcessSquareRetrieveOrderResponse))))) // Square API responses are how we process order.created/order.updated webhooks
+	functions.CloudEvent("ProcessCDCEvent", deadletter.CloudEvent("ProcessCDCEvent", tracing.CloudEvent("ProcessCDCEvent", metrics.CloudEvent("ProcessCDCEvent", ProcessCDCEvent))))
+	functions.CloudEvent("CustomerWatcher", deadletter.CloudEvent("CustomerWatcher", filter.CloudEvent(customerWatcherFilter, tracing.CloudEvent("CustomerWatcher", metrics.CloudEvent("CustomerWatcher", CustomerWatcher)))))
+	functions.CloudEvent("PaymentWatcher", deadletter.CloudEvent("PaymentWatcher", filter.CloudEvent(paymentWatcherFilter, tracing.CloudEvent("PaymentWatcher", metrics.CloudEvent("PaymentWatcher", PaymentWatcher)))))
+	functions.HTTP("OrderAdmin", tracing.HTTP("OrderAdmin", metrics.HTTP("OrderAdmin", OrderAdmin)))
+	functions.HTTP("Kitchen", tracing.HTTP("Kitchen", metrics.HTTP("Kitchen", Kitchen)))
+	functions.HTTP("PickupBoard", tracing.HTTP("PickupBoard", metrics.HTTP("PickupBoard", PickupBoard)))
+	functions.HTTP("Pickup", tracing.HTTP("Pickup", metrics.HTTP("Pickup", Pickup)))
 }
 
 // ProcessOrderEvent
 func ProcessSquareRetrieveOrderResponse(ctx context.Context, e event.Event) error {
-	// there are two CloudEvents - one for the pubsub message "event", and then the data within
-	var msg eventschemas.MessagePublishedData
-	if err := e.DataAs(&msg); err != nil {
-		slog.ErrorContext(ctx, err.Error(), "event", e)
-		return err
-	}
-
-	nestedEvent := &event.Event{}
-	if err := nestedEvent.UnmarshalJSON(msg.Message.Data); err != nil {
-		return err
-	}
-
-	return writeSquareOrderToFirestore(ctx, nestedEvent)
+	return orderWriter.ProcessEvent(ctx, e)
 }
 
-type FundraiserDoc struct {
-	OrderNumber uint16 `json:"orderNumber" firestore:"orderNumber"`
+var orderDecoders = map[string]controller.Decoder[orderType.Order]{
+	eventschemas.SquareRetrieveOrderResponseType: func(ctx context.Context, e *event.Event) (*controller.Proposal[orderType.Order], error) {
+		sgoc := &eventschemas.SquareRetrieveOrderResponse{}
+		if err := eventschemas.DataAs(e, sgoc); err != nil {
+			return nil, failure.Poison(err)
+		}
+		return &controller.Proposal[orderType.Order]{
+			Entity:         sgoc.BaseOrder.Order,
+			IdempotencyKey: sgoc.BaseOrder.IdempotencyKey,
+			CreateOnly:     e.Source() == squarewebhooktype.SQUARE_WEBHOOK_ORDER_CREATED,
+		}, nil
+	},
 }
 
-func writeSquareOrderToFirestore(ctx context.Context, e *event.Event) error {
-	orderCreateRequest := false
-	attemptedWrite := false
+// assignOrderNumber numbers a newly written order
+func assignOrderNumber(ctx context.Context, tx *firestore.Transaction, proposedOrder *orderType.Order) error {
+	return numberOrder(ctx, proposedOrder)
+}
 
-	if e.Source() == squarewebhooktype.SQUARE_WEBHOOK_ORDER_CREATED {
-		orderCreateRequest = true
+// keepOrderState carries the fields maintained by fundraiser-manager, rather than Square, over to the revision of an
+// order replacing it, numbering orders which were written without a number
+func keepOrderState(ctx context.Context, tx *firestore.Transaction, persistedOrder, proposedOrder *orderType.Order) error {
+	proposedOrder.Expedite = persistedOrder.Expedite
+	proposedOrder.LabelIDs = persistedOrder.LabelIDs
+	proposedOrder.LabelRequestTime = persistedOrder.LabelRequestTime
+	proposedOrder.StaffNotes = persistedOrder.StaffNotes
+	proposedOrder.Status = persistedOrder.Status
+	proposedOrder.StatusTransitions = persistedOrder.StatusTransitions
+
+	if persistedOrder.Number == 0 {
+		return numberOrder(ctx, proposedOrder)
 	}
+	proposedOrder.Code = persistedOrder.Code
+	proposedOrder.Number = persistedOrder.Number
+	proposedOrder.OutsideWindow = persistedOrder.OutsideWindow
+	return nil
+}
 
-	var idempotencyKey string
-	var proposedOrder *orderType.Order
-	switch e.Type() {
-	case eventschemas.SquareRetrieveOrderResponseType:
-		sgoc := &eventschemas.SquareRetrieveOrderResponse{}
-		if err := e.DataAs(sgoc); err != nil {
-			return err
-		}
-		idempotencyKey = sgoc.BaseOrder.IdempotencyKey
-		proposedOrder = sgoc.BaseOrder.Order
-	default:
-		// TODO: slog
+// numberOrder allocates the next number (see fundraiserType.OrderNumbering) of the order's fundraiser, flagging or
+// refusing (per the fundraiser's WindowPolicy) an order created outside the fundraiser's window
+func numberOrder(ctx context.Context, o *orderType.Order) error {
+	// the number allocated by an earlier attempt of a retried transaction is kept
+	if o.Number != 0 {
 		return nil
 	}
 
-	// make sure to update the map to denote that we've processed this event already
-	//
-	// the boolean here is only to allow Firestore to map back to Go struct; the important
-	// thing is that the key is put into the map
-	proposedOrder.IdempotencyKeys = make(map[string]bool, 1)
-	if idempotencyKey != "" {
-		proposedOrder.IdempotencyKeys[idempotencyKey] = true
+	fundraiserID, ok := fundraiser.FromContext(ctx)
+	if !ok {
+		fundraiserID = router.Default
+	}
+	createdTime := o.CreatedTime
+	if createdTime.IsZero() {
+		createdTime = time.Now()
 	}
 
-	// ensure the firestore expiration timestamp is written in the appropriate field
-	proposedOrder.Expiration = expirationTime
+	f, orderNumber, err := orderNumbers.Allocate(ctx, fundraiserID, o.Source, createdTime)
+	if errors.Is(err, fundraiserType.ErrOrderNumbersExhausted) {
+		// the range must be widened before the order can be numbered
+		return failure.Permanent(err)
+	} else if err != nil {
+		return err
+	}
 
-	docRef := firestoreClient.Doc(fmt.Sprintf("%s/%s", orderDocPath, proposedOrder.ID))
-	transaction := func(ctx context.Context, tx *firestore.Transaction) error {
-		var orderNumber uint16 = 1000
-		docSnap, err := tx.Get(docRef)
-		if err != nil {
-			if status.Code(err) == codes.NotFound {
-				// order document doesn't yet exist, so just write it
-				fundraiserDocRef := firestoreClient.Doc(fundraiserDocPath)
-				fundraiserDocSnap, err := tx.Get(fundraiserDocRef)
-				if err != nil {
-					if status.Code(err) == codes.NotFound {
-						// this is extremely unlikely, but create it if it doesn't exist
-						if err := tx.Set(fundraiserDocRef, FundraiserDoc{OrderNumber: orderNumber}); err != nil {
-							return err
-						}
-					} else {
-						return err
-					}
-				} else {
-					fundraiserDoc := &FundraiserDoc{}
-					if err := fundraiserDocSnap.DataTo(&fundraiserDoc); err != nil {
-						return err
-					}
-					if err := tx.Update(fundraiserDocRef, []firestore.Update{{Path: "orderNumber", Value: firestore.Increment(1)}}); err != nil {
-						return err
-					}
-					orderNumber = fundraiserDoc.OrderNumber + 1
-				}
-				proposedOrder.Number = orderNumber
-				attemptedWrite = true
-				return tx.Set(docRef, proposedOrder)
-			}
-			// document exists but there was some error, bail
-			return err
+	if !f.InWindow(createdTime) {
+		if f.RefusesOutsideWindow() {
+			return failure.Permanent(fmt.Errorf("order created at %s is outside the window of fundraiser %s", createdTime, fundraiserID))
 		}
+		o.OutsideWindow = true
+		slog.WarnContext(ctx, fmt.Sprintf("order created at %s is outside the window of fundraiser %s", createdTime, fundraiserID))
+	}
 
-		if orderCreateRequest {
-			// if we get a create request and the doc already exists via an out of order event, just squelch it
-			// TODO: test this case
-			return nil
-		}
+	o.Code, o.Number = orderNumber.Code, orderNumber.Number
+	slog.DebugContext(logging.WithOrderNumber(ctx, o.Number), fmt.Sprintf("assigned order number %s", o.Code))
+	return nil
+}
 
-		// since the document already exists and we have an update event, let's make sure
-		// we really should update it
-		persistedOrder := &orderType.Order{}
-		if err := docSnap.DataTo(persistedOrder); err != nil {
-			return err
-		}
-		// search the map to see if we've observed the idempotency key before
-		if _, ok := persistedOrder.IdempotencyKeys[idempotencyKey]; ok {
-			// we've already processed this update from square, so ignore it
-			slog.DebugContext(ctx, "skipped duplicate event seen from Square", "idempotencyKey", idempotencyKey)
-			return nil
-		}
+// recordOrderMetrics tracks how long orders take to be labeled and ready
+func recordOrderMetrics(ctx context.Context, oldOrder, newOrder *orderType.Order) {
+	var oldStatus, newStatus orderType.OrderStatus
+	if oldOrder != nil {
+		oldStatus = oldOrder.Status
+	}
+	if newOrder != nil {
+		newStatus = newOrder.Status
+	}
+	if oldStatus == newStatus {
+		return
+	}
 
-		// check to see if this square event is out of order
-		if persistedOrder.SquareUpdatedTime.After(proposedOrder.SquareUpdatedTime) || persistedOrder.Version >= proposedOrder.Version {
-			// we've already processed a newer update from square, so ignore it
-			slog.DebugContext(ctx, "skipped out of order event seen from Square", "idempotencyKey", idempotencyKey)
-			return nil
+	switch newStatus {
+	case orderType.ORDER_STATUS_LABELED, orderType.ORDER_STATUS_READY:
+		if newOrder.CreatedTime.IsZero() {
+			return
 		}
-
-		// copy over idempotency keys from what we've seen before
-		for key, val := range persistedOrder.IdempotencyKeys {
-			proposedOrder.IdempotencyKeys[key] = val
+		reachedAt := time.Now()
+		if n := len(newOrder.StatusTransitions); n > 0 && newOrder.StatusTransitions[n-1].Status == newStatus {
+			reachedAt = newOrder.StatusTransitions[n-1].Timestamp
 		}
-
-		// TODO: handle field updates
-
-		// if we get here, we have a newer proposal for order so let's write it
-		attemptedWrite = true
-		return tx.Set(docRef, proposedOrder)
+		metrics.RecordOrderTimeToStatus(ctx, string(newStatus), reachedAt.Sub(newOrder.CreatedTime))
 	}
+}
 
-	if err := firestoreClient.RunTransaction(ctx, transaction); err != nil {
-		return err
+// countOrders counts the orders of the fundraiser in each status, for metrics.ObserveOrdersByStatus
+func countOrders(ctx context.Context, fundraiserID string) (map[string]int64, error) {
+	counts, err := orders.NewStore(firestoreClient, fundraiserID).CountByStatus(ctx)
+	if err != nil {
+		return nil, err
 	}
+	byStatus := make(map[string]int64, len(counts))
+	for orderStatus, n := range counts {
+		byStatus[string(orderStatus)] = n
+	}
+	return byStatus, nil
+}
 
-	// if we got here and attemptedWrite is true, then we wrote the document successfully
-	if attemptedWrite {
-		slog.InfoContext(ctx, fmt.Sprintf("order %v written at %v", docRef.ID, docRef.Path))
+// OrderAdmin serves the staff API (see orders.Handler) over the orders of the fundraiser named by the fundraiser query
+// parameter, or FUNDRAISER_ID if it is not given
+func OrderAdmin(w http.ResponseWriter, r *http.Request) {
+	fundraiserID, ok := requestedFundraiser(w, r)
+	if !ok {
+		return
 	}
-	return nil
+	r = r.WithContext(fundraiser.NewContext(r.Context(), fundraiserID))
+	orders.Handler(orders.NewStore(firestoreClient, fundraiserID))(w, r)
 }
 
-// ProcessCDCEvent generates internal domain events from changes to firestore orders collection
-func ProcessCDCEvent(ctx context.Context, e event.Event) error {
-	var data firestoredata.DocumentEventData
-	if err := proto.Unmarshal(e.Data(), &data); err != nil {
-		return fmt.Errorf("proto.Unmarshal: %w", err)
+// Kitchen serves the kitchen display (see kitchen.Handler) of the fundraiser named by the fundraiser query parameter,
+// or FUNDRAISER_ID if it is not given; orders bumped on the display are moved to READY as OrderAdmin would
+func Kitchen(w http.ResponseWriter, r *http.Request) {
+	fundraiserID, ok := requestedFundraiser(w, r)
+	if !ok {
+		return
 	}
+	r = r.WithContext(fundraiser.NewContext(r.Context(), fundraiserID))
+	bumper := kitchen.ServiceBumper{Service: orders.NewStore(firestoreClient, fundraiserID)}
+	kitchen.Handler(kitchen.NewSource(firestoreClient, fundraiserID), bumper)(w, r)
+}
 
-	var internalEvent *event.Event
-	if data.GetValue() == nil {
-		// the order document was deleted
-		order := &orderType.Order{}
-		err := util.ParseFirebaseDocument(data.OldValue, order)
-		if err != nil {
-			return err
-		}
-		internalEvent, err = eventschemas.NewOrderDeleted(order)
-		if err != nil {
-			return err
-		}
-	} else if data.GetOldValue() == nil {
-		// the order document was created
-		order := &orderType.Order{}
-		err := util.ParseFirebaseDocument(data.Value, order)
-		if err != nil {
-			return err
-		}
-		internalEvent, err = eventschemas.NewOrderCreated(order)
-		if err != nil {
-			return err
-		}
-	} else {
-		// the order document was updated
-		order := &orderType.Order{}
-		err := util.ParseFirebaseDocument(data.Value, order)
-		if err != nil {
-			return err
-		}
-		oldOrder := &orderType.Order{}
-		if err = util.ParseFirebaseDocument(data.OldValue, oldOrder); err != nil {
-			return err
-		}
-		internalEvent, err = eventschemas.NewOrderUpdated(oldOrder, order, data.UpdateMask.FieldPaths)
-		if err != nil {
-			return err
-		}
+// PickupBoard serves the public "now serving" board (see pickup.BoardHandler) of the fundraiser named by the fundraiser
+// query parameter, or FUNDRAISER_ID if it is not given
+func PickupBoard(w http.ResponseWriter, r *http.Request) {
+	fundraiserID, ok := requestedFundraiser(w, r)
+	if !ok {
+		return
 	}
+	r = r.WithContext(fundraiser.NewContext(r.Context(), fundraiserID))
+	pickup.BoardHandler(kitchen.NewSource(firestoreClient, fundraiserID))(w, r)
+}
 
-	eventJSON, err := internalEvent.MarshalJSON()
-	if err != nil {
-		return err
+// Pickup serves the confirmation of pickups (see pickup.Handler) for the fundraiser named by the fundraiser query
+// parameter, or FUNDRAISER_ID if it is not given
+func Pickup(w http.ResponseWriter, r *http.Request) {
+	fundraiserID, ok := requestedFundraiser(w, r)
+	if !ok {
+		return
 	}
-	timeoutContext, cancel := context.WithTimeout(context.Background(), PUBLISH_TIMEOUT_SEC)
-	defer cancel()
+	r = r.WithContext(fundraiser.NewContext(r.Context(), fundraiserID))
+	pickup.Handler(pickup.NewStore(firestoreClient, fundraiserID))(w, r)
+}
 
-	publishResult := orderEventsTopic.Publish(timeoutContext, &pubsub.Message{Data: eventJSON})
-	messageID, err := publishResult.Get(timeoutContext) // this call blocks until complete or timeout occurs
-	if err != nil {
-		return err
+// requestedFundraiser returns the fundraiser named by the fundraiser query parameter of r, or FUNDRAISER_ID if it is
+// not given; if the name is not valid, a 400 is written and false is returned
+func requestedFundraiser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	fundraiserID := r.URL.Query().Get("fundraiser")
+	if fundraiserID == "" {
+		return router.Default, true
 	}
-	slog.InfoContext(ctx, fmt.Sprintf("published %s", internalEvent.Type()), "messageID", messageID, "orderID", internalEvent.Subject())
-	return nil
+	if err := config.Check("fundraiserID", fundraiserID); err != nil {
+		http.Error(w, fmt.Sprintf("fundraiser: %v", err), http.StatusBadRequest)
+		return "", false
+	}
+	return fundraiserID, true
+}
+
+// ProcessCDCEvent generates internal domain events from changes to firestore orders collection
+func ProcessCDCEvent(ctx context.Context, e event.Event) error {
+	return orderPublisher.ProcessCDCEvent(ctx, e)
 }
 
 // PaymentWatcher updates relevant order objects based on observed payment events
 func PaymentWatcher(ctx context.Context, e event.Event) error {
-	// there are two CloudEvents - one for the pubsub message "event", and then the data within
-	var msg eventschemas.MessagePublishedData
-	if err := e.DataAs(&msg); err != nil {
+	nestedEvent, err := eventschemas.NestedEvent(e)
+	if err != nil {
 		slog.ErrorContext(ctx, err.Error(), "event", e)
 		return err
 	}
 
-	nestedEvent := &event.Event{}
-	if err := nestedEvent.UnmarshalJSON(msg.Message.Data); err != nil {
-		return err
-	}
-
 	var idempotencyKey string
 	var paymentToProcess *paymentType.Payment
 	var fieldsInPaymentUpdate []string
@@ -307,17 +339,17 @@ func PaymentWatcher(ctx context.Context, e event.Event) error {
 	switch nestedEvent.Type() {
 	case eventschemas.PaymentCreatedType:
 		pc := &eventschemas.PaymentCreated{}
-		if err := nestedEvent.DataAs(pc); err != nil {
+		if err := eventschemas.DataAs(nestedEvent, pc); err != nil {
 			slog.ErrorContext(ctx, err.Error(), "event", nestedEvent)
-			return err
+			return failure.Poison(err)
 		}
 		idempotencyKey = pc.IdempotencyKey
 		paymentToProcess = pc.Payment
 	case eventschemas.PaymentUpdatedType:
 		pu := &eventschemas.PaymentUpdated{}
-		if err := nestedEvent.DataAs(pu); err != nil {
+		if err := eventschemas.DataAs(nestedEvent, pu); err != nil {
 			slog.ErrorContext(ctx, err.Error(), "event", nestedEvent)
-			return err
+			return failure.Poison(err)
 		}
 		idempotencyKey = pu.IdempotencyKey
 		paymentToProcess = pu.Payment
@@ -327,7 +359,12 @@ func PaymentWatcher(ctx context.Context, e event.Event) error {
 		return nil
 	}
 
-	docs := firestoreClient.Collection(orderDocPath).Where("squarePaymentID", "==", paymentToProcess.ID)
+	fundraiserID := router.Of(nestedEvent)
+	ctx = fundraiser.NewContext(ctx, fundraiserID)
+	ctx = logging.WithLabel(ctx, logging.LabelPaymentID, paymentToProcess.ID)
+	ctx = logging.WithLabel(ctx, logging.LabelOrderID, paymentToProcess.SquareOrderID)
+
+	docs := firestoreClient.Collection(fundraiser.Path(fundraiserID, "orders")).Where("squarePaymentID", "==", paymentToProcess.ID)
 	return firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
 		docSnaps, err := tx.Documents(docs).GetAll()
 		if err != nil {
@@ -337,25 +374,17 @@ func PaymentWatcher(ctx context.Context, e event.Event) error {
 		if len(docSnaps) == 0 {
 			// there is no order object for the payment we just saw, we should request it via Square API
 			if paymentToProcess.SquareOrderID == "" {
-				slog.InfoContext(ctx, "no order ID for payment event", "paymentID", paymentToProcess.ID)
+				slog.InfoContext(ctx, "no order ID for payment event")
 				return nil
 			}
 			getOrderEvent := eventschemas.NewSquareRetrieveOrderRequest(paymentToProcess.SquareOrderID)
-			eventJSON, err := getOrderEvent.MarshalJSON()
-			if err != nil {
-				slog.ErrorContext(ctx, err.Error(), "event", nestedEvent)
-				return err
-			}
-			timeoutContext, cancel := context.WithTimeout(context.Background(), PUBLISH_TIMEOUT_SEC)
-			defer cancel()
-
-			publishResult := squareOrderRequestTopic.Publish(timeoutContext, &pubsub.Message{Data: eventJSON})
-			messageID, err := publishResult.Get(timeoutContext) // this call blocks until complete or timeout occurs
+			fundraiser.Set(getOrderEvent, fundraiserID)
+			messageID, err := controller.Publish(ctx, squareOrderRequestTopic, getOrderEvent)
 			if err != nil {
 				slog.ErrorContext(ctx, err.Error(), "event", nestedEvent)
 				return err
 			}
-			slog.InfoContext(ctx, "published SquareRetrieveOrderRequest during payment processing", "messageID", messageID, "orderID", paymentToProcess.SquareOrderID)
+			slog.InfoContext(ctx, "published SquareRetrieveOrderRequest during payment processing", "messageID", messageID)
 
 			// TODO: write order with fields from payment
 			pendingOrder, err := orderType.CreateOrderFromPayment(*paymentToProcess)
@@ -363,7 +392,19 @@ func PaymentWatcher(ctx context.Context, e event.Event) error {
 				slog.DebugContext(ctx, fmt.Sprintf("order could not be created from payment: %v", err), "event", nestedEvent)
 				return nil
 			}
-			return tx.Set(firestoreClient.Doc(fmt.Sprintf("%s/%s", orderDocPath, pendingOrder.ID)), pendingOrder)
+			// like the orders the writer creates, the placeholder expires with the fundraiser and records the payment
+			// event, so that redeliveries of it are recognized below as already processed
+			if idempotencyKey != "" {
+				pendingOrder.SetIdempotencyKeys(map[string]bool{idempotencyKey: true})
+			}
+			pendingOrder.SetExpiration(router.Expiration(fundraiserID))
+			pendingOrder.SetTraceParent(tracing.TraceParent(ctx))
+			// number the order now, as it may be picked up before Square tells us about it
+			if err := numberOrder(ctx, pendingOrder); err != nil {
+				slog.ErrorContext(ctx, err.Error(), "event", nestedEvent)
+				return err
+			}
+			return tx.Set(firestoreClient.Doc(fundraiser.Path(fundraiserID, "orders", pendingOrder.ID)), pendingOrder)
 		}
 		for _, docSnap := range docSnaps {
 			// if we're here, we have updated payment information for a valid order
@@ -373,8 +414,10 @@ func PaymentWatcher(ctx context.Context, e event.Event) error {
 				continue
 			}
 
+			ctx := logging.WithOrderNumber(logging.WithLabel(ctx, logging.LabelOrderID, order.ID), order.Number)
+
 			if _, ok := order.IdempotencyKeys[idempotencyKey]; ok {
-				slog.DebugContext(ctx, "already processed update for this payment", "idempotencyKey", idempotencyKey, "orderID", order.ID, "paymentID", paymentToProcess.ID)
+				slog.DebugContext(ctx, "already processed update for this payment", "idempotencyKey", idempotencyKey)
 				continue
 			}
 
@@ -455,7 +498,7 @@ func PaymentWatcher(ctx context.Context, e event.Event) error {
 				slog.ErrorContext(ctx, "failed to update order with new customer info", "error", err)
 				continue // we quietly continue here so as to not fail the entire txn
 			}
-			slog.DebugContext(ctx, "updated order with new customer info", "orderID", order.ID)
+			slog.DebugContext(ctx, "updated order with new customer info")
 		}
 		return nil
 	})
@@ -463,15 +506,9 @@ func PaymentWatcher(ctx context.Context, e event.Event) error {
 
 // CustomerWatcher updates relevant order objects based on observed customer events
 func CustomerWatcher(ctx context.Context, e event.Event) error {
-	// there are two CloudEvents - one for the pubsub message "event", and then the data within
-	var msg eventschemas.MessagePublishedData
-	if err := e.DataAs(&msg); err != nil {
-		slog.Error(err.Error(), "event", e)
-		return err
-	}
-
-	nestedEvent := &event.Event{}
-	if err := nestedEvent.UnmarshalJSON(msg.Message.Data); err != nil {
+	nestedEvent, err := eventschemas.NestedEvent(e)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error(), "event", e)
 		return err
 	}
 
@@ -482,15 +519,15 @@ func CustomerWatcher(ctx context.Context, e event.Event) error {
 	switch nestedEvent.Type() {
 	case eventschemas.CustomerCreatedType:
 		rc := &eventschemas.CustomerCreated{}
-		if err := nestedEvent.DataAs(rc); err != nil {
-			return err
+		if err := eventschemas.DataAs(nestedEvent, rc); err != nil {
+			return failure.Poison(err)
 		}
 		idempotencyKey = rc.IdempotencyKey
 		customerToProcess = rc.Customer
 	case eventschemas.CustomerUpdatedType:
 		ru := &eventschemas.CustomerUpdated{}
-		if err := nestedEvent.DataAs(ru); err != nil {
-			return err
+		if err := eventschemas.DataAs(nestedEvent, ru); err != nil {
+			return failure.Poison(err)
 		}
 		idempotencyKey = ru.IdempotencyKey
 		customerToProcess = ru.Customer
@@ -500,14 +537,18 @@ func CustomerWatcher(ctx context.Context, e event.Event) error {
 		return nil
 	}
 
-	docs := firestoreClient.Collection(orderDocPath).Where("squareCustomerID", "==", customerToProcess.ID)
+	fundraiserID := router.Of(nestedEvent)
+	ctx = fundraiser.NewContext(ctx, fundraiserID)
+	ctx = logging.WithLabel(ctx, logging.LabelCustomerID, customerToProcess.ID)
+
+	docs := firestoreClient.Collection(fundraiser.Path(fundraiserID, "orders")).Where("squareCustomerID", "==", customerToProcess.ID)
 	return firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
 		orderSnaps, err := tx.Documents(docs).GetAll()
 		if err != nil {
 			return err
 		}
 		if len(orderSnaps) == 0 {
-			slog.DebugContext(ctx, "no orders were found to be updated via customerID", "customerID", customerToProcess.ID)
+			slog.DebugContext(ctx, "no orders were found to be updated via customerID")
 		}
 		for _, orderSnap := range orderSnaps {
 			// if we're here, we have updated customer information for a valid order
@@ -517,6 +558,8 @@ func CustomerWatcher(ctx context.Context, e event.Event) error {
 				continue
 			}
 
+			ctx := logging.WithOrderNumber(logging.WithLabel(ctx, logging.LabelOrderID, order.ID), order.Number)
+
 			if _, ok := order.IdempotencyKeys[idempotencyKey]; ok {
 				slog.DebugContext(ctx, "already processed update for this order", "idempotencyKey", idempotencyKey)
 				continue
@@ -580,7 +623,7 @@ func CustomerWatcher(ctx context.Context, e event.Event) error {
 				slog.ErrorContext(ctx, "failed to update order with new customer info", "error", err)
 				continue // we quietly continue here so as to not fail the entire txn
 			}
-			slog.DebugContext(ctx, "updated order with new customer info", "orderID", order.ID, "updates", updates)
+			slog.DebugContext(ctx, "updated order with new customer info", "updates", updates)
 		}
 		return nil
 	})
@@ -14,6 +14,8 @@ locals {
 resource "google_project_service" "service" {
   for_each = toset([
     "run.googleapis.com",
+    "cloudtrace.googleapis.com",
+    "monitoring.googleapis.com",
     "eventarc.googleapis.com",
     "storage.googleapis.com",
     "pubsub.googleapis.com",
@@ -83,7 +85,9 @@ resource "google_cloudfunctions2_function" "order-controller-square-order-respon
       GCP_PROJECT                = var.gcp_project_id
       EXPIRATION_TIME            = var.expiration_time
       FUNDRAISER_ID              = var.fundraiser_id
+      FUNDRAISERS                = var.fundraisers
       ORDER_EVENTS_TOPIC         = var.order_events_topic
+      ORDER_NUMBER_BLOCK_SIZE    = var.order_number_block_size
       SQUARE_ORDER_REQUEST_TOPIC = var.square_order_request_topic
     }
   }
@@ -120,17 +124,12 @@ resource "google_cloudfunctions2_function" "customer-watcher" {
       GCP_PROJECT                = var.gcp_project_id
       EXPIRATION_TIME            = var.expiration_time
       FUNDRAISER_ID              = var.fundraiser_id
+      FUNDRAISERS                = var.fundraisers
       ORDER_EVENTS_TOPIC         = var.order_events_topic
+      ORDER_NUMBER_BLOCK_SIZE    = var.order_number_block_size
       SQUARE_ORDER_REQUEST_TOPIC = var.square_order_request_topic
     }
   }
-
-  event_trigger {
-    trigger_region = var.gcp_region
-    event_type     = "google.cloud.pubsub.topic.v1.messagePublished"
-    pubsub_topic   = "projects/${var.gcp_project_id}/topics/${var.customer_events_topic}"
-    retry_policy   = "RETRY_POLICY_RETRY"
-  }
 }
 
 resource "google_cloudfunctions2_function" "payment-watcher" {
@@ -157,15 +156,249 @@ resource "google_cloudfunctions2_function" "payment-watcher" {
       GCP_PROJECT                = var.gcp_project_id
       EXPIRATION_TIME            = var.expiration_time
       FUNDRAISER_ID              = var.fundraiser_id
+      FUNDRAISERS                = var.fundraisers
       ORDER_EVENTS_TOPIC         = var.order_events_topic
+      ORDER_NUMBER_BLOCK_SIZE    = var.order_number_block_size
       SQUARE_ORDER_REQUEST_TOPIC = var.square_order_request_topic
     }
   }
+}
 
-  event_trigger {
-    trigger_region = var.gcp_region
-    event_type     = "google.cloud.pubsub.topic.v1.messagePublished"
-    pubsub_topic   = "projects/${var.gcp_project_id}/topics/${var.payment_events_topic}"
-    retry_policy   = "RETRY_POLICY_RETRY"
+resource "google_cloudfunctions2_function" "order-admin" {
+  name     = "${local.function_group}-${var.fundraiser_id}-order-admin"
+  location = var.gcp_region
+
+  build_config {
+    runtime     = "go121"
+    entry_point = "OrderAdmin"
+    source {
+      storage_source {
+        bucket = var.gcs_function_source_bucket
+        object = google_storage_bucket_object.function_source_object.name
+      }
+    }
+  }
+
+  service_config {
+    available_memory   = "128Mi"
+    timeout_seconds    = 60
+    min_instance_count = var.min_instance_count
+
+    environment_variables = {
+      GCP_PROJECT                = var.gcp_project_id
+      EXPIRATION_TIME            = var.expiration_time
+      FUNDRAISER_ID              = var.fundraiser_id
+      FUNDRAISERS                = var.fundraisers
+      ORDER_EVENTS_TOPIC         = var.order_events_topic
+      ORDER_NUMBER_BLOCK_SIZE    = var.order_number_block_size
+      SQUARE_ORDER_REQUEST_TOPIC = var.square_order_request_topic
+    }
+  }
+}
+
+# like dead-letter-controller, this endpoint is not granted to allUsers; staff must be given
+# roles/run.invoker on the service and call it with an identity token, e.g.
+#   curl -H "Authorization: Bearer $(gcloud auth print-identity-token)" "<uri>/orders?status=READY"
+output "order_admin_uri" {
+  value = google_cloudfunctions2_function.order-admin.service_config[0].uri
+}
+
+resource "google_cloudfunctions2_function" "kitchen" {
+  name     = "${local.function_group}-${var.fundraiser_id}-kitchen"
+  location = var.gcp_region
+
+  build_config {
+    runtime     = "go121"
+    entry_point = "Kitchen"
+    source {
+      storage_source {
+        bucket = var.gcs_function_source_bucket
+        object = google_storage_bucket_object.function_source_object.name
+      }
+    }
+  }
+
+  service_config {
+    available_memory = "256Mi"
+    available_cpu    = "1"
+    # each display holds its event stream open until the timeout, then reconnects; many displays share an instance
+    timeout_seconds                  = 3600
+    max_instance_request_concurrency = 40
+    min_instance_count               = var.min_instance_count
+
+    environment_variables = {
+      GCP_PROJECT                = var.gcp_project_id
+      EXPIRATION_TIME            = var.expiration_time
+      FUNDRAISER_ID              = var.fundraiser_id
+      FUNDRAISERS                = var.fundraisers
+      ORDER_EVENTS_TOPIC         = var.order_events_topic
+      ORDER_NUMBER_BLOCK_SIZE    = var.order_number_block_size
+      SQUARE_ORDER_REQUEST_TOPIC = var.square_order_request_topic
+    }
+  }
+}
+
+# like order-admin, the kitchen display is only served to callers granted roles/run.invoker, e.g. through an
+# identity-aware proxy in front of the screens in the kitchen
+output "kitchen_uri" {
+  value = google_cloudfunctions2_function.kitchen.service_config[0].uri
+}
+
+resource "google_cloudfunctions2_function" "pickup-board" {
+  name     = "${local.function_group}-${var.fundraiser_id}-pickup-board"
+  location = var.gcp_region
+
+  build_config {
+    runtime     = "go121"
+    entry_point = "PickupBoard"
+    source {
+      storage_source {
+        bucket = var.gcs_function_source_bucket
+        object = google_storage_bucket_object.function_source_object.name
+      }
+    }
+  }
+
+  service_config {
+    available_memory = "256Mi"
+    available_cpu    = "1"
+    # like the kitchen display, each board holds its event stream open until the timeout, then reconnects
+    timeout_seconds                  = 3600
+    max_instance_request_concurrency = 40
+    min_instance_count               = var.min_instance_count
+
+    environment_variables = {
+      GCP_PROJECT                = var.gcp_project_id
+      EXPIRATION_TIME            = var.expiration_time
+      FUNDRAISER_ID              = var.fundraiser_id
+      FUNDRAISERS                = var.fundraisers
+      ORDER_EVENTS_TOPIC         = var.order_events_topic
+      ORDER_NUMBER_BLOCK_SIZE    = var.order_number_block_size
+      SQUARE_ORDER_REQUEST_TOPIC = var.square_order_request_topic
+    }
+  }
+}
+
+# the board only shows the numbers of the orders which are ready, so it is served to allUsers for the screens facing
+# the customers
+resource "google_cloud_run_service_iam_member" "pickup_board_invoker" {
+  location = google_cloudfunctions2_function.pickup-board.location
+  service  = lower(google_cloudfunctions2_function.pickup-board.name)
+  role     = "roles/run.invoker"
+  member   = "allUsers"
+}
+
+output "pickup_board_uri" {
+  value = google_cloudfunctions2_function.pickup-board.service_config[0].uri
+}
+
+resource "google_cloudfunctions2_function" "pickup" {
+  name     = "${local.function_group}-${var.fundraiser_id}-pickup"
+  location = var.gcp_region
+
+  build_config {
+    runtime     = "go121"
+    entry_point = "Pickup"
+    source {
+      storage_source {
+        bucket = var.gcs_function_source_bucket
+        object = google_storage_bucket_object.function_source_object.name
+      }
+    }
   }
+
+  service_config {
+    available_memory   = "128Mi"
+    timeout_seconds    = 60
+    min_instance_count = var.min_instance_count
+
+    environment_variables = {
+      GCP_PROJECT                = var.gcp_project_id
+      EXPIRATION_TIME            = var.expiration_time
+      FUNDRAISER_ID              = var.fundraiser_id
+      FUNDRAISERS                = var.fundraisers
+      ORDER_EVENTS_TOPIC         = var.order_events_topic
+      ORDER_NUMBER_BLOCK_SIZE    = var.order_number_block_size
+      SQUARE_ORDER_REQUEST_TOPIC = var.square_order_request_topic
+    }
+  }
+}
+
+# like order-admin, confirming pickups is only served to staff granted roles/run.invoker, as it closes orders
+output "pickup_uri" {
+  value = google_cloudfunctions2_function.pickup.service_config[0].uri
+}
+
+# the watchers below are subscribed to their topics with push subscriptions rather than event triggers, as only the
+# former can be filtered; their filters are generated from the handlers' declarations into subscription_filters.tf,
+# and the functions framework turns each pushed message into the CloudEvent an event trigger would deliver, taking
+# the topic from the path of the endpoint
+
+# Pub/Sub pushes to the watchers as the default compute service account
+data "google_compute_default_service_account" "default" {
+  project = var.gcp_project_id
+}
+
+resource "google_pubsub_subscription" "customer_watcher" {
+  name   = google_cloudfunctions2_function.customer-watcher.name
+  topic  = var.customer_events_topic
+  filter = local.subscription_filters["CustomerWatcher"]
+
+  ack_deadline_seconds = google_cloudfunctions2_function.customer-watcher.service_config[0].timeout_seconds
+
+  retry_policy {
+    minimum_backoff = "10s"
+    maximum_backoff = "600s"
+  }
+
+  expiration_policy {
+    ttl = ""
+  }
+
+  push_config {
+    push_endpoint = "${google_cloudfunctions2_function.customer-watcher.service_config[0].uri}/projects/${var.gcp_project_id}/topics/${var.customer_events_topic}"
+
+    oidc_token {
+      service_account_email = data.google_compute_default_service_account.default.email
+    }
+  }
+}
+
+resource "google_cloud_run_service_iam_member" "customer_watcher_invoker" {
+  location = google_cloudfunctions2_function.customer-watcher.location
+  service  = lower(google_cloudfunctions2_function.customer-watcher.name)
+  role     = "roles/run.invoker"
+  member   = "serviceAccount:${data.google_compute_default_service_account.default.email}"
+}
+
+resource "google_pubsub_subscription" "payment_watcher" {
+  name   = google_cloudfunctions2_function.payment-watcher.name
+  topic  = var.payment_events_topic
+  filter = local.subscription_filters["PaymentWatcher"]
+
+  ack_deadline_seconds = google_cloudfunctions2_function.payment-watcher.service_config[0].timeout_seconds
+
+  retry_policy {
+    minimum_backoff = "10s"
+    maximum_backoff = "600s"
+  }
+
+  expiration_policy {
+    ttl = ""
+  }
+
+  push_config {
+    push_endpoint = "${google_cloudfunctions2_function.payment-watcher.service_config[0].uri}/projects/${var.gcp_project_id}/topics/${var.payment_events_topic}"
+
+    oidc_token {
+      service_account_email = data.google_compute_default_service_account.default.email
+    }
+  }
+}
+
+resource "google_cloud_run_service_iam_member" "payment_watcher_invoker" {
+  location = google_cloudfunctions2_function.payment-watcher.location
+  service  = lower(google_cloudfunctions2_function.payment-watcher.name)
+  role     = "roles/run.invoker"
+  member   = "serviceAccount:${data.google_compute_default_service_account.default.email}"
 }
@@ -30,6 +30,12 @@ variable "expiration_time" {
   type        = string
 }
 
+variable "fundraisers" {
+  description = "How Square objects are routed to the fundraisers served by this deployment, as YAML (see pkg/fundraiser); if empty, every object belongs to fundraiser_id"
+  type        = string
+  default     = ""
+}
+
 variable "min_instance_count" {
   description = "The limit on the minimum number of function instances that may coexist at a given time"
   type        = number
@@ -51,6 +57,12 @@ variable "order_events_topic" {
   type        = string
 }
 
+variable "order_number_block_size" {
+  description = "How many order numbers each function instance leases at a time; larger blocks contend less on the fundraiser document, but leave larger gaps in the numbering"
+  type        = number
+  default     = 10
+}
+
 variable "square_order_request_topic" {
   description = "The pubsub topic where Square order requests are published"
   type        = string
@@ -5,294 +5,146 @@ import (
 	"fmt"
 	"log/slog"
 	"slices"
-	"time"
 
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/pubsub"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"google.golang.org/protobuf/proto"
 
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 	"github.com/cloudevents/sdk-go/v2/event"
-	"github.com/googleapis/google-cloudevents-go/cloud/firestoredata"
 
+	"github.com/kofc7186/fundraiser-manager/pkg/claimcheck"
+	"github.com/kofc7186/fundraiser-manager/pkg/config"
+	"github.com/kofc7186/fundraiser-manager/pkg/controller"
+	"github.com/kofc7186/fundraiser-manager/pkg/deadletter"
 	eventschemas "github.com/kofc7186/fundraiser-manager/pkg/event/schemas"
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
 	"github.com/kofc7186/fundraiser-manager/pkg/logging"
+	"github.com/kofc7186/fundraiser-manager/pkg/metrics"
+	"github.com/kofc7186/fundraiser-manager/pkg/tracing"
 	paymentType "github.com/kofc7186/fundraiser-manager/pkg/types/payment"
 	refundType "github.com/kofc7186/fundraiser-manager/pkg/types/refund"
-	"github.com/kofc7186/fundraiser-manager/pkg/util"
 
 	_ "github.com/GoogleCloudPlatform/functions-framework-go/funcframework"
 )
 
-const (
-	FUNCTION_NAME       = "payment-controller"
-	PUBLISH_TIMEOUT_SEC = 2 * time.Second
-)
+const FUNCTION_NAME = "payment-controller"
 
 var firestoreClient *firestore.Client
-var paymentDocPath string
+var router *fundraiser.Router
 
-var paymentEventsTopic *pubsub.Topic
 var squarePaymentRequestTopic *pubsub.Topic
 
-var expirationTime time.Time
+var paymentWriter *controller.SquareWriter[paymentType.Payment, *paymentType.Payment]
+var paymentPublisher *controller.CDCPublisher[paymentType.Payment]
 
 func init() {
 	slog.SetDefault(logging.FunctionLogger(FUNCTION_NAME))
 
-	psClient, err := pubsub.NewClient(context.Background(), util.GetEnvOrPanic("GCP_PROJECT"))
+	if err := tracing.Init(FUNCTION_NAME); err != nil {
+		panic(err)
+	}
+	if err := metrics.Init(FUNCTION_NAME); err != nil {
+		panic(err)
+	}
+	if err := deadletter.Init(); err != nil {
+		panic(err)
+	}
+	if err := eventschemas.Init(); err != nil {
+		panic(err)
+	}
+
+	var cfg struct {
+		config.Fundraiser
+		PaymentEventsTopic        string `env:"PAYMENT_EVENTS_TOPIC" validate:"required,topic"`
+		SquarePaymentRequestTopic string `env:"SQUARE_PAYMENT_REQUEST_TOPIC" validate:"required,topic"`
+	}
+	config.MustLoad(&cfg)
+
+	psClient, err := pubsub.NewClient(context.Background(), cfg.GCPProject)
 	if err != nil {
 		panic(err)
 	}
 
-	PAYMENT_EVENTS_TOPIC := util.GetEnvOrPanic("PAYMENT_EVENTS_TOPIC")
-	paymentEventsTopic = psClient.Topic(PAYMENT_EVENTS_TOPIC)
+	paymentEventsTopic := psClient.Topic(cfg.PaymentEventsTopic)
 	if ok, err := paymentEventsTopic.Exists(context.Background()); !ok || err != nil {
-		panic(fmt.Sprintf("existence check for %s failed: %v", PAYMENT_EVENTS_TOPIC, err))
+		panic(fmt.Sprintf("existence check for %s failed: %v", cfg.PaymentEventsTopic, err))
 	}
 
-	SQUARE_PAYMENT_REQUEST_TOPIC := util.GetEnvOrPanic("SQUARE_PAYMENT_REQUEST_TOPIC")
-	squarePaymentRequestTopic = psClient.Topic(SQUARE_PAYMENT_REQUEST_TOPIC)
+	squarePaymentRequestTopic = psClient.Topic(cfg.SquarePaymentRequestTopic)
 	if ok, err := squarePaymentRequestTopic.Exists(context.Background()); !ok || err != nil {
-		panic(fmt.Sprintf("existence check for %s failed: %v", SQUARE_PAYMENT_REQUEST_TOPIC, err))
+		panic(fmt.Sprintf("existence check for %s failed: %v", cfg.SquarePaymentRequestTopic, err))
 	}
 
-	firestoreClient, err = firestore.NewClient(context.Background(), util.GetEnvOrPanic("GCP_PROJECT"))
+	firestoreClient, err = firestore.NewClient(context.Background(), cfg.GCPProject)
 	if err != nil {
 		panic(err)
 	}
 
-	paymentDocPath = fmt.Sprintf("fundraisers/%s/payments", util.GetEnvOrPanic("FUNDRAISER_ID"))
-
-	expirationTime, err = time.Parse(time.RFC3339, util.GetEnvOrPanic("EXPIRATION_TIME"))
+	router, err = fundraiser.Load()
 	if err != nil {
 		panic(err)
 	}
+	if err := router.Watch(context.Background(), firestoreClient); err != nil {
+		panic(err)
+	}
+
+	paymentWriter = controller.NewSquareWriter[paymentType.Payment]("payment", firestoreClient, "payments", router, paymentDecoders)
+	paymentPublisher = &controller.CDCPublisher[paymentType.Payment]{
+		EntityName: "payment",
+		Topic:      paymentEventsTopic,
+		NewCreated: eventschemas.NewPaymentCreated,
+		NewUpdated: eventschemas.NewPaymentUpdated,
+		NewDeleted: eventschemas.NewPaymentDeleted,
+	}
 
 	// do this last so we are ensured to have all the required clients established above
-	functions.CloudEvent("ProcessSquarePaymentWebhookEvent", ProcessSquarePaymentWebhookEvent)
-	functions.CloudEvent("ProcessSquarePaymentResponse", ProcessSquarePaymentWebhookEvent) // Square API responses just get written like inbound webhooks
-	functions.CloudEvent("ProcessCDCEvent", ProcessCDCEvent)
-	functions.CloudEvent("RefundWatcher", RefundWatcher)
+	functions.CloudEvent("ProcessSquarePaymentWebhookEvent", deadletter.CloudEvent("ProcessSquarePaymentWebhookEvent", tracing.CloudEvent("ProcessSquarePaymentWebhookEvent", metrics.CloudEvent("ProcessSquarePaymentWebhookEvent", claimcheck.CloudEvent(ProcessSquarePaymentWebhookEvent)))))
+	functions.CloudEvent("ProcessSquarePaymentResponse", deadletter.CloudEvent("ProcessSquarePaymentResponse", tracing.CloudEvent("ProcessSquarePaymentResponse", metrics.CloudEvent("ProcessSquarePaymentResponse", claimcheck.CloudEvent(ProcessSquarePaymentWebhookEvent))))) // Square API responses just get written like inbound webhooks
+	functions.CloudEvent("ProcessCDCEvent", deadletter.CloudEvent("ProcessCDCEvent", tracing.CloudEvent("ProcessCDCEvent", metrics.CloudEvent("ProcessCDCEvent", ProcessCDCEvent))))
+	functions.CloudEvent("RefundWatcher", deadletter.CloudEvent("RefundWatcher", tracing.CloudEvent("RefundWatcher", metrics.CloudEvent("RefundWatcher", RefundWatcher))))
 }
 
 // ProcessSquarePaymentWebhookEvent
 func ProcessSquarePaymentWebhookEvent(ctx context.Context, e event.Event) error {
-	// there are two CloudEvents - one for the pubsub message "event", and then the data within
-	var msg eventschemas.MessagePublishedData
-	if err := e.DataAs(&msg); err != nil {
-		slog.ErrorContext(ctx, err.Error(), "event", e)
-		return err
-	}
-
-	nestedEvent := &event.Event{}
-	if err := nestedEvent.UnmarshalJSON(msg.Message.Data); err != nil {
-		slog.ErrorContext(ctx, err.Error(), "event", e)
-		return err
-	}
-
-	return writeSquarePaymentToFirestore(ctx, nestedEvent)
+	return paymentWriter.ProcessEvent(ctx, e)
 }
 
-func writeSquarePaymentToFirestore(ctx context.Context, e *event.Event) error {
-	paymentCreateRequest := false
-	attemptedWrite := false
-
-	var idempotencyKey string
-	var proposedPayment *paymentType.Payment
-	switch e.Type() {
-	case eventschemas.PaymentCreatedFromSquareType:
-		paymentCreateRequest = true
-
+var paymentDecoders = map[string]controller.Decoder[paymentType.Payment]{
+	eventschemas.PaymentCreatedFromSquareType: func(ctx context.Context, e *event.Event) (*controller.Proposal[paymentType.Payment], error) {
 		pr := &eventschemas.PaymentReceivedFromSquare{}
-		if err := e.DataAs(pr); err != nil {
-			slog.ErrorContext(ctx, err.Error(), "event", e)
-			return err
+		if err := eventschemas.DataAs(e, pr); err != nil {
+			return nil, failure.Poison(err)
 		}
-		idempotencyKey = pr.BasePayment.IdempotencyKey
-		proposedPayment = pr.BasePayment.Payment
-	case eventschemas.PaymentUpdatedFromSquareType:
+		return &controller.Proposal[paymentType.Payment]{Entity: pr.BasePayment.Payment, IdempotencyKey: pr.BasePayment.IdempotencyKey, CreateOnly: true}, nil
+	},
+	eventschemas.PaymentUpdatedFromSquareType: func(ctx context.Context, e *event.Event) (*controller.Proposal[paymentType.Payment], error) {
 		pu := &eventschemas.PaymentUpdatedFromSquare{}
-		if err := e.DataAs(pu); err != nil {
-			slog.ErrorContext(ctx, err.Error(), "event", e)
-			return err
+		if err := eventschemas.DataAs(e, pu); err != nil {
+			return nil, failure.Poison(err)
 		}
-		idempotencyKey = pu.BasePayment.IdempotencyKey
-		proposedPayment = pu.BasePayment.Payment
-	case eventschemas.SquareGetPaymentResponseType:
+		return &controller.Proposal[paymentType.Payment]{Entity: pu.BasePayment.Payment, IdempotencyKey: pu.BasePayment.IdempotencyKey}, nil
+	},
+	eventschemas.SquareGetPaymentResponseType: func(ctx context.Context, e *event.Event) (*controller.Proposal[paymentType.Payment], error) {
 		sgpr := &eventschemas.SquareGetPaymentResponse{}
-		if err := e.DataAs(sgpr); err != nil {
-			slog.ErrorContext(ctx, err.Error(), "event", e)
-			return err
-		}
-		idempotencyKey = sgpr.BasePayment.IdempotencyKey
-		proposedPayment = sgpr.BasePayment.Payment
-	default:
-		// TODO: slog
-		return nil
-	}
-
-	// make sure to update the map to denote that we've processed this event already
-	//
-	// the boolean here is only to allow Firestore to map back to Go struct; the important
-	// thing is that the key is put into the map
-	proposedPayment.IdempotencyKeys = make(map[string]bool, 1)
-	if idempotencyKey != "" {
-		proposedPayment.IdempotencyKeys[idempotencyKey] = true
-	}
-
-	// ensure the firestore expiration timestamp is written in the appropriate field
-	proposedPayment.Expiration = expirationTime
-
-	docRef := firestoreClient.Doc(fmt.Sprintf("%s/%s", paymentDocPath, proposedPayment.ID))
-	transaction := func(ctx context.Context, t *firestore.Transaction) error {
-		docSnap, err := t.Get(docRef)
-		if err != nil {
-			if status.Code(err) == codes.NotFound {
-				// document doesn't yet exist, so just write it
-				attemptedWrite = true
-				return t.Set(docRef, proposedPayment)
-			}
-			// document exists but there was some error, bail
-			slog.ErrorContext(ctx, err.Error(), "event", e)
-			return err
-		}
-
-		if paymentCreateRequest {
-			// if we get a create request and the doc already exists via an out of order event, just squelch it
-			// TODO: test this case
-			return nil
-		}
-
-		// since the document already exists and we have an update event, let's make sure
-		// we really should update it
-		persistedPayment := &paymentType.Payment{}
-		if err := docSnap.DataTo(persistedPayment); err != nil {
-			slog.ErrorContext(ctx, err.Error(), "event", e)
-			return err
-		}
-		// search the map to see if we've observed the idempotency key before
-		if _, ok := persistedPayment.IdempotencyKeys[idempotencyKey]; ok {
-			// we've already processed this update from square, so ignore it
-			slog.DebugContext(ctx, "skipped duplicate event seen from Square", "idempotencyKey", idempotencyKey)
-			return nil
-		}
-
-		// check to see if this square event is out of order
-		if persistedPayment.SquareUpdatedTime.After(proposedPayment.SquareUpdatedTime) {
-			// we've already processed a newer update from square, so ignore it
-			slog.DebugContext(ctx, "skipped out of order event seen from Square", "idempotencyKey", idempotencyKey)
-			return nil
-		}
-
-		// copy over idempotency keys from what we've seen before
-		for key, val := range persistedPayment.IdempotencyKeys {
-			proposedPayment.IdempotencyKeys[key] = val
+		if err := eventschemas.DataAs(e, sgpr); err != nil {
+			return nil, failure.Poison(err)
 		}
-
-		// if we get here, we have a newer proposal for payment so let's write it
-		attemptedWrite = true
-		return t.Set(docRef, proposedPayment)
-	}
-
-	if err := firestoreClient.RunTransaction(ctx, transaction); err != nil {
-		slog.ErrorContext(ctx, err.Error(), "event", e)
-		return err
-	}
-
-	// if we got here and attemptedWrite is true, then we wrote the document successfully
-	if attemptedWrite {
-		slog.InfoContext(ctx, fmt.Sprintf("payment %v written at %v", docRef.ID, docRef.Path))
-	}
-	return nil
+		return &controller.Proposal[paymentType.Payment]{Entity: sgpr.BasePayment.Payment, IdempotencyKey: sgpr.BasePayment.IdempotencyKey}, nil
+	},
 }
 
 // ProcessCDCEvent generates internal domain events from changes to firestore payments collection
 func ProcessCDCEvent(ctx context.Context, e event.Event) error {
-	var data firestoredata.DocumentEventData
-	if err := proto.Unmarshal(e.Data(), &data); err != nil {
-		slog.ErrorContext(ctx, err.Error(), "event", e)
-		return fmt.Errorf("proto.Unmarshal: %w", err)
-	}
-
-	var internalEvent *event.Event
-	if data.GetValue() == nil {
-		// the payment document was deleted
-		payment := &paymentType.Payment{}
-		err := util.ParseFirebaseDocument(data.OldValue, payment)
-		if err != nil {
-			slog.ErrorContext(ctx, err.Error(), "event", e)
-			return err
-		}
-		internalEvent, err = eventschemas.NewPaymentDeleted(payment)
-		if err != nil {
-			slog.ErrorContext(ctx, err.Error(), "event", e)
-			return err
-		}
-	} else if data.GetOldValue() == nil {
-		// the payment document was created
-		payment := &paymentType.Payment{}
-		err := util.ParseFirebaseDocument(data.Value, payment)
-		if err != nil {
-			slog.ErrorContext(ctx, err.Error(), "event", e)
-			return err
-		}
-		internalEvent, err = eventschemas.NewPaymentCreated(payment)
-		if err != nil {
-			slog.ErrorContext(ctx, err.Error(), "event", e)
-			return err
-		}
-	} else {
-		// the payment document was updated
-		payment := &paymentType.Payment{}
-		err := util.ParseFirebaseDocument(data.Value, payment)
-		if err != nil {
-			slog.ErrorContext(ctx, err.Error(), "event", e)
-			return err
-		}
-		oldPayment := &paymentType.Payment{}
-		if err = util.ParseFirebaseDocument(data.OldValue, oldPayment); err != nil {
-			slog.ErrorContext(ctx, err.Error(), "event", e)
-			return err
-		}
-		internalEvent, err = eventschemas.NewPaymentUpdated(oldPayment, payment, data.UpdateMask.FieldPaths)
-		if err != nil {
-			slog.ErrorContext(ctx, err.Error(), "event", e)
-			return err
-		}
-	}
-
-	eventJSON, err := internalEvent.MarshalJSON()
-	if err != nil {
-		slog.ErrorContext(ctx, err.Error(), "event", e)
-		return err
-	}
-	timeoutContext, cancel := context.WithTimeout(context.Background(), PUBLISH_TIMEOUT_SEC)
-	defer cancel()
-
-	publishResult := paymentEventsTopic.Publish(timeoutContext, &pubsub.Message{Data: eventJSON})
-	messageID, err := publishResult.Get(timeoutContext) // this call blocks until complete or timeout occurs
-	if err != nil {
-		slog.ErrorContext(ctx, err.Error(), "event", e)
-		return err
-	}
-	slog.InfoContext(ctx, fmt.Sprintf("published %s", internalEvent.Type()), "messageID", messageID, "paymentID", internalEvent.Subject())
-	return nil
+	return paymentPublisher.ProcessCDCEvent(ctx, e)
 }
 
 // RefundWatcher updates the payment object based on observed refunds
 func RefundWatcher(ctx context.Context, e event.Event) error {
-	// there are two CloudEvents - one for the pubsub message "event", and then the data within
-	var msg eventschemas.MessagePublishedData
-	if err := e.DataAs(&msg); err != nil {
-		slog.ErrorContext(ctx, err.Error(), "event", e)
-		return err
-	}
-
-	nestedEvent := &event.Event{}
-	if err := nestedEvent.UnmarshalJSON(msg.Message.Data); err != nil {
+	nestedEvent, err := eventschemas.NestedEvent(e)
+	if err != nil {
 		slog.ErrorContext(ctx, err.Error(), "event", e)
 		return err
 	}
@@ -303,25 +155,25 @@ func RefundWatcher(ctx context.Context, e event.Event) error {
 	switch nestedEvent.Type() {
 	case eventschemas.RefundCreatedType:
 		rc := &eventschemas.RefundCreated{}
-		if err := nestedEvent.DataAs(rc); err != nil {
+		if err := eventschemas.DataAs(nestedEvent, rc); err != nil {
 			slog.ErrorContext(ctx, err.Error(), "event", e)
-			return err
+			return failure.Poison(err)
 		}
 		idempotencyKey = rc.IdempotencyKey
 		refundToProcess = rc.Refund
 	case eventschemas.RefundUpdatedType:
 		ru := &eventschemas.RefundUpdated{}
-		if err := nestedEvent.DataAs(ru); err != nil {
+		if err := eventschemas.DataAs(nestedEvent, ru); err != nil {
 			slog.ErrorContext(ctx, err.Error(), "event", e)
-			return err
+			return failure.Poison(err)
 		}
 		idempotencyKey = ru.IdempotencyKey
 		refundToProcess = ru.Refund
 	case eventschemas.RefundDeletedType:
 		rd := &eventschemas.RefundDeleted{}
-		if err := nestedEvent.DataAs(rd); err != nil {
+		if err := eventschemas.DataAs(nestedEvent, rd); err != nil {
 			slog.ErrorContext(ctx, err.Error(), "event", e)
-			return err
+			return failure.Poison(err)
 		}
 		idempotencyKey = rd.IdempotencyKey
 		refundToProcess = rd.Refund
@@ -330,29 +182,26 @@ func RefundWatcher(ctx context.Context, e event.Event) error {
 		return nil
 	}
 
+	fundraiserID := router.Of(nestedEvent)
+	ctx = fundraiser.NewContext(ctx, fundraiserID)
+	ctx = logging.WithLabel(ctx, logging.LabelRefundID, refundToProcess.ID)
+	ctx = logging.WithLabel(ctx, logging.LabelPaymentID, refundToProcess.SquarePaymentID)
+
 	// if we have a new refund, find the matching internal Payment object
-	paymentDocRef := firestoreClient.Doc(fmt.Sprintf("%s/%s", paymentDocPath, refundToProcess.SquarePaymentID))
+	paymentDocRef := firestoreClient.Doc(fundraiser.Path(fundraiserID, "payments", refundToProcess.SquarePaymentID))
 	transaction := func(ctx context.Context, t *firestore.Transaction) error {
 		paymentDocSnap, err := t.Get(paymentDocRef)
 		if err != nil {
 			if status.Code(err) == codes.NotFound {
 				// payment object doesn't yet exist, so just fetch it
 				getPaymentEvent := eventschemas.NewSquareGetPaymentRequest(refundToProcess.SquarePaymentID)
-				eventJSON, err := getPaymentEvent.MarshalJSON()
-				if err != nil {
-					slog.ErrorContext(ctx, err.Error(), "event", e)
-					return err
-				}
-				timeoutContext, cancel := context.WithTimeout(context.Background(), PUBLISH_TIMEOUT_SEC)
-				defer cancel()
-
-				publishResult := squarePaymentRequestTopic.Publish(timeoutContext, &pubsub.Message{Data: eventJSON})
-				messageID, err := publishResult.Get(timeoutContext) // this call blocks until complete or timeout occurs
+				fundraiser.Set(getPaymentEvent, fundraiserID)
+				messageID, err := controller.Publish(ctx, squarePaymentRequestTopic, getPaymentEvent)
 				if err != nil {
 					slog.ErrorContext(ctx, err.Error(), "event", e)
 					return err
 				}
-				slog.InfoContext(ctx, "published SquareGetPaymentRequest during refund processing", "messageID", messageID, "paymentID", refundToProcess.SquarePaymentID)
+				slog.InfoContext(ctx, "published SquareGetPaymentRequest during refund processing", "messageID", messageID)
 				return nil
 			}
 			// document exists but there was some database error, bail
@@ -369,7 +218,7 @@ func RefundWatcher(ctx context.Context, e event.Event) error {
 		// search the map to see if we've observed the idempotency key before (i.e. processed this event before)
 		if _, ok := persistedPayment.IdempotencyKeys[idempotencyKey]; ok {
 			// we've already processed this, so ignore the duplicate
-			slog.DebugContext(ctx, "already processed update for this refund", "idempotencyKey", idempotencyKey, "refundID", refundToProcess.ID, "paymentID", persistedPayment.ID)
+			slog.DebugContext(ctx, "already processed update for this refund", "idempotencyKey", idempotencyKey)
 			return nil
 		}
 
@@ -9,11 +9,15 @@ locals {
     fileset("${path.module}", "**terraform/**"), # terraform files
     fileset("${path.module}", "*source-*.zip")   # other source zips
   )
+  # when routing to several fundraisers, changes to the documents of each of them must be published
+  fundraiser_path_segment = var.fundraisers == "" ? var.fundraiser_id : "{fundraiser}"
 }
 
 resource "google_project_service" "service" {
   for_each = toset([
     "run.googleapis.com",
+    "cloudtrace.googleapis.com",
+    "monitoring.googleapis.com",
     "eventarc.googleapis.com",
     "storage.googleapis.com",
     "pubsub.googleapis.com",
@@ -84,6 +88,7 @@ resource "google_cloudfunctions2_function" "payment-controller-square-webhook" {
       GCP_PROJECT                  = var.gcp_project_id
       EXPIRATION_TIME              = var.expiration_time
       FUNDRAISER_ID                = var.fundraiser_id
+      FUNDRAISERS                  = var.fundraisers
       PAYMENT_EVENTS_TOPIC         = var.payment_events_topic
       SQUARE_PAYMENT_REQUEST_TOPIC = var.square_payment_request_topic
     }
@@ -121,6 +126,7 @@ resource "google_cloudfunctions2_function" "payment-controller-cdc" {
       GCP_PROJECT                  = var.gcp_project_id
       EXPIRATION_TIME              = var.expiration_time
       FUNDRAISER_ID                = var.fundraiser_id
+      FUNDRAISERS                  = var.fundraisers
       PAYMENT_EVENTS_TOPIC         = var.payment_events_topic
       SQUARE_PAYMENT_REQUEST_TOPIC = var.square_payment_request_topic
     }
@@ -136,7 +142,7 @@ resource "google_cloudfunctions2_function" "payment-controller-cdc" {
     event_filters {
       operator  = "match-path-pattern"
       attribute = "document"
-      value     = "fundraisers/${var.fundraiser_id}/payments/{payment}"
+      value     = "fundraisers/${local.fundraiser_path_segment}/payments/{payment}"
     }
     retry_policy   = "RETRY_POLICY_RETRY"
   }
@@ -166,6 +172,7 @@ resource "google_cloudfunctions2_function" "payment-controller-square-payment-re
       GCP_PROJECT                  = var.gcp_project_id
       EXPIRATION_TIME              = var.expiration_time
       FUNDRAISER_ID                = var.fundraiser_id
+      FUNDRAISERS                  = var.fundraisers
       PAYMENT_EVENTS_TOPIC         = var.payment_events_topic
       SQUARE_PAYMENT_REQUEST_TOPIC = var.square_payment_request_topic
     }
@@ -9,11 +9,15 @@ locals {
     fileset("${path.module}", "**terraform/**"), # terraform files
     fileset("${path.module}", "*source-*.zip")   # other source zips
   )
+  # when routing to several fundraisers, changes to the documents of each of them must be published
+  fundraiser_path_segment = var.fundraisers == "" ? var.fundraiser_id : "{fundraiser}"
 }
 
 resource "google_project_service" "service" {
   for_each = toset([
     "run.googleapis.com",
+    "cloudtrace.googleapis.com",
+    "monitoring.googleapis.com",
     "eventarc.googleapis.com",
     "storage.googleapis.com",
     "pubsub.googleapis.com",
@@ -83,6 +87,7 @@ resource "google_cloudfunctions2_function" "refund-controller-square-webhook" {
       GCP_PROJECT         = var.gcp_project_id
       EXPIRATION_TIME     = var.expiration_time
       FUNDRAISER_ID       = var.fundraiser_id
+      FUNDRAISERS         = var.fundraisers
       REFUND_EVENTS_TOPIC = var.refund_events_topic
     }
   }
@@ -119,6 +124,7 @@ resource "google_cloudfunctions2_function" "refund-controller-cdc" {
       GCP_PROJECT         = var.gcp_project_id
       EXPIRATION_TIME     = var.expiration_time
       FUNDRAISER_ID       = var.fundraiser_id
+      FUNDRAISERS         = var.fundraisers
       REFUND_EVENTS_TOPIC = var.refund_events_topic
     }
   }
@@ -133,7 +139,7 @@ resource "google_cloudfunctions2_function" "refund-controller-cdc" {
     event_filters {
       operator  = "match-path-pattern"
       attribute = "document"
-      value     = "fundraisers/${var.fundraiser_id}/refunds/{refund}"
+      value     = "fundraisers/${local.fundraiser_path_segment}/refunds/{refund}"
     }
     retry_policy   = "RETRY_POLICY_RETRY"
   }
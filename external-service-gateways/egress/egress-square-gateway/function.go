@@ -4,164 +4,201 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"os"
+	"strings"
 	"time"
 
+	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/pubsub"
 
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 	"github.com/cloudevents/sdk-go/v2/event"
 
-	retryablehttp "github.com/hashicorp/go-retryablehttp"
-
 	"github.com/antihax/optional"
+	"github.com/kofc7186/fundraiser-manager/pkg/claimcheck"
+	"github.com/kofc7186/fundraiser-manager/pkg/coalesce"
+	"github.com/kofc7186/fundraiser-manager/pkg/config"
+	"github.com/kofc7186/fundraiser-manager/pkg/controller"
+	"github.com/kofc7186/fundraiser-manager/pkg/deadletter"
 	eventschemas "github.com/kofc7186/fundraiser-manager/pkg/event/schemas"
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
 	"github.com/kofc7186/fundraiser-manager/pkg/logging"
+	"github.com/kofc7186/fundraiser-manager/pkg/metrics"
+	"github.com/kofc7186/fundraiser-manager/pkg/secrets"
 	"github.com/kofc7186/fundraiser-manager/pkg/square/api"
+	squareclient "github.com/kofc7186/fundraiser-manager/pkg/square/client"
 	"github.com/kofc7186/fundraiser-manager/pkg/square/types/models"
-	"github.com/kofc7186/fundraiser-manager/pkg/util"
+	"github.com/kofc7186/fundraiser-manager/pkg/tracing"
 
 	_ "github.com/GoogleCloudPlatform/functions-framework-go/funcframework"
 )
 
-const (
-	FUNCTION_NAME       = "egress-square-gateway"
-	PUBLISH_TIMEOUT_SEC = 2 * time.Second
-)
+const FUNCTION_NAME = "egress-square-gateway"
 
 var paymentResponseTopic *pubsub.Topic
 var orderResponseTopic *pubsub.Topic
 var customerResponseTopic *pubsub.Topic
 
-var squareClient *api.APIClient
+var squareClient *squareclient.Client
+var router *fundraiser.Router
+
+// requests coalesces identical requests handled by this instance
+var requests = &coalesce.Group{}
 
 func init() {
 	slog.SetDefault(logging.FunctionLogger(FUNCTION_NAME))
 
-	psClient, err := pubsub.NewClient(context.Background(), util.GetEnvOrPanic("GCP_PROJECT"))
+	if err := tracing.Init(FUNCTION_NAME); err != nil {
+		panic(err)
+	}
+	if err := metrics.Init(FUNCTION_NAME); err != nil {
+		panic(err)
+	}
+	if err := deadletter.Init(); err != nil {
+		panic(err)
+	}
+	if err := claimcheck.Init(); err != nil {
+		panic(err)
+	}
+	if err := eventschemas.Init(); err != nil {
+		panic(err)
+	}
+
+	cfg := struct {
+		GCPProject                string        `env:"GCP_PROJECT" validate:"required"`
+		PaymentResponseTopicPath  string        `env:"SQUARE_PAYMENT_RESPONSE_TOPIC_PATH" validate:"required,topic"`
+		OrderResponseTopicPath    string        `env:"SQUARE_ORDER_RESPONSE_TOPIC_PATH" validate:"required,topic"`
+		CustomerResponseTopicPath string        `env:"SQUARE_CUSTOMER_RESPONSE_TOPIC_PATH" validate:"required,topic"`
+		SquareEnvironment         string        `env:"SQUARE_ENVIRONMENT" validate:"required,squareEnvironment"`
+		SquareVersion             string        `env:"SQUARE_VERSION"`
+		SquareAccessToken         string        `env:"SQUARE_ACCESS_TOKEN,secret" validate:"required"`
+		FunctionTimeout           time.Duration `env:"FUNCTION_TIMEOUT" validate:"positive"`
+		// concurrent order requests are batched into a single call, which needs the function to handle requests
+		// concurrently
+		OrderBatchWindow time.Duration `env:"ORDER_BATCH_WINDOW"`
+	}{
+		OrderBatchWindow: squareclient.DefaultOptions.OrderBatchWindow,
+	}
+	// the access token is read from Secret Manager, and re-read as it is rotated
+	secretProvider := secrets.Open()
+	if err := (&config.Loader{Secrets: secretProvider}).Load(context.Background(), &cfg); err != nil {
+		panic(fmt.Sprintf("invalid configuration:\n%v", err))
+	}
+
+	psClient, err := pubsub.NewClient(context.Background(), cfg.GCPProject)
 	if err != nil {
 		panic(err)
 	}
 
-	SQUARE_PAYMENT_RESPONSE_TOPIC_PATH := util.GetEnvOrPanic("SQUARE_PAYMENT_RESPONSE_TOPIC_PATH")
-	paymentResponseTopic = psClient.Topic(SQUARE_PAYMENT_RESPONSE_TOPIC_PATH)
+	paymentResponseTopic = psClient.Topic(cfg.PaymentResponseTopicPath)
 	if ok, err := paymentResponseTopic.Exists(context.Background()); !ok || err != nil {
-		panic(fmt.Sprintf("existence check for %s failed: %v", SQUARE_PAYMENT_RESPONSE_TOPIC_PATH, err))
+		panic(fmt.Sprintf("existence check for %s failed: %v", cfg.PaymentResponseTopicPath, err))
 	}
 
-	SQUARE_ORDER_RESPONSE_TOPIC_PATH := util.GetEnvOrPanic("SQUARE_ORDER_RESPONSE_TOPIC_PATH")
-	orderResponseTopic = psClient.Topic(SQUARE_ORDER_RESPONSE_TOPIC_PATH)
+	orderResponseTopic = psClient.Topic(cfg.OrderResponseTopicPath)
 	if ok, err := orderResponseTopic.Exists(context.Background()); !ok || err != nil {
-		panic(fmt.Sprintf("existence check for %s failed: %v", SQUARE_ORDER_RESPONSE_TOPIC_PATH, err))
+		panic(fmt.Sprintf("existence check for %s failed: %v", cfg.OrderResponseTopicPath, err))
 	}
 
-	SQUARE_CUSTOMER_RESPONSE_TOPIC_PATH := util.GetEnvOrPanic("SQUARE_CUSTOMER_RESPONSE_TOPIC_PATH")
-	customerResponseTopic = psClient.Topic(SQUARE_CUSTOMER_RESPONSE_TOPIC_PATH)
+	customerResponseTopic = psClient.Topic(cfg.CustomerResponseTopicPath)
 	if ok, err := customerResponseTopic.Exists(context.Background()); !ok || err != nil {
-		panic(fmt.Sprintf("existence check for %s failed: %v", SQUARE_CUSTOMER_RESPONSE_TOPIC_PATH, err))
+		panic(fmt.Sprintf("existence check for %s failed: %v", cfg.CustomerResponseTopicPath, err))
 	}
 
 	// initialize Square Client
 	var configuration *api.Configuration
 
-	SQUARE_ENVIRONMENT := util.GetEnvOrPanic("SQUARE_ENVIRONMENT")
-	if SQUARE_ENVIRONMENT == "sandbox" {
+	if cfg.SquareEnvironment == "sandbox" {
 		configuration = api.NewSandboxConfiguration()
 	} else {
 		configuration = api.NewConfiguration()
 	}
-	if SQUARE_VERSION, ok := os.LookupEnv("SQUARE_VERSION"); ok {
-		configuration.AddDefaultHeader("Square-Version", SQUARE_VERSION)
+	if cfg.SquareVersion != "" {
+		configuration.AddDefaultHeader("Square-Version", cfg.SquareVersion)
 	}
 
-	retryClient := retryablehttp.NewClient()
-	retryClient.Logger = logging.Logger
-	configuration.HTTPClient = retryClient.StandardClient()
-
-	// configure authentication credentials
-	configuration.AddDefaultHeader("Authorization", fmt.Sprintf("Bearer %s", util.GetEnvOrPanic("SQUARE_ACCESS_TOKEN")))
+	// calls to Square, including their retries, must leave time to publish the response before the function times out
+	options := squareclient.DefaultOptions
+	if cfg.FunctionTimeout > 0 {
+		options.MaxElapsed = cfg.FunctionTimeout - 5*controller.PublishTimeout
+	}
+	options.OrderBatchWindow = cfg.OrderBatchWindow
+	accessToken := secrets.NewValue(secretProvider, "SQUARE_ACCESS_TOKEN", cfg.SquareAccessToken)
+	squareClient = squareclient.New(configuration, accessToken, options)
 
-	squareClient = api.NewAPIClient(configuration)
+	router, err = fundraiser.Load()
+	if err != nil {
+		panic(err)
+	}
+	firestoreClient, err := firestore.NewClient(context.Background(), cfg.GCPProject)
+	if err != nil {
+		panic(err)
+	}
+	if err := router.Watch(context.Background(), firestoreClient); err != nil {
+		panic(err)
+	}
 
 	// do this last so we are ensured to have all the required clients established above
-	functions.CloudEvent("EgressSquarePaymentGateway", EgressSquarePaymentGateway)
-	functions.CloudEvent("EgressSquareOrderGateway", EgressSquareOrderGateway)
-	functions.CloudEvent("EgressSquareCustomerGateway", EgressSquareCustomerGateway)
+	functions.CloudEvent("EgressSquarePaymentGateway", deadletter.CloudEvent("EgressSquarePaymentGateway", tracing.CloudEvent("EgressSquarePaymentGateway", metrics.CloudEvent("EgressSquarePaymentGateway", EgressSquarePaymentGateway))))
+	functions.CloudEvent("EgressSquareOrderGateway", deadletter.CloudEvent("EgressSquareOrderGateway", tracing.CloudEvent("EgressSquareOrderGateway", metrics.CloudEvent("EgressSquareOrderGateway", EgressSquareOrderGateway))))
+	functions.CloudEvent("EgressSquareCustomerGateway", deadletter.CloudEvent("EgressSquareCustomerGateway", tracing.CloudEvent("EgressSquareCustomerGateway", metrics.CloudEvent("EgressSquareCustomerGateway", EgressSquareCustomerGateway))))
 }
 
 // EgressSquarePaymentGateway invokes the Square API to get the payment object for the specified request
 func EgressSquarePaymentGateway(ctx context.Context, e event.Event) error {
-	// there are two CloudEvents - one for the pubsub message "event", and then the data within
-	var msg eventschemas.MessagePublishedData
-	if err := e.DataAs(&msg); err != nil {
+	nestedEvent, err := eventschemas.NestedEvent(e)
+	if err != nil {
 		slog.ErrorContext(ctx, err.Error(), "event", e)
 		return err
 	}
 
-	nestedEvent := &event.Event{}
-	if err := nestedEvent.UnmarshalJSON(msg.Message.Data); err != nil {
-		return err
-	}
-
 	var responseEvents []*event.Event
 	switch nestedEvent.Type() {
 	case eventschemas.SquareGetPaymentRequestType:
 		paymentID := nestedEvent.Subject()
+		ctx = logging.WithLabel(ctx, logging.LabelPaymentID, paymentID)
 
-		payment, httpResponse, err := squareClient.PaymentsApi.GetPayment(ctx, paymentID)
-		if err != nil {
-			slog.ErrorContext(ctx, "error getting payment from Square", "paymentID", paymentID, "error", err, "httpResponse", httpResponse)
-			return err
-		}
-
-		if len(payment.Errors) != 0 {
-			return fmt.Errorf("error(s) calling GetPayment: %v", payment.Errors)
-		}
+		return coalesced(ctx, nestedEvent, func() error {
+			payment, err := squareClient.GetPayment(ctx, paymentID)
+			if err != nil {
+				slog.ErrorContext(ctx, "error getting payment from Square", "error", err)
+				return err
+			}
 
-		responseEvent, err := eventschemas.NewSquareGetPaymentResponse(nestedEvent.Source(), payment)
-		if err != nil {
-			return err
-		}
-		responseEvents = append(responseEvents, responseEvent)
+			responseEvent, err := eventschemas.NewSquareGetPaymentResponse(nestedEvent.Source(), payment)
+			if err != nil {
+				return failure.Permanent(err)
+			}
+			if payment.Payment != nil {
+				route(nestedEvent, responseEvent, fundraiser.PaymentAttributes(payment.Payment))
+			}
+			return publish(ctx, paymentResponseTopic, responseEvent)
+		})
 	case eventschemas.SquareListPaymentsRequestType:
 		slpr := &eventschemas.SquareListPaymentsRequest{}
-		if err := nestedEvent.DataAs(slpr); err != nil {
-			return err
+		if err := eventschemas.DataAs(nestedEvent, slpr); err != nil {
+			return failure.Poison(err)
 		}
-		payments, httpResponse, err := squareClient.PaymentsApi.ListPayments(ctx, &api.PaymentsApiListPaymentsOpts{
+		payments, err := squareClient.ListPayments(ctx, &api.PaymentsApiListPaymentsOpts{
 			BeginTime: optional.NewString(slpr.BeginTime.Format(time.RFC3339)),
 			EndTime:   optional.NewString(slpr.EndTime.Format(time.RFC3339)),
 		})
 		if err != nil {
-			slog.ErrorContext(ctx, "error listing payments from Square", "error", err, "httpResponse", httpResponse)
+			slog.ErrorContext(ctx, "error listing payments from Square", "error", err)
 			return err
 		}
-
-		if len(payments.Errors) != 0 {
-			return fmt.Errorf("error(s) calling ListPayments: %v", payments.Errors)
-		}
 		for _, payment := range payments.Payments {
 			responseEvent, err := eventschemas.NewSquareGetPaymentResponse(nestedEvent.Source(), models.GetPaymentResponse{Payment: &payment})
 			if err != nil {
-				return err
+				return failure.Permanent(err)
 			}
+			route(nestedEvent, responseEvent, fundraiser.PaymentAttributes(&payment))
 			responseEvents = append(responseEvents, responseEvent)
 		}
 	}
 
 	for _, responseEvent := range responseEvents {
-		respBytes, err := responseEvent.MarshalJSON()
-		if err != nil {
-			return err
-		}
-
-		timeoutContext, cancel := context.WithTimeout(context.Background(), PUBLISH_TIMEOUT_SEC)
-		defer cancel()
-
-		publishResult := paymentResponseTopic.Publish(timeoutContext, &pubsub.Message{Data: respBytes})
-		// this call blocks until complete or timeout occurs
-		if _, err := publishResult.Get(timeoutContext); err != nil {
-			slog.ErrorContext(ctx, err.Error())
+		if err := publish(ctx, paymentResponseTopic, responseEvent); err != nil {
 			return err
 		}
 	}
@@ -171,98 +208,90 @@ func EgressSquarePaymentGateway(ctx context.Context, e event.Event) error {
 
 // EgressSquareOrderGateway invokes the Square API to get the order object for the specified request
 func EgressSquareOrderGateway(ctx context.Context, e event.Event) error {
-	// there are two CloudEvents - one for the pubsub message "event", and then the data within
-	var msg eventschemas.MessagePublishedData
-	if err := e.DataAs(&msg); err != nil {
+	nestedEvent, err := eventschemas.NestedEvent(e)
+	if err != nil {
 		slog.ErrorContext(ctx, err.Error(), "event", e)
 		return err
 	}
 
-	nestedEvent := &event.Event{}
-	if err := nestedEvent.UnmarshalJSON(msg.Message.Data); err != nil {
-		return err
-	}
-
 	orderID := nestedEvent.Subject()
+	ctx = logging.WithLabel(ctx, logging.LabelOrderID, orderID)
 
-	order, httpResponse, err := squareClient.OrdersApi.RetrieveOrder(ctx, orderID)
-	if err != nil {
-		slog.ErrorContext(ctx, "error getting order from Square", "orderID", orderID, "error", err, "httpResponse", httpResponse)
-		return err
-	}
-
-	if len(order.Errors) != 0 {
-		return fmt.Errorf("error(s) calling RetrieveOrder: %v", order.Errors)
-	}
-
-	responseEvent, err := eventschemas.NewSquareRetrieveOrderResponse(nestedEvent.Source(), order)
-	if err != nil {
-		return err
-	}
-
-	respBytes, err := responseEvent.MarshalJSON()
-	if err != nil {
-		return err
-	}
-
-	timeoutContext, cancel := context.WithTimeout(context.Background(), PUBLISH_TIMEOUT_SEC)
-	defer cancel()
-
-	publishResult := orderResponseTopic.Publish(timeoutContext, &pubsub.Message{Data: respBytes})
-	// this call blocks until complete or timeout occurs
-	if _, err := publishResult.Get(timeoutContext); err != nil {
-		slog.ErrorContext(ctx, err.Error())
-		return err
-	}
+	return coalesced(ctx, nestedEvent, func() error {
+		order, err := squareClient.RetrieveOrder(ctx, orderID)
+		if err != nil {
+			slog.ErrorContext(ctx, "error getting order from Square", "error", err)
+			return err
+		}
 
-	return nil
+		responseEvent, err := eventschemas.NewSquareRetrieveOrderResponse(nestedEvent.Source(), order)
+		if err != nil {
+			return failure.Permanent(err)
+		}
+		if order.Order != nil {
+			route(nestedEvent, responseEvent, fundraiser.OrderAttributes(order.Order))
+		}
+		return publish(ctx, orderResponseTopic, responseEvent)
+	})
 }
 
 // EgressSquareCustomerGateway invokes the Square API to get the customer object for the specified request
 func EgressSquareCustomerGateway(ctx context.Context, e event.Event) error {
-	// there are two CloudEvents - one for the pubsub message "event", and then the data within
-	var msg eventschemas.MessagePublishedData
-	if err := e.DataAs(&msg); err != nil {
+	nestedEvent, err := eventschemas.NestedEvent(e)
+	if err != nil {
 		slog.ErrorContext(ctx, err.Error(), "event", e)
 		return err
 	}
 
-	nestedEvent := &event.Event{}
-	if err := nestedEvent.UnmarshalJSON(msg.Message.Data); err != nil {
-		return err
-	}
-
 	customerID := nestedEvent.Subject()
+	ctx = logging.WithLabel(ctx, logging.LabelCustomerID, customerID)
 
-	customer, httpResponse, err := squareClient.CustomersApi.RetrieveCustomer(ctx, customerID)
-	if err != nil {
-		slog.ErrorContext(ctx, "error getting customer from Square", "customerID", customerID, "error", err, "httpResponse", httpResponse)
-		return err
-	}
+	return coalesced(ctx, nestedEvent, func() error {
+		customer, err := squareClient.RetrieveCustomer(ctx, customerID)
+		if err != nil {
+			slog.ErrorContext(ctx, "error getting customer from Square", "error", err)
+			return err
+		}
 
-	if len(customer.Errors) != 0 {
-		return fmt.Errorf("error(s) calling RetrieveCustomer: %v", customer.Errors)
-	}
+		responseEvent, err := eventschemas.NewSquareRetrieveCustomerResponse(nestedEvent.Source(), customer)
+		if err != nil {
+			return failure.Permanent(err)
+		}
+		if customer.Customer != nil {
+			route(nestedEvent, responseEvent, fundraiser.CustomerAttributes(customer.Customer))
+		}
+		return publish(ctx, customerResponseTopic, responseEvent)
+	})
+}
 
-	responseEvent, err := eventschemas.NewSquareRetrieveCustomerResponse(nestedEvent.Source(), customer)
-	if err != nil {
-		return err
+// coalesced handles the request nestedEvent with fn, unless an identical request arrived while another was being
+// handled by this instance and is queued behind it (in which case it shares its outcome); the watchers fire a request
+// for each change they see, so a customer with several orders may be requested several times at once
+func coalesced(ctx context.Context, nestedEvent *event.Event, fn func() error) error {
+	fundraiserID, _ := fundraiser.Get(nestedEvent)
+	key := strings.Join([]string{nestedEvent.Type(), nestedEvent.Source(), nestedEvent.Subject(), fundraiserID}, "|")
+	skipped, err := requests.Do(ctx, key, fn)
+	if skipped && err == nil {
+		slog.DebugContext(ctx, "coalesced duplicate Square request", "event", nestedEvent)
 	}
+	return err
+}
 
-	respBytes, err := responseEvent.MarshalJSON()
-	if err != nil {
-		return err
+// route records the fundraiser of the response to a request: that of the request if the requester knew it (e.g. the
+// order of a payment belongs to the payment's fundraiser), otherwise the one the object retrieved from Square
+// resolves to
+func route(request, response *event.Event, attributes fundraiser.Attributes) {
+	if fundraiserID, ok := fundraiser.Get(request); ok {
+		fundraiser.Set(response, fundraiserID)
+		return
 	}
+	fundraiser.Set(response, router.Resolve(attributes))
+}
 
-	timeoutContext, cancel := context.WithTimeout(context.Background(), PUBLISH_TIMEOUT_SEC)
-	defer cancel()
-
-	publishResult := customerResponseTopic.Publish(timeoutContext, &pubsub.Message{Data: respBytes})
-	// this call blocks until complete or timeout occurs
-	if _, err := publishResult.Get(timeoutContext); err != nil {
+func publish(ctx context.Context, topic *pubsub.Topic, responseEvent *event.Event) error {
+	if _, err := controller.Publish(ctx, topic, responseEvent); err != nil {
 		slog.ErrorContext(ctx, err.Error())
 		return err
 	}
-
 	return nil
 }
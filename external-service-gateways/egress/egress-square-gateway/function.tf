@@ -1,5 +1,6 @@
 locals {
-  function_group = "egress-square-gateway"
+  function_group  = "egress-square-gateway"
+  timeout_seconds = 60
   function_exclude_list = setunion(
     ["go.sum"],
     fileset("${path.module}", "cmd/**"),         # main harness
@@ -10,18 +11,23 @@ locals {
     fileset("${path.module}", "*source-*.zip")   # other source zips
   )
 
+  # order and customer requests are handled concurrently so that identical requests are coalesced, and order
+  # requests batched, within an instance; Cloud Run needs a whole CPU to handle requests concurrently
   apis = tomap({
     payment = {
-      api   = "EgressSquarePaymentGateway",
-      topic = var.square_payment_events_request_topic
+      api         = "EgressSquarePaymentGateway",
+      topic       = var.square_payment_events_request_topic
+      concurrency = 1
     },
     order = {
-      api   = "EgressSquareOrderGateway",
-      topic = var.square_order_events_request_topic
+      api         = "EgressSquareOrderGateway",
+      topic       = var.square_order_events_request_topic
+      concurrency = 16
     },
     customer = {
-      api   = "EgressSquareCustomerGateway",
-      topic = var.square_customer_events_request_topic
+      api         = "EgressSquareCustomerGateway",
+      topic       = var.square_customer_events_request_topic
+      concurrency = 16
     }
   })
 }
@@ -29,6 +35,8 @@ locals {
 resource "google_project_service" "service" {
   for_each = toset([
     "run.googleapis.com",
+    "cloudtrace.googleapis.com",
+    "monitoring.googleapis.com",
     "eventarc.googleapis.com",
     "secretmanager.googleapis.com",
     "storage.googleapis.com",
@@ -93,26 +101,30 @@ resource "google_cloudfunctions2_function" "egress_square_gateway" {
 
   service_config {
     available_memory   = "128Mi"
-    timeout_seconds    = 60
+    available_cpu      = each.value.concurrency > 1 ? "1" : null
+    timeout_seconds    = local.timeout_seconds
     min_instance_count = var.min_instance_count
 
+    max_instance_request_concurrency = each.value.concurrency
+
     environment_variables = {
       GCP_PROJECT        = var.gcp_project_id
       EXPIRATION_TIME    = var.expiration_time
+      FUNDRAISER_ID      = var.fundraiser_id
+      FUNDRAISERS        = var.fundraisers
       SQUARE_ENVIRONMENT = var.square_environment
       SQUARE_VERSION     = var.square_version
+      CLAIM_CHECK_BUCKET = "gs://${var.claim_check_bucket}"
+      FUNCTION_TIMEOUT   = "${local.timeout_seconds}s"
+      ORDER_BATCH_WINDOW = "50ms"
+
+      # read from Secret Manager when the function starts and again as it is rotated
+      SQUARE_ACCESS_TOKEN_SECRET = google_secret_manager_secret.square_access_token.id
 
       SQUARE_PAYMENT_RESPONSE_TOPIC_PATH  = var.square_payment_events_response_topic
       SQUARE_ORDER_RESPONSE_TOPIC_PATH    = var.square_order_events_response_topic
       SQUARE_CUSTOMER_RESPONSE_TOPIC_PATH = var.square_customer_events_response_topic
     }
-
-    secret_environment_variables {
-      key        = "SQUARE_ACCESS_TOKEN"
-      project_id = var.gcp_project_id
-      secret     = google_secret_manager_secret.square_access_token.secret_id
-      version    = "latest"
-    }
   }
 
   event_trigger {
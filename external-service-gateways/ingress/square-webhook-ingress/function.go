@@ -1,120 +1,215 @@
 package squarewebhookingress
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
-	"time"
 
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 
+	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/pubsub"
 	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/kofc7186/fundraiser-manager/pkg/claimcheck"
+	"github.com/kofc7186/fundraiser-manager/pkg/config"
+	"github.com/kofc7186/fundraiser-manager/pkg/controller"
 	eventschemas "github.com/kofc7186/fundraiser-manager/pkg/event/schemas"
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
 	"github.com/kofc7186/fundraiser-manager/pkg/logging"
+	"github.com/kofc7186/fundraiser-manager/pkg/metrics"
+	"github.com/kofc7186/fundraiser-manager/pkg/secrets"
 	squarewebhooktypes "github.com/kofc7186/fundraiser-manager/pkg/square/types/webhooks"
 	"github.com/kofc7186/fundraiser-manager/pkg/square/webhooks"
-	"github.com/kofc7186/fundraiser-manager/pkg/util"
+	"github.com/kofc7186/fundraiser-manager/pkg/tracing"
 
 	_ "github.com/GoogleCloudPlatform/functions-framework-go/funcframework"
 )
 
-const (
-	FUNCTION_NAME       = "square-webhook-ingress"
-	PUBLISH_TIMEOUT_SEC = 2 * time.Second
-)
+const FUNCTION_NAME = "square-webhook-ingress"
 
 var squareOrderRequestTopic *pubsub.Topic
 var squarePaymentWebhookTopic *pubsub.Topic
 var squareRefundWebhookTopic *pubsub.Topic
 var squareCustomerWebhookTopic *pubsub.Topic
-var SQUARE_SIGNATURE_KEY string
+var signatureKey *secrets.Value
+var router *fundraiser.Router
 var WEBHOOK_URL string
 
 func init() {
 	slog.SetDefault(logging.FunctionLogger(FUNCTION_NAME))
 
-	// if we don't have these environment variables set, we should panic ASAP
-	SQUARE_SIGNATURE_KEY = util.GetEnvOrPanic("SQUARE_SIGNATURE_KEY")
-	SQUARE_ORDER_REQUEST_TOPIC := util.GetEnvOrPanic("SQUARE_ORDER_REQUEST_TOPIC")
-	SQUARE_PAYMENT_WEBHOOK_TOPIC := util.GetEnvOrPanic("SQUARE_PAYMENT_WEBHOOK_TOPIC")
-	SQUARE_REFUND_WEBHOOK_TOPIC := util.GetEnvOrPanic("SQUARE_REFUND_WEBHOOK_TOPIC")
-	SQUARE_CUSTOMER_WEBHOOK_TOPIC := util.GetEnvOrPanic("SQUARE_CUSTOMER_WEBHOOK_TOPIC")
-	WEBHOOK_URL = util.GetEnvOrPanic("WEBHOOK_URL")
+	if err := tracing.Init(FUNCTION_NAME); err != nil {
+		panic(err)
+	}
+	if err := metrics.Init(FUNCTION_NAME); err != nil {
+		panic(err)
+	}
+	if err := claimcheck.Init(); err != nil {
+		panic(err)
+	}
+
+	if err := eventschemas.Init(); err != nil {
+		panic(err)
+	}
+
+	// if the configuration is invalid, we should panic ASAP
+	var cfg struct {
+		GCPProject                 string `env:"GCP_PROJECT" validate:"required"`
+		SquareSignatureKey         string `env:"SQUARE_SIGNATURE_KEY,secret" validate:"required"`
+		SquareOrderRequestTopic    string `env:"SQUARE_ORDER_REQUEST_TOPIC" validate:"required,topic"`
+		SquarePaymentWebhookTopic  string `env:"SQUARE_PAYMENT_WEBHOOK_TOPIC" validate:"required,topic"`
+		SquareRefundWebhookTopic   string `env:"SQUARE_REFUND_WEBHOOK_TOPIC" validate:"required,topic"`
+		SquareCustomerWebhookTopic string `env:"SQUARE_CUSTOMER_WEBHOOK_TOPIC" validate:"required,topic"`
+		WebhookURL                 string `env:"WEBHOOK_URL" validate:"required,url"`
+	}
+	// the signature key is read from Secret Manager, and re-read as it is rotated
+	secretProvider := secrets.Open()
+	if err := (&config.Loader{Secrets: secretProvider}).Load(context.Background(), &cfg); err != nil {
+		panic(fmt.Sprintf("invalid configuration:\n%v", err))
+	}
+	signatureKey = secrets.NewValue(secretProvider, "SQUARE_SIGNATURE_KEY", cfg.SquareSignatureKey)
+	WEBHOOK_URL = cfg.WebhookURL
+
+	var err error
+	router, err = fundraiser.Load()
+	if err != nil {
+		panic(err)
+	}
+	firestoreClient, err := firestore.NewClient(context.Background(), cfg.GCPProject)
+	if err != nil {
+		panic(err)
+	}
+	if err := router.Watch(context.Background(), firestoreClient); err != nil {
+		panic(err)
+	}
 
-	psClient, err := pubsub.NewClient(context.Background(), util.GetEnvOrPanic("GCP_PROJECT"))
+	psClient, err := pubsub.NewClient(context.Background(), cfg.GCPProject)
 	if err != nil {
 		panic(err)
 	}
 
-	squareOrderRequestTopic = psClient.Topic(SQUARE_ORDER_REQUEST_TOPIC)
+	squareOrderRequestTopic = psClient.Topic(cfg.SquareOrderRequestTopic)
 	if ok, err := squareOrderRequestTopic.Exists(context.Background()); !ok || err != nil {
-		panic(fmt.Sprintf("existence check for %s failed: %v", SQUARE_ORDER_REQUEST_TOPIC, err))
+		panic(fmt.Sprintf("existence check for %s failed: %v", cfg.SquareOrderRequestTopic, err))
 	}
 
-	squarePaymentWebhookTopic = psClient.Topic(SQUARE_PAYMENT_WEBHOOK_TOPIC)
+	squarePaymentWebhookTopic = psClient.Topic(cfg.SquarePaymentWebhookTopic)
 	if ok, err := squarePaymentWebhookTopic.Exists(context.Background()); !ok || err != nil {
-		panic(fmt.Sprintf("existence check for %s failed: %v", SQUARE_PAYMENT_WEBHOOK_TOPIC, err))
+		panic(fmt.Sprintf("existence check for %s failed: %v", cfg.SquarePaymentWebhookTopic, err))
 	}
 
-	squareRefundWebhookTopic = psClient.Topic(SQUARE_REFUND_WEBHOOK_TOPIC)
+	squareRefundWebhookTopic = psClient.Topic(cfg.SquareRefundWebhookTopic)
 	if ok, err := squareRefundWebhookTopic.Exists(context.Background()); !ok || err != nil {
-		panic(fmt.Sprintf("existence check for %s failed: %v", SQUARE_REFUND_WEBHOOK_TOPIC, err))
+		panic(fmt.Sprintf("existence check for %s failed: %v", cfg.SquareRefundWebhookTopic, err))
 	}
 
-	squareCustomerWebhookTopic = psClient.Topic(SQUARE_CUSTOMER_WEBHOOK_TOPIC)
+	squareCustomerWebhookTopic = psClient.Topic(cfg.SquareCustomerWebhookTopic)
 	if ok, err := squareCustomerWebhookTopic.Exists(context.Background()); !ok || err != nil {
-		panic(fmt.Sprintf("existence check for %s failed: %v", SQUARE_CUSTOMER_WEBHOOK_TOPIC, err))
+		panic(fmt.Sprintf("existence check for %s failed: %v", cfg.SquareCustomerWebhookTopic, err))
 	}
 
 	// do this last so we are ensured to have all the required clients established above
-	functions.HTTP("WebhookRouter", WebhookRouter)
+	functions.HTTP("WebhookRouter", tracing.HTTP("WebhookRouter", metrics.HTTP("WebhookRouter", WebhookRouter)))
+}
+
+// verify verifies the webhook with the current signature key and, if its signature does not match, once more with
+// the key re-read in case it was rotated
+func verify(r *http.Request) (squarewebhooktypes.SquareWebhookEvent, error) {
+	ctx := r.Context()
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	verifyWithKey := func() (squarewebhooktypes.SquareWebhookEvent, error) {
+		key, err := signatureKey.Get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		return webhooks.VerifySquareWebhook(r, key, WEBHOOK_URL)
+	}
+
+	webhookEvent, err := verifyWithKey()
+	if errors.Is(err, webhooks.ErrInvalidSignature) {
+		changed, refreshErr := signatureKey.Refresh(ctx)
+		if refreshErr != nil {
+			slog.WarnContext(ctx, "refreshing the signature key failed", "error", refreshErr)
+		}
+		if changed {
+			return verifyWithKey()
+		}
+	}
+	return webhookEvent, err
 }
 
 // WebhookRouter is the function that routes the incoming request based on
 func WebhookRouter(w http.ResponseWriter, r *http.Request) {
 	// parse and validate the input came from Square
-	webhookEvent, err := webhooks.VerifySquareWebhook(r, SQUARE_SIGNATURE_KEY, WEBHOOK_URL)
+	webhookEvent, err := verify(r)
 	if err != nil {
+		if errors.Is(err, webhooks.ErrInvalidSignature) {
+			metrics.RecordSignatureFailure(r.Context())
+		}
 		slog.ErrorContext(r.Context(), err.Error())
 		w.WriteHeader(http.StatusBadRequest)
 		_, _ = w.Write([]byte(err.Error()))
 		return
 	}
+	metrics.RecordWebhookReceived(r.Context(), webhookEvent.EventType())
 
 	// create the correct internal event
 	var internalEvent *cloudevents.Event
 	var pubTopic *pubsub.Topic
+	var labelKey string
+	// orders are routed once they have been retrieved, as the webhooks lack their source
+	var attributes fundraiser.Attributes
 	switch t := webhookEvent.(type) {
 	case *squarewebhooktypes.PaymentCreated:
 		internalEvent, err = eventschemas.NewPaymentCreatedFromSquare(t)
+		attributes = fundraiser.PaymentAttributes(&t.Data.Object.Payment)
 		pubTopic = squarePaymentWebhookTopic
+		labelKey = logging.LabelPaymentID
 	case *squarewebhooktypes.PaymentUpdated:
 		internalEvent, err = eventschemas.NewPaymentUpdatedFromSquare(t)
+		attributes = fundraiser.PaymentAttributes(&t.Data.Object.Payment)
 		pubTopic = squarePaymentWebhookTopic
+		labelKey = logging.LabelPaymentID
 	case *squarewebhooktypes.RefundCreated:
 		internalEvent, err = eventschemas.NewRefundCreatedFromSquare(t)
+		attributes = fundraiser.RefundAttributes(&t.Data.Object.Refund)
 		pubTopic = squareRefundWebhookTopic
+		labelKey = logging.LabelRefundID
 	case *squarewebhooktypes.RefundUpdated:
 		internalEvent, err = eventschemas.NewRefundUpdatedFromSquare(t)
+		attributes = fundraiser.RefundAttributes(&t.Data.Object.Refund)
 		pubTopic = squareRefundWebhookTopic
+		labelKey = logging.LabelRefundID
 	case *squarewebhooktypes.CustomerCreated:
 		internalEvent, err = eventschemas.NewCustomerCreatedFromSquare(t)
+		attributes = fundraiser.CustomerAttributes(&t.Data.Object.Customer)
 		pubTopic = squareCustomerWebhookTopic
+		labelKey = logging.LabelCustomerID
 	case *squarewebhooktypes.CustomerUpdated:
 		internalEvent, err = eventschemas.NewCustomerUpdatedFromSquare(t)
+		attributes = fundraiser.CustomerAttributes(&t.Data.Object.Customer)
 		pubTopic = squareCustomerWebhookTopic
+		labelKey = logging.LabelCustomerID
 	// these two types are different; since the Square webhook doesn't include the 'order' object, we immediately have to fetch it
 	case *squarewebhooktypes.OrderCreated:
 		internalEvent = eventschemas.NewSquareRetrieveOrderRequest(t.Data.Object.OrderCreated.OrderId)
 		internalEvent.SetSource(squarewebhooktypes.SQUARE_WEBHOOK_ORDER_CREATED)
 		pubTopic = squareOrderRequestTopic
+		labelKey = logging.LabelOrderID
 	case *squarewebhooktypes.OrderUpdated:
 		internalEvent = eventschemas.NewSquareRetrieveOrderRequest(t.Data.Object.OrderUpdated.OrderId)
 		internalEvent.SetSource(squarewebhooktypes.SQUARE_WEBHOOK_ORDER_UPDATED)
 		pubTopic = squareOrderRequestTopic
+		labelKey = logging.LabelOrderID
 	default:
 		err = errors.New("unsupported webhook event received")
 		slog.ErrorContext(r.Context(), err.Error())
@@ -130,29 +225,24 @@ func WebhookRouter(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// publish to correct topic
-	eventJSON, err := internalEvent.MarshalJSON()
-	if err != nil {
-		slog.ErrorContext(r.Context(), err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
-		return
+	ctx := logging.WithLabel(r.Context(), labelKey, internalEvent.Subject())
+	if len(attributes.ObjectIDs) > 0 {
+		fundraiserID := router.Resolve(attributes)
+		fundraiser.Set(internalEvent, fundraiserID)
+		ctx = fundraiser.NewContext(ctx, fundraiserID)
 	}
 
-	timeoutContext, cancel := context.WithTimeout(context.Background(), PUBLISH_TIMEOUT_SEC)
-	defer cancel()
-
-	publishResult := pubTopic.Publish(timeoutContext, &pubsub.Message{Data: eventJSON})
-	messageID, err := publishResult.Get(timeoutContext) // this call blocks until complete or timeout occurs
+	// publish to correct topic
+	messageID, err := controller.Publish(ctx, pubTopic, internalEvent)
 	if err != nil {
-		slog.ErrorContext(r.Context(), err.Error())
+		slog.ErrorContext(ctx, err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 		_, _ = w.Write([]byte(err.Error()))
 		return
 	}
 
 	// respond back to Square that we've successfully ingested the webhook event
-	slog.DebugContext(r.Context(), fmt.Sprintf("successfully published %s", messageID), "event_id", webhookEvent.ID())
+	slog.DebugContext(ctx, fmt.Sprintf("successfully published %s", messageID), "event_id", webhookEvent.ID())
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("\"ok\""))
 }
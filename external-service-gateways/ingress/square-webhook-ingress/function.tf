@@ -15,6 +15,8 @@ locals {
 resource "google_project_service" "service" {
   for_each = toset([
     "run.googleapis.com",
+    "cloudtrace.googleapis.com",
+    "monitoring.googleapis.com",
     "secretmanager.googleapis.com",
     "storage.googleapis.com",
   ])
@@ -82,18 +84,17 @@ resource "google_cloudfunctions2_function" "webhook" {
     environment_variables = {
       GCP_PROJECT                   = var.gcp_project_id
       EXPIRATION_TIME               = var.expiration_time
+      FUNDRAISER_ID                 = var.fundraiser_id
+      FUNDRAISERS                   = var.fundraisers
       SQUARE_ORDER_REQUEST_TOPIC    = var.square_order_request_topic
       SQUARE_CUSTOMER_WEBHOOK_TOPIC = var.square_customer_webhook_topic
       SQUARE_PAYMENT_WEBHOOK_TOPIC  = var.square_payment_webhook_topic
       SQUARE_REFUND_WEBHOOK_TOPIC   = var.square_refund_webhook_topic
       WEBHOOK_URL                   = local.webhook_url
-    }
+      CLAIM_CHECK_BUCKET            = "gs://${var.claim_check_bucket}"
 
-    secret_environment_variables {
-      key        = "SQUARE_SIGNATURE_KEY"
-      project_id = var.gcp_project_id
-      secret     = google_secret_manager_secret.square_signature_key.secret_id
-      version    = "latest"
+      # read from Secret Manager when the function starts and again as it is rotated
+      SQUARE_SIGNATURE_KEY_SECRET = google_secret_manager_secret.square_signature_key.id
     }
   }
 }
@@ -1,7 +1,179 @@
 package squarewebhookingress
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
 
-func TestMain(t *testing.T) {
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/cloudevents/sdk-go/v2/event"
 
+	"github.com/kofc7186/fundraiser-manager/pkg/event/eventtest"
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser/fundraisertest"
+	"github.com/kofc7186/fundraiser-manager/pkg/square/webhooks/webhookstest"
+)
+
+const testProject = "test-project"
+
+// testRoutes route the sandbox payment, which is taken at another location, to another fundraiser
+const testRoutes = `
+rules:
+- id: fishfry-sandbox
+  locations: [LH2GN6FGF5MQH]
+`
+
+// maps the environment variable naming each topic to the topic ID created in the fake Pub/Sub server
+var testTopics = map[string]string{
+	"SQUARE_ORDER_REQUEST_TOPIC":    "square-order-request",
+	"SQUARE_PAYMENT_WEBHOOK_TOPIC":  "square-payment-webhook",
+	"SQUARE_REFUND_WEBHOOK_TOPIC":   "square-refund-webhook",
+	"SQUARE_CUSTOMER_WEBHOOK_TOPIC": "square-customer-webhook",
+}
+
+// fakePubSub is started during package variable initialization, which happens before init() in function.go
+// connects to Pub/Sub and checks that each topic exists, and watches the fundraiser documents in Firestore
+var fakePubSub = startFakePubSub()
+
+func startFakePubSub() *pstest.Server {
+	srv := pstest.NewServer()
+
+	env := map[string]string{
+		"PUBSUB_EMULATOR_HOST":    srv.Addr,
+		"FIRESTORE_EMULATOR_HOST": fundraisertest.StartFirestore(),
+		"GCP_PROJECT":             testProject,
+		"K_SERVICE":               FUNCTION_NAME,
+		"EXPIRATION_TIME":         "2024-03-16T00:00:00Z",
+		"FUNDRAISER_ID":           "fishfry-030824",
+		"FUNDRAISERS":             testRoutes,
+		"SQUARE_SIGNATURE_KEY":    webhookstest.SignatureKey,
+		"WEBHOOK_URL":             webhookstest.NotificationURL,
+		"OTEL_TRACES_EXPORTER":    "none",
+		"OTEL_METRICS_EXPORTER":   "none",
+	}
+	for key, topic := range testTopics {
+		env[key] = topic
+
+		topicName := fmt.Sprintf("projects/%s/topics/%s", testProject, topic)
+		if _, err := srv.GServer.CreateTopic(context.Background(), &pubsubpb.Topic{Name: topicName}); err != nil {
+			panic(err)
+		}
+		if _, err := srv.GServer.CreateSubscription(context.Background(), &pubsubpb.Subscription{
+			Name:  fmt.Sprintf("projects/%s/subscriptions/%s", testProject, topic),
+			Topic: topicName,
+		}); err != nil {
+			panic(err)
+		}
+	}
+
+	for key, value := range env {
+		if err := os.Setenv(key, value); err != nil {
+			panic(err)
+		}
+	}
+	return srv
+}
+
+// pullAll returns (and acknowledges) every message published to the topic since the last call
+func pullAll(t *testing.T, topic string) []*pubsubpb.PubsubMessage {
+	t.Helper()
+	resp, err := fakePubSub.GServer.Pull(context.Background(), &pubsubpb.PullRequest{
+		Subscription:      fmt.Sprintf("projects/%s/subscriptions/%s", testProject, topic),
+		ReturnImmediately: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msgs []*pubsubpb.PubsubMessage
+	var ackIDs []string
+	for _, received := range resp.ReceivedMessages {
+		msgs = append(msgs, received.Message)
+		ackIDs = append(ackIDs, received.AckId)
+	}
+	if len(ackIDs) > 0 {
+		if _, err := fakePubSub.GServer.Acknowledge(context.Background(), &pubsubpb.AcknowledgeRequest{
+			Subscription: fmt.Sprintf("projects/%s/subscriptions/%s", testProject, topic),
+			AckIds:       ackIDs,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return msgs
+}
+
+func TestWebhookRouter(t *testing.T) {
+	// fixture names are prefixed with the Square webhook object, e.g. "payment.created.pos"
+	expectedTopics := map[string]string{
+		"customer": testTopics["SQUARE_CUSTOMER_WEBHOOK_TOPIC"],
+		"order":    testTopics["SQUARE_ORDER_REQUEST_TOPIC"],
+		"payment":  testTopics["SQUARE_PAYMENT_WEBHOOK_TOPIC"],
+		"refund":   testTopics["SQUARE_REFUND_WEBHOOK_TOPIC"],
+	}
+
+	for _, name := range webhookstest.WebhookNames() {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			WebhookRouter(w, webhookstest.NewSignedRequest(t, webhookstest.Webhook(t, name)))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+			}
+
+			expectedTopic := expectedTopics[strings.SplitN(name, ".", 2)[0]]
+			for _, topic := range testTopics {
+				msgs := pullAll(t, topic)
+				if topic != expectedTopic {
+					if len(msgs) != 0 {
+						t.Errorf("unexpected %d message(s) published to %s", len(msgs), topic)
+					}
+					continue
+				}
+				if len(msgs) != 1 {
+					t.Fatalf("got %d message(s) published to %s, want 1", len(msgs), topic)
+				}
+
+				published := &event.Event{}
+				if err := published.UnmarshalJSON(msgs[0].Data); err != nil {
+					t.Fatal(err)
+				}
+				eventtest.AssertGoldenEvent(t, filepath.Join("testdata", name+".golden.json"), published)
+			}
+		})
+	}
+}
+
+func TestWebhookRouterRejectsInvalidSignature(t *testing.T) {
+	body := webhookstest.Webhook(t, "payment.created.online")
+	r := httptest.NewRequest(http.MethodPost, webhookstest.NotificationURL, bytes.NewReader(body))
+	r.Header.Set("x-square-hmacsha256-signature", webhookstest.Sign(t, body, "some-other-key", webhookstest.NotificationURL))
+
+	w := httptest.NewRecorder()
+	WebhookRouter(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	for _, topic := range testTopics {
+		if msgs := pullAll(t, topic); len(msgs) != 0 {
+			t.Errorf("unexpected %d message(s) published to %s", len(msgs), topic)
+		}
+	}
+}
+
+func TestWebhookRouterRejectsUnsupportedType(t *testing.T) {
+	body := []byte(`{"merchant_id":"6SSW7HV8K2ST5","type":"inventory.count.updated","event_id":"x","created_at":"2024-03-08T22:15:03.719Z","data":{}}`)
+
+	w := httptest.NewRecorder()
+	WebhookRouter(w, webhookstest.NewSignedRequest(t, body))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
 }
@@ -30,6 +30,12 @@ variable "expiration_time" {
   type        = string
 }
 
+variable "fundraisers" {
+  description = "How Square objects are routed to the fundraisers served by this deployment, as YAML (see pkg/fundraiser); if empty, every object belongs to fundraiser_id"
+  type        = string
+  default     = ""
+}
+
 variable "min_instance_count" {
   description = "The limit on the minimum number of function instances that may coexist at a given time"
   type        = number
@@ -55,3 +61,8 @@ variable "square_refund_webhook_topic" {
   description = "The pubsub topic where Square refund webhook events are published"
   type        = string
 }
+
+variable "claim_check_bucket" {
+  description = "The name of the GCS bucket which oversized event payloads are checked into"
+  type        = string
+}
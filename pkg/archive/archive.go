@@ -0,0 +1,108 @@
+// Package archive exports the Firestore documents of a fundraiser to object storage before the TTL policy deletes
+// them after EXPIRATION_TIME, and imports such archives back into Firestore
+//
+// An archive is a set of gzip-compressed newline-delimited JSON bundles, one per collection, beneath a prefix
+// (see Prefix) along with a manifest recording the size, SHA-256 checksum and document count of each bundle
+package archive
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// EXPIRATION_FIELD is the field which the Firestore TTL policy expires documents by
+const EXPIRATION_FIELD = "expiration"
+
+// Collections are the collections beneath fundraisers/<id> which are archived
+var Collections = []string{"events", "orders", "payments", "refunds", "customers"}
+
+// Export writes every document in Collections of the fundraiser to an archive at prefix
+func Export(ctx context.Context, client *firestore.Client, bucket Bucket, fundraiserID, prefix string) (*Manifest, error) {
+	manifest := &Manifest{FundraiserID: fundraiserID, CreatedTime: time.Now().UTC(), Bundles: []Bundle{}}
+	for _, collection := range Collections {
+		docs := client.Collection(collectionPath(fundraiserID, collection)).Documents(ctx)
+		bundle, err := writeBundle(ctx, bucket, prefix, collection, func() (*document, error) {
+			docSnap, err := docs.Next()
+			if err == iterator.Done {
+				return nil, nil
+			} else if err != nil {
+				return nil, err
+			}
+			return &document{ID: docSnap.Ref.ID, Data: encodeMap(docSnap.Data())}, nil
+		})
+		docs.Stop()
+		if err != nil {
+			return nil, fmt.Errorf("exporting %s: %w", collection, err)
+		}
+		manifest.Bundles = append(manifest.Bundles, bundle)
+	}
+
+	if err := writeManifest(ctx, bucket, prefix, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Import verifies the archive at prefix and writes its documents into the fundraiser, which need not be the one
+// the archive was exported from; unless expiration is zero, it replaces the expiration of every document, as
+// those of an archive have usually passed and the TTL policy would promptly delete them again
+func Import(ctx context.Context, client *firestore.Client, bucket Bucket, prefix, fundraiserID string, expiration time.Time) (*Manifest, error) {
+	manifest, err := Verify(ctx, bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := client.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	for _, bundle := range manifest.Bundles {
+		path := collectionPath(fundraiserID, bundle.Collection)
+		err := readBundle(ctx, bucket, prefix, bundle, func(doc *document) error {
+			data, err := decodeMap(client, doc.Data)
+			if err != nil {
+				return err
+			}
+			setExpiration(data, expiration)
+
+			job, err := writer.Set(client.Doc(fmt.Sprintf("%s/%s", path, doc.ID)), data)
+			if err != nil {
+				return err
+			}
+			jobs = append(jobs, job)
+			return nil
+		})
+		if err != nil {
+			writer.End()
+			return nil, fmt.Errorf("importing %s: %w", bundle.Collection, err)
+		}
+	}
+	writer.End()
+
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+// setExpiration replaces the expiration of a document, keeping its representation; events carry it as a
+// CloudEvent extension, which is a string
+func setExpiration(data map[string]interface{}, expiration time.Time) {
+	if expiration.IsZero() {
+		return
+	}
+	switch data[EXPIRATION_FIELD].(type) {
+	case time.Time:
+		data[EXPIRATION_FIELD] = expiration
+	case string:
+		data[EXPIRATION_FIELD] = expiration.UTC().Format(time.RFC3339)
+	}
+}
+
+func collectionPath(fundraiserID, collection string) string {
+	return fmt.Sprintf("fundraisers/%s/%s", fundraiserID, collection)
+}
@@ -0,0 +1,174 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/type/latlng"
+)
+
+func testDocuments() []*document {
+	return []*document{
+		{ID: "KkAkhdMsgzn59SM8A89WgKwekxLZY", Data: map[string]interface{}{
+			"expiration":  time.Date(2024, 3, 10, 5, 0, 0, 0, time.UTC),
+			"orderNumber": int64(1042),
+			"tipAmount":   float64(3),
+			"totalAmount": 17.25,
+			"label":       []byte("%PDF-1.7"),
+			"location":    &latlng.LatLng{Latitude: 40.1, Longitude: -75.3},
+			"items":       []interface{}{"fish", map[string]interface{}{"quantity": int64(2)}},
+			"customer":    map[string]interface{}{"firstName": "Ada", "notes": nil, "vip": true},
+		}},
+		{ID: "R2B3Z8WMVt3EAmzYWLZvz7Y69EbZY", Data: map[string]interface{}{
+			"expiration": "2024-03-10T05:00:00Z",
+			"nan":        math.NaN(),
+		}},
+	}
+}
+
+func writeTestArchive(t *testing.T, bucket Bucket, prefix string) *Manifest {
+	t.Helper()
+	manifest := &Manifest{FundraiserID: "fishfry-030824", CreatedTime: time.Now().UTC()}
+	for _, collection := range []string{"orders", "events"} {
+		docs := testDocuments()
+		bundle, err := writeBundle(context.Background(), bucket, prefix, collection, func() (*document, error) {
+			if len(docs) == 0 {
+				return nil, nil
+			}
+			doc := &document{ID: docs[0].ID, Data: encodeMap(docs[0].Data)}
+			docs = docs[1:]
+			return doc, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest.Bundles = append(manifest.Bundles, bundle)
+	}
+	if err := writeManifest(context.Background(), bucket, prefix, manifest); err != nil {
+		t.Fatal(err)
+	}
+	return manifest
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	bucket := DirBucket(t.TempDir())
+	prefix := Prefix("fishfry-030824", time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC))
+	if prefix != "fishfry-030824/20240309T120000Z/" {
+		t.Errorf("Prefix = %s", prefix)
+	}
+	written := writeTestArchive(t, bucket, prefix)
+
+	manifest, err := Verify(context.Background(), bucket, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(manifest, written) {
+		t.Errorf("manifest = %+v, want %+v", manifest, written)
+	}
+	if manifest.Bundles[0].Documents != 2 || manifest.Bundles[0].Object != "orders.ndjson.gz" {
+		t.Errorf("unexpected bundle %+v", manifest.Bundles[0])
+	}
+
+	var read []*document
+	err = readBundle(context.Background(), bucket, prefix, manifest.Bundles[0], func(doc *document) error {
+		data, err := decodeMap(nil, doc.Data)
+		if err != nil {
+			return err
+		}
+		read = append(read, &document{ID: doc.ID, Data: data})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := testDocuments()
+	if len(read) != len(want) {
+		t.Fatalf("read %d documents, want %d", len(read), len(want))
+	}
+	// NaN never equals itself, so compare it separately
+	if nan, ok := read[1].Data["nan"].(float64); !ok || !math.IsNaN(nan) {
+		t.Errorf("nan = %#v", read[1].Data["nan"])
+	}
+	delete(read[1].Data, "nan")
+	delete(want[1].Data, "nan")
+	for i := range want {
+		if !reflect.DeepEqual(read[i], want[i]) {
+			t.Errorf("document %d = %#v, want %#v", i, read[i].Data, want[i].Data)
+		}
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	dir := t.TempDir()
+	bucket := DirBucket(dir)
+	writeTestArchive(t, bucket, "a/")
+
+	object := filepath.Join(dir, "a", "events.ndjson.gz")
+	contents, err := os.ReadFile(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(object, append(contents, 0), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Verify(context.Background(), bucket, "a/"); err == nil || !strings.Contains(err.Error(), "events.ndjson.gz") {
+		t.Errorf("err = %v, want a size mismatch on events.ndjson.gz", err)
+	}
+}
+
+func TestVerifyMissingManifest(t *testing.T) {
+	if _, err := Verify(context.Background(), DirBucket(t.TempDir()), "a/"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("err = %v, want ErrObjectNotFound", err)
+	}
+}
+
+func TestEncodeValue(t *testing.T) {
+	encoded, err := json.Marshal(encodeMap(map[string]interface{}{
+		"at":    time.Date(2024, 3, 8, 21, 30, 0, 0, time.FixedZone("EST", -5*60*60)),
+		"whole": float64(3),
+		"inf":   math.Inf(1),
+		"part":  2.5,
+		"count": int64(7),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"at":{"$timestamp":"2024-03-09T02:30:00Z"},"count":7,"inf":{"$double":"+Inf"},"part":2.5,"whole":{"$double":"3"}}`
+	if string(encoded) != want {
+		t.Errorf("encoded = %s, want %s", encoded, want)
+	}
+}
+
+func TestSetExpiration(t *testing.T) {
+	expiration := time.Date(2025, 3, 10, 5, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{"timestamp", time.Date(2024, 3, 10, 5, 0, 0, 0, time.UTC), expiration},
+		{"event extension", "2024-03-10T05:00:00Z", "2025-03-10T05:00:00Z"},
+		{"absent", nil, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			data := map[string]interface{}{}
+			if test.value != nil {
+				data[EXPIRATION_FIELD] = test.value
+			}
+			setExpiration(data, expiration)
+			if data[EXPIRATION_FIELD] != test.want {
+				t.Errorf("expiration = %v, want %v", data[EXPIRATION_FIELD], test.want)
+			}
+		})
+	}
+}
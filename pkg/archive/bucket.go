@@ -0,0 +1,101 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// ErrObjectNotFound is returned when reading an object which does not exist
+var ErrObjectNotFound = errors.New("object not found")
+
+// Bucket stores archive objects by name
+type Bucket interface {
+	NewWriter(ctx context.Context, name string) (io.WriteCloser, error)
+	NewReader(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// OpenBucket returns the GCS bucket for a gs://<bucket> URL, and otherwise a DirBucket rooted at the given path
+func OpenBucket(ctx context.Context, location string) (Bucket, error) {
+	if bucketName, ok := strings.CutPrefix(location, "gs://"); ok {
+		return NewGCSBucket(ctx, strings.TrimSuffix(bucketName, "/"))
+	}
+	return DirBucket(strings.TrimPrefix(location, "file://")), nil
+}
+
+// DirBucket stores objects as files beneath a local directory
+type DirBucket string
+
+func (d DirBucket) NewWriter(ctx context.Context, name string) (io.WriteCloser, error) {
+	path := filepath.Join(string(d), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+func (d DirBucket) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(string(d), filepath.FromSlash(name)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", name, ErrObjectNotFound)
+	}
+	return f, err
+}
+
+// GCSBucket stores objects in Cloud Storage
+type GCSBucket struct {
+	service *storage.Service
+	bucket  string
+}
+
+// NewGCSBucket returns the named Cloud Storage bucket, using application default credentials
+func NewGCSBucket(ctx context.Context, bucket string) (*GCSBucket, error) {
+	service, err := storage.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &GCSBucket{service: service, bucket: bucket}, nil
+}
+
+func (g *GCSBucket) NewWriter(ctx context.Context, name string) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	w := &gcsWriter{PipeWriter: pw, done: make(chan error, 1)}
+	go func() {
+		_, err := g.service.Objects.Insert(g.bucket, &storage.Object{Name: name}).Media(pr).Context(ctx).Do()
+		// unblock any further writes should the upload fail part way through
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+func (g *GCSBucket) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := g.service.Objects.Get(g.bucket, name).Context(ctx).Download()
+	var apiError *googleapi.Error
+	if errors.As(err, &apiError) && apiError.Code == 404 {
+		return nil, fmt.Errorf("gs://%s/%s: %w", g.bucket, name, ErrObjectNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// gcsWriter streams into an upload, which only completes once the writer is closed
+type gcsWriter struct {
+	*io.PipeWriter
+	done chan error
+}
+
+func (w *gcsWriter) Close() error {
+	if err := w.PipeWriter.Close(); err != nil {
+		return err
+	}
+	return <-w.done
+}
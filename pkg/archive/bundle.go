@@ -0,0 +1,192 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"time"
+)
+
+// MANIFEST_NAME is the object, relative to an archive's prefix, which describes the archive; it is written last,
+// so an archive without a manifest is incomplete
+const MANIFEST_NAME = "manifest.json"
+
+// Manifest describes an archive of a fundraiser
+type Manifest struct {
+	FundraiserID string    `json:"fundraiserId"`
+	CreatedTime  time.Time `json:"createdTime"`
+	Bundles      []Bundle  `json:"bundles"`
+}
+
+// Bundle is a gzip-compressed newline-delimited JSON object holding every document of one collection
+type Bundle struct {
+	Collection string `json:"collection"`
+	// Object is the name of the bundle, relative to the archive's prefix
+	Object    string `json:"object"`
+	Documents int    `json:"documents"`
+	// Size and SHA256 are of the compressed object
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// document is a line of a bundle
+type document struct {
+	ID   string                 `json:"id"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// Prefix is where an archive of the fundraiser created at t is stored within a bucket
+func Prefix(fundraiserID string, t time.Time) string {
+	return fmt.Sprintf("%s/%s/", fundraiserID, t.UTC().Format("20060102T150405Z"))
+}
+
+// writeBundle writes the documents returned by next, until it returns nil, to a new bundle
+func writeBundle(ctx context.Context, bucket Bucket, prefix, collection string, next func() (*document, error)) (bundle Bundle, err error) {
+	bundle = Bundle{Collection: collection, Object: collection + ".ndjson.gz"}
+	w, err := bucket.NewWriter(ctx, path.Join(prefix, bundle.Object))
+	if err != nil {
+		return bundle, err
+	}
+	defer func() {
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(w, hash)}
+	zw := gzip.NewWriter(counter)
+	encoder := json.NewEncoder(zw)
+	for {
+		doc, err := next()
+		if err != nil {
+			return bundle, err
+		}
+		if doc == nil {
+			break
+		}
+		if err := encoder.Encode(doc); err != nil {
+			return bundle, fmt.Errorf("%s/%s: %w", collection, doc.ID, err)
+		}
+		bundle.Documents++
+	}
+	if err := zw.Close(); err != nil {
+		return bundle, err
+	}
+
+	bundle.Size = counter.n
+	bundle.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return bundle, nil
+}
+
+// readBundle calls fn with each document of the bundle, then checks the bundle against the manifest; as fn is
+// called before the checksum can be verified, call Verify first if fn has side effects
+func readBundle(ctx context.Context, bucket Bucket, prefix string, bundle Bundle, fn func(*document) error) error {
+	r, err := bucket.NewReader(ctx, path.Join(prefix, bundle.Object))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	hash := sha256.New()
+	counter := &countingWriter{w: hash}
+	zr, err := gzip.NewReader(io.TeeReader(r, counter))
+	if err != nil {
+		return fmt.Errorf("%s: %w", bundle.Object, err)
+	}
+
+	documents := 0
+	decoder := json.NewDecoder(bufio.NewReader(zr))
+	decoder.UseNumber()
+	for decoder.More() {
+		doc := &document{}
+		if err := decoder.Decode(doc); err != nil {
+			return fmt.Errorf("%s: document %d: %w", bundle.Object, documents+1, err)
+		}
+		if err := fn(doc); err != nil {
+			return fmt.Errorf("%s: %s: %w", bundle.Object, doc.ID, err)
+		}
+		documents++
+	}
+	// drain the gzip trailer and anything after it, so the checksum covers the whole object
+	if _, err := io.Copy(io.Discard, zr); err != nil {
+		return fmt.Errorf("%s: %w", bundle.Object, err)
+	}
+	if _, err := io.Copy(io.Discard, io.TeeReader(r, counter)); err != nil {
+		return err
+	}
+
+	switch {
+	case counter.n != bundle.Size:
+		return fmt.Errorf("%s: size is %d bytes, manifest records %d", bundle.Object, counter.n, bundle.Size)
+	case hex.EncodeToString(hash.Sum(nil)) != bundle.SHA256:
+		return fmt.Errorf("%s: checksum does not match manifest", bundle.Object)
+	case documents != bundle.Documents:
+		return fmt.Errorf("%s: holds %d documents, manifest records %d", bundle.Object, documents, bundle.Documents)
+	}
+	return nil
+}
+
+// ReadManifest returns the manifest of the archive at prefix
+func ReadManifest(ctx context.Context, bucket Bucket, prefix string) (*Manifest, error) {
+	r, err := bucket.NewReader(ctx, path.Join(prefix, MANIFEST_NAME))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	manifest := &Manifest{}
+	if err := json.NewDecoder(r).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("%s: %w", MANIFEST_NAME, err)
+	}
+	return manifest, nil
+}
+
+func writeManifest(ctx context.Context, bucket Bucket, prefix string, manifest *Manifest) error {
+	w, err := bucket.NewWriter(ctx, path.Join(prefix, MANIFEST_NAME))
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Verify checks that every bundle of the archive at prefix is intact and decodable, returning its manifest
+func Verify(ctx context.Context, bucket Bucket, prefix string) (*Manifest, error) {
+	manifest, err := ReadManifest(ctx, bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, bundle := range manifest.Bundles {
+		err := readBundle(ctx, bucket, prefix, bundle, func(doc *document) error {
+			_, err := decodeMap(nil, doc.Data)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,154 @@
+package archive
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/genproto/googleapis/type/latlng"
+)
+
+// Firestore values which JSON cannot represent are encoded as single-key objects, so they are restored with their
+// original type on import
+const (
+	TIMESTAMP_KEY = "$timestamp"
+	BYTES_KEY     = "$bytes"
+	DOUBLE_KEY    = "$double"
+	REFERENCE_KEY = "$reference"
+	GEO_POINT_KEY = "$geoPoint"
+)
+
+// encodeValue converts a value returned by firestore.DocumentSnapshot.Data into one which survives a round trip
+// through JSON
+func encodeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		return map[string]interface{}{TIMESTAMP_KEY: v.UTC().Format(time.RFC3339Nano)}
+	case []byte:
+		return map[string]interface{}{BYTES_KEY: base64.StdEncoding.EncodeToString(v)}
+	case float64:
+		// integral doubles would otherwise be restored as integers, and NaN and infinities are not valid JSON
+		if v == math.Trunc(v) || math.IsNaN(v) {
+			return map[string]interface{}{DOUBLE_KEY: strconv.FormatFloat(v, 'g', -1, 64)}
+		}
+		return v
+	case *firestore.DocumentRef:
+		return map[string]interface{}{REFERENCE_KEY: relativePath(v.Path)}
+	case *latlng.LatLng:
+		return map[string]interface{}{GEO_POINT_KEY: []float64{v.GetLatitude(), v.GetLongitude()}}
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = encodeValue(v[i])
+		}
+		return values
+	case map[string]interface{}:
+		return encodeMap(v)
+	}
+	return value
+}
+
+func encodeMap(m map[string]interface{}) map[string]interface{} {
+	encoded := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		encoded[key] = encodeValue(value)
+	}
+	return encoded
+}
+
+// decodeValue reverses encodeValue on a value decoded with json.Decoder.UseNumber; references are resolved with
+// client, or left as paths if it is nil
+func decodeValue(client *firestore.Client, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			var err error
+			if values[i], err = decodeValue(client, v[i]); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	case map[string]interface{}:
+		if len(v) == 1 {
+			if decoded, ok, err := decodeTyped(client, v); ok || err != nil {
+				return decoded, err
+			}
+		}
+		return decodeMap(client, v)
+	}
+	return value, nil
+}
+
+func decodeMap(client *firestore.Client, m map[string]interface{}) (map[string]interface{}, error) {
+	decoded := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		var err error
+		if decoded[key], err = decodeValue(client, value); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return decoded, nil
+}
+
+// decodeTyped decodes the single-key objects written by encodeValue; ok is false for any other object
+func decodeTyped(client *firestore.Client, m map[string]interface{}) (decoded interface{}, ok bool, err error) {
+	for key, value := range m {
+		switch key {
+		case TIMESTAMP_KEY, BYTES_KEY, DOUBLE_KEY, REFERENCE_KEY:
+			s, isString := value.(string)
+			if !isString {
+				return nil, true, fmt.Errorf("%s must be a string, got %v", key, value)
+			}
+			switch key {
+			case TIMESTAMP_KEY:
+				decoded, err = time.Parse(time.RFC3339Nano, s)
+			case BYTES_KEY:
+				decoded, err = base64.StdEncoding.DecodeString(s)
+			case DOUBLE_KEY:
+				decoded, err = strconv.ParseFloat(s, 64)
+			case REFERENCE_KEY:
+				decoded = s
+				if client != nil {
+					decoded = client.Doc(s)
+				}
+			}
+			return decoded, true, err
+		case GEO_POINT_KEY:
+			point, isPoint := value.([]interface{})
+			if !isPoint || len(point) != 2 {
+				return nil, true, fmt.Errorf("%s must be [latitude, longitude], got %v", key, value)
+			}
+			var coordinates [2]float64
+			for i := range point {
+				n, isNumber := point[i].(json.Number)
+				if !isNumber {
+					return nil, true, fmt.Errorf("%s must be [latitude, longitude], got %v", key, value)
+				}
+				if coordinates[i], err = n.Float64(); err != nil {
+					return nil, true, err
+				}
+			}
+			return &latlng.LatLng{Latitude: coordinates[0], Longitude: coordinates[1]}, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// relativePath strips the projects/<p>/databases/<d>/documents/ prefix from a document path
+func relativePath(path string) string {
+	if _, relative, ok := strings.Cut(path, "/documents/"); ok {
+		return relative
+	}
+	return path
+}
@@ -0,0 +1,250 @@
+// Package claimcheck keeps oversized payloads out of events: when the data of an event is larger than a threshold,
+// its raw Square payload is checked into blob storage under its SHA-256 hash and the event carries a reference to
+// it instead, which the handlers consuming the event check back out before decoding it
+//
+// Payloads are checked in by controller.Publish once Init has configured a Store, and checked out by handlers
+// wrapped with CloudEvent; references name the bucket they were written to, so events replayed into another
+// fundraiser still resolve
+package claimcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/archive"
+	"github.com/kofc7186/fundraiser-manager/pkg/config"
+)
+
+const (
+	// BUCKET_ENV is the environment variable holding the location of the bucket payloads are checked into, e.g.
+	// gs://fishfry-030824-claim-checks; payloads are not checked in unless it is set
+	BUCKET_ENV = "CLAIM_CHECK_BUCKET"
+	// THRESHOLD_ENV is the environment variable which overrides DefaultThreshold
+	THRESHOLD_ENV = "CLAIM_CHECK_THRESHOLD"
+
+	// EXTENSION is the CloudEvent extension listing the fields of an event's data which have been checked in
+	EXTENSION = "claimcheck"
+	// REFERENCE_FIELD is the only field of the object which replaces a payload checked in
+	REFERENCE_FIELD = "$claimCheck"
+)
+
+// DefaultThreshold is the size in bytes of the data of an event above which its payloads are checked in; it keeps
+// events well within the 1 MiB limit of the Firestore documents the event lake records them in
+const DefaultThreshold = 256 * 1024
+
+// Fields are the top-level fields of event data which may be checked in: the raw Square payload of a webhook event,
+// or of an API response
+var Fields = []string{"raw", "Raw"}
+
+var (
+	// ErrNotFound is returned when the payload a reference names no longer exists
+	ErrNotFound = errors.New("claim-checked payload not found")
+	// ErrCorrupt is returned when a payload does not match the hash or size of its reference
+	ErrCorrupt = errors.New("claim-checked payload does not match its reference")
+)
+
+// Reference locates a payload which has been checked in
+type Reference struct {
+	// Location is the bucket holding the payload, as passed to archive.OpenBucket
+	Location string `json:"location"`
+	Object   string `json:"object"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+}
+
+// Store checks payloads into a bucket
+type Store struct {
+	Location  string
+	Bucket    archive.Bucket
+	Threshold int
+}
+
+var store *Store
+
+// Init configures the Store payloads are checked into from BUCKET_ENV and THRESHOLD_ENV; if BUCKET_ENV is not set,
+// events are published whole
+func Init() error {
+	var cfg struct {
+		Location  string `env:"CLAIM_CHECK_BUCKET"`
+		Threshold int    `env:"CLAIM_CHECK_THRESHOLD"`
+	}
+	cfg.Threshold = DefaultThreshold
+	if err := config.Load(&cfg); err != nil {
+		return err
+	}
+	if cfg.Location == "" {
+		return nil
+	}
+	if cfg.Threshold < 0 {
+		return fmt.Errorf("%s must be a non-negative integer, got %d", THRESHOLD_ENV, cfg.Threshold)
+	}
+
+	bucket, err := openBucket(context.Background(), cfg.Location)
+	if err != nil {
+		return err
+	}
+	store = &Store{Location: cfg.Location, Bucket: bucket, Threshold: cfg.Threshold}
+	return nil
+}
+
+// Offload checks the payloads of e into the Store configured by Init, if any; see Store.Offload
+func Offload(ctx context.Context, e *event.Event) error {
+	if store == nil {
+		return nil
+	}
+	return store.Offload(ctx, e)
+}
+
+// Offload checks each of the Fields of the data of e into the store, replacing it with a reference, if the data
+// is larger than the threshold; events which have already been offloaded are left alone
+func (s *Store) Offload(ctx context.Context, e *event.Event) error {
+	if len(e.Data()) <= s.Threshold {
+		return nil
+	}
+	if _, ok := e.Extensions()[EXTENSION]; ok {
+		return nil
+	}
+
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(e.Data(), &data); err != nil {
+		// only JSON objects have fields to check in
+		return nil
+	}
+	var offloaded []string
+	for _, field := range Fields {
+		payload, ok := data[field]
+		if !ok || string(payload) == "null" {
+			continue
+		}
+		ref, err := s.CheckIn(ctx, payload)
+		if err != nil {
+			return fmt.Errorf("checking in %s of %s: %w", field, e.ID(), err)
+		}
+		if data[field], err = json.Marshal(map[string]*Reference{REFERENCE_FIELD: ref}); err != nil {
+			return err
+		}
+		offloaded = append(offloaded, field)
+	}
+	if len(offloaded) == 0 {
+		return nil
+	}
+
+	if err := e.SetData(e.DataContentType(), data); err != nil {
+		return err
+	}
+	e.SetExtension(EXTENSION, strings.Join(offloaded, ","))
+	return nil
+}
+
+// CheckIn writes payload to the store under its hash, so that checking in the same payload again is harmless
+func (s *Store) CheckIn(ctx context.Context, payload []byte) (*Reference, error) {
+	sum := sha256.Sum256(payload)
+	ref := &Reference{
+		Location: s.Location,
+		Object:   "sha256/" + hex.EncodeToString(sum[:]),
+		SHA256:   hex.EncodeToString(sum[:]),
+		Size:     int64(len(payload)),
+	}
+
+	w, err := s.Bucket.NewWriter(ctx, ref.Object)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(payload); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+// buckets caches the buckets references have been checked out of, by location
+var buckets sync.Map
+
+// openBucket is replaced by tests
+var openBucket = archive.OpenBucket
+
+// CheckOut reads the payload ref names, verifying it against the reference
+func CheckOut(ctx context.Context, ref *Reference) ([]byte, error) {
+	var bucket archive.Bucket
+	if b, ok := buckets.Load(ref.Location); ok {
+		bucket = b.(archive.Bucket)
+	} else {
+		var err error
+		if bucket, err = openBucket(ctx, ref.Location); err != nil {
+			return nil, err
+		}
+		buckets.Store(ref.Location, bucket)
+	}
+
+	r, err := bucket.NewReader(ctx, ref.Object)
+	if errors.Is(err, archive.ErrObjectNotFound) {
+		return nil, fmt.Errorf("%s/%s: %w", ref.Location, ref.Object, ErrNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	payload, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(payload)
+	if int64(len(payload)) != ref.Size || hex.EncodeToString(sum[:]) != ref.SHA256 {
+		return nil, fmt.Errorf("%s/%s: %w", ref.Location, ref.Object, ErrCorrupt)
+	}
+	return payload, nil
+}
+
+// Resolve checks out the payloads of e, restoring its data as it was before Offload
+func Resolve(ctx context.Context, e *event.Event) error {
+	if _, ok := e.Extensions()[EXTENSION]; !ok {
+		return nil
+	}
+
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(e.Data(), &data); err != nil {
+		return err
+	}
+	for field, value := range data {
+		ref, ok := reference(value)
+		if !ok {
+			continue
+		}
+		payload, err := CheckOut(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("checking out %s of %s: %w", field, e.ID(), err)
+		}
+		data[field] = payload
+	}
+
+	if err := e.SetData(e.DataContentType(), data); err != nil {
+		return err
+	}
+	e.SetExtension(EXTENSION, nil)
+	return nil
+}
+
+// reference decodes value if it is a reference
+func reference(value json.RawMessage) (*Reference, bool) {
+	if !bytes.Contains(value, []byte(REFERENCE_FIELD)) {
+		return nil, false
+	}
+	var wrapper map[string]*Reference
+	if err := json.Unmarshal(value, &wrapper); err != nil || len(wrapper) != 1 || wrapper[REFERENCE_FIELD] == nil {
+		return nil, false
+	}
+	return wrapper[REFERENCE_FIELD], true
+}
@@ -0,0 +1,177 @@
+package claimcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/archive"
+	eventschemas "github.com/kofc7186/fundraiser-manager/pkg/event/schemas"
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+	"github.com/kofc7186/fundraiser-manager/pkg/tracing"
+)
+
+// newTestEvent returns a golden getPayment response, whose data carries a raw Square payload
+func newTestEvent(t *testing.T) *event.Event {
+	data, err := os.ReadFile(filepath.Join("..", "event", "schemas", "testdata", "get_payment.golden.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := event.New()
+	if err := e.UnmarshalJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	return &e
+}
+
+func newTestStore(t *testing.T, threshold int) *Store {
+	dir := t.TempDir()
+	return &Store{Location: dir, Bucket: archive.DirBucket(dir), Threshold: threshold}
+}
+
+func TestOffloadResolve(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, 0)
+	e := newTestEvent(t)
+	original := e.Clone()
+
+	if err := s.Offload(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.Extensions()[EXTENSION]; got != "Raw" {
+		t.Fatalf("%s extension is %v, want Raw", EXTENSION, got)
+	}
+	if bytes.Equal(e.Data(), original.Data()) || len(e.Data()) >= len(original.Data()) {
+		t.Fatalf("data was not offloaded: %s", e.Data())
+	}
+	// offloaded events still conform to their definitions, so they can be published
+	if err := eventschemas.Validate(e); err != nil {
+		t.Errorf("offloaded event does not validate: %v", err)
+	}
+
+	// offloading again is harmless
+	offloaded := e.Clone()
+	if err := s.Offload(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(e.Data(), offloaded.Data()) {
+		t.Errorf("offloading twice changed the data: %s", e.Data())
+	}
+
+	if err := Resolve(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := e.Extensions()[EXTENSION]; ok {
+		t.Errorf("%s extension survived Resolve", EXTENSION)
+	}
+	var want, got eventschemas.SquareGetPaymentResponse
+	if err := original.DataAs(&want); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.DataAs(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Raw.Payment == nil || got.Raw.Payment.Id != want.Raw.Payment.Id {
+		t.Errorf("resolved payload is %+v, want %+v", got.Raw, want.Raw)
+	}
+}
+
+func TestOffloadBelowThreshold(t *testing.T) {
+	e := newTestEvent(t)
+	original := e.Clone()
+	if err := newTestStore(t, len(e.Data())).Offload(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := e.Extensions()[EXTENSION]; ok || !bytes.Equal(e.Data(), original.Data()) {
+		t.Errorf("an event within the threshold was offloaded: %s", e.Data())
+	}
+}
+
+func TestCheckOutErrors(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, 0)
+	ref, err := s.CheckIn(ctx, []byte(`{"payment": {}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload, err := CheckOut(ctx, ref); err != nil || string(payload) != `{"payment": {}}` {
+		t.Fatalf("CheckOut = %s, %v", payload, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(s.Location, filepath.FromSlash(ref.Object)), []byte(`{"payment": []}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckOut(ctx, ref); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("CheckOut of a modified payload returned %v, want ErrCorrupt", err)
+	}
+
+	missing := *ref
+	missing.Object = "sha256/missing"
+	if _, err := CheckOut(ctx, &missing); !errors.Is(err, ErrNotFound) {
+		t.Errorf("CheckOut of a missing payload returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestCloudEvent(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, 0)
+
+	deliver := func(nested *event.Event) event.Event {
+		nestedJSON, err := nested.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		delivery := event.New()
+		delivery.SetID("1")
+		delivery.SetType(tracing.PubSubMessagePublishedType)
+		if err := delivery.SetData("application/json", eventschemas.MessagePublishedData{
+			Message:      eventschemas.PubSubMessage{Data: nestedJSON, MessageID: "1"},
+			Subscription: "projects/p/subscriptions/s",
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return delivery
+	}
+
+	e := newTestEvent(t)
+	if err := s.Offload(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	var handled *eventschemas.SquareGetPaymentResponse
+	var subscription string
+	fn := CloudEvent(func(ctx context.Context, delivery event.Event) error {
+		var msg eventschemas.MessagePublishedData
+		if err := delivery.DataAs(&msg); err != nil {
+			return err
+		}
+		subscription = msg.Subscription
+		nested, err := eventschemas.NestedEvent(delivery)
+		if err != nil {
+			return err
+		}
+		handled = &eventschemas.SquareGetPaymentResponse{}
+		return nested.DataAs(handled)
+	})
+	if err := fn(ctx, deliver(e)); err != nil {
+		t.Fatal(err)
+	}
+	if handled == nil || handled.Raw.Payment == nil {
+		t.Fatalf("the function was passed %+v", handled)
+	}
+	if subscription != "projects/p/subscriptions/s" {
+		t.Errorf("the subscription of the message was lost: %q", subscription)
+	}
+
+	// payloads which cannot be checked out are not retried
+	if err := os.RemoveAll(s.Location); err != nil {
+		t.Fatal(err)
+	}
+	if err := fn(ctx, deliver(e)); failure.ClassOf(err) != failure.CLASS_PERMANENT || !errors.Is(err, ErrNotFound) {
+		t.Errorf("a missing payload returned %v, want a permanent ErrNotFound", err)
+	}
+}
@@ -0,0 +1,98 @@
+package claimcheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+	"github.com/kofc7186/fundraiser-manager/pkg/tracing"
+)
+
+// CloudEvent wraps a CloudEvent function so that the payloads of the event nested within a Pub/Sub message are
+// checked out before it is called, leaving the rest of the message as it was delivered
+//
+// Failures to read a payload are retried, while payloads which are missing or corrupt fail permanently; messages
+// which cannot be decoded are left to the function to classify
+func CloudEvent(fn func(context.Context, event.Event) error) func(context.Context, event.Event) error {
+	return func(ctx context.Context, e event.Event) error {
+		if e.Type() != tracing.PubSubMessagePublishedType {
+			return fn(ctx, e)
+		}
+
+		resolved, err := resolveMessage(ctx, e)
+		switch {
+		case errors.Is(err, ErrNotFound) || errors.Is(err, ErrCorrupt):
+			return failure.Permanent(err)
+		case err != nil:
+			return err
+		case resolved == nil:
+			return fn(ctx, e)
+		}
+		return fn(ctx, *resolved)
+	}
+}
+
+// resolveMessage returns a copy of the Pub/Sub delivery e with the payloads of the event it carries checked out, or
+// nil if there are none (or the message cannot be decoded)
+func resolveMessage(ctx context.Context, e event.Event) (*event.Event, error) {
+	// the message is decoded generically so that fields other than its data survive
+	var delivery map[string]json.RawMessage
+	if err := json.Unmarshal(e.Data(), &delivery); err != nil {
+		return nil, nil
+	}
+	messageKey := key(delivery, "message")
+	var message map[string]json.RawMessage
+	if err := json.Unmarshal(delivery[messageKey], &message); err != nil {
+		return nil, nil
+	}
+	dataKey := key(message, "data")
+	var nestedJSON []byte
+	if err := json.Unmarshal(message[dataKey], &nestedJSON); err != nil {
+		return nil, nil
+	}
+	nestedEvent := event.New()
+	if err := nestedEvent.UnmarshalJSON(nestedJSON); err != nil {
+		return nil, nil
+	}
+	if _, ok := nestedEvent.Extensions()[EXTENSION]; !ok {
+		return nil, nil
+	}
+
+	if err := Resolve(ctx, &nestedEvent); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if nestedJSON, err = nestedEvent.MarshalJSON(); err != nil {
+		return nil, err
+	}
+	if message[dataKey], err = json.Marshal(nestedJSON); err != nil {
+		return nil, err
+	}
+	if delivery[messageKey], err = json.Marshal(message); err != nil {
+		return nil, err
+	}
+	resolved := e.Clone()
+	if err := resolved.SetData(e.DataContentType(), delivery); err != nil {
+		return nil, err
+	}
+	return &resolved, nil
+}
+
+// key returns the key of m matching name as encoding/json would, i.e. preferring an exact match but otherwise
+// ignoring case
+func key(m map[string]json.RawMessage, name string) string {
+	if _, ok := m[name]; ok {
+		return name
+	}
+	for k := range m {
+		if strings.EqualFold(k, name) {
+			return k
+		}
+	}
+	return name
+}
@@ -0,0 +1,88 @@
+// Package coalesce deduplicates identical requests handled by the same instance: the requests arriving while an
+// identical one is being handled share a single follow-up call, made once it returns
+//
+// A request is never answered by a call which started before it arrived, as that call may have read the object
+// before the change that prompted the request (e.g. Square sends order.updated for OPEN and then COMPLETED a second
+// apart), so a burst of identical requests costs at most two calls
+package coalesce
+
+import (
+	"context"
+	"sync"
+)
+
+// Group coalesces the calls made through it by key; the zero value is ready to use
+type Group struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is the calls of a key
+type flight struct {
+	// running is the call being made
+	running *call
+	// next, if set, is the call shared by the requests which arrived after running started; it is made once running
+	// returns
+	next *call
+}
+
+type call struct {
+	// done is closed once err is set
+	done chan struct{}
+	err  error
+}
+
+// Do calls fn, unless a call of key has been queued behind one in flight, in which case it waits for that call and
+// returns its error; coalesced reports whether fn was skipped
+func (g *Group) Do(ctx context.Context, key string, fn func() error) (coalesced bool, err error) {
+	g.mu.Lock()
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	f, ok := g.flights[key]
+	if !ok {
+		c := &call{done: make(chan struct{})}
+		g.flights[key] = &flight{running: c}
+		g.mu.Unlock()
+		return false, g.run(key, c, fn)
+	}
+	if f.next != nil {
+		c := f.next
+		g.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case <-c.done:
+			return true, c.err
+		}
+	}
+
+	// the call in flight may have read the object before the change this request is for, so this request makes
+	// the next call, which the requests arriving meanwhile share
+	c := &call{done: make(chan struct{})}
+	f.next = c
+	previous := f.running
+	g.mu.Unlock()
+
+	// the requests sharing c wait on it, so it is made even if ctx is done; fn is bounded by ctx regardless
+	<-previous.done
+	g.mu.Lock()
+	f.running, f.next = c, nil
+	g.mu.Unlock()
+	return false, g.run(key, c, fn)
+}
+
+// run makes the call c of key, the running call of its flight, which is forgotten once it returns unless another
+// call has been queued behind it
+func (g *Group) run(key string, c *call, fn func() error) (err error) {
+	defer func() {
+		g.mu.Lock()
+		c.err = err
+		if f := g.flights[key]; f.next == nil {
+			delete(g.flights, key)
+		}
+		close(c.done)
+		g.mu.Unlock()
+	}()
+	return fn()
+}
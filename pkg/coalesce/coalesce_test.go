@@ -0,0 +1,137 @@
+package coalesce
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInFlight(t *testing.T) {
+	g := &Group{}
+	var calls atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	do := func(wg *sync.WaitGroup, coalescedCount *atomic.Int32) {
+		defer wg.Done()
+		coalesced, err := g.Do(context.Background(), "order/CAISENgvlJ6jLWAzERDzjyHVybY", func() error {
+			if calls.Add(1) == 1 {
+				close(started)
+			}
+			<-release
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		if coalesced {
+			coalescedCount.Add(1)
+		}
+	}
+
+	var wg sync.WaitGroup
+	var coalescedCount atomic.Int32
+	wg.Add(1)
+	go do(&wg, &coalescedCount)
+	<-started
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go do(&wg, &coalescedCount)
+	}
+	// the four requests arriving during the first call queue behind it rather than joining it
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := calls.Load(); got != 2 {
+		t.Errorf("fn was called %d times, want 2", got)
+	}
+	if got := coalescedCount.Load(); got != 3 {
+		t.Errorf("%d calls were coalesced, want 3", got)
+	}
+}
+
+func TestChangeDuringCall(t *testing.T) {
+	g := &Group{}
+	ctx := context.Background()
+	var version atomic.Int32
+	version.Store(1)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	first := make(chan int32)
+	go func() {
+		var read int32
+		g.Do(ctx, "order/CAISENgvlJ6jLWAzERDzjyHVybY", func() error {
+			read = version.Load()
+			close(started)
+			<-release
+			return nil
+		})
+		first <- read
+	}()
+	<-started
+
+	// the object changes after the call in flight read it, prompting another request
+	version.Store(2)
+	var read int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if coalesced, err := g.Do(ctx, "order/CAISENgvlJ6jLWAzERDzjyHVybY", func() error {
+			read = version.Load()
+			return nil
+		}); coalesced || err != nil {
+			t.Errorf("Do = %v, %v; want the request to make its own call", coalesced, err)
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	<-done
+
+	if got := <-first; got != 1 {
+		t.Errorf("the first call read version %d, want 1", got)
+	}
+	if read != 2 {
+		t.Errorf("the request prompted by the change read version %d, want 2", read)
+	}
+}
+
+func TestAfterReturn(t *testing.T) {
+	g := &Group{}
+	ctx := context.Background()
+	calls := 0
+	fn := func() error {
+		calls++
+		return nil
+	}
+
+	for i := 0; i < 2; i++ {
+		if coalesced, err := g.Do(ctx, "a", fn); coalesced || err != nil {
+			t.Errorf("Do = %v, %v; want a call after the previous one returned", coalesced, err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("fn was called %d times, want 2", calls)
+	}
+	if len(g.flights) != 0 {
+		t.Errorf("%d keys are still tracked after their calls returned", len(g.flights))
+	}
+}
+
+func TestFailed(t *testing.T) {
+	g := &Group{}
+	ctx := context.Background()
+	failed := errors.New("failed")
+
+	if _, err := g.Do(ctx, "a", func() error { return failed }); err != failed {
+		t.Fatalf("Do returned %v", err)
+	}
+	called := false
+	if coalesced, err := g.Do(ctx, "a", func() error { called = true; return nil }); coalesced || err != nil || !called {
+		t.Errorf("the retry of a failed call was coalesced: %v, %v", coalesced, err)
+	}
+}
@@ -0,0 +1,253 @@
+// Package config loads the typed configuration of a function into a struct whose fields are tagged with the
+// environment variable they are read from:
+//
+//	var cfg struct {
+//		config.Fundraiser
+//		OrderEventsTopic  string        `env:"ORDER_EVENTS_TOPIC" validate:"required,topic"`
+//		ArchiveLeadTime   time.Duration `env:"ARCHIVE_LEAD_TIME" default:"72h"`
+//		SquareAccessToken string        `env:"SQUARE_ACCESS_TOKEN,secret" validate:"required"`
+//	}
+//
+// Values are taken from, in increasing precedence, the field's default (its default tag, or else the value it
+// already holds), a YAML file of environment variables in the format of gcloud's --env-vars-file (.env.yaml, or
+// the file named by CONFIG_FILE), the environment itself and, for fields tagged as secrets, a SecretProvider (by
+// default, the file named by CONFIG_SECRETS_FILE, if set), so that insecure values and secrets may be kept apart;
+// every value which is missing, malformed or invalid is reported at once rather than the function stopping at the
+// first
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// FILE_ENV is the environment variable naming the YAML file values are read from
+	FILE_ENV = "CONFIG_FILE"
+	// DEFAULT_FILE is read if FILE_ENV is not set and it exists in the working directory
+	DEFAULT_FILE = ".env.yaml"
+	// SECRETS_FILE_ENV is the environment variable naming a SecretsFile secrets are read from
+	SECRETS_FILE_ENV = "CONFIG_SECRETS_FILE"
+)
+
+// Fundraiser is the configuration shared by the functions of a fundraiser
+type Fundraiser struct {
+	GCPProject     string    `env:"GCP_PROJECT" validate:"required"`
+	FundraiserID   string    `env:"FUNDRAISER_ID" validate:"required,fundraiserID"`
+	ExpirationTime time.Time `env:"EXPIRATION_TIME" validate:"required"`
+}
+
+// Error is a problem with the value of a single environment variable
+type Error struct {
+	Name string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Name, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrMissing is the Error of a required value which is not set
+var ErrMissing = errors.New("not set")
+
+// Loader loads configurations
+type Loader struct {
+	// File is the YAML file to read; if empty, FILE_ENV or DEFAULT_FILE is read
+	File string
+	// LookupEnv reads the environment; if nil, os.LookupEnv is used
+	LookupEnv func(string) (string, bool)
+	// Secrets provides the values of fields tagged as secrets, which are otherwise read like any other
+	Secrets SecretProvider
+}
+
+// Load loads the configuration of the function into v, which must point to a struct, using a Loader reading the
+// environment, the YAML file if there is one and, if SECRETS_FILE_ENV is set, the SecretsFile it names
+func Load(v any) error {
+	l := &Loader{}
+	if path, ok := os.LookupEnv(SECRETS_FILE_ENV); ok {
+		secrets, err := ReadSecretsFile(path)
+		if err != nil {
+			return err
+		}
+		l.Secrets = secrets
+	}
+	return l.Load(context.Background(), v)
+}
+
+// MustLoad loads the configuration of the function into v, panicking with every problem found if it is invalid
+func MustLoad(v any) {
+	if err := Load(v); err != nil {
+		panic(fmt.Sprintf("invalid configuration:\n%v", err))
+	}
+}
+
+// Load loads the configuration into v, which must point to a struct; the returned error joins an Error for each
+// problem found
+func (l *Loader) Load(ctx context.Context, v any) error {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Pointer || target.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: cannot load into %T", v)
+	}
+
+	file, err := l.readFile()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, f := range fields(target.Elem()) {
+		value, ok, err := l.lookup(ctx, f, file)
+		if err != nil {
+			errs = append(errs, &Error{Name: f.name, Err: err})
+			continue
+		}
+		if !ok {
+			value, ok = f.defaultValue, f.hasDefault
+		}
+		if ok {
+			if err := set(f.value, value); err != nil {
+				errs = append(errs, &Error{Name: f.name, Err: err})
+				continue
+			}
+		}
+		for _, rule := range f.rules {
+			if err := validate(rule, f.value, ok); err != nil {
+				errs = append(errs, &Error{Name: f.name, Err: err})
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readFile reads the YAML file of the loader, if any
+func (l *Loader) readFile() (map[string]string, error) {
+	path, explicit := l.File, l.File != ""
+	if !explicit {
+		path, explicit = l.lookupEnv(FILE_ENV)
+	}
+	if !explicit {
+		path = DEFAULT_FILE
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	var values map[string]string
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("config: %s: %w", path, err)
+	}
+	return values, nil
+}
+
+func (l *Loader) lookupEnv(name string) (string, bool) {
+	if l.LookupEnv == nil {
+		return os.LookupEnv(name)
+	}
+	return l.LookupEnv(name)
+}
+
+// lookup returns the value of f from the highest-precedence source which has one
+func (l *Loader) lookup(ctx context.Context, f field, file map[string]string) (string, bool, error) {
+	if f.secret && l.Secrets != nil {
+		if value, ok, err := l.Secrets.Secret(ctx, f.name); err != nil || ok {
+			return value, ok, err
+		}
+	}
+	if value, ok := l.lookupEnv(f.name); ok {
+		return value, true, nil
+	}
+	value, ok := file[f.name]
+	return value, ok, nil
+}
+
+// field is a configuration value of the struct being loaded
+type field struct {
+	name         string
+	secret       bool
+	defaultValue string
+	hasDefault   bool
+	rules        []string
+	value        reflect.Value
+}
+
+// fields returns the tagged fields of the struct s, including those of the structs it embeds or contains
+func fields(s reflect.Value) []field {
+	var found []field
+	for i := 0; i < s.NumField(); i++ {
+		structField := s.Type().Field(i)
+		if !structField.IsExported() {
+			continue
+		}
+		tag, ok := structField.Tag.Lookup("env")
+		if !ok {
+			if s.Field(i).Kind() == reflect.Struct && structField.Type != reflect.TypeOf(time.Time{}) {
+				found = append(found, fields(s.Field(i))...)
+			}
+			continue
+		}
+
+		name, options, _ := strings.Cut(tag, ",")
+		f := field{name: name, secret: options == "secret", value: s.Field(i)}
+		f.defaultValue, f.hasDefault = structField.Tag.Lookup("default")
+		if rules := structField.Tag.Get("validate"); rules != "" {
+			f.rules = strings.Split(rules, ",")
+		}
+		found = append(found, f)
+	}
+	return found
+}
+
+// set parses value into the field v
+func set(v reflect.Value, value string) error {
+	switch v.Interface().(type) {
+	case time.Time:
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("%q is not an RFC 3339 timestamp", value)
+		}
+		v.Set(reflect.ValueOf(t))
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", value)
+		}
+		v.SetInt(int64(d))
+	default:
+		switch v.Kind() {
+		case reflect.String:
+			v.SetString(value)
+		case reflect.Int, reflect.Int64:
+			i, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%q is not an integer", value)
+			}
+			v.SetInt(i)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%q is not a boolean", value)
+			}
+			v.SetBool(b)
+		default:
+			return fmt.Errorf("config: unsupported type %s", v.Type())
+		}
+	}
+	return nil
+}
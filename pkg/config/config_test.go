@@ -0,0 +1,141 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Fundraiser
+	OrderEventsTopic  string        `env:"ORDER_EVENTS_TOPIC" validate:"required,topic"`
+	SquareEnvironment string        `env:"SQUARE_ENVIRONMENT" default:"production" validate:"squareEnvironment"`
+	LeadTime          time.Duration `env:"LEAD_TIME" default:"72h" validate:"positive"`
+	MaxAttempts       int           `env:"MAX_ATTEMPTS"`
+	PullEnabled       bool          `env:"PULL_ENABLED"`
+	WebhookURL        string        `env:"WEBHOOK_URL" validate:"url"`
+	SquareAccessToken string        `env:"SQUARE_ACCESS_TOKEN,secret" validate:"required"`
+}
+
+// environ returns a LookupEnv over the given variables
+func environ(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), ".env.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	l := &Loader{
+		File: writeFile(t, `
+GCP_PROJECT: kofc7186-fundraiser
+FUNDRAISER_ID: fishfry-030824
+EXPIRATION_TIME: "2024-03-16T00:00:00Z"
+ORDER_EVENTS_TOPIC: fishfry-030824-order-events
+MAX_ATTEMPTS: "3"
+SQUARE_ACCESS_TOKEN: from-file
+`),
+		LookupEnv: environ(map[string]string{
+			// the environment overrides the file
+			"ORDER_EVENTS_TOPIC": "projects/kofc7186-fundraiser/topics/fishfry-030824-order-events",
+			"PULL_ENABLED":       "true",
+		}),
+		Secrets: SecretsFile{"SQUARE_ACCESS_TOKEN": "from-secrets"},
+	}
+
+	var cfg testConfig
+	if err := l.Load(context.Background(), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := testConfig{
+		Fundraiser: Fundraiser{
+			GCPProject:     "kofc7186-fundraiser",
+			FundraiserID:   "fishfry-030824",
+			ExpirationTime: time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC),
+		},
+		OrderEventsTopic:  "projects/kofc7186-fundraiser/topics/fishfry-030824-order-events",
+		SquareEnvironment: "production",
+		LeadTime:          72 * time.Hour,
+		MaxAttempts:       3,
+		PullEnabled:       true,
+		SquareAccessToken: "from-secrets",
+	}
+	if cfg != want {
+		t.Errorf("loaded %+v, want %+v", cfg, want)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	l := &Loader{
+		File: filepath.Join(t.TempDir(), "missing.yaml"),
+		LookupEnv: environ(map[string]string{
+			"FUNDRAISER_ID":      "Fish Fry",
+			"EXPIRATION_TIME":    "March 16th",
+			"ORDER_EVENTS_TOPIC": "goog-events",
+			"SQUARE_ENVIRONMENT": "staging",
+			"LEAD_TIME":          "-1h",
+			"MAX_ATTEMPTS":       "five",
+			"WEBHOOK_URL":        "/webhook",
+		}),
+	}
+	if err := l.Load(context.Background(), &testConfig{}); err == nil {
+		t.Fatal("a missing file was ignored")
+	}
+
+	l.File = writeFile(t, "")
+	err := l.Load(context.Background(), &testConfig{})
+	if err == nil {
+		t.Fatal("an invalid configuration loaded")
+	}
+	for _, name := range []string{"GCP_PROJECT", "FUNDRAISER_ID", "EXPIRATION_TIME", "ORDER_EVENTS_TOPIC",
+		"SQUARE_ENVIRONMENT", "LEAD_TIME", "MAX_ATTEMPTS", "WEBHOOK_URL", "SQUARE_ACCESS_TOKEN"} {
+		if !strings.Contains(err.Error(), name+": ") {
+			t.Errorf("no problem with %s was reported in:\n%v", name, err)
+		}
+	}
+	if !errors.Is(err, ErrMissing) {
+		t.Errorf("%v is not ErrMissing", err)
+	}
+	if strings.Contains(err.Error(), "PULL_ENABLED") {
+		t.Errorf("an optional value was reported missing:\n%v", err)
+	}
+}
+
+func TestValidators(t *testing.T) {
+	for _, test := range []struct {
+		rule, value string
+		valid       bool
+	}{
+		{"fundraiserID", "fishfry-030824", true},
+		{"fundraiserID", "ff-24", false},
+		{"fundraiserID", "fishfry-030824-", false},
+		{"fundraiserID", "fishfry_030824", false},
+		{"fundraiserID", "0fishfry", false},
+		{"fundraiserID", strings.Repeat("a", 31), false},
+		{"topic", "fishfry-030824-order-events", true},
+		{"topic", "projects/p/topics/fishfry-030824-order-events", true},
+		{"topic", "ab", false},
+		{"topic", "projects/p/subscriptions/s", false},
+		{"squareEnvironment", "sandbox", true},
+		{"url", "https://example.com/webhook", true},
+		{"url", "example.com/webhook", false},
+	} {
+		err := validate(test.rule, reflect.ValueOf(test.value), true)
+		if (err == nil) != test.valid {
+			t.Errorf("%s %q: %v", test.rule, test.value, err)
+		}
+	}
+}
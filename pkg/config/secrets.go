@@ -0,0 +1,37 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SecretProvider provides the values of fields tagged as secrets, e.g. `env:"SQUARE_ACCESS_TOKEN,secret"`
+type SecretProvider interface {
+	// Secret returns the value of the secret with the given name, or false if the provider does not hold it
+	Secret(ctx context.Context, name string) (value string, ok bool, err error)
+}
+
+// SecretsFile provides secrets from a YAML file in the same format as DEFAULT_FILE, such as one written from the
+// secrets of a CI pipeline so that they need not be kept alongside the other values
+type SecretsFile map[string]string
+
+// ReadSecretsFile reads a SecretsFile
+func ReadSecretsFile(path string) (SecretsFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	var secrets SecretsFile
+	if err := yaml.Unmarshal(data, &secrets); err != nil {
+		return nil, fmt.Errorf("config: %s: %w", path, err)
+	}
+	return secrets, nil
+}
+
+func (s SecretsFile) Secret(ctx context.Context, name string) (string, bool, error) {
+	value, ok := s[name]
+	return value, ok, nil
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+var (
+	// fundraiser IDs name buckets, Pub/Sub topics, Cloud Run services and Firestore documents, so are restricted to
+	// what all of them accept, leaving room for the suffixes each adds
+	fundraiserIDPattern = regexp.MustCompile(`^[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+	// see https://cloud.google.com/pubsub/docs/create-topic#resource_names
+	topicIDPattern   = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9\-_.~+%]{2,254}$`)
+	topicPathPattern = regexp.MustCompile(`^projects/[^/]+/topics/([^/]+)$`)
+)
+
+// SQUARE_ENVIRONMENTS are the values of the squareEnvironment rule
+var SQUARE_ENVIRONMENTS = []string{"production", "sandbox"}
+
+// validators check the values of fields by the rules named in their validate tags; required is checked by
+// validate itself, and the others are not applied to fields left unset
+var validators = map[string]func(v reflect.Value) error{
+	"fundraiserID": func(v reflect.Value) error {
+		if !fundraiserIDPattern.MatchString(v.String()) {
+			return fmt.Errorf("%q must be 6 to 30 lowercase letters, digits or hyphens, beginning with a letter and not ending with a hyphen", v.String())
+		}
+		return nil
+	},
+	"topic": func(v reflect.Value) error {
+		id := v.String()
+		if match := topicPathPattern.FindStringSubmatch(id); match != nil {
+			id = match[1]
+		}
+		if !topicIDPattern.MatchString(id) || strings.HasPrefix(id, "goog") {
+			return fmt.Errorf("%q is not a valid Pub/Sub topic name", v.String())
+		}
+		return nil
+	},
+	"squareEnvironment": func(v reflect.Value) error {
+		for _, environment := range SQUARE_ENVIRONMENTS {
+			if v.String() == environment {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q must be one of %s", v.String(), strings.Join(SQUARE_ENVIRONMENTS, ", "))
+	},
+	"url": func(v reflect.Value) error {
+		u, err := url.Parse(v.String())
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("%q is not an absolute URL", v.String())
+		}
+		return nil
+	},
+	"positive": func(v reflect.Value) error {
+		if v.Int() <= 0 {
+			return fmt.Errorf("must be positive, got %v", v.Interface())
+		}
+		return nil
+	},
+}
+
+// Check checks a value loaded other than into a tagged field against a rule, e.g. Check("fundraiserID", id)
+func Check(rule, value string) error {
+	return validate(rule, reflect.ValueOf(value), true)
+}
+
+// validate checks the value v of a field against rule; isSet reports whether a value was found for it, rather than
+// it holding its zero value or the value it held before loading
+func validate(rule string, v reflect.Value, isSet bool) error {
+	if rule == "required" {
+		if v.IsZero() {
+			return ErrMissing
+		}
+		return nil
+	}
+	if !isSet && v.IsZero() {
+		return nil
+	}
+	validator, ok := validators[rule]
+	if !ok {
+		return fmt.Errorf("config: unknown rule %q", rule)
+	}
+	return validator(v)
+}
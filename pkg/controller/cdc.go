@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/googleapis/google-cloudevents-go/cloud/firestoredata"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
+	"github.com/kofc7186/fundraiser-manager/pkg/logging"
+	"github.com/kofc7186/fundraiser-manager/pkg/tracing"
+	"github.com/kofc7186/fundraiser-manager/pkg/util"
+)
+
+// CDCPublisher generates internal domain events from changes to the Firestore documents of a single entity type,
+// routed to the fundraiser whose collection the document is in
+type CDCPublisher[T any] struct {
+	// EntityName is used to label log entries; suffixed with "ID" it must match a logging label, e.g. "payment"
+	EntityName string
+	// Topic is where the internal domain events are published
+	Topic *pubsub.Topic
+
+	NewCreated func(entity *T) (*event.Event, error)
+	NewUpdated func(oldEntity, newEntity *T, fieldMask []string) (*event.Event, error)
+	NewDeleted func(entity *T) (*event.Event, error)
+
+	// OnChange, if set, is invoked with the document before and after each change (either may be nil); the
+	// fundraiser of the document is carried by ctx (see fundraiser.FromContext)
+	OnChange func(ctx context.Context, oldEntity, newEntity *T)
+}
+
+// ProcessCDCEvent is a CloudEvent function which publishes the internal domain event for a Firestore document change
+//
+// The published event continues the trace of the write which caused the change, with a link to the span of this
+// invocation
+func (p *CDCPublisher[T]) ProcessCDCEvent(ctx context.Context, e event.Event) (err error) {
+	var data firestoredata.DocumentEventData
+	if err := proto.Unmarshal(e.Data(), &data); err != nil {
+		slog.ErrorContext(ctx, err.Error(), "event", e)
+		return failure.Poison(fmt.Errorf("proto.Unmarshal: %w", err))
+	}
+
+	document := data.GetValue()
+	if document == nil {
+		document = data.GetOldValue()
+	}
+	traceParent := document.GetFields()["traceparent"].GetStringValue()
+	ctx, span := tracing.Tracer().Start(tracing.ContextWithTraceParent(ctx, traceParent), fmt.Sprintf("publish %s change", p.EntityName),
+		trace.WithLinks(trace.LinkFromContext(ctx)))
+	defer func() { tracing.End(span, err) }()
+	fundraiserID, routed := fundraiser.FromDocument(document.GetName())
+	if routed {
+		ctx = fundraiser.NewContext(ctx, fundraiserID)
+	}
+
+	oldEntity, newEntity, err := p.entities(&data)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error(), "event", e)
+		return err
+	}
+	if p.OnChange != nil {
+		p.OnChange(ctx, oldEntity, newEntity)
+	}
+
+	internalEvent, err := p.internalEvent(oldEntity, newEntity, data.GetUpdateMask().GetFieldPaths())
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error(), "event", e)
+		return failure.Permanent(err)
+	}
+	if routed {
+		fundraiser.Set(internalEvent, fundraiserID)
+	}
+	ctx = logging.WithLabel(ctx, p.EntityName+"ID", internalEvent.Subject())
+
+	messageID, err := Publish(ctx, p.Topic, internalEvent)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error(), "event", e)
+		return err
+	}
+	slog.InfoContext(ctx, fmt.Sprintf("published %s", internalEvent.Type()), "messageID", messageID)
+	return nil
+}
+
+// InternalEvent returns the created, updated or deleted event for the document change
+func (p *CDCPublisher[T]) InternalEvent(data *firestoredata.DocumentEventData) (*event.Event, error) {
+	oldEntity, newEntity, err := p.entities(data)
+	if err != nil {
+		return nil, err
+	}
+	e, err := p.internalEvent(oldEntity, newEntity, data.GetUpdateMask().GetFieldPaths())
+	if err != nil {
+		return nil, err
+	}
+
+	document := data.GetValue()
+	if document == nil {
+		document = data.GetOldValue()
+	}
+	if fundraiserID, ok := fundraiser.FromDocument(document.GetName()); ok {
+		fundraiser.Set(e, fundraiserID)
+	}
+	return e, nil
+}
+
+func (p *CDCPublisher[T]) internalEvent(oldEntity, newEntity *T, fieldMask []string) (*event.Event, error) {
+	switch {
+	case newEntity == nil:
+		// the document was deleted
+		return p.NewDeleted(oldEntity)
+	case oldEntity == nil:
+		// the document was created
+		return p.NewCreated(newEntity)
+	}
+	// the document was updated
+	return p.NewUpdated(oldEntity, newEntity, fieldMask)
+}
+
+// entities parses the document before and after the change; oldEntity is nil upon creation, and newEntity is
+// nil upon deletion
+func (p *CDCPublisher[T]) entities(data *firestoredata.DocumentEventData) (oldEntity, newEntity *T, err error) {
+	if data.GetOldValue() == nil && data.GetValue() == nil {
+		return nil, nil, failure.Poison(errors.New("document change has neither an old nor a new value"))
+	}
+	if data.GetOldValue() != nil {
+		oldEntity = new(T)
+		if err := util.ParseFirebaseDocument(data.OldValue, oldEntity); err != nil {
+			return nil, nil, failure.Poison(err)
+		}
+	}
+	if data.GetValue() != nil {
+		newEntity = new(T)
+		if err := util.ParseFirebaseDocument(data.Value, newEntity); err != nil {
+			return nil, nil, failure.Poison(err)
+		}
+	}
+	return oldEntity, newEntity, nil
+}
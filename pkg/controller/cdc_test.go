@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/googleapis/google-cloudevents-go/cloud/firestoredata"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+
+	eventschemas "github.com/kofc7186/fundraiser-manager/pkg/event/schemas"
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
+	paymentType "github.com/kofc7186/fundraiser-manager/pkg/types/payment"
+)
+
+func TestMain(m *testing.M) {
+	// required by the event constructors in pkg/event/schemas
+	os.Setenv("K_SERVICE", "controller-test")
+	os.Setenv("EXPIRATION_TIME", "2024-03-16T00:00:00Z")
+	os.Exit(m.Run())
+}
+
+func paymentDocument(id, status string) *firestoredata.Document {
+	return &firestoredata.Document{
+		Name: "projects/test-project/databases/(default)/documents/fundraisers/fishfry-030824/payments/" + id,
+		Fields: map[string]*firestoredata.Value{
+			"id":     {ValueType: &firestoredata.Value_StringValue{StringValue: id}},
+			"status": {ValueType: &firestoredata.Value_StringValue{StringValue: status}},
+		},
+	}
+}
+
+func newPaymentPublisher(topic *pubsub.Topic) *CDCPublisher[paymentType.Payment] {
+	return &CDCPublisher[paymentType.Payment]{
+		EntityName: "payment",
+		Topic:      topic,
+		NewCreated: eventschemas.NewPaymentCreated,
+		NewUpdated: eventschemas.NewPaymentUpdated,
+		NewDeleted: eventschemas.NewPaymentDeleted,
+	}
+}
+
+func TestInternalEvent(t *testing.T) {
+	publisher := newPaymentPublisher(nil)
+
+	tests := []struct {
+		name     string
+		data     *firestoredata.DocumentEventData
+		wantType string
+	}{
+		{
+			name:     "created",
+			data:     &firestoredata.DocumentEventData{Value: paymentDocument("p1", "APPROVED")},
+			wantType: eventschemas.PaymentCreatedType,
+		},
+		{
+			name: "updated",
+			data: &firestoredata.DocumentEventData{
+				Value:      paymentDocument("p1", "COMPLETED"),
+				OldValue:   paymentDocument("p1", "APPROVED"),
+				UpdateMask: &firestoredata.DocumentMask{FieldPaths: []string{"status"}},
+			},
+			wantType: eventschemas.PaymentUpdatedType,
+		},
+		{
+			name:     "deleted",
+			data:     &firestoredata.DocumentEventData{OldValue: paymentDocument("p1", "COMPLETED")},
+			wantType: eventschemas.PaymentDeletedType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := publisher.InternalEvent(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.Type() != tt.wantType {
+				t.Errorf("type = %q, want %q", e.Type(), tt.wantType)
+			}
+			if e.Subject() != "p1" {
+				t.Errorf("subject = %q, want %q", e.Subject(), "p1")
+			}
+		})
+	}
+}
+
+func TestProcessCDCEventPublishes(t *testing.T) {
+	ctx := context.Background()
+	srv := pstest.NewServer()
+	defer srv.Close()
+
+	conn, err := grpc.Dial(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	client, err := pubsub.NewClient(ctx, "test-project", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	topic, err := client.CreateTopic(ctx, "payment-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer topic.Stop()
+
+	// record spans so that the trace context is propagated
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(tracetest.NewSpanRecorder())))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	const writerTraceID = "0af7651916cd43dd8448eb211c80319c"
+	document := paymentDocument("p1", "APPROVED")
+	document.Fields["traceparent"] = &firestoredata.Value{ValueType: &firestoredata.Value_StringValue{StringValue: "00-" + writerTraceID + "-b7ad6b7169203331-01"}}
+
+	data, err := proto.Marshal(&firestoredata.DocumentEventData{Value: document})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cdcEvent := event.New()
+	cdcEvent.SetID("cdc")
+	cdcEvent.SetType("google.cloud.firestore.document.v1.created")
+	cdcEvent.SetSource("//firestore.googleapis.com/projects/test-project/databases/(default)")
+	if err := cdcEvent.SetData("application/protobuf", data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := newPaymentPublisher(topic).ProcessCDCEvent(ctx, cdcEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := srv.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("published %d messages, want 1", len(messages))
+	}
+	var published map[string]interface{}
+	if err := json.Unmarshal(messages[0].Data, &published); err != nil {
+		t.Fatal(err)
+	}
+	if published["type"] != eventschemas.PaymentCreatedType || published["subject"] != "p1" {
+		t.Errorf("unexpected published event: %v", published)
+	}
+	if published[fundraiser.EXTENSION] != "fishfry-030824" || messages[0].Attributes[fundraiser.EXTENSION] != "fishfry-030824" {
+		t.Errorf("published event was not routed to the fundraiser of its document: %v", published)
+	}
+	traceParent, _ := published["traceparent"].(string)
+	if !strings.HasPrefix(traceParent, "00-"+writerTraceID+"-") {
+		t.Errorf("published event does not continue the trace of the write: traceparent = %q", traceParent)
+	}
+}
+
+func TestProcessCDCEventMalformed(t *testing.T) {
+	cdcEvent := event.New()
+	if err := cdcEvent.SetData("application/protobuf", []byte("not a protobuf")); err != nil {
+		t.Fatal(err)
+	}
+	if err := newPaymentPublisher(nil).ProcessCDCEvent(context.Background(), cdcEvent); err == nil {
+		t.Error("expected error for malformed data")
+	}
+}
@@ -0,0 +1,54 @@
+// Package controller provides the building blocks shared by the fundraiser-manager controllers: idempotent,
+// order-aware writes of Square objects into Firestore, and publication of internal domain events from
+// Firestore change data capture (CDC) events
+package controller
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/claimcheck"
+	"github.com/kofc7186/fundraiser-manager/pkg/event/filter"
+	eventschemas "github.com/kofc7186/fundraiser-manager/pkg/event/schemas"
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+	"github.com/kofc7186/fundraiser-manager/pkg/metrics"
+	"github.com/kofc7186/fundraiser-manager/pkg/tracing"
+)
+
+// PublishTimeout bounds how long a handler will wait for Pub/Sub to acknowledge a published event
+const PublishTimeout = 2 * time.Second
+
+// Publish marshals e and publishes it to topic, blocking until Pub/Sub acknowledges it or PublishTimeout elapses
+//
+// The trace context of ctx is recorded on e so the handler of the published event continues the trace; events
+// whose data does not conform to their schema are never published, and fail permanently
+//
+// The type, subject and source of e are also set as attributes of the message, so that subscriptions can filter
+// on them without decoding it; once e has been validated, oversized payloads are checked into blob storage (see
+// pkg/claimcheck)
+func Publish(ctx context.Context, topic *pubsub.Topic, e *event.Event) (string, error) {
+	if err := eventschemas.Validate(e); err != nil {
+		return "", failure.Permanent(err)
+	}
+	if err := claimcheck.Offload(ctx, e); err != nil {
+		return "", err
+	}
+	tracing.Inject(ctx, e)
+
+	eventJSON, err := e.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+
+	timeoutContext, cancel := context.WithTimeout(context.Background(), PublishTimeout)
+	defer cancel()
+
+	start := time.Now()
+	publishResult := topic.Publish(timeoutContext, &pubsub.Message{Data: eventJSON, Attributes: filter.Attributes(e)})
+	messageID, err := publishResult.Get(timeoutContext) // this call blocks until complete or timeout occurs
+	metrics.RecordPublish(ctx, topic.ID(), time.Since(start), err)
+	return messageID, err
+}
@@ -0,0 +1,22 @@
+package controller
+
+import "time"
+
+// Entity is implemented by pointers to the internal types (e.g. *payment.Payment) that controllers persist
+// from Square objects
+type Entity[T any] interface {
+	*T
+
+	// GetID returns the ID of the entity, which is also the ID of its Firestore document
+	GetID() string
+	// GetIdempotencyKeys returns the set of event idempotency keys already applied to the entity
+	GetIdempotencyKeys() map[string]bool
+	// SetIdempotencyKeys replaces the set of event idempotency keys applied to the entity
+	SetIdempotencyKeys(map[string]bool)
+	// SetExpiration sets the timestamp used by the Firestore TTL policy to delete the entity
+	SetExpiration(time.Time)
+	// SetTraceParent records the W3C traceparent of the operation writing the entity
+	SetTraceParent(string)
+	// IsOutOfOrder reports whether the entity reflects an older revision from Square than persisted
+	IsOutOfOrder(persisted *T) bool
+}
@@ -0,0 +1,237 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"cloud.google.com/go/firestore"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	eventschemas "github.com/kofc7186/fundraiser-manager/pkg/event/schemas"
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
+	"github.com/kofc7186/fundraiser-manager/pkg/logging"
+	"github.com/kofc7186/fundraiser-manager/pkg/metrics"
+	"github.com/kofc7186/fundraiser-manager/pkg/tracing"
+)
+
+// Proposal is an entity decoded from an inbound event, along with how it should be applied
+type Proposal[T any] struct {
+	Entity         *T
+	IdempotencyKey string
+	// CreateOnly denotes that the event reflects the creation of the object in Square, so it is squelched if
+	// the document already exists (e.g. because an update event was processed first)
+	CreateOnly bool
+}
+
+// Decoder extracts a Proposal from an event; returning a nil Proposal with a nil error squelches the event
+type Decoder[T any] func(ctx context.Context, e *event.Event) (*Proposal[T], error)
+
+// Outcome describes what a SquareWriter did with an event
+type Outcome int
+
+const (
+	OutcomeIgnored Outcome = iota
+	OutcomeCreated
+	OutcomeUpdated
+	OutcomeSquelchedExisting
+	OutcomeSquelchedDuplicate
+	OutcomeSquelchedOutOfOrder
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeCreated:
+		return "created"
+	case OutcomeUpdated:
+		return "updated"
+	case OutcomeSquelchedExisting:
+		return "squelched_existing"
+	case OutcomeSquelchedDuplicate:
+		return "squelched_duplicate"
+	case OutcomeSquelchedOutOfOrder:
+		return "squelched_out_of_order"
+	}
+	return "ignored"
+}
+
+// SquareWriter idempotently writes entities decoded from Square-sourced events into a collection of the fundraiser
+// each event belongs to, discarding duplicate and out of order events
+type SquareWriter[T any, PT Entity[T]] struct {
+	// OnCreate, if set, is invoked within the write transaction before a new document is written; the fundraiser
+	// of the document is carried by ctx (see fundraiser.FromContext)
+	OnCreate func(ctx context.Context, tx *firestore.Transaction, entity PT) error
+	// OnUpdate, if set, is invoked within the write transaction before an existing document is replaced, e.g. to
+	// carry fields maintained by the controller over from the persisted entity
+	OnUpdate func(ctx context.Context, tx *firestore.Transaction, persisted, proposed PT) error
+
+	client     *firestore.Client
+	collection string
+	decoders   map[string]Decoder[T]
+	entityName string
+	router     *fundraiser.Router
+	labelKey   string
+}
+
+// NewSquareWriter returns a SquareWriter for the named collection of each fundraiser, which decodes events with the
+// decoder registered for their type; every written document has its expiration field set to the expiration of its
+// fundraiser, as given by router
+//
+// entityName is used in log messages, and suffixed with "ID" as the log label for the entity (e.g. "payment" is
+// labelled with logging.LabelPaymentID)
+func NewSquareWriter[T any, PT Entity[T]](entityName string, client *firestore.Client, collection string, router *fundraiser.Router, decoders map[string]Decoder[T]) *SquareWriter[T, PT] {
+	return &SquareWriter[T, PT]{
+		client:     client,
+		collection: collection,
+		decoders:   decoders,
+		entityName: entityName,
+		router:     router,
+		labelKey:   entityName + "ID",
+	}
+}
+
+// ProcessEvent is a CloudEvent function which writes the event carried within a Pub/Sub message
+func (w *SquareWriter[T, PT]) ProcessEvent(ctx context.Context, e event.Event) error {
+	nestedEvent, err := eventschemas.NestedEvent(e)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error(), "event", e)
+		return err
+	}
+
+	_, err = w.Write(ctx, nestedEvent)
+	return err
+}
+
+// Write decodes e and writes the resulting entity into Firestore within a transaction
+func (w *SquareWriter[T, PT]) Write(ctx context.Context, e *event.Event) (outcome Outcome, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, fmt.Sprintf("write %s", w.entityName))
+	defer func() {
+		if err != nil {
+			metrics.RecordWrite(ctx, w.entityName, "error")
+		} else {
+			metrics.RecordWrite(ctx, w.entityName, outcome.String())
+		}
+		span.SetAttributes(attribute.String("fundraiser.outcome", outcome.String()))
+		tracing.End(span, err)
+	}()
+
+	decoder, ok := w.decoders[e.Type()]
+	if !ok {
+		slog.DebugContext(ctx, fmt.Sprintf("squelching %q event", e.Type()), "event", e)
+		return OutcomeIgnored, nil
+	}
+
+	proposal, err := decoder(ctx, e)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error(), "event", e)
+		return OutcomeIgnored, err
+	}
+	if proposal == nil {
+		return OutcomeIgnored, nil
+	}
+	proposed := PT(proposal.Entity)
+	fundraiserID := w.router.Of(e)
+	ctx = fundraiser.NewContext(ctx, fundraiserID)
+	ctx = logging.WithLabel(ctx, w.labelKey, proposed.GetID())
+	span.SetAttributes(attribute.String("fundraiser."+w.labelKey, proposed.GetID()))
+
+	// make sure to update the map to denote that we've processed this event already
+	//
+	// the boolean here is only to allow Firestore to map back to Go struct; the important
+	// thing is that the key is put into the map
+	idempotencyKeys := make(map[string]bool, 1)
+	if proposal.IdempotencyKey != "" {
+		idempotencyKeys[proposal.IdempotencyKey] = true
+	}
+	proposed.SetIdempotencyKeys(idempotencyKeys)
+
+	// ensure the firestore expiration timestamp is written in the appropriate field
+	proposed.SetExpiration(w.router.Expiration(fundraiserID))
+
+	// carry the trace through to the CDC event caused by this write
+	proposed.SetTraceParent(tracing.TraceParent(ctx))
+
+	attempts := 0
+	docRef := w.client.Doc(fundraiser.Path(fundraiserID, w.collection, proposed.GetID()))
+	transaction := func(ctx context.Context, tx *firestore.Transaction) error {
+		attempts++
+		var persisted PT
+		docSnap, err := tx.Get(docRef)
+		if err != nil {
+			if status.Code(err) != codes.NotFound {
+				// document may exist but there was some error, bail
+				return err
+			}
+		} else {
+			persisted = new(T)
+			if err := docSnap.DataTo(persisted); err != nil {
+				return err
+			}
+		}
+
+		outcome = decide(persisted, proposed, proposal)
+		switch outcome {
+		case OutcomeCreated:
+			if w.OnCreate != nil {
+				if err := w.OnCreate(ctx, tx, proposed); err != nil {
+					return err
+				}
+			}
+		case OutcomeUpdated:
+			// copy over idempotency keys from what we've seen before
+			for key, val := range persisted.GetIdempotencyKeys() {
+				idempotencyKeys[key] = val
+			}
+			if w.OnUpdate != nil {
+				if err := w.OnUpdate(ctx, tx, persisted, proposed); err != nil {
+					return err
+				}
+			}
+		default:
+			slog.DebugContext(ctx, fmt.Sprintf("skipped event: %s", outcome), "idempotencyKey", proposal.IdempotencyKey, "event", e)
+			return nil
+		}
+		return tx.Set(docRef, proposed)
+	}
+
+	err = w.client.RunTransaction(ctx, transaction)
+	metrics.RecordTransactionAttempts(ctx, w.entityName, attempts)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error(), "event", e)
+		return OutcomeIgnored, err
+	}
+
+	if outcome == OutcomeCreated || outcome == OutcomeUpdated {
+		slog.InfoContext(ctx, fmt.Sprintf("%s %v written at %v", w.entityName, docRef.ID, docRef.Path))
+	}
+	return outcome, nil
+}
+
+// decide determines how a proposal should be applied given the currently persisted entity (nil if there is none)
+func decide[T any, PT Entity[T]](persisted, proposed PT, proposal *Proposal[T]) Outcome {
+	if persisted == nil {
+		// document doesn't yet exist, so just write it
+		return OutcomeCreated
+	}
+
+	if proposal.CreateOnly {
+		// if we get a create request and the doc already exists via an out of order event, just squelch it
+		return OutcomeSquelchedExisting
+	}
+
+	// search the map to see if we've observed the idempotency key before
+	if _, ok := persisted.GetIdempotencyKeys()[proposal.IdempotencyKey]; ok {
+		return OutcomeSquelchedDuplicate
+	}
+
+	// check to see if this square event is out of order
+	if proposed.IsOutOfOrder(persisted) {
+		return OutcomeSquelchedOutOfOrder
+	}
+
+	// if we get here, we have a newer proposal so let's write it
+	return OutcomeUpdated
+}
@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+	paymentType "github.com/kofc7186/fundraiser-manager/pkg/types/payment"
+)
+
+func TestDecidePayment(t *testing.T) {
+	now := time.Date(2024, 3, 9, 18, 0, 0, 0, time.UTC)
+	persisted := &paymentType.Payment{
+		ID:                "payment",
+		IdempotencyKeys:   map[string]bool{"seen": true},
+		SquareUpdatedTime: now,
+	}
+
+	tests := []struct {
+		name       string
+		persisted  *paymentType.Payment
+		updated    time.Time
+		key        string
+		createOnly bool
+		want       Outcome
+	}{
+		{name: "new document", persisted: nil, updated: now, key: "new", want: OutcomeCreated},
+		{name: "new document from create", persisted: nil, updated: now, key: "new", createOnly: true, want: OutcomeCreated},
+		{name: "create for existing document", persisted: persisted, updated: now.Add(time.Minute), key: "new", createOnly: true, want: OutcomeSquelchedExisting},
+		{name: "duplicate", persisted: persisted, updated: now.Add(time.Minute), key: "seen", want: OutcomeSquelchedDuplicate},
+		{name: "out of order", persisted: persisted, updated: now.Add(-time.Minute), key: "new", want: OutcomeSquelchedOutOfOrder},
+		{name: "same timestamp", persisted: persisted, updated: now, key: "new", want: OutcomeUpdated},
+		{name: "newer", persisted: persisted, updated: now.Add(time.Minute), key: "new", want: OutcomeUpdated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proposed := &paymentType.Payment{ID: "payment", SquareUpdatedTime: tt.updated}
+			proposal := &Proposal[paymentType.Payment]{Entity: proposed, IdempotencyKey: tt.key, CreateOnly: tt.createOnly}
+			if got := decide(tt.persisted, proposed, proposal); got != tt.want {
+				t.Errorf("decide() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecideOrderVersion(t *testing.T) {
+	now := time.Date(2024, 3, 9, 18, 0, 0, 0, time.UTC)
+	persisted := &orderType.Order{ID: "order", IdempotencyKeys: map[string]bool{}, SquareUpdatedTime: now, Version: 3}
+
+	for version, want := range map[int32]Outcome{2: OutcomeSquelchedOutOfOrder, 3: OutcomeSquelchedOutOfOrder, 4: OutcomeUpdated} {
+		proposed := &orderType.Order{ID: "order", SquareUpdatedTime: now, Version: version}
+		proposal := &Proposal[orderType.Order]{Entity: proposed, IdempotencyKey: "new"}
+		if got := decide(persisted, proposed, proposal); got != want {
+			t.Errorf("version %d: decide() = %s, want %s", version, got, want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package deadletter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"cloud.google.com/go/firestore"
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/config"
+	eventschemas "github.com/kofc7186/fundraiser-manager/pkg/event/schemas"
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
+	"github.com/kofc7186/fundraiser-manager/pkg/logging"
+	"github.com/kofc7186/fundraiser-manager/pkg/metrics"
+	"github.com/kofc7186/fundraiser-manager/pkg/tracing"
+)
+
+// MAX_ATTEMPTS_ENV is the environment variable which overrides DefaultMaxAttempts
+const MAX_ATTEMPTS_ENV = "DEAD_LETTER_MAX_ATTEMPTS"
+
+// DefaultMaxAttempts is how many times a message is delivered to a failing handler before it is dead-lettered
+const DefaultMaxAttempts = 5
+
+var client *firestore.Client
+var router *fundraiser.Router
+var maxAttempts = DefaultMaxAttempts
+
+// Init establishes the Firestore client used to record failed deliveries, and the router filing them under the
+// fundraiser of their event; until it is called, Pub/Sub messages are handled by CloudEvent as if they were any other
+// event
+func Init() error {
+	var cfg struct {
+		config.Fundraiser
+		MaxAttempts int `env:"DEAD_LETTER_MAX_ATTEMPTS" validate:"positive"`
+	}
+	cfg.MaxAttempts = DefaultMaxAttempts
+	if err := config.Load(&cfg); err != nil {
+		return err
+	}
+	maxAttempts = cfg.MaxAttempts
+
+	var err error
+	if router, err = fundraiser.Load(); err != nil {
+		return err
+	}
+	if client, err = firestore.NewClient(context.Background(), cfg.GCPProject); err != nil {
+		return err
+	}
+	return router.Watch(context.Background(), client)
+}
+
+// CloudEvent wraps a CloudEvent function so that failures are only retried while they might succeed:
+//
+//   - a retryable failure of a Pub/Sub message is recorded, and once the message has failed
+//     DEAD_LETTER_MAX_ATTEMPTS times it is acknowledged and captured for an operator to replay
+//   - a permanent or poison failure is logged as CRITICAL and acknowledged at once, capturing a Pub/Sub message
+//     for an operator to replay once the cause has been fixed
+//
+// Retryable failures of events which were not delivered by Pub/Sub (e.g. Firestore change events) are returned
+// as-is, as there is no topic to replay them to
+func CloudEvent(name string, fn func(context.Context, event.Event) error) func(context.Context, event.Event) error {
+	return func(ctx context.Context, e event.Event) error {
+		err := fn(ctx, e)
+		if err == nil {
+			return nil
+		}
+		class := failure.ClassOf(err)
+
+		if client == nil || e.Type() != tracing.PubSubMessagePublishedType {
+			if class == failure.CLASS_RETRYABLE {
+				return err
+			}
+			slog.Log(ctx, logging.LevelCritical, fmt.Sprintf("acknowledging event after %s failure: %v", class, err), "event", e)
+			return nil
+		}
+
+		var msg eventschemas.MessagePublishedData
+		if dataErr := e.DataAs(&msg); dataErr != nil {
+			slog.ErrorContext(ctx, "unable to decode message for dead-letter capture: "+dataErr.Error(), "event", e)
+			return err
+		}
+		if msg.Subscription == "" {
+			// messages pushed to the function by a filtered subscription (see pkg/event/filter) do not name it, but
+			// each such subscription is named after the function's service
+			msg.Subscription = os.Getenv("K_SERVICE")
+		}
+
+		fundraiserID := fundraiserOf(msg)
+		store := NewStore(client, fundraiserID, router.Expiration(fundraiserID))
+		letter, captureErr := store.RecordFailure(ctx, name, topicFromSource(e.Source()), msg, err, maxAttempts)
+		if captureErr != nil {
+			// leave the message to be redelivered, so the failure can be captured next time
+			slog.ErrorContext(ctx, "unable to record failure for dead-letter capture: "+captureErr.Error(), "event", e)
+			return err
+		}
+		if letter.Status != STATUS_DEAD {
+			return err
+		}
+
+		ctx = logging.WithLabel(ctx, logging.LabelDeadLetterID, letter.ID)
+		metrics.RecordDeadLetter(ctx, name)
+		if class == failure.CLASS_RETRYABLE {
+			slog.ErrorContext(ctx, fmt.Sprintf("giving up after %d attempts; message captured as dead letter %s", letter.Attempts, letter.ID),
+				"error", err.Error())
+		} else {
+			slog.Log(ctx, logging.LevelCritical, fmt.Sprintf("%s failure; message captured as dead letter %s", class, letter.ID),
+				"error", err.Error())
+		}
+		return nil
+	}
+}
+
+// fundraiserOf returns the fundraiser of the event carried by msg; a message which doesn't carry a CloudEvent is
+// filed under the default fundraiser
+func fundraiserOf(msg eventschemas.MessagePublishedData) string {
+	nestedEvent := &event.Event{}
+	if err := nestedEvent.UnmarshalJSON(msg.Message.Data); err != nil {
+		return router.Default
+	}
+	return router.Of(nestedEvent)
+}
@@ -0,0 +1,45 @@
+package deadletter
+
+import (
+	"testing"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	eventschemas "github.com/kofc7186/fundraiser-manager/pkg/event/schemas"
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
+)
+
+func TestFundraiserOf(t *testing.T) {
+	router = &fundraiser.Router{Default: "fishfry-022324"}
+	t.Cleanup(func() { router = nil })
+
+	e := event.New()
+	e.SetID("1")
+	e.SetSource("test")
+	e.SetType("test")
+	fundraiser.Set(&e, "fishfry-030824")
+	routed, err := e.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetExtension(fundraiser.EXTENSION, nil)
+	unrouted, err := e.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		data []byte
+		want string
+	}{
+		"routed":       {routed, "fishfry-030824"},
+		"unrouted":     {unrouted, "fishfry-022324"},
+		"not an event": {[]byte("not an event"), "fishfry-022324"},
+	}
+	for name, tt := range tests {
+		msg := eventschemas.MessagePublishedData{Message: eventschemas.PubSubMessage{Data: tt.data}}
+		if got := fundraiserOf(msg); got != tt.want {
+			t.Errorf("%s: got %s, want %s", name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Package deadletter captures Pub/Sub messages whose handlers keep failing, acknowledging them once they are
+// out of attempts rather than retrying until Pub/Sub discards them, and lets operators inspect, edit and
+// replay the captured messages to their original topic
+package deadletter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+)
+
+type Status string
+
+const (
+	// STATUS_RETRYING is a message whose handler has failed, but which Pub/Sub is still redelivering
+	STATUS_RETRYING Status = "RETRYING"
+	// STATUS_DEAD is a message which has been acknowledged without being handled, awaiting an operator
+	STATUS_DEAD Status = "DEAD"
+	// STATUS_REPLAYED is a dead message an operator has re-published to its original topic
+	STATUS_REPLAYED Status = "REPLAYED"
+	// STATUS_DISCARDED is a dead message an operator has chosen not to replay
+	STATUS_DISCARDED Status = "DISCARDED"
+)
+
+func ParseStatus(status string) (Status, error) {
+	switch Status(status) {
+	case STATUS_RETRYING:
+		return STATUS_RETRYING, nil
+	case STATUS_DEAD:
+		return STATUS_DEAD, nil
+	case STATUS_REPLAYED:
+		return STATUS_REPLAYED, nil
+	case STATUS_DISCARDED:
+		return STATUS_DISCARDED, nil
+	}
+	return "", fmt.Errorf("%q is not a valid Status", status)
+}
+
+// Letter is a Pub/Sub message which a function failed to handle, along with where it came from and why it failed
+type Letter struct {
+	Attempts          int       `json:"attempts" firestore:"attempts"`
+	Class             string    `json:"class" firestore:"class"`
+	Expiration        time.Time `json:"expiration" firestore:"expiration"`
+	FirstFailedTime   time.Time `json:"firstFailedTime" firestore:"firstFailedTime"`
+	Function          string    `json:"function" firestore:"function"`
+	ID                string    `json:"id" firestore:"id"`
+	LastError         string    `json:"lastError" firestore:"lastError"`
+	LastFailedTime    time.Time `json:"lastFailedTime" firestore:"lastFailedTime"`
+	Message           string    `json:"message" firestore:"message"`
+	MessageID         string    `json:"messageId" firestore:"messageId"`
+	ReplayedMessageID string    `json:"replayedMessageId,omitempty" firestore:"replayedMessageId,omitempty"`
+	Status            Status    `json:"status" firestore:"status"`
+	Subscription      string    `json:"subscription" firestore:"subscription"`
+	Topic             string    `json:"topic" firestore:"topic"`
+}
+
+// letterID identifies a message as delivered to a particular subscription, as the same message is delivered
+// (and may fail) once per subscription to its topic
+func letterID(subscription, messageID string) string {
+	return fmt.Sprintf("%s-%s", lastSegment(subscription), messageID)
+}
+
+// topicFromSource extracts the topic ID from the source of a Pub/Sub CloudEvent, which is of the form
+// //pubsub.googleapis.com/projects/<project>/topics/<topic>
+func topicFromSource(source string) string {
+	return lastSegment(source)
+}
+
+func lastSegment(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// recordFailure updates l for another failed delivery at now; the letter is dead once it has used maxAttempts,
+// or immediately if the failure is not worth retrying
+func (l *Letter) recordFailure(handlerErr error, now time.Time, maxAttempts int) {
+	if l.FirstFailedTime.IsZero() {
+		l.FirstFailedTime = now
+	}
+	l.Attempts++
+	l.Class = string(failure.ClassOf(handlerErr))
+	l.LastError = handlerErr.Error()
+	l.LastFailedTime = now
+
+	if l.Status == "" {
+		l.Status = STATUS_RETRYING
+	}
+	if l.Status == STATUS_RETRYING && (l.Attempts >= maxAttempts || !failure.IsRetryable(handlerErr)) {
+		l.Status = STATUS_DEAD
+	}
+}
+
+// CloudEvent decodes the captured message as the CloudEvent it carries
+func (l *Letter) CloudEvent() (*event.Event, error) {
+	e := &event.Event{}
+	if err := e.UnmarshalJSON([]byte(l.Message)); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
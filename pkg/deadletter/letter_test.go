@@ -0,0 +1,99 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+)
+
+func TestRecordFailure(t *testing.T) {
+	first := time.Date(2024, 3, 8, 17, 0, 0, 0, time.UTC)
+	letter := &Letter{}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		letter.recordFailure(errors.New("attempt failed"), first.Add(time.Duration(attempt-1)*time.Minute), 3)
+		if letter.Attempts != attempt {
+			t.Fatalf("attempts = %d, want %d", letter.Attempts, attempt)
+		}
+
+		want := STATUS_RETRYING
+		if attempt == 3 {
+			want = STATUS_DEAD
+		}
+		if letter.Status != want {
+			t.Errorf("after attempt %d: status = %s, want %s", attempt, letter.Status, want)
+		}
+	}
+
+	if !letter.FirstFailedTime.Equal(first) {
+		t.Errorf("firstFailedTime = %v, want %v", letter.FirstFailedTime, first)
+	}
+	if !letter.LastFailedTime.Equal(first.Add(2 * time.Minute)) {
+		t.Errorf("lastFailedTime = %v, want %v", letter.LastFailedTime, first.Add(2*time.Minute))
+	}
+}
+
+func TestRecordFailurePermanent(t *testing.T) {
+	letter := &Letter{}
+	letter.recordFailure(failure.Permanent(errors.New(`"BOGUS" is not a valid PaymentStatus`)), time.Now(), 5)
+	if letter.Status != STATUS_DEAD {
+		t.Errorf("status = %s, want %s", letter.Status, STATUS_DEAD)
+	}
+	if letter.Class != string(failure.CLASS_PERMANENT) {
+		t.Errorf("class = %s, want %s", letter.Class, failure.CLASS_PERMANENT)
+	}
+}
+
+func TestRecordFailureKeepsOperatorStatus(t *testing.T) {
+	// a redelivery racing with an operator's replay must not resurrect the letter
+	letter := &Letter{Attempts: 5, Status: STATUS_REPLAYED}
+	letter.recordFailure(errors.New("late redelivery"), time.Now(), 5)
+	if letter.Status != STATUS_REPLAYED {
+		t.Errorf("status = %s, want %s", letter.Status, STATUS_REPLAYED)
+	}
+}
+
+func TestLetterID(t *testing.T) {
+	id := letterID("projects/kofc7186/subscriptions/eventarc-us-east1-payment-controller-sub-123", "10776451470958557")
+	if want := "eventarc-us-east1-payment-controller-sub-123-10776451470958557"; id != want {
+		t.Errorf("letterID = %q, want %q", id, want)
+	}
+}
+
+func TestTopicFromSource(t *testing.T) {
+	topic := topicFromSource("//pubsub.googleapis.com/projects/kofc7186/topics/fishfry-030824-payment-events")
+	if want := "fishfry-030824-payment-events"; topic != want {
+		t.Errorf("topicFromSource = %q, want %q", topic, want)
+	}
+}
+
+func TestCloudEventPassesThroughUncapturedEvents(t *testing.T) {
+	handlerErr := errors.New("failed")
+	wrapped := CloudEvent("Handler", func(ctx context.Context, e event.Event) error {
+		return handlerErr
+	})
+
+	// Firestore change events are retried by Eventarc but have no topic to replay them to
+	e := event.New()
+	e.SetType("google.cloud.firestore.document.v1.written")
+	if err := wrapped(context.Background(), e); !errors.Is(err, handlerErr) {
+		t.Errorf("err = %v, want %v", err, handlerErr)
+	}
+}
+
+func TestCloudEventAcknowledgesPermanentFailures(t *testing.T) {
+	wrapped := CloudEvent("Handler", func(ctx context.Context, e event.Event) error {
+		return failure.Poison(errors.New("proto.Unmarshal: cannot parse invalid wire-format data"))
+	})
+
+	e := event.New()
+	e.SetType("google.cloud.firestore.document.v1.written")
+	if err := wrapped(context.Background(), e); err != nil {
+		t.Errorf("err = %v, want the event to be acknowledged", err)
+	}
+}
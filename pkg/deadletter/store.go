@@ -0,0 +1,174 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	eventschemas "github.com/kofc7186/fundraiser-manager/pkg/event/schemas"
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
+)
+
+var (
+	// ErrNotFound is returned when no letter exists with the requested ID
+	ErrNotFound = errors.New("dead letter not found")
+	// ErrNotDead is returned when an operator acts on a letter which is not (or no longer) dead
+	ErrNotDead = errors.New("dead letter is not in the DEAD status")
+)
+
+// Store persists letters in the deadLetters collection of a fundraiser
+type Store struct {
+	client         *firestore.Client
+	collectionPath string
+	expiration     time.Time
+}
+
+// NewStore returns a Store for the fundraiser's letters; expiration is used by the Firestore TTL policy to
+// delete them along with the rest of the fundraiser
+func NewStore(client *firestore.Client, fundraiserID string, expiration time.Time) *Store {
+	return &Store{
+		client:         client,
+		collectionPath: fundraiser.Path(fundraiserID, "deadLetters"),
+		expiration:     expiration,
+	}
+}
+
+// RecordFailure records that function failed to handle the Pub/Sub message delivered in msg from topic,
+// returning the letter as updated for this attempt
+func (s *Store) RecordFailure(ctx context.Context, function, topic string, msg eventschemas.MessagePublishedData, handlerErr error, maxAttempts int) (*Letter, error) {
+	docRef := s.client.Collection(s.collectionPath).Doc(letterID(msg.Subscription, msg.Message.MessageID))
+
+	letter := &Letter{}
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		*letter = Letter{
+			Expiration:   s.expiration,
+			Function:     function,
+			ID:           docRef.ID,
+			Message:      string(msg.Message.Data),
+			MessageID:    msg.Message.MessageID,
+			Subscription: msg.Subscription,
+			Topic:        topic,
+		}
+
+		docSnap, err := tx.Get(docRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if docSnap.Exists() {
+			if err := docSnap.DataTo(letter); err != nil {
+				return err
+			}
+		}
+
+		letter.recordFailure(handlerErr, time.Now(), maxAttempts)
+		return tx.Set(docRef, letter)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return letter, nil
+}
+
+// Get returns the letter with the given ID
+func (s *Store) Get(ctx context.Context, id string) (*Letter, error) {
+	docSnap, err := s.client.Collection(s.collectionPath).Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	letter := &Letter{}
+	if err := docSnap.DataTo(letter); err != nil {
+		return nil, err
+	}
+	return letter, nil
+}
+
+// List returns the letters in the given status, most recently failed first
+func (s *Store) List(ctx context.Context, letterStatus Status) ([]*Letter, error) {
+	docSnaps, err := s.client.Collection(s.collectionPath).Where("status", "==", letterStatus).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]*Letter, 0, len(docSnaps))
+	for _, docSnap := range docSnaps {
+		letter := &Letter{}
+		if err := docSnap.DataTo(letter); err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+
+	// sorted here rather than in the query so that no composite index is required
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].LastFailedTime.After(letters[j].LastFailedTime)
+	})
+	return letters, nil
+}
+
+// SetMessage replaces the message carried by a dead letter, e.g. to correct malformed data before replaying it
+func (s *Store) SetMessage(ctx context.Context, id string, message []byte) (*Letter, error) {
+	return s.update(ctx, id, func(letter *Letter) error {
+		letter.Message = string(message)
+		if _, err := letter.CloudEvent(); err != nil {
+			return fmt.Errorf("message is not a valid CloudEvent: %w", err)
+		}
+		return nil
+	})
+}
+
+// MarkReplayed records that a dead letter was re-published to its original topic as replayedMessageID
+func (s *Store) MarkReplayed(ctx context.Context, id, replayedMessageID string) (*Letter, error) {
+	return s.update(ctx, id, func(letter *Letter) error {
+		letter.ReplayedMessageID = replayedMessageID
+		letter.Status = STATUS_REPLAYED
+		return nil
+	})
+}
+
+// Discard records that an operator has chosen not to replay a dead letter
+func (s *Store) Discard(ctx context.Context, id string) (*Letter, error) {
+	return s.update(ctx, id, func(letter *Letter) error {
+		letter.Status = STATUS_DISCARDED
+		return nil
+	})
+}
+
+// update applies fn to a dead letter within a transaction, so concurrent operators cannot both act on it
+func (s *Store) update(ctx context.Context, id string, fn func(*Letter) error) (*Letter, error) {
+	docRef := s.client.Collection(s.collectionPath).Doc(id)
+
+	letter := &Letter{}
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(docRef)
+		if status.Code(err) == codes.NotFound {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+
+		*letter = Letter{}
+		if err := docSnap.DataTo(letter); err != nil {
+			return err
+		}
+		if letter.Status != STATUS_DEAD {
+			return ErrNotDead
+		}
+		if err := fn(letter); err != nil {
+			return err
+		}
+		return tx.Set(docRef, letter)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return letter, nil
+}
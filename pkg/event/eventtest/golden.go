@@ -0,0 +1,78 @@
+// Package eventtest provides golden-file assertions for the CloudEvents and internal types produced by
+// fundraiser-manager, so that any change to their wire format shows up as a diff in review
+package eventtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// update rewrites golden files with the actual output instead of comparing against them; it is only
+// registered in test binaries that import this package, so pass it to those packages explicitly:
+//
+//	go test ./pkg/event/schemas ./pkg/types/order -update
+var update = flag.Bool("update", false, "update golden files")
+
+// normalizedID replaces the random UUIDv7 assigned to each new event so golden files are stable
+const normalizedID = "00000000-0000-0000-0000-000000000000"
+
+// AssertGoldenEvent compares the JSON encoding of e (with its ID normalized) against the golden file at path
+func AssertGoldenEvent(t testing.TB, path string, e *event.Event) {
+	t.Helper()
+	if e == nil {
+		t.Fatal("event is nil")
+	}
+
+	normalized := e.Clone()
+	normalized.SetID(normalizedID)
+
+	eventJSON, err := normalized.MarshalJSON()
+	if err != nil {
+		t.Fatalf("marshalling event: %v", err)
+	}
+
+	// round-trip through a map so the golden file is indented and keys are sorted
+	var eventMap map[string]interface{}
+	if err := json.Unmarshal(eventJSON, &eventMap); err != nil {
+		t.Fatalf("unmarshalling event: %v", err)
+	}
+	AssertGoldenJSON(t, path, eventMap)
+}
+
+// AssertGoldenJSON compares the indented JSON encoding of v against the golden file at path
+func AssertGoldenJSON(t testing.TB, path string, v interface{}) {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		t.Fatalf("marshalling %T: %v", v, err)
+	}
+	actual := buf.Bytes()
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, actual, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file (run with -update to create it): %v", err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("output does not match %s (run with -update to accept changes)\n--- expected\n%s\n--- actual\n%s", path, expected, actual)
+	}
+}
@@ -0,0 +1,215 @@
+package filter
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/cloudevents/sdk-go/v2/types"
+)
+
+type unknownValue struct{}
+
+// unknown is the value of an attribute the event lacks, and of any operation on it; as in SQL, a condition which
+// is unknown does not match, and nor does its negation
+var unknown = unknownValue{}
+
+// attributeValue returns the value of a context attribute or extension of e, as a string, int64 or bool
+func attributeValue(e *event.Event, name string) interface{} {
+	var value string
+	switch name {
+	case "id":
+		value = e.ID()
+	case "source":
+		value = e.Source()
+	case "specversion":
+		value = e.SpecVersion()
+	case "type":
+		value = e.Type()
+	case "datacontenttype":
+		value = e.DataContentType()
+	case "dataschema":
+		value = e.DataSchema()
+	case "subject":
+		value = e.Subject()
+	case "time":
+		if !e.Time().IsZero() {
+			value = e.Time().Format(time.RFC3339Nano)
+		}
+	default:
+		extension, ok := e.Extensions()[name]
+		if !ok {
+			return unknown
+		}
+		switch extension := extension.(type) {
+		case int32:
+			return int64(extension)
+		case bool:
+			return extension
+		}
+		s, err := types.ToString(extension)
+		if err != nil {
+			return unknown
+		}
+		return s
+	}
+	if value == "" {
+		return unknown
+	}
+	return value
+}
+
+func evaluate(n node, e *event.Event) interface{} {
+	switch n := n.(type) {
+	case *literal:
+		return n.value
+	case *attribute:
+		return attributeValue(e, n.name)
+	case *exists:
+		return attributeValue(e, n.name) != unknown
+	case *unary:
+		operand := evaluate(n.operand, e)
+		if n.op == "-" {
+			if i, ok := toInteger(operand); ok {
+				return -i
+			}
+			return unknown
+		}
+		if b, ok := toBoolean(operand); ok {
+			return !b
+		}
+		return unknown
+	case *binary:
+		switch n.op {
+		case "AND", "OR", "XOR":
+			return logical(n.op, evaluate(n.left, e), evaluate(n.right, e))
+		}
+		return compare(n.op, evaluate(n.left, e), evaluate(n.right, e))
+	case *like:
+		value, ok := toString(evaluate(n.value, e))
+		if !ok {
+			return unknown
+		}
+		return n.regexp.MatchString(value) != n.not
+	case *in:
+		value := evaluate(n.value, e)
+		if value == unknown {
+			return unknown
+		}
+		for _, item := range n.set {
+			if compare("=", value, evaluate(item, e)) == true {
+				return !n.not
+			}
+		}
+		return n.not
+	}
+	return unknown
+}
+
+func logical(op string, left, right interface{}) interface{} {
+	l, lok := toBoolean(left)
+	r, rok := toBoolean(right)
+	switch {
+	case op == "AND" && (lok && !l || rok && !r):
+		return false
+	case op == "OR" && (lok && l || rok && r):
+		return true
+	case !lok || !rok:
+		return unknown
+	case op == "AND":
+		return l && r
+	case op == "OR":
+		return l || r
+	}
+	return l != r
+}
+
+// compare compares values of the same type, converting the right to the type of the left if they differ
+func compare(op string, left, right interface{}) interface{} {
+	var c int
+	switch l := left.(type) {
+	case string:
+		r, ok := toString(right)
+		if !ok {
+			return unknown
+		}
+		c = strings.Compare(l, r)
+	case int64:
+		r, ok := toInteger(right)
+		if !ok {
+			return unknown
+		}
+		switch {
+		case l < r:
+			c = -1
+		case l > r:
+			c = 1
+		}
+	case bool:
+		r, ok := toBoolean(right)
+		if !ok {
+			return unknown
+		}
+		if op != "=" && op != "!=" {
+			return unknown
+		}
+		if l != r {
+			c = 1
+		}
+	default:
+		return unknown
+	}
+
+	switch op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+func toString(value interface{}) (string, bool) {
+	switch value := value.(type) {
+	case string:
+		return value, true
+	case int64:
+		return strconv.FormatInt(value, 10), true
+	case bool:
+		return strconv.FormatBool(value), true
+	}
+	return "", false
+}
+
+func toInteger(value interface{}) (int64, bool) {
+	switch value := value.(type) {
+	case int64:
+		return value, true
+	case string:
+		i, err := strconv.ParseInt(value, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+func toBoolean(value interface{}) (bool, bool) {
+	switch value := value.(type) {
+	case bool:
+		return value, true
+	case string:
+		switch strings.ToLower(value) {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		}
+	}
+	return false, false
+}
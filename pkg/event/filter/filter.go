@@ -0,0 +1,55 @@
+// Package filter selects CloudEvents with expressions in a subset of CloudEvents SQL, e.g.
+//
+//	type IN ('org.kofc7186.fundraiserManager.order.created', 'org.kofc7186.fundraiserManager.order.updated')
+//	type LIKE 'org.kofc7186.fundraiserManager.square.%' AND NOT EXISTS subject
+//
+// Expressions may refer to the context attributes and extensions of an event by name and use string, integer and
+// boolean literals, the comparison operators (=, !=, <>, <, <=, >, >=), LIKE (where % matches any run of
+// characters and _ any single one), IN, EXISTS, NOT, AND, OR and XOR
+//
+// Handlers declare the events they handle with CloudEvent, which both drops any others in-process and, through
+// PubSubFilter and the subscription-filters command, declares the filter of the handler's Pub/Sub subscription so
+// that they are not delivered at all
+package filter
+
+import (
+	"fmt"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// Expression is a parsed filter expression
+type Expression struct {
+	source string
+	root   node
+}
+
+// Parse parses a filter expression, which must be a condition
+func Parse(expression string) (*Expression, error) {
+	root, err := parse(expression)
+	if err != nil {
+		return nil, fmt.Errorf("filter %q: %w", expression, err)
+	}
+	return &Expression{source: expression, root: root}, nil
+}
+
+// MustParse is like Parse but panics if the expression cannot be parsed; it is intended for expressions declared
+// when a function is initialized
+func MustParse(expression string) *Expression {
+	x, err := Parse(expression)
+	if err != nil {
+		panic(err)
+	}
+	return x
+}
+
+// Match reports whether e satisfies the expression; conditions on attributes e lacks are not satisfied, nor are
+// their negations
+func (x *Expression) Match(e *event.Event) bool {
+	return evaluate(x.root, e) == true
+}
+
+// String returns the expression as it was parsed
+func (x *Expression) String() string {
+	return x.source
+}
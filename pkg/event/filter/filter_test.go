@@ -0,0 +1,188 @@
+package filter
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	eventschemas "github.com/kofc7186/fundraiser-manager/pkg/event/schemas"
+	"github.com/kofc7186/fundraiser-manager/pkg/tracing"
+)
+
+func newTestEvent() *event.Event {
+	e := event.New()
+	e.SetID("018e1f8a-6a30-7000-8000-000000000001")
+	e.SetSource("payment-controller-fishfry-030824-cdc")
+	e.SetType("org.kofc7186.fundraiserManager.payment.updated")
+	e.SetSubject("KkAkhdMsgzn59SM8A89WgKwekxLZY")
+	e.SetExtension("schemaversion", 1)
+	e.SetExtension("expiration", "2024-03-16T00:00:00Z")
+	return &e
+}
+
+func TestMatch(t *testing.T) {
+	e := newTestEvent()
+
+	for expression, want := range map[string]bool{
+		"type = 'org.kofc7186.fundraiserManager.payment.updated'":                                                      true,
+		`type = "org.kofc7186.fundraiserManager.payment.created"`:                                                      false,
+		"type <> 'org.kofc7186.fundraiserManager.payment.created'":                                                     true,
+		"type IN ('org.kofc7186.fundraiserManager.payment.created', 'org.kofc7186.fundraiserManager.payment.updated')": true,
+		"type NOT IN ('org.kofc7186.fundraiserManager.payment.updated')":                                               false,
+		"type LIKE 'org.kofc7186.fundraiserManager.payment.%'":                                                         true,
+		"type LIKE 'org.kofc7186.fundraiserManager.payment.update_'":                                                   true,
+		"type LIKE 'org.kofc7186.fundraiserManager.square.%'":                                                          false,
+		"type NOT LIKE 'org.kofc7186.fundraiserManager.square.%'":                                                      true,
+		"source LIKE '%-cdc' AND subject = 'KkAkhdMsgzn59SM8A89WgKwekxLZY'":                                            true,
+		"source LIKE '%-cdc' AND NOT subject = 'KkAkhdMsgzn59SM8A89WgKwekxLZY'":                                        false,
+		"subject = 'other' OR (type = 'other' OR id = '018e1f8a-6a30-7000-8000-000000000001')":                         true,
+		"TRUE XOR subject = 'KkAkhdMsgzn59SM8A89WgKwekxLZY'":                                                           false,
+		"EXISTS subject AND EXISTS expiration":                                                                         true,
+		"schemaversion = 1 AND schemaversion < 2 AND schemaversion >= -1":                                              true,
+		"schemaversion = '1'": true,
+		"subject != 'it\\'s'": true,
+		"expiration > '2024-03-15' AND expiration <= '2024-03-16T00:00:00Z'": true,
+		// conditions on, and negations of conditions on, missing attributes are unknown, and so never match
+		"EXISTS dataschema":                         false,
+		"dataschema = 'x'":                          false,
+		"NOT dataschema = 'x'":                      false,
+		"dataschema = 'x' OR source LIKE '%-cdc'":   true,
+		"NOT (dataschema = 'x' AND type = 'other')": true,
+	} {
+		x, err := Parse(expression)
+		if err != nil {
+			t.Errorf("%s: %v", expression, err)
+			continue
+		}
+		if got := x.Match(e); got != want {
+			t.Errorf("%s matched %v, want %v", expression, got, want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"type",
+		"type = ",
+		"type = 'unterminated",
+		"type IN ()",
+		"type LIKE subject",
+		"(type = 'a'",
+		"type = 'a' subject = 'b'",
+		"type == 'a'",
+		"EXISTS 'type'",
+	} {
+		if _, err := Parse(expression); err == nil {
+			t.Errorf("%q parsed", expression)
+		}
+	}
+}
+
+func TestPubSubFilter(t *testing.T) {
+	// messages without attributes are accepted for the handler to match
+	const unfiltered = "NOT attributes:type OR "
+	for expression, want := range map[string]string{
+		"type = 'a.b'":                                  unfiltered + `attributes.type = "a.b"`,
+		"'a.b' != type":                                 unfiltered + `attributes.type != "a.b"`,
+		"type IN ('a', 'b')":                            unfiltered + `(attributes.type = "a" OR attributes.type = "b")`,
+		"type NOT IN ('a', 'b')":                        unfiltered + `(attributes.type != "a" AND attributes.type != "b")`,
+		"type LIKE 'a.%'":                               unfiltered + `hasPrefix(attributes.type, "a.")`,
+		"type NOT LIKE 'a\\%%'":                         unfiltered + `NOT hasPrefix(attributes.type, "a%")`,
+		"type LIKE 'a'":                                 unfiltered + `attributes.type = "a"`,
+		"EXISTS subject AND NOT source = 'x'":           unfiltered + `(attributes:subject AND NOT attributes.source = "x")`,
+		"type IN ('a', 'b') AND EXISTS subject":         unfiltered + `((attributes.type = "a" OR attributes.type = "b") AND attributes:subject)`,
+		"type = 'a' AND subject = 'b' AND source = 'c'": unfiltered + `(attributes.type = "a" AND attributes.subject = "b" AND attributes.source = "c")`,
+		"type = 'a' OR subject = 'b' AND source = 'c'":  unfiltered + `attributes.type = "a" OR (attributes.subject = "b" AND attributes.source = "c")`,
+		"NOT (type = 'a' OR type = 'b')":                unfiltered + `NOT (attributes.type = "a" OR attributes.type = "b")`,
+	} {
+		x, err := Parse(expression)
+		if err != nil {
+			t.Errorf("%s: %v", expression, err)
+			continue
+		}
+		got, err := x.PubSubFilter()
+		if err != nil {
+			t.Errorf("%s: %v", expression, err)
+		} else if got != want {
+			t.Errorf("%s translated to %s, want %s", expression, got, want)
+		}
+	}
+
+	for _, expression := range []string{
+		"id = 'a'",                  // not an attribute of the message
+		"type = subject",            // Pub/Sub only compares with literals
+		"type LIKE '%.created'",     // not a prefix
+		"type = 'a' XOR type = 'b'", // no XOR
+		"type > 'a'",                // no ordering
+	} {
+		if filter, err := MustParse(expression).PubSubFilter(); err == nil {
+			t.Errorf("%s translated to %s", expression, filter)
+		}
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	e := newTestEvent()
+	want := map[string]string{
+		"type":    "org.kofc7186.fundraiserManager.payment.updated",
+		"subject": "KkAkhdMsgzn59SM8A89WgKwekxLZY",
+		"source":  "payment-controller-fishfry-030824-cdc",
+	}
+	if got := Attributes(e); !reflect.DeepEqual(got, want) {
+		t.Errorf("Attributes = %v, want %v", got, want)
+	}
+
+	e.SetSubject("")
+	if _, ok := Attributes(e)["subject"]; ok {
+		t.Error("an event without a subject has a subject attribute")
+	}
+}
+
+func TestCloudEvent(t *testing.T) {
+	var handled []string
+	fn := CloudEvent("type LIKE 'org.kofc7186.fundraiserManager.payment.%'", func(ctx context.Context, e event.Event) error {
+		handled = append(handled, e.ID())
+		return nil
+	})
+
+	deliver := func(id, eventType string) {
+		nested := newTestEvent()
+		nested.SetType(eventType)
+		nestedJSON, err := nested.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		delivery := event.New()
+		delivery.SetID(id)
+		delivery.SetType(tracing.PubSubMessagePublishedType)
+		if err := delivery.SetData("application/json", eventschemas.MessagePublishedData{
+			Message: eventschemas.PubSubMessage{Data: nestedJSON, MessageID: id},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := fn(context.Background(), delivery); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deliver("1", "org.kofc7186.fundraiserManager.payment.created")
+	deliver("2", "org.kofc7186.fundraiserManager.order.created")
+
+	// messages which do not carry an event are left to the function
+	undecodable := event.New()
+	undecodable.SetID("3")
+	undecodable.SetType(tracing.PubSubMessagePublishedType)
+	if err := undecodable.SetData("application/json", json.RawMessage(`{"message": {"data": "bm90IGFuIGV2ZW50"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fn(context.Background(), undecodable); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"1", "3"}; !reflect.DeepEqual(handled, want) {
+		t.Errorf("handled %v, want %v", handled, want)
+	}
+}
@@ -0,0 +1,39 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	eventschemas "github.com/kofc7186/fundraiser-manager/pkg/event/schemas"
+	"github.com/kofc7186/fundraiser-manager/pkg/tracing"
+)
+
+// CloudEvent wraps a CloudEvent function so that it is only called for events matching expression, and
+// acknowledges any others; events delivered by Pub/Sub are matched by the event nested within
+//
+// The expression is also the declaration from which the subscription-filters command generates the filter of the
+// function's Pub/Sub subscription, so that the other events are not delivered at all; that filter lets through
+// messages without attributes, such as those published before events were filtered, which are matched here instead
+func CloudEvent(expression string, fn func(context.Context, event.Event) error) func(context.Context, event.Event) error {
+	x := MustParse(expression)
+	return func(ctx context.Context, e event.Event) error {
+		target := &e
+		if e.Type() == tracing.PubSubMessagePublishedType {
+			nestedEvent, err := eventschemas.NestedEvent(e)
+			if err != nil {
+				// leave the function to classify the failure
+				return fn(ctx, e)
+			}
+			target = nestedEvent
+		}
+
+		if !x.Match(target) {
+			slog.DebugContext(ctx, fmt.Sprintf("squelching %q event not matching %s", target.Type(), x), "event", target)
+			return nil
+		}
+		return fn(ctx, e)
+	}
+}
@@ -0,0 +1,368 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// the nodes of a parsed expression
+type (
+	literal struct {
+		value interface{} // string, int64 or bool
+	}
+	attribute struct {
+		name string
+	}
+	exists struct {
+		name string
+	}
+	unary struct {
+		op      string // NOT or -
+		operand node
+	}
+	binary struct {
+		op          string // AND, OR, XOR, =, !=, <, <=, > or >=
+		left, right node
+	}
+	like struct {
+		value   node
+		pattern string
+		regexp  *regexp.Regexp
+		not     bool
+	}
+	in struct {
+		value node
+		set   []node
+		not   bool
+	}
+)
+
+type node interface{}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdentifier
+	tokenKeyword
+	tokenString
+	tokenInteger
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	text  string // upper-cased for keywords
+	value interface{}
+	pos   int
+}
+
+var keywords = map[string]bool{
+	"AND": true, "OR": true, "XOR": true, "NOT": true, "LIKE": true, "IN": true, "EXISTS": true, "TRUE": true, "FALSE": true,
+}
+
+func lex(expression string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expression); {
+		c := expression[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'' || c == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(expression) && expression[j] != c; j++ {
+				// only the quote is unescaped, leaving the escapes of LIKE patterns
+				if expression[j] == '\\' && j+1 < len(expression) && expression[j+1] == c {
+					j++
+				}
+				b.WriteByte(expression[j])
+			}
+			if j == len(expression) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: expression[i : j+1], value: b.String(), pos: i})
+			i = j + 1
+		case isDigit(c):
+			j := i
+			for j < len(expression) && isDigit(expression[j]) {
+				j++
+			}
+			value, err := strconv.ParseInt(expression[i:j], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("integer at %d: %w", i, err)
+			}
+			tokens = append(tokens, token{kind: tokenInteger, text: expression[i:j], value: value, pos: i})
+			i = j
+		case isLetter(c):
+			j := i
+			for j < len(expression) && (isLetter(expression[j]) || isDigit(expression[j])) {
+				j++
+			}
+			word := expression[i:j]
+			if upper := strings.ToUpper(word); keywords[upper] {
+				tokens = append(tokens, token{kind: tokenKeyword, text: upper, pos: i})
+			} else {
+				// attribute names are lower case
+				tokens = append(tokens, token{kind: tokenIdentifier, text: strings.ToLower(word), pos: i})
+			}
+			i = j
+		default:
+			operator := ""
+			for _, candidate := range []string{"!=", "<>", "<=", ">=", "=", "<", ">", "(", ")", ",", "-"} {
+				if strings.HasPrefix(expression[i:], candidate) {
+					operator = candidate
+					break
+				}
+			}
+			if operator == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			if operator == "<>" {
+				operator = "!="
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: operator, pos: i})
+			i += len(operator)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(expression)}), nil
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// parser is a recursive descent parser; from lowest to highest, the precedence of the operators is OR, XOR, AND,
+// NOT, the comparisons (including LIKE and IN) and unary minus
+type parser struct {
+	tokens []token
+	next   int
+}
+
+func parse(expression string) (node, error) {
+	tokens, err := lex(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.unexpected(t)
+	}
+	if !isBoolean(n) {
+		return nil, fmt.Errorf("expression is not a condition")
+	}
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) accept(kind tokenKind, text string) bool {
+	if t := p.peek(); t.kind == kind && t.text == text {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	if !p.accept(kind, text) {
+		return p.unexpected(p.peek())
+	}
+	return nil
+}
+
+func (p *parser) unexpected(t token) error {
+	if t.kind == tokenEOF {
+		return fmt.Errorf("unexpected end of expression")
+	}
+	return fmt.Errorf("unexpected %s at %d", t.text, t.pos)
+}
+
+func (p *parser) or() (node, error) {
+	return p.logical("OR", p.xor)
+}
+
+func (p *parser) xor() (node, error) {
+	return p.logical("XOR", p.and)
+}
+
+func (p *parser) and() (node, error) {
+	return p.logical("AND", p.not)
+}
+
+func (p *parser) logical(op string, operand func() (node, error)) (node, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(tokenKeyword, op) {
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) not() (node, error) {
+	if p.accept(tokenKeyword, "NOT") {
+		operand, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: "NOT", operand: operand}, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (node, error) {
+	left, err := p.negation()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	switch {
+	case t.kind == tokenOperator && (t.text == "=" || t.text == "!=" || t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">="):
+		p.next++
+		right, err := p.negation()
+		if err != nil {
+			return nil, err
+		}
+		return &binary{op: t.text, left: left, right: right}, nil
+	case t.kind == tokenKeyword && (t.text == "LIKE" || t.text == "IN"):
+		return p.membership(left, false)
+	case t.kind == tokenKeyword && t.text == "NOT":
+		if following := p.tokens[p.next+1]; following.kind == tokenKeyword && (following.text == "LIKE" || following.text == "IN") {
+			p.next++
+			return p.membership(left, true)
+		}
+	}
+	return left, nil
+}
+
+// membership parses the LIKE or IN following value
+func (p *parser) membership(value node, not bool) (node, error) {
+	if p.accept(tokenKeyword, "LIKE") {
+		t := p.peek()
+		if t.kind != tokenString {
+			return nil, p.unexpected(t)
+		}
+		p.next++
+		pattern := t.value.(string)
+		return &like{value: value, pattern: pattern, regexp: likeRegexp(pattern), not: not}, nil
+	}
+
+	if err := p.expect(tokenKeyword, "IN"); err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokenOperator, "("); err != nil {
+		return nil, err
+	}
+	n := &in{value: value, not: not}
+	for {
+		item, err := p.negation()
+		if err != nil {
+			return nil, err
+		}
+		n.set = append(n.set, item)
+		if !p.accept(tokenOperator, ",") {
+			break
+		}
+	}
+	if err := p.expect(tokenOperator, ")"); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (p *parser) negation() (node, error) {
+	if p.accept(tokenOperator, "-") {
+		operand, err := p.negation()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: "-", operand: operand}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.peek()
+	p.next++
+	switch {
+	case t.kind == tokenString || t.kind == tokenInteger:
+		return &literal{value: t.value}, nil
+	case t.kind == tokenKeyword && (t.text == "TRUE" || t.text == "FALSE"):
+		return &literal{value: t.text == "TRUE"}, nil
+	case t.kind == tokenIdentifier:
+		return &attribute{name: t.text}, nil
+	case t.kind == tokenKeyword && t.text == "EXISTS":
+		name := p.peek()
+		if name.kind != tokenIdentifier {
+			return nil, p.unexpected(name)
+		}
+		p.next++
+		return &exists{name: name.text}, nil
+	case t.kind == tokenOperator && t.text == "(":
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenOperator, ")"); err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+	p.next--
+	return nil, p.unexpected(t)
+}
+
+// likeRegexp translates a LIKE pattern, in which % matches any run of characters, _ matches any single character
+// and \ escapes either
+func likeRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString(`^(?s:`)
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case c == '%':
+			b.WriteString(`.*`)
+		case c == '_':
+			b.WriteString(`.`)
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString(`)$`)
+	return regexp.MustCompile(b.String())
+}
+
+// isBoolean reports whether n always evaluates to a boolean (or to unknown)
+func isBoolean(n node) bool {
+	switch n := n.(type) {
+	case *literal:
+		_, ok := n.value.(bool)
+		return ok
+	case *unary:
+		return n.op == "NOT"
+	case *binary, *like, *in, *exists:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package filter
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// PubSubAttributes are the context attributes published as attributes of the Pub/Sub message carrying an event,
+// under the same names, and so the only ones a subscription filter may refer to; fundraiserid is the extension
+// routing the event to its fundraiser (see pkg/fundraiser)
+var PubSubAttributes = []string{"type", "subject", "source", "fundraiserid"}
+
+// MAX_PUBSUB_FILTER_LENGTH is the longest filter Pub/Sub accepts on a subscription
+const MAX_PUBSUB_FILTER_LENGTH = 256
+
+// Attributes returns the Pub/Sub message attributes for e
+func Attributes(e *event.Event) map[string]string {
+	attributes := make(map[string]string, len(PubSubAttributes))
+	for _, name := range PubSubAttributes {
+		if value, ok := attributeValue(e, name).(string); ok {
+			attributes[name] = value
+		}
+	}
+	return attributes
+}
+
+// PubSubFilter translates the expression into the filter syntax of Pub/Sub subscriptions, over the attributes
+// set by Attributes; messages without them, such as those published before events were filtered, are also accepted
+// (every event has a type, so a message without a type attribute has none of them), leaving CloudEvent to match them
+//
+// Only conditions which compare an attribute for (in)equality with literals, test its prefix with a LIKE pattern
+// ending in its only %, or test its existence, combined with NOT, AND and OR, can be translated
+func (x *Expression) PubSubFilter() (string, error) {
+	filter, err := pubSubOperand("OR", x.root)
+	if err != nil {
+		return "", fmt.Errorf("filter %q: %w", x.source, err)
+	}
+	filter = "NOT attributes:type OR " + filter
+	if len(filter) > MAX_PUBSUB_FILTER_LENGTH {
+		return "", fmt.Errorf("filter %q: Pub/Sub filter is longer than %d bytes: %s", x.source, MAX_PUBSUB_FILTER_LENGTH, filter)
+	}
+	return filter, nil
+}
+
+func pubSubFilter(n node) (string, error) {
+	switch n := n.(type) {
+	case *binary:
+		switch n.op {
+		case "AND", "OR":
+			left, err := pubSubOperand(n.op, n.left)
+			if err != nil {
+				return "", err
+			}
+			right, err := pubSubOperand(n.op, n.right)
+			if err != nil {
+				return "", err
+			}
+			return left + " " + n.op + " " + right, nil
+		case "=", "!=":
+			name, value, err := pubSubComparison(n.left, n.right)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("attributes.%s %s %s", name, n.op, value), nil
+		}
+		return "", fmt.Errorf("%s cannot be applied by Pub/Sub", n.op)
+	case *unary:
+		if n.op != "NOT" {
+			return "", fmt.Errorf("unary %s cannot be applied by Pub/Sub", n.op)
+		}
+		operand, err := pubSubFilter(n.operand)
+		if err != nil {
+			return "", err
+		}
+		if compound(n.operand) {
+			operand = "(" + operand + ")"
+		}
+		return "NOT " + operand, nil
+	case *exists:
+		if err := checkPubSubAttribute(n.name); err != nil {
+			return "", err
+		}
+		return "attributes:" + n.name, nil
+	case *like:
+		name, err := pubSubAttribute(n.value)
+		if err != nil {
+			return "", err
+		}
+		prefix, exact, ok := likePrefix(n.pattern)
+		switch {
+		case !ok:
+			return "", fmt.Errorf("LIKE %q cannot be applied by Pub/Sub, as it is not a prefix", n.pattern)
+		case exact && n.not:
+			return fmt.Sprintf("attributes.%s != %s", name, strconv.Quote(prefix)), nil
+		case exact:
+			return fmt.Sprintf("attributes.%s = %s", name, strconv.Quote(prefix)), nil
+		case n.not:
+			return fmt.Sprintf("NOT hasPrefix(attributes.%s, %s)", name, strconv.Quote(prefix)), nil
+		}
+		return fmt.Sprintf("hasPrefix(attributes.%s, %s)", name, strconv.Quote(prefix)), nil
+	case *in:
+		op, join := "=", " OR "
+		if n.not {
+			op, join = "!=", " AND "
+		}
+		comparisons := make([]string, len(n.set))
+		for i, item := range n.set {
+			name, value, err := pubSubComparison(n.value, item)
+			if err != nil {
+				return "", err
+			}
+			comparisons[i] = fmt.Sprintf("attributes.%s %s %s", name, op, value)
+		}
+		return strings.Join(comparisons, join), nil
+	}
+	return "", fmt.Errorf("%T cannot be applied by Pub/Sub", n)
+}
+
+// pubSubOperand translates an operand of AND or OR, parenthesizing it if need be, as Pub/Sub does not allow AND and
+// OR to be mixed without parentheses
+func pubSubOperand(op string, n node) (string, error) {
+	filter, err := pubSubFilter(n)
+	if err != nil {
+		return "", err
+	}
+	if b, ok := n.(*binary); ok && b.op == op {
+		return filter, nil
+	}
+	if compound(n) {
+		filter = "(" + filter + ")"
+	}
+	return filter, nil
+}
+
+// compound reports whether the translation of n joins several conditions
+func compound(n node) bool {
+	switch n := n.(type) {
+	case *binary:
+		return n.op == "AND" || n.op == "OR"
+	case *in:
+		return len(n.set) > 1
+	}
+	return false
+}
+
+// pubSubComparison returns the attribute and quoted literal compared by a condition, in either order
+func pubSubComparison(left, right node) (string, string, error) {
+	if _, ok := left.(*literal); ok {
+		left, right = right, left
+	}
+	name, err := pubSubAttribute(left)
+	if err != nil {
+		return "", "", err
+	}
+	l, ok := right.(*literal)
+	if !ok {
+		return "", "", fmt.Errorf("attribute %s can only be compared with a literal by Pub/Sub", name)
+	}
+	value, _ := toString(l.value)
+	return name, strconv.Quote(value), nil
+}
+
+func pubSubAttribute(n node) (string, error) {
+	a, ok := n.(*attribute)
+	if !ok {
+		return "", fmt.Errorf("only attributes can be filtered on by Pub/Sub")
+	}
+	return a.name, checkPubSubAttribute(a.name)
+}
+
+func checkPubSubAttribute(name string) error {
+	if !slices.Contains(PubSubAttributes, name) {
+		return fmt.Errorf("%s is not published as a Pub/Sub attribute", name)
+	}
+	return nil
+}
+
+// likePrefix returns the literal prefix matched by a pattern which has no wildcard other than a trailing %, and
+// whether the pattern has no wildcard at all
+func likePrefix(pattern string) (prefix string, exact bool, ok bool) {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteByte(pattern[i])
+		case c == '%' && i == len(pattern)-1:
+			return b.String(), false, true
+		case c == '%' || c == '_':
+			return "", false, false
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), true, true
+}
@@ -0,0 +1,170 @@
+package jsonschema
+
+import (
+	"encoding"
+	"encoding/json"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Generate returns the schema of the JSON encoding of v, following the rules of encoding/json; named structs
+// other than v's own type are placed in $defs
+//
+// No property is required, so that data encoded before a field was added still validates; renaming or removing
+// a field, or changing its type, is what calls for a new schema
+func Generate(v interface{}) *Schema {
+	g := &generator{defs: make(map[string]*Schema)}
+
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	root := &Schema{Schema: DRAFT}
+	if t.Kind() == reflect.Struct {
+		g.structSchema(t, root)
+	} else {
+		*root = *g.schema(t)
+		root.Schema = DRAFT
+	}
+	if len(g.defs) > 0 {
+		root.Defs = g.defs
+	}
+	return root
+}
+
+type generator struct {
+	defs map[string]*Schema
+}
+
+func (g *generator) schema(t reflect.Type) *Schema {
+	switch {
+	case t == timeType:
+		return &Schema{Type: Types{"string"}, Format: "date-time"}
+	case t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType):
+		// the encoding is up to the type
+		return &Schema{}
+	case t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType):
+		return &Schema{Type: Types{"string"}}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: Types{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: Types{"integer"}}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		minimum := 0.0
+		return &Schema{Type: Types{"integer"}, Minimum: &minimum}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: Types{"number"}}
+	case reflect.String:
+		return &Schema{Type: Types{"string"}}
+	case reflect.Pointer:
+		return nullable(g.schema(t.Elem()))
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// base64
+			return &Schema{Type: Types{"string", "null"}}
+		}
+		return &Schema{Type: Types{"array", "null"}, Items: g.schema(t.Elem())}
+	case reflect.Array:
+		return &Schema{Type: Types{"array"}, Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: Types{"object", "null"}, AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			s := &Schema{}
+			g.structSchema(t, s)
+			return s
+		}
+		name := defName(t)
+		if _, ok := g.defs[name]; !ok {
+			s := &Schema{}
+			// registered before descending so that recursive types terminate
+			g.defs[name] = s
+			g.structSchema(t, s)
+		}
+		return &Schema{Ref: "#/$defs/" + name}
+	}
+	// interfaces, and anything else encoding/json would encode dynamically
+	return &Schema{}
+}
+
+// structSchema fills s with the properties of struct type t, flattening embedded structs as encoding/json does
+func (g *generator) structSchema(t reflect.Type, s *Schema) {
+	s.Type = Types{"object"}
+	s.Properties = make(map[string]*Schema)
+	g.addFields(t, s.Properties)
+}
+
+func (g *generator) addFields(t reflect.Type, properties map[string]*Schema) {
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			fieldType := field.Type
+			for fieldType.Kind() == reflect.Pointer {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				embedded = append(embedded, fieldType)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = g.schema(field.Type)
+	}
+
+	// as with encoding/json, fields promoted from embedded structs lose to those of the outer struct
+	for _, embeddedType := range embedded {
+		promoted := make(map[string]*Schema)
+		g.addFields(embeddedType, promoted)
+		for name, s := range promoted {
+			if _, ok := properties[name]; !ok {
+				properties[name] = s
+			}
+		}
+	}
+}
+
+// nullable additionally allows null
+func nullable(s *Schema) *Schema {
+	switch {
+	case s.Ref != "":
+		return &Schema{AnyOf: []*Schema{s, {Type: Types{"null"}}}}
+	case len(s.Type) == 0:
+		return s
+	}
+	for _, name := range s.Type {
+		if name == "null" {
+			return s
+		}
+	}
+	s.Type = append(s.Type, "null")
+	return s
+}
+
+// defName qualifies a type name with the last element of its package path, e.g. order.Order
+func defName(t reflect.Type) string {
+	return path.Base(t.PkgPath()) + "." + t.Name()
+}
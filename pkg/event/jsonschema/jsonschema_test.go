@@ -0,0 +1,122 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testItem struct {
+	Name     string `json:"name"`
+	Quantity uint16 `json:"quantity"`
+}
+
+type testBase struct {
+	ID   string `json:"id"`
+	Note string `json:"note"`
+}
+
+type testOrder struct {
+	testBase
+	Note      int             `json:"note"` // shadows testBase.Note
+	Created   time.Time       `json:"created"`
+	Items     []testItem      `json:"items"`
+	Parent    *testOrder      `json:"parent,omitempty"`
+	Keys      map[string]bool `json:"keys"`
+	Anything  interface{}     `json:"anything"`
+	Untagged  float64         ``
+	Ignored   string          `json:"-"`
+	unexposed string
+	Extra     map[string]float64 `json:"extra,omitempty"`
+}
+
+func TestGenerate(t *testing.T) {
+	s := Generate(&testOrder{})
+
+	if s.Schema != DRAFT || !reflect.DeepEqual(s.Type, Types{"object"}) {
+		t.Errorf("unexpected root %+v", s)
+	}
+	var names []string
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	for _, name := range []string{"id", "note", "created", "items", "parent", "keys", "anything", "Untagged", "extra"} {
+		if _, ok := s.Properties[name]; !ok {
+			t.Errorf("missing property %s, have %v", name, names)
+		}
+	}
+	if len(s.Properties) != 9 {
+		t.Errorf("unexpected properties %v", names)
+	}
+
+	if note := s.Properties["note"]; !reflect.DeepEqual(note.Type, Types{"integer"}) {
+		t.Errorf("the outer note should win, got %+v", note)
+	}
+	if created := s.Properties["created"]; created.Format != "date-time" {
+		t.Errorf("created = %+v", created)
+	}
+	if parent := s.Properties["parent"]; len(parent.AnyOf) != 2 || parent.AnyOf[0].Ref != "#/$defs/jsonschema.testOrder" {
+		t.Errorf("parent = %+v", parent)
+	}
+	if item := s.Defs["jsonschema.testItem"]; item == nil || *item.Properties["quantity"].Minimum != 0 {
+		t.Errorf("testItem def = %+v", item)
+	}
+
+	// definitions survive a round trip through JSON
+	encoded, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, s) {
+		t.Errorf("round trip changed the schema:\n%s", encoded)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s := Generate(&testOrder{})
+
+	valid, err := json.Marshal(&testOrder{
+		testBase: testBase{ID: "1"},
+		Created:  time.Date(2024, 3, 8, 17, 0, 0, 0, time.UTC),
+		Items:    []testItem{{Name: "fish", Quantity: 2}},
+		Parent:   &testOrder{testBase: testBase{ID: "0"}},
+		Anything: []int{1, 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ValidateJSON(valid); err != nil {
+		t.Errorf("valid document rejected: %v", err)
+	}
+	// no property is required, and unknown properties are allowed
+	if err := s.ValidateJSON([]byte(`{"added": true}`)); err != nil {
+		t.Errorf("sparse document rejected: %v", err)
+	}
+
+	invalid := `{
+		"created": "yesterday",
+		"items": [{"name": "fish", "quantity": -1}, {"quantity": 1.5}],
+		"parent": {"id": 7},
+		"keys": {"a": "yes"},
+		"Untagged": "1"
+	}`
+	err = s.ValidateJSON([]byte(invalid))
+	var validationError *ValidationError
+	if !errors.As(err, &validationError) {
+		t.Fatalf("err = %v, want a *ValidationError", err)
+	}
+	var paths []string
+	for _, violation := range validationError.Violations {
+		paths = append(paths, violation.Path)
+	}
+	want := []string{"/Untagged", "/created", "/items/0/quantity", "/items/1/quantity", "/keys/a", "/parent"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("violations at %v, want %v: %v", paths, want, err)
+	}
+}
@@ -0,0 +1,182 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxErrors bounds the number of violations reported by Validate
+const maxErrors = 10
+
+// ValidationError lists the ways in which a value violates a schema
+type ValidationError struct {
+	Violations []Violation
+}
+
+// Violation is a value which violates a schema; Path is a JSON Pointer to the value
+type Violation struct {
+	Path    string
+	Message string
+}
+
+func (v *ValidationError) Error() string {
+	messages := make([]string, len(v.Violations))
+	for i, violation := range v.Violations {
+		path := violation.Path
+		if path == "" {
+			path = "/"
+		}
+		messages[i] = fmt.Sprintf("%s: %s", path, violation.Message)
+	}
+	return "schema validation failed: " + strings.Join(messages, "; ")
+}
+
+// ValidateJSON decodes data and validates it against s
+func (s *Schema) ValidateJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	return s.Validate(value)
+}
+
+// Validate checks a value decoded by encoding/json against s, returning a *ValidationError if it is invalid; it
+// supports the keywords of the Schema type, resolving $ref only within the root's $defs
+func (s *Schema) Validate(value interface{}) error {
+	v := &validator{root: s}
+	v.validate(s, value, "")
+	if len(v.violations) == 0 {
+		return nil
+	}
+	return &ValidationError{Violations: v.violations}
+}
+
+type validator struct {
+	root       *Schema
+	violations []Violation
+}
+
+func (v *validator) fail(path, format string, args ...interface{}) {
+	if len(v.violations) < maxErrors {
+		v.violations = append(v.violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+func (v *validator) validate(s *Schema, value interface{}, path string) {
+	if s.Ref != "" {
+		name, ok := strings.CutPrefix(s.Ref, "#/$defs/")
+		def, found := v.root.Defs[name]
+		if !ok || !found {
+			v.fail(path, "unresolvable $ref %s", s.Ref)
+			return
+		}
+		v.validate(def, value, path)
+	}
+
+	if len(s.AnyOf) > 0 {
+		matched := false
+		for _, option := range s.AnyOf {
+			if (&validator{root: v.root}).matches(option, value, path) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.fail(path, "does not match any of the allowed schemas")
+			return
+		}
+	}
+
+	if len(s.Type) > 0 && !hasType(s.Type, value) {
+		v.fail(path, "expected %s, got %s", strings.Join(s.Type, " or "), typeOf(value))
+		return
+	}
+
+	switch value := value.(type) {
+	case string:
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, value); err != nil {
+				v.fail(path, "%q is not an RFC 3339 date-time", value)
+			}
+		}
+	case float64:
+		if s.Minimum != nil && value < *s.Minimum {
+			v.fail(path, "%v is less than the minimum of %v", value, *s.Minimum)
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range value {
+				v.validate(s.Items, item, path+"/"+strconv.Itoa(i))
+			}
+		}
+	case map[string]interface{}:
+		// sorted so that the violations reported are deterministic
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			propertyPath := path + "/" + escape(key)
+			if property, ok := s.Properties[key]; ok {
+				v.validate(property, value[key], propertyPath)
+			} else if s.AdditionalProperties != nil {
+				v.validate(s.AdditionalProperties, value[key], propertyPath)
+			}
+		}
+	}
+}
+
+// matches reports whether value is valid against s, resolving references against the validator's root
+func (v *validator) matches(s *Schema, value interface{}, path string) bool {
+	v.validate(s, value, path)
+	return len(v.violations) == 0
+}
+
+func hasType(types Types, value interface{}) bool {
+	for _, name := range types {
+		switch actual := typeOf(value); {
+		case name == actual:
+			return true
+		case name == "number" && actual == "integer":
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) && !math.IsInf(value, 0) {
+			return "integer"
+		}
+		return "number"
+	case json.Number:
+		if _, err := value.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// escape encodes a key as a JSON Pointer reference token
+func escape(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
@@ -1,24 +1,57 @@
 package schemas
 
 import (
+	"fmt"
+	"sync"
+	"time"
+
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/google/uuid"
-	"github.com/kofc7186/fundraiser-manager/pkg/util"
+	"github.com/kofc7186/fundraiser-manager/pkg/config"
 )
 
 const applicationJSON = "application/json"
 
+// attributes are the attributes of every event created by the function
+type attributes struct {
+	Source         string    `env:"K_SERVICE" validate:"required"`
+	ExpirationTime time.Time `env:"EXPIRATION_TIME" validate:"required"`
+}
+
+// loadAttributes loads the attributes of the function's events once
+var loadAttributes = sync.OnceValues(func() (attributes, error) {
+	var attrs attributes
+	err := config.Load(&attrs)
+	return attrs, err
+})
+
+// Init loads the attributes given to the events the function creates, so that a function missing them fails when
+// it starts rather than when it first creates an event
+func Init() error {
+	_, err := loadAttributes()
+	return err
+}
+
 func newEvent(eventType string) *cloudevents.Event {
+	attrs, err := loadAttributes()
+	if err != nil {
+		panic(fmt.Sprintf("invalid configuration:\n%v", err))
+	}
+
 	event := cloudevents.NewEvent()
 
-	event.SetSource(util.GetEnvOrPanic("K_SERVICE"))
+	event.SetSource(attrs.Source)
 	event.SetType(eventType)
 
 	// UUIDv7 are used because they contain timestamps and thus can be easily sorted in chronological order
 	event.SetID(uuid.Must(uuid.NewV7()).String())
 
 	// This will be recorded as the field which triggers deletion upon a Firestore TTL policy sweep
-	event.SetExtension("expiration", util.GetEnvOrPanic("EXPIRATION_TIME"))
+	event.SetExtension("expiration", attrs.ExpirationTime.Format(time.RFC3339))
+
+	if version := CurrentVersion(eventType); version != 0 {
+		event.SetExtension(SCHEMA_VERSION_EXTENSION, version)
+	}
 
 	return &event
 }
@@ -47,7 +47,7 @@ func NewCustomerCreatedFromSquare(squareCustomerCreatedEvent *webhooks.CustomerC
 		Raw: squareCustomerCreatedEvent,
 	}
 
-	if err = event.SetData(applicationJSON, cc); err != nil {
+	if err = setData(event, cc); err != nil {
 		return nil, err
 	}
 	return event, nil
@@ -70,7 +70,7 @@ func NewCustomerUpdatedFromSquare(squareCustomerUpdatedEvent *webhooks.CustomerU
 		Raw: squareCustomerUpdatedEvent,
 	}
 
-	if err = event.SetData(applicationJSON, cu); err != nil {
+	if err = setData(event, cu); err != nil {
 		return nil, err
 	}
 	return event, nil
@@ -91,7 +91,7 @@ func NewCustomerCreated(customer *customer.Customer) (*cloudevents.Event, error)
 		},
 	}
 
-	if err := event.SetData(applicationJSON, cc); err != nil {
+	if err := setData(event, cc); err != nil {
 		return nil, err
 	}
 	return event, nil
@@ -116,7 +116,7 @@ func NewCustomerUpdated(oldCustomer, newCustomer *customer.Customer, fieldMask [
 		UpdatedFields: fieldMask,
 	}
 
-	if err := event.SetData(applicationJSON, cu); err != nil {
+	if err := setData(event, cu); err != nil {
 		return nil, err
 	}
 	return event, nil
@@ -137,7 +137,7 @@ func NewCustomerDeleted(customer *customer.Customer) (*cloudevents.Event, error)
 		},
 	}
 
-	if err := event.SetData(applicationJSON, cd); err != nil {
+	if err := setData(event, cd); err != nil {
 		return nil, err
 	}
 	return event, nil
@@ -39,7 +39,7 @@ func NewOrderCreated(order *order.Order) (*cloudevents.Event, error) {
 		},
 	}
 
-	if err := event.SetData(applicationJSON, oc); err != nil {
+	if err := setData(event, oc); err != nil {
 		return nil, err
 	}
 	return event, nil
@@ -64,7 +64,7 @@ func NewOrderUpdated(oldOrder, newOrder *order.Order, fieldMask []string) (*clou
 		UpdatedFields: fieldMask,
 	}
 
-	if err := event.SetData(applicationJSON, ou); err != nil {
+	if err := setData(event, ou); err != nil {
 		return nil, err
 	}
 	return event, nil
@@ -85,7 +85,7 @@ func NewOrderDeleted(order *order.Order) (*cloudevents.Event, error) {
 		},
 	}
 
-	if err := event.SetData(applicationJSON, od); err != nil {
+	if err := setData(event, od); err != nil {
 		return nil, err
 	}
 	return event, nil
@@ -103,7 +103,7 @@ func NewOrderStarted(id string) (*cloudevents.Event, error) {
 		StartTime: time.Now(),
 	}
 
-	if err := event.SetData(applicationJSON, os); err != nil {
+	if err := setData(event, os); err != nil {
 		return nil, err
 	}
 	return event, nil
@@ -121,7 +121,7 @@ func NewOrderReleased(id string) (*cloudevents.Event, error) {
 		ReleaseTime: time.Now(),
 	}
 
-	if err := event.SetData(applicationJSON, os); err != nil {
+	if err := setData(event, os); err != nil {
 		return nil, err
 	}
 	return event, nil
@@ -139,7 +139,7 @@ func NewOrderPrepared(id string) (*cloudevents.Event, error) {
 		PrepareTime: time.Now(),
 	}
 
-	if err := event.SetData(applicationJSON, os); err != nil {
+	if err := setData(event, os); err != nil {
 		return nil, err
 	}
 	return event, nil
@@ -157,7 +157,7 @@ func NewOrderDelivered(id string) (*cloudevents.Event, error) {
 		DeliveryTime: time.Now(),
 	}
 
-	if err := event.SetData(applicationJSON, os); err != nil {
+	if err := setData(event, os); err != nil {
 		return nil, err
 	}
 	return event, nil
@@ -175,7 +175,7 @@ func NewOrderCanceled(id string) (*cloudevents.Event, error) {
 		CancelTime: time.Now(),
 	}
 
-	if err := event.SetData(applicationJSON, os); err != nil {
+	if err := setData(event, os); err != nil {
 		return nil, err
 	}
 	return event, nil
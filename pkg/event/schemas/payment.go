@@ -47,7 +47,7 @@ func NewPaymentCreatedFromSquare(squarePaymentCreatedEvent *webhooks.PaymentCrea
 		Raw: squarePaymentCreatedEvent,
 	}
 
-	if err = event.SetData(applicationJSON, pr); err != nil {
+	if err = setData(event, pr); err != nil {
 		return nil, err
 	}
 	return event, nil
@@ -70,7 +70,7 @@ func NewPaymentUpdatedFromSquare(squarePaymentUpdatedEvent *webhooks.PaymentUpda
 		Raw: squarePaymentUpdatedEvent,
 	}
 
-	if err = event.SetData(applicationJSON, pu); err != nil {
+	if err = setData(event, pu); err != nil {
 		return nil, err
 	}
 	return event, nil
@@ -91,7 +91,7 @@ func NewPaymentCreated(payment *payment.Payment) (*cloudevents.Event, error) {
 		},
 	}
 
-	if err := event.SetData(applicationJSON, pc); err != nil {
+	if err := setData(event, pc); err != nil {
 		return nil, err
 	}
 	return event, nil
@@ -116,7 +116,7 @@ func NewPaymentUpdated(oldPayment, newPayment *payment.Payment, fieldMask []stri
 		UpdatedFields: fieldMask,
 	}
 
-	if err := event.SetData(applicationJSON, pu); err != nil {
+	if err := setData(event, pu); err != nil {
 		return nil, err
 	}
 	return event, nil
@@ -137,7 +137,7 @@ func NewPaymentDeleted(payment *payment.Payment) (*cloudevents.Event, error) {
 		},
 	}
 
-	if err := event.SetData(applicationJSON, pd); err != nil {
+	if err := setData(event, pd); err != nil {
 		return nil, err
 	}
 	return event, nil
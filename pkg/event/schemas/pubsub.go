@@ -1,17 +1,42 @@
 package schemas
 
+import (
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+)
+
 // from https://github.com/GoogleCloudPlatform/golang-samples/blob/HEAD/functions/functionsv2/hellopubsub/hello_pubsub.go
 
 // MessagePublishedData contains the full Pub/Sub message
 // See the documentation for more details:
 // https://cloud.google.com/eventarc/docs/cloudevents#pubsub
 type MessagePublishedData struct {
-	Message PubSubMessage
+	Message      PubSubMessage
+	Subscription string `json:"subscription"`
 }
 
 // PubSubMessage is the payload of a Pub/Sub event.
 // See the documentation for more details:
 // https://cloud.google.com/pubsub/docs/reference/rest/v1/PubsubMessage
 type PubSubMessage struct {
-	Data []byte `json:"data"`
+	Data      []byte `json:"data"`
+	MessageID string `json:"messageId"`
+}
+
+// NestedEvent extracts the internal CloudEvent carried within a Pub/Sub message
+//
+// there are two CloudEvents - one for the pubsub message "event", and then the data within; as a message which
+// does not carry a CloudEvent cannot be handled, any error is classified as poison
+func NestedEvent(e event.Event) (*event.Event, error) {
+	var msg MessagePublishedData
+	if err := e.DataAs(&msg); err != nil {
+		return nil, failure.Poison(err)
+	}
+
+	nestedEvent := &event.Event{}
+	if err := nestedEvent.UnmarshalJSON(msg.Message.Data); err != nil {
+		return nil, failure.Poison(err)
+	}
+	return nestedEvent, nil
 }
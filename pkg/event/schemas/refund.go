@@ -47,7 +47,7 @@ func NewRefundCreatedFromSquare(squareRefundCreatedEvent *webhooks.RefundCreated
 		Raw: squareRefundCreatedEvent,
 	}
 
-	if err = event.SetData(applicationJSON, rc); err != nil {
+	if err = setData(event, rc); err != nil {
 		return nil, err
 	}
 	return event, nil
@@ -70,7 +70,7 @@ func NewRefundUpdatedFromSquare(squareRefundUpdatedEvent *webhooks.RefundUpdated
 		Raw: squareRefundUpdatedEvent,
 	}
 
-	if err = event.SetData(applicationJSON, ru); err != nil {
+	if err = setData(event, ru); err != nil {
 		return nil, err
 	}
 	return event, nil
@@ -91,7 +91,7 @@ func NewRefundCreated(refund *refund.Refund) (*cloudevents.Event, error) {
 		},
 	}
 
-	if err := event.SetData(applicationJSON, rc); err != nil {
+	if err := setData(event, rc); err != nil {
 		return nil, err
 	}
 	return event, nil
@@ -116,7 +116,7 @@ func NewRefundUpdated(oldRefund, newRefund *refund.Refund, fieldMask []string) (
 		UpdatedFields: fieldMask,
 	}
 
-	if err := event.SetData(applicationJSON, ru); err != nil {
+	if err := setData(event, ru); err != nil {
 		return nil, err
 	}
 	return event, nil
@@ -137,7 +137,7 @@ func NewRefundDeleted(refund *refund.Refund) (*cloudevents.Event, error) {
 		},
 	}
 
-	if err := event.SetData(applicationJSON, rd); err != nil {
+	if err := setData(event, rd); err != nil {
 		return nil, err
 	}
 	return event, nil
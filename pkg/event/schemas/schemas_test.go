@@ -0,0 +1,174 @@
+package schemas
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/kofc7186/fundraiser-manager/pkg/event/eventtest"
+	"github.com/kofc7186/fundraiser-manager/pkg/square/types/models"
+	"github.com/kofc7186/fundraiser-manager/pkg/square/types/webhooks"
+	"github.com/kofc7186/fundraiser-manager/pkg/square/webhooks/webhookstest"
+	"github.com/kofc7186/fundraiser-manager/pkg/types/payment"
+)
+
+func setTestEnv(t *testing.T) {
+	t.Setenv("K_SERVICE", "schemas-test")
+	t.Setenv("EXPIRATION_TIME", "2024-03-16T00:00:00Z")
+}
+
+func golden(name string) string {
+	return filepath.Join("testdata", name+".golden.json")
+}
+
+// unmarshalWebhook decodes the named webhook fixture into a new T
+func unmarshalWebhook[T any](t *testing.T, name string) *T {
+	t.Helper()
+	v := new(T)
+	if err := json.Unmarshal(webhookstest.Webhook(t, name), v); err != nil {
+		t.Fatalf("unmarshalling %q: %v", name, err)
+	}
+	return v
+}
+
+func TestEventsFromSquareWebhooks(t *testing.T) {
+	setTestEnv(t)
+
+	tests := map[string]func(t *testing.T, name string) (*cloudevents.Event, error){
+		"payment.created.online": func(t *testing.T, name string) (*cloudevents.Event, error) {
+			return NewPaymentCreatedFromSquare(unmarshalWebhook[webhooks.PaymentCreated](t, name))
+		},
+		"payment.created.pos": func(t *testing.T, name string) (*cloudevents.Event, error) {
+			return NewPaymentCreatedFromSquare(unmarshalWebhook[webhooks.PaymentCreated](t, name))
+		},
+		"payment.created.sandbox": func(t *testing.T, name string) (*cloudevents.Event, error) {
+			return NewPaymentCreatedFromSquare(unmarshalWebhook[webhooks.PaymentCreated](t, name))
+		},
+		"payment.updated.online": func(t *testing.T, name string) (*cloudevents.Event, error) {
+			return NewPaymentUpdatedFromSquare(unmarshalWebhook[webhooks.PaymentUpdated](t, name))
+		},
+		"payment.updated.pos": func(t *testing.T, name string) (*cloudevents.Event, error) {
+			return NewPaymentUpdatedFromSquare(unmarshalWebhook[webhooks.PaymentUpdated](t, name))
+		},
+		"refund.created": func(t *testing.T, name string) (*cloudevents.Event, error) {
+			return NewRefundCreatedFromSquare(unmarshalWebhook[webhooks.RefundCreated](t, name))
+		},
+		"refund.created.unlinked": func(t *testing.T, name string) (*cloudevents.Event, error) {
+			return NewRefundCreatedFromSquare(unmarshalWebhook[webhooks.RefundCreated](t, name))
+		},
+		"refund.updated": func(t *testing.T, name string) (*cloudevents.Event, error) {
+			return NewRefundUpdatedFromSquare(unmarshalWebhook[webhooks.RefundUpdated](t, name))
+		},
+		"customer.created": func(t *testing.T, name string) (*cloudevents.Event, error) {
+			return NewCustomerCreatedFromSquare(unmarshalWebhook[webhooks.CustomerCreated](t, name))
+		},
+		"customer.updated": func(t *testing.T, name string) (*cloudevents.Event, error) {
+			return NewCustomerUpdatedFromSquare(unmarshalWebhook[webhooks.CustomerUpdated](t, name))
+		},
+	}
+
+	for name, newEvent := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := newEvent(t, name)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			eventtest.AssertGoldenEvent(t, golden(name), e)
+		})
+	}
+}
+
+func TestEventsFromSquareAPIResponses(t *testing.T) {
+	setTestEnv(t)
+	const requestSource = "payment-controller"
+
+	tests := map[string]func(t *testing.T, name string) (*cloudevents.Event, error){
+		"get_payment": func(t *testing.T, name string) (*cloudevents.Event, error) {
+			var response models.GetPaymentResponse
+			webhookstest.UnmarshalAPIResponse(t, name, &response)
+			return NewSquareGetPaymentResponse(requestSource, response)
+		},
+		"retrieve_order.online": func(t *testing.T, name string) (*cloudevents.Event, error) {
+			var response models.RetrieveOrderResponse
+			webhookstest.UnmarshalAPIResponse(t, name, &response)
+			return NewSquareRetrieveOrderResponse(requestSource, response)
+		},
+		"retrieve_order.pos": func(t *testing.T, name string) (*cloudevents.Event, error) {
+			var response models.RetrieveOrderResponse
+			webhookstest.UnmarshalAPIResponse(t, name, &response)
+			return NewSquareRetrieveOrderResponse(requestSource, response)
+		},
+		"retrieve_customer": func(t *testing.T, name string) (*cloudevents.Event, error) {
+			var response models.RetrieveCustomerResponse
+			webhookstest.UnmarshalAPIResponse(t, name, &response)
+			return NewSquareRetrieveCustomerResponse(requestSource, response)
+		},
+	}
+
+	for name, newEvent := range tests {
+		t.Run(name, func(t *testing.T) {
+			e, err := newEvent(t, name)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			eventtest.AssertGoldenEvent(t, golden(name), e)
+		})
+	}
+}
+
+func TestSquareRequestEvents(t *testing.T) {
+	setTestEnv(t)
+
+	begin := time.Date(2024, time.March, 8, 21, 0, 0, 0, time.UTC)
+	end := time.Date(2024, time.March, 9, 3, 0, 0, 0, time.UTC)
+
+	tests := map[string]*cloudevents.Event{
+		"get_payment.request":       NewSquareGetPaymentRequest("bP9mAsEMYPUGjjGNaNO5ZDVyLhSZY"),
+		"list_payments.request":     NewSquareListPaymentsRequest(begin, end),
+		"retrieve_order.request":    NewSquareRetrieveOrderRequest("CAISENgvlJ6jLWAzERDzjyHVybY"),
+		"retrieve_customer.request": NewSquareRetrieveCustomerRequest("JDKYHBWT1D4F8MFH63DBMEN8Y4"),
+	}
+
+	for name, e := range tests {
+		t.Run(name, func(t *testing.T) {
+			eventtest.AssertGoldenEvent(t, golden(name), e)
+		})
+	}
+}
+
+func TestNewPaymentUpdated(t *testing.T) {
+	setTestEnv(t)
+
+	created := unmarshalWebhook[webhooks.PaymentCreated](t, "payment.created.online")
+	updated := unmarshalWebhook[webhooks.PaymentUpdated](t, "payment.updated.online")
+
+	oldPayment, err := payment.CreateInternalPaymentFromSquarePayment(created.Data.Object.Payment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPayment, err := payment.CreateInternalPaymentFromSquarePayment(updated.Data.Object.Payment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewPaymentUpdated(oldPayment, newPayment, []string{"feeAmount", "status"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Type() != PaymentUpdatedType || e.Subject() != newPayment.ID {
+		t.Errorf("got type %q subject %q", e.Type(), e.Subject())
+	}
+
+	pu := &PaymentUpdated{}
+	if err := e.DataAs(pu); err != nil {
+		t.Fatal(err)
+	}
+	if pu.OldPayment.Status != payment.PAYMENT_STATUS_APPROVED || pu.Payment.Status != payment.PAYMENT_STATUS_COMPLETED {
+		t.Errorf("got statuses %q -> %q", pu.OldPayment.Status, pu.Payment.Status)
+	}
+	if len(pu.UpdatedFields) != 2 || pu.IdempotencyKey == "" {
+		t.Errorf("unexpected payload: %+v", pu)
+	}
+}
@@ -51,7 +51,7 @@ func NewSquareGetPaymentResponse(source string, response models.GetPaymentRespon
 		Raw:           response,
 	}
 
-	_ = event.SetData(applicationJSON, sgpc)
+	_ = setData(event, sgpc)
 	return event, nil
 }
 
@@ -67,7 +67,7 @@ func NewSquareListPaymentsRequest(beginTime, endTime time.Time) *cloudevents.Eve
 		BeginTime: beginTime,
 		EndTime:   endTime,
 	}
-	_ = event.SetData(applicationJSON, slpr)
+	_ = setData(event, slpr)
 	return event
 }
 
@@ -102,7 +102,7 @@ func NewSquareRetrieveOrderResponse(source string, response models.RetrieveOrder
 		Raw:           response,
 	}
 
-	_ = event.SetData(applicationJSON, sgoc)
+	_ = setData(event, sgoc)
 	return event, nil
 }
 
@@ -137,6 +137,6 @@ func NewSquareRetrieveCustomerResponse(source string, response models.RetrieveCu
 		Raw:           response,
 	}
 
-	_ = event.SetData(applicationJSON, sgcc)
+	_ = setData(event, sgcc)
 	return event, nil
 }
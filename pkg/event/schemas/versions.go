@@ -0,0 +1,221 @@
+package schemas
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/types"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/event/jsonschema"
+)
+
+//go:generate go test . -run TestDefinitions -update
+
+// SCHEMA_VERSION_EXTENSION is the CloudEvent extension carrying the version of the schema an event's data conforms
+// to; events published before schemas were versioned lack it, and are treated as version 1
+const SCHEMA_VERSION_EXTENSION = "schemaversion"
+
+// DATA_SCHEMA_BASE_URI is where the definitions below are published, and so the base of each event's dataschema
+const DATA_SCHEMA_BASE_URI = "https://raw.githubusercontent.com/kofc7186/fundraiser-manager/main/pkg/event/schemas/definitions/"
+
+const eventTypePrefix = "org.kofc7186.fundraiserManager."
+
+// definitions holds the JSON Schema of every version of every event type; those of current versions are generated
+// from the Go types by TestDefinitions, while those of earlier versions are kept as they were
+//
+//go:embed definitions/*.json
+var definitions embed.FS
+
+var parsedDefinitions sync.Map
+
+// ErrUnknownVersion is returned for events published with a schema version this build does not know of
+var ErrUnknownVersion = errors.New("unknown schema version")
+
+// Upcaster rewrites the data of an event from the version before the one it is registered for into that version
+type Upcaster func(data map[string]interface{}) error
+
+type registration struct {
+	version int
+	// newData returns the Go type of the current version, or is nil if events of the type carry no data
+	newData func() interface{}
+	// upcasters is keyed by the version each produces
+	upcasters map[int]Upcaster
+}
+
+func dataOf[T any]() func() interface{} {
+	return func() interface{} { return new(T) }
+}
+
+// a new version is needed whenever a field of an event is renamed or removed or changes type; bump the version,
+// register an Upcaster producing it and run go generate to write its definition
+var registry = map[string]registration{
+	CustomerCreatedFromSquareType: {version: 1, newData: dataOf[CustomerCreatedFromSquare]()},
+	CustomerUpdatedFromSquareType: {version: 1, newData: dataOf[CustomerUpdatedFromSquare]()},
+	CustomerCreatedType:           {version: 1, newData: dataOf[CustomerCreated]()},
+	CustomerUpdatedType:           {version: 1, newData: dataOf[CustomerUpdated]()},
+	CustomerDeletedType:           {version: 1, newData: dataOf[CustomerDeleted]()},
+
+	OrderCreatedType:   {version: 1, newData: dataOf[OrderCreated]()},
+	OrderUpdatedType:   {version: 1, newData: dataOf[OrderUpdated]()},
+	OrderDeletedType:   {version: 1, newData: dataOf[OrderDeleted]()},
+	OrderStartedType:   {version: 1, newData: dataOf[OrderStarted]()},
+	OrderReleasedType:  {version: 1, newData: dataOf[OrderReleased]()},
+	OrderPreparedType:  {version: 1, newData: dataOf[OrderPrepared]()},
+	OrderDeliveredType: {version: 1, newData: dataOf[OrderDelivered]()},
+	OrderCanceledType:  {version: 1, newData: dataOf[OrderCanceled]()},
+
+	PaymentCreatedFromSquareType: {version: 1, newData: dataOf[PaymentReceivedFromSquare]()},
+	PaymentUpdatedFromSquareType: {version: 1, newData: dataOf[PaymentUpdatedFromSquare]()},
+	PaymentCreatedType:           {version: 1, newData: dataOf[PaymentCreated]()},
+	PaymentUpdatedType:           {version: 1, newData: dataOf[PaymentUpdated]()},
+	PaymentDeletedType:           {version: 1, newData: dataOf[PaymentDeleted]()},
+
+	RefundCreatedFromSquareType: {version: 1, newData: dataOf[RefundCreatedFromSquare]()},
+	RefundUpdatedFromSquareType: {version: 1, newData: dataOf[RefundUpdatedFromSquare]()},
+	RefundCreatedType:           {version: 1, newData: dataOf[RefundCreated]()},
+	RefundUpdatedType:           {version: 1, newData: dataOf[RefundUpdated]()},
+	RefundDeletedType:           {version: 1, newData: dataOf[RefundDeleted]()},
+
+	SquareGetPaymentRequestType:        {version: 1},
+	SquareGetPaymentResponseType:       {version: 1, newData: dataOf[SquareGetPaymentResponse]()},
+	SquareListPaymentsRequestType:      {version: 1, newData: dataOf[SquareListPaymentsRequest]()},
+	SquareRetrieveOrderRequestType:     {version: 1},
+	SquareRetrieveOrderResponseType:    {version: 1, newData: dataOf[SquareRetrieveOrderResponse]()},
+	SquareRetrieveCustomerRequestType:  {version: 1},
+	SquareRetrieveCustomerResponseType: {version: 1, newData: dataOf[SquareRetrieveCustomerResponse]()},
+}
+
+// CurrentVersion returns the version of the schema which events of the type are published with, or 0 if the type
+// is not one of ours
+func CurrentVersion(eventType string) int {
+	return registry[eventType].version
+}
+
+// SchemaVersion returns the version of the schema the event was published with
+func SchemaVersion(e *cloudevents.Event) (int, error) {
+	value, ok := e.Extensions()[SCHEMA_VERSION_EXTENSION]
+	if !ok {
+		return 1, nil
+	}
+	version, err := types.ToInteger(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", SCHEMA_VERSION_EXTENSION, err)
+	}
+	return int(version), nil
+}
+
+// DataSchemaURI identifies the definition of a version of the event type
+func DataSchemaURI(eventType string, version int) string {
+	return DATA_SCHEMA_BASE_URI + definitionName(eventType, version)
+}
+
+func definitionName(eventType string, version int) string {
+	return fmt.Sprintf("%s.v%d.json", strings.TrimPrefix(eventType, eventTypePrefix), version)
+}
+
+// Definition returns the JSON Schema of a version of the event type
+func Definition(eventType string, version int) (*jsonschema.Schema, error) {
+	name := definitionName(eventType, version)
+	if s, ok := parsedDefinitions.Load(name); ok {
+		return s.(*jsonschema.Schema), nil
+	}
+
+	data, err := definitions.ReadFile("definitions/" + name)
+	if err != nil {
+		return nil, fmt.Errorf("%s version %d: %w", eventType, version, ErrUnknownVersion)
+	}
+	s, err := jsonschema.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	parsedDefinitions.Store(name, s)
+	return s, nil
+}
+
+// Validate checks the data of one of our events against the schema of the version it was published with; events
+// of other types, and those which carry no data, are not checked
+func Validate(e *cloudevents.Event) error {
+	r, ok := registry[e.Type()]
+	if !ok || r.newData == nil {
+		return nil
+	}
+
+	version, err := SchemaVersion(e)
+	if err != nil {
+		return err
+	}
+	s, err := Definition(e.Type(), version)
+	if err != nil {
+		return err
+	}
+	if err := s.ValidateJSON(e.Data()); err != nil {
+		return fmt.Errorf("%s %s version %d: %w", e.Type(), e.ID(), version, err)
+	}
+	return nil
+}
+
+// Upcast rewrites the data of an event published with an earlier version of its schema into the current version
+func Upcast(e *cloudevents.Event) error {
+	r, ok := registry[e.Type()]
+	if !ok {
+		return nil
+	}
+	version, err := SchemaVersion(e)
+	if err != nil {
+		return err
+	}
+	if version > r.version {
+		return fmt.Errorf("%s version %d is newer than %d: %w", e.Type(), version, r.version, ErrUnknownVersion)
+	}
+	if version == r.version {
+		return nil
+	}
+
+	if r.newData != nil {
+		data := make(map[string]interface{})
+		if err := json.Unmarshal(e.Data(), &data); err != nil {
+			return err
+		}
+		for next := version + 1; next <= r.version; next++ {
+			upcaster, ok := r.upcasters[next]
+			if !ok {
+				return fmt.Errorf("no upcaster from %s version %d to %d", e.Type(), next-1, next)
+			}
+			if err := upcaster(data); err != nil {
+				return fmt.Errorf("upcasting %s to version %d: %w", e.Type(), next, err)
+			}
+		}
+		if err := setData(e, data); err != nil {
+			return err
+		}
+	}
+	e.SetExtension(SCHEMA_VERSION_EXTENSION, r.version)
+	return nil
+}
+
+// DataAs decodes the data of one of our events into v, which must be the Go type of the current version of its
+// schema, after validating it against the version it was published with and upcasting it; as an event which
+// fails either step never will succeed, the caller should treat any error as poison
+func DataAs(e *cloudevents.Event, v interface{}) error {
+	upcast := e.Clone()
+	if err := Validate(&upcast); err != nil {
+		return err
+	}
+	if err := Upcast(&upcast); err != nil {
+		return err
+	}
+	return upcast.DataAs(v)
+}
+
+// setData sets the data of a new event, along with the dataschema of its current version
+func setData(event *cloudevents.Event, data interface{}) error {
+	if r, ok := registry[event.Type()]; ok {
+		event.SetDataSchema(DataSchemaURI(event.Type(), r.version))
+	}
+	return event.SetData(applicationJSON, data)
+}
@@ -0,0 +1,181 @@
+package schemas
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/event/eventtest"
+	"github.com/kofc7186/fundraiser-manager/pkg/event/jsonschema"
+)
+
+// TestDefinitions checks the definition of the current version of each event type against its Go type; run with
+// -update (or go generate) to write the definition of a new version
+func TestDefinitions(t *testing.T) {
+	for eventType, r := range registry {
+		if r.newData == nil {
+			continue
+		}
+		eventType, r := eventType, r
+		t.Run(definitionName(eventType, r.version), func(t *testing.T) {
+			s := jsonschema.Generate(r.newData())
+			s.ID = DataSchemaURI(eventType, r.version)
+			s.Title = eventType
+			eventtest.AssertGoldenJSON(t, filepath.Join("definitions", definitionName(eventType, r.version)), s)
+		})
+	}
+}
+
+// TestDefinitionsParse checks every embedded definition, including those of earlier versions
+func TestDefinitionsParse(t *testing.T) {
+	entries, err := definitions.ReadDir("definitions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		data, err := definitions.ReadFile("definitions/" + entry.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := jsonschema.Parse(data)
+		if err != nil {
+			t.Errorf("%s: %v", entry.Name(), err)
+			continue
+		}
+		if !strings.HasSuffix(s.ID, "/"+entry.Name()) {
+			t.Errorf("%s has $id %s", entry.Name(), s.ID)
+		}
+	}
+}
+
+// TestGoldenEventsValidate checks that the events produced by the constructors conform to their definitions
+func TestGoldenEventsValidate(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.golden.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := cloudevents.NewEvent()
+		if err := e.UnmarshalJSON(data); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if CurrentVersion(e.Type()) == 0 {
+			t.Errorf("%s: %s is not registered", path, e.Type())
+		}
+		if err := Validate(&e); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+}
+
+func TestValidateRejectsWrongTypes(t *testing.T) {
+	setTestEnv(t)
+
+	e := newEvent(OrderStartedType)
+	if err := setData(e, map[string]interface{}{"startTime": "yesterday"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Validate(e)
+	var validationError *jsonschema.ValidationError
+	if !errors.As(err, &validationError) || validationError.Violations[0].Path != "/startTime" {
+		t.Errorf("err = %v, want a violation at /startTime", err)
+	}
+	if err := DataAs(e, &OrderStarted{}); err == nil {
+		t.Error("DataAs decoded an invalid event")
+	}
+}
+
+func TestUnknownVersion(t *testing.T) {
+	setTestEnv(t)
+
+	e := newEvent(OrderStartedType)
+	e.SetExtension(SCHEMA_VERSION_EXTENSION, CurrentVersion(OrderStartedType)+1)
+	if err := setData(e, &OrderStarted{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := DataAs(e, &OrderStarted{}); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("err = %v, want ErrUnknownVersion", err)
+	}
+}
+
+func TestEventsPredatingVersioning(t *testing.T) {
+	setTestEnv(t)
+
+	e, err := NewOrderStarted("CAISENgvlJ6jLWAzERDzjyHVybY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, err := SchemaVersion(e); err != nil || version != CurrentVersion(OrderStartedType) {
+		t.Errorf("SchemaVersion = %d, %v", version, err)
+	}
+	if e.DataSchema() != DataSchemaURI(OrderStartedType, CurrentVersion(OrderStartedType)) {
+		t.Errorf("dataschema = %s", e.DataSchema())
+	}
+
+	delete(e.Extensions(), SCHEMA_VERSION_EXTENSION)
+	if version, err := SchemaVersion(e); err != nil || version != 1 {
+		t.Errorf("SchemaVersion without the extension = %d, %v, want 1", version, err)
+	}
+}
+
+func TestUnversionedSquareResponse(t *testing.T) {
+	// a getPayment response as published before schemas were versioned is of the current version
+	data, err := os.ReadFile(filepath.Join("testdata", "get_payment.v1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := cloudevents.NewEvent()
+	if err := e.UnmarshalJSON(data); err != nil {
+		t.Fatal(err)
+	}
+
+	sgpr := &SquareGetPaymentResponse{}
+	if err := DataAs(&e, sgpr); err != nil {
+		t.Fatal(err)
+	}
+	if sgpr.RequestSource != "payment-controller" || sgpr.Raw.Payment == nil || sgpr.Payment.ID != sgpr.Raw.Payment.Id {
+		t.Errorf("unexpected data %+v", sgpr)
+	}
+}
+
+func TestUpcast(t *testing.T) {
+	const eventType = "org.kofc7186.fundraiserManager.test.upcast"
+	registry[eventType] = registration{
+		version: 3,
+		newData: dataOf[map[string]interface{}](),
+		upcasters: map[int]Upcaster{
+			2: func(data map[string]interface{}) error { data["name"] = data["Name"]; delete(data, "Name"); return nil },
+			3: func(data map[string]interface{}) error { data["count"] = 1.0; return nil },
+		},
+	}
+	t.Cleanup(func() { delete(registry, eventType) })
+
+	e := cloudevents.NewEvent()
+	e.SetType(eventType)
+	e.SetExtension(SCHEMA_VERSION_EXTENSION, 1)
+	if err := e.SetData(applicationJSON, map[string]interface{}{"Name": "Jane"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Upcast(&e); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := SchemaVersion(&e); version != 3 || e.DataSchema() != DataSchemaURI(eventType, 3) {
+		t.Errorf("upcast event has version %d and dataschema %s", version, e.DataSchema())
+	}
+	var fields map[string]interface{}
+	if err := e.DataAs(&fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["name"] != "Jane" || fields["Name"] != nil || fields["count"] != 1.0 {
+		t.Errorf("data was not upcast through each version: %v", fields)
+	}
+}
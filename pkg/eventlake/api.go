@@ -0,0 +1,122 @@
+package eventlake
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/httpjson"
+)
+
+// Reader queries an event lake; it is implemented both by Lake, reading Firestore directly, and by Client,
+// reading through the HTTP API served by Handler
+type Reader interface {
+	List(ctx context.Context, q Query) (*Page, error)
+	Timeline(ctx context.Context, subjects []string) (*Timeline, error)
+}
+
+var _ Reader = (*Lake)(nil)
+var _ Reader = (*Client)(nil)
+
+// Handler serves the read API over an event lake:
+//
+//	GET /events?type=&typePrefix=&subject=&source=&since=&until=&after=&limit=    a Page of events (see Query.Values)
+//	GET /timeline?subject=<id>[&subject=<id>...]                                  the Timeline of the subjects
+func Handler(reader Reader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if r.Method != http.MethodGet {
+			httpjson.Error(ctx, w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+			return
+		}
+
+		switch strings.Trim(r.URL.Path, "/") {
+		case "events":
+			q, err := ParseQuery(r.URL.Query())
+			if err != nil {
+				httpjson.Error(ctx, w, http.StatusBadRequest, err)
+				return
+			}
+			page, err := reader.List(ctx, q)
+			if err != nil {
+				httpjson.Error(ctx, w, http.StatusInternalServerError, err)
+				return
+			}
+			httpjson.Write(ctx, w, http.StatusOK, page)
+		case "timeline":
+			subjects := r.URL.Query()["subject"]
+			if len(subjects) == 0 {
+				httpjson.Error(ctx, w, http.StatusBadRequest, fmt.Errorf("at least one subject is required"))
+				return
+			}
+			t, err := reader.Timeline(ctx, subjects)
+			if err != nil {
+				httpjson.Error(ctx, w, http.StatusInternalServerError, err)
+				return
+			}
+			httpjson.Write(ctx, w, http.StatusOK, t)
+		default:
+			httpjson.Error(ctx, w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+		}
+	}
+}
+
+// Client reads an event lake through the HTTP API served by Handler
+type Client struct {
+	// BaseURL is the URL of the EventLakeQuery function
+	BaseURL string
+	// HTTPClient makes the requests, and must authenticate them to the function, e.g. the client returned by
+	// google.golang.org/api/idtoken.NewClient(ctx, BaseURL); if nil, http.DefaultClient is used
+	HTTPClient *http.Client
+}
+
+// List returns a page of the events matching q
+func (c *Client) List(ctx context.Context, q Query) (*Page, error) {
+	page := &Page{}
+	if err := c.get(ctx, "events", q.Values(), page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// Timeline returns the events about the given subjects along with the changes they recorded
+func (c *Client) Timeline(ctx context.Context, subjects []string) (*Timeline, error) {
+	t := &Timeline{}
+	if err := c.get(ctx, "timeline", url.Values{"subject": subjects}, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, values url.Values, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.BaseURL, "/")+"/"+path+"?"+values.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiError struct {
+			Error string `json:"error"`
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(body, &apiError) != nil || apiError.Error == "" {
+			apiError.Error = string(body)
+		}
+		return fmt.Errorf("event lake responded with HTTP status %d: %s", resp.StatusCode, apiError.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
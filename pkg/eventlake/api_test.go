@@ -0,0 +1,168 @@
+package eventlake
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// fakeReader records the query it was asked and returns canned results
+type fakeReader struct {
+	query    Query
+	subjects []string
+	events   []*event.Event
+}
+
+func (f *fakeReader) List(ctx context.Context, q Query) (*Page, error) {
+	f.query = q
+	return &Page{Events: f.events, Next: f.events[len(f.events)-1].ID()}, nil
+}
+
+func (f *fakeReader) Timeline(ctx context.Context, subjects []string) (*Timeline, error) {
+	f.subjects = subjects
+	return timeline(f.events)
+}
+
+func newTestEvent(t *testing.T, id, eventType string, data interface{}) *event.Event {
+	t.Helper()
+	e := event.New()
+	e.SetID(id)
+	e.SetSource("payment-controller-fishfry-030824-cdc")
+	e.SetSubject("KkAkhdMsgzn59SM8A89WgKwekxLZY")
+	e.SetType(eventType)
+	if err := e.SetData("application/json", data); err != nil {
+		t.Fatal(err)
+	}
+	return &e
+}
+
+func TestClientList(t *testing.T) {
+	reader := &fakeReader{events: []*event.Event{
+		newTestEvent(t, "018e1f8a-6a30-7000-8000-000000000001", "org.kofc7186.fundraiserManager.payment.created", map[string]interface{}{}),
+	}}
+	server := httptest.NewServer(Handler(reader))
+	defer server.Close()
+
+	q := Query{
+		Types:      []string{"org.kofc7186.fundraiserManager.payment.created"},
+		TypePrefix: "org.kofc7186.fundraiserManager.",
+		Subjects:   []string{"KkAkhdMsgzn59SM8A89WgKwekxLZY", "R2B3Z8WMVt3EAmzYWLZvz7Y69EbZY"},
+		Source:     "payment-controller-fishfry-030824-cdc",
+		Since:      time.Date(2024, 3, 8, 16, 0, 0, 0, time.UTC),
+		Until:      time.Date(2024, 3, 8, 21, 30, 0, 0, time.UTC),
+		After:      "018e1f8a-6a30-7000-8000-000000000000",
+		Limit:      10,
+	}
+	page, err := (&Client{BaseURL: server.URL}).List(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(reader.query, q) {
+		t.Errorf("handler received query %+v, want %+v", reader.query, q)
+	}
+	if len(page.Events) != 1 || page.Events[0].ID() != reader.events[0].ID() || page.Next != reader.events[0].ID() {
+		t.Errorf("unexpected page %+v", page)
+	}
+}
+
+func TestClientTimeline(t *testing.T) {
+	reader := &fakeReader{events: []*event.Event{
+		newTestEvent(t, "018e1f8a-6a30-7000-8000-000000000001", "org.kofc7186.fundraiserManager.square.payment.updated", map[string]interface{}{
+			"payment": map[string]interface{}{"tipAmount": 3},
+		}),
+		newTestEvent(t, "018e1f8a-6a30-7000-8000-000000000002", "org.kofc7186.fundraiserManager.payment.updated", map[string]interface{}{
+			"oldPayment": map[string]interface{}{"tipAmount": 0, "status": "COMPLETED", "idempotencyKeys": map[string]interface{}{"a": true}},
+			"payment":    map[string]interface{}{"tipAmount": 3, "status": "COMPLETED", "idempotencyKeys": map[string]interface{}{"a": true, "b": true}},
+		}),
+	}}
+	server := httptest.NewServer(Handler(reader))
+	defer server.Close()
+
+	got, err := (&Client{BaseURL: server.URL}).Timeline(context.Background(), []string{"KkAkhdMsgzn59SM8A89WgKwekxLZY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(reader.subjects, []string{"KkAkhdMsgzn59SM8A89WgKwekxLZY"}) {
+		t.Errorf("handler received subjects %v", reader.subjects)
+	}
+	entries := got.Entries
+	if got.Truncated {
+		t.Error("timeline of 2 events was truncated")
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if len(entries[0].Changes) != 0 {
+		t.Errorf("Square events do not change documents themselves, got %+v", entries[0].Changes)
+	}
+	// JSON numbers decode as float64
+	want := []Change{{Field: "tipAmount", Old: float64(0), New: float64(3)}}
+	if !reflect.DeepEqual(entries[1].Changes, want) {
+		t.Errorf("changes = %+v, want %+v", entries[1].Changes, want)
+	}
+}
+
+func TestTimelineTruncated(t *testing.T) {
+	events := make([]*event.Event, MaxTimelineEvents+1)
+	for i := range events {
+		events[i] = newTestEvent(t, fmt.Sprintf("018e1f8a-6a30-7000-8000-%012d", i), "org.kofc7186.fundraiserManager.square.payment.updated", map[string]interface{}{})
+	}
+
+	got, err := timeline(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Truncated || len(got.Entries) != MaxTimelineEvents {
+		t.Errorf("got %d entries (truncated %v), want the first %d and truncated", len(got.Entries), got.Truncated, MaxTimelineEvents)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	server := httptest.NewServer(Handler(&fakeReader{}))
+	defer server.Close()
+
+	_, err := (&Client{BaseURL: server.URL}).Timeline(context.Background(), nil)
+	if err == nil || !strings.Contains(err.Error(), "at least one subject is required") {
+		t.Errorf("err = %v, want the handler's error message", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	changes := diff(
+		map[string]interface{}{"status": "ONLINE", "customer": map[string]interface{}{"firstName": "Ada", "lastName": "King"}, "traceparent": "00-a"},
+		map[string]interface{}{"status": "LABELED", "customer": map[string]interface{}{"firstName": "Ada"}, "orderNumber": 1042, "traceparent": "00-b"},
+	)
+	want := []Change{
+		{Field: "customer.lastName", Old: "King"},
+		{Field: "orderNumber", New: 1042},
+		{Field: "status", Old: "ONLINE", New: "LABELED"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("diff = %+v, want %+v", changes, want)
+	}
+}
+
+func TestDocumentChangesDeleted(t *testing.T) {
+	e := newTestEvent(t, "018e1f8a-6a30-7000-8000-000000000003", "org.kofc7186.fundraiserManager.refund.deleted", map[string]interface{}{
+		"refund": map[string]interface{}{"refundAmount": 12.5},
+	})
+	changes, err := documentChanges(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Change{{Field: "refundAmount", Old: 12.5}}; !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %+v, want %+v", changes, want)
+	}
+
+	if _, err := documentChanges(newTestEvent(t, "018e1f8a-6a30-7000-8000-000000000004", "org.kofc7186.fundraiserManager.order.updated", "not an object")); err == nil {
+		t.Error("expected an error decoding malformed data")
+	}
+}
@@ -0,0 +1,118 @@
+// Package eventlake reads back the events recorded by the event-lake-controller, which stores every CloudEvent
+// published within a fundraiser at fundraisers/<id>/events/<event id>
+//
+// Each document is the JSON form of its event, so the subject, type and source attributes are top-level fields,
+// which Firestore indexes like any other; queries on them are filtered by Firestore rather than in memory
+package eventlake
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"google.golang.org/api/iterator"
+)
+
+// Lake is the event lake of a single fundraiser
+type Lake struct {
+	client         *firestore.Client
+	collectionPath string
+	fundraiserID   string
+}
+
+// New returns the event lake of the fundraiser
+func New(client *firestore.Client, fundraiserID string) *Lake {
+	return &Lake{
+		client:         client,
+		collectionPath: CollectionPath(fundraiserID),
+		fundraiserID:   fundraiserID,
+	}
+}
+
+// CollectionPath is the Firestore collection holding the event lake of the fundraiser
+func CollectionPath(fundraiserID string) string {
+	return fmt.Sprintf("fundraisers/%s/events", fundraiserID)
+}
+
+// maxInValues is the most values Firestore accepts in an "in" filter
+const maxInValues = 30
+
+// Stream calls fn with each event matching q, in ID (and so chronological) order, stopping at the first error;
+// q.Limit is ignored
+func (l *Lake) Stream(ctx context.Context, q Query, fn func(context.Context, *event.Event) error) error {
+	// the equality criteria are filtered in Firestore, which merges the single-field indexes of the fields they filter
+	// rather than requiring a composite index; the type prefix, and the subjects or types beyond what a single "in"
+	// filter allows, are filtered in memory
+	firestoreQuery := l.client.Collection(l.collectionPath).OrderBy(firestore.DocumentID, firestore.Asc)
+	inUsed := false
+	for _, criterion := range []struct {
+		field  string
+		values []string
+	}{{"subject", q.Subjects}, {"type", q.Types}} {
+		switch {
+		case len(criterion.values) == 1:
+			firestoreQuery = firestoreQuery.Where(criterion.field, "==", criterion.values[0])
+		case len(criterion.values) > 1 && len(criterion.values) <= maxInValues && !inUsed:
+			firestoreQuery = firestoreQuery.Where(criterion.field, "in", criterion.values)
+			inUsed = true
+		}
+	}
+	if q.Source != "" {
+		firestoreQuery = firestoreQuery.Where("source", "==", q.Source)
+	}
+	switch {
+	case q.After != "" && (q.Since.IsZero() || q.After >= idBound(q.Since)):
+		firestoreQuery = firestoreQuery.StartAfter(q.After)
+	case !q.Since.IsZero():
+		firestoreQuery = firestoreQuery.StartAt(idBound(q.Since))
+	}
+	if !q.Until.IsZero() {
+		firestoreQuery = firestoreQuery.EndBefore(idBound(q.Until))
+	}
+
+	docs := firestoreQuery.Documents(ctx)
+	defer docs.Stop()
+	for {
+		docSnap, err := docs.Next()
+		if err == iterator.Done {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		e, err := decode(docSnap.Data())
+		if err != nil {
+			return fmt.Errorf("decoding event %s: %w", docSnap.Ref.ID, err)
+		}
+		if !q.Matches(e) {
+			continue
+		}
+		if err := fn(ctx, e); err != nil {
+			return err
+		}
+	}
+}
+
+// decode rebuilds a CloudEvent from the JSON representation stored by the event-lake-controller
+func decode(eventMap map[string]interface{}) (*event.Event, error) {
+	eventJSON, err := json.Marshal(eventMap)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &event.Event{}
+	if err := e.UnmarshalJSON(eventJSON); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// idBound returns the smallest UUIDv7 which could be generated at t; as the timestamp makes up the leading
+// 48 bits of a UUIDv7, comparing these strings orders events by the millisecond in which they were created
+func idBound(t time.Time) string {
+	ms := uint64(t.UnixMilli())
+	return fmt.Sprintf("%08x-%04x-7000-8000-000000000000", ms>>16, ms&0xffff)
+}
@@ -0,0 +1,99 @@
+package eventlake
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/google/uuid"
+)
+
+func TestIDBoundOrdersUUIDv7(t *testing.T) {
+	before := time.Now()
+	id := uuid.Must(uuid.NewV7()).String()
+	after := time.Now().Add(time.Millisecond)
+
+	if lower := idBound(before); id < lower {
+		t.Errorf("id %s sorts before lower bound %s", id, lower)
+	}
+	if upper := idBound(after); id >= upper {
+		t.Errorf("id %s does not sort before upper bound %s", id, upper)
+	}
+}
+
+func TestIDBoundIsValidUUIDv7(t *testing.T) {
+	bound := time.Date(2024, 3, 8, 21, 0, 0, 0, time.UTC)
+	id, err := uuid.Parse(idBound(bound))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Version() != 7 {
+		t.Errorf("version = %d, want 7", id.Version())
+	}
+	if sec, nsec := id.Time().UnixTime(); !time.Unix(sec, nsec).Equal(bound) {
+		t.Errorf("time = %v, want %v", time.Unix(sec, nsec).UTC(), bound)
+	}
+}
+
+func TestQueryMatches(t *testing.T) {
+	e := event.New()
+	e.SetType("org.kofc7186.fundraiserManager.square.payment.updated")
+	e.SetSubject("KkAkhdMsgzn59SM8A89WgKwekxLZY")
+	e.SetSource("egress-square-gateway-fishfry-030824")
+
+	tests := []struct {
+		name  string
+		query Query
+		want  bool
+	}{
+		{"empty", Query{}, true},
+		{"type", Query{Types: []string{"org.kofc7186.fundraiserManager.square.payment.created", e.Type()}}, true},
+		{"other type", Query{Types: []string{"org.kofc7186.fundraiserManager.square.payment.created"}}, false},
+		{"type prefix", Query{TypePrefix: "org.kofc7186.fundraiserManager.square."}, true},
+		{"other type prefix", Query{TypePrefix: "org.kofc7186.fundraiserManager.payment."}, false},
+		{"subject", Query{Subjects: []string{"R2B3Z8WMVt3EAmzYWLZvz7Y69EbZY", e.Subject()}}, true},
+		{"other subject", Query{Subjects: []string{"R2B3Z8WMVt3EAmzYWLZvz7Y69EbZY"}}, false},
+		{"source", Query{Source: e.Source()}, true},
+		{"other source", Query{Source: "square-webhook-ingress-fishfry-030824"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.query.Matches(&e); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	e := event.New()
+	e.SetID(uuid.Must(uuid.NewV7()).String())
+	e.SetSource("payment-controller-fishfry-030824-cdc")
+	e.SetType("org.kofc7186.fundraiserManager.payment.created")
+	e.SetExtension("expiration", "2024-03-10T05:00:00Z")
+	if err := e.SetData("application/json", map[string]interface{}{"payment": map[string]interface{}{"tipAmount": 2.5}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the event-lake-controller stores the JSON encoding of the event as a map
+	eventJSON, err := e.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var eventMap map[string]interface{}
+	if err := json.Unmarshal(eventJSON, &eventMap); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := decode(eventMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ID() != e.ID() || decoded.Type() != e.Type() || decoded.Extensions()["expiration"] != "2024-03-10T05:00:00Z" {
+		t.Errorf("decoded event %v does not match %v", decoded, e)
+	}
+	if string(decoded.Data()) != string(e.Data()) {
+		t.Errorf("data = %s, want %s", decoded.Data(), e.Data())
+	}
+}
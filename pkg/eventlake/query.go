@@ -0,0 +1,116 @@
+package eventlake
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// Query selects events from the lake; zero values match every event
+type Query struct {
+	// Types matches events with any of the given types
+	Types []string
+	// TypePrefix matches events whose type starts with the prefix, e.g. "org.kofc7186.fundraiserManager.square."
+	TypePrefix string
+	// Subjects matches events about any of the given entities, e.g. an order ID and the IDs of its payments
+	Subjects []string
+	// Source matches events published by a single function, e.g. "square-webhook-ingress-fishfry-030824"
+	Source string
+	// Since and Until bound the times at which the events were created, as recorded in their UUIDv7 IDs;
+	// Since is inclusive and Until is exclusive
+	Since time.Time
+	Until time.Time
+	// After resumes a query following the event with this ID, as returned in Page.Next
+	After string
+	// Limit bounds the number of events returned by List; zero selects DefaultLimit
+	Limit int
+}
+
+const (
+	// DefaultLimit is the number of events listed when the query does not specify a limit
+	DefaultLimit = 100
+	// MaxLimit is the largest number of events which may be listed at once
+	MaxLimit = 1000
+)
+
+// Matches reports whether e satisfies the type, subject and source criteria of q (the time range is applied by
+// the Firestore query, as it is derived from the document ID); Lake.Stream applies it to what Firestore couldn't filter
+func (q Query) Matches(e *event.Event) bool {
+	if len(q.Types) != 0 && !slices.Contains(q.Types, e.Type()) {
+		return false
+	}
+	if q.TypePrefix != "" && !strings.HasPrefix(e.Type(), q.TypePrefix) {
+		return false
+	}
+	if len(q.Subjects) != 0 && !slices.Contains(q.Subjects, e.Subject()) {
+		return false
+	}
+	if q.Source != "" && e.Source() != q.Source {
+		return false
+	}
+	return true
+}
+
+// Values encodes q as the URL query parameters accepted by Handler
+func (q Query) Values() url.Values {
+	values := url.Values{}
+	for _, eventType := range q.Types {
+		values.Add("type", eventType)
+	}
+	for _, subject := range q.Subjects {
+		values.Add("subject", subject)
+	}
+	if q.TypePrefix != "" {
+		values.Set("typePrefix", q.TypePrefix)
+	}
+	if q.Source != "" {
+		values.Set("source", q.Source)
+	}
+	if !q.Since.IsZero() {
+		values.Set("since", q.Since.Format(time.RFC3339Nano))
+	}
+	if !q.Until.IsZero() {
+		values.Set("until", q.Until.Format(time.RFC3339Nano))
+	}
+	if q.After != "" {
+		values.Set("after", q.After)
+	}
+	if q.Limit != 0 {
+		values.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return values
+}
+
+// ParseQuery decodes the URL query parameters produced by Query.Values
+func ParseQuery(values url.Values) (Query, error) {
+	q := Query{
+		Types:      values["type"],
+		TypePrefix: values.Get("typePrefix"),
+		Subjects:   values["subject"],
+		Source:     values.Get("source"),
+		After:      values.Get("after"),
+	}
+
+	var err error
+	if since := values.Get("since"); since != "" {
+		if q.Since, err = time.Parse(time.RFC3339Nano, since); err != nil {
+			return Query{}, fmt.Errorf("since: %w", err)
+		}
+	}
+	if until := values.Get("until"); until != "" {
+		if q.Until, err = time.Parse(time.RFC3339Nano, until); err != nil {
+			return Query{}, fmt.Errorf("until: %w", err)
+		}
+	}
+	if limit := values.Get("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit < 1 || q.Limit > MaxLimit {
+			return Query{}, fmt.Errorf("limit must be between 1 and %d, got %q", MaxLimit, limit)
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,115 @@
+package eventlake
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/controller"
+	eventschemas "github.com/kofc7186/fundraiser-manager/pkg/event/schemas"
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
+)
+
+// REPLAYED_FROM_EXTENSION records the fundraiser from whose event lake a replayed event was read
+const REPLAYED_FROM_EXTENSION = "replayedfrom"
+
+// SourceTopics maps the types of the events which originate outside of fundraiser-manager (Square webhooks and
+// Square API responses) to the suffix of the topic they are published to
+//
+// Replaying these through the controllers regenerates every Firestore document and internal event derived from
+// them, so other event types are not replayed unless asked for
+var SourceTopics = map[string]string{
+	eventschemas.PaymentCreatedFromSquareType:       "square-payment-webhook",
+	eventschemas.PaymentUpdatedFromSquareType:       "square-payment-webhook",
+	eventschemas.RefundCreatedFromSquareType:        "square-refund-webhook",
+	eventschemas.RefundUpdatedFromSquareType:        "square-refund-webhook",
+	eventschemas.CustomerCreatedFromSquareType:      "square-customer-webhook",
+	eventschemas.CustomerUpdatedFromSquareType:      "square-customer-webhook",
+	eventschemas.SquareGetPaymentResponseType:       "square-payment-response",
+	eventschemas.SquareRetrieveOrderResponseType:    "square-order-response",
+	eventschemas.SquareRetrieveCustomerResponseType: "square-customer-response",
+}
+
+// Sink receives each replayed event, e.g. Publisher.Publish to re-publish it into another fundraiser, or a
+// function applying it directly to a handler under test:
+//
+//	lake.Replay(ctx, query, func(ctx context.Context, e *event.Event) error {
+//		_, err := paymentWriter.Write(ctx, e)
+//		return err
+//	})
+type Sink func(ctx context.Context, e *event.Event) error
+
+// Replay streams the events matching q to sink in ID order, returning how many were replayed; if q does not
+// name any types, only the SourceTopics types are replayed
+//
+// Each event keeps its ID, so the idempotency checks of the handlers apply as they did originally, but loses its
+// trace context so that the replay is not recorded as part of the original traces
+func (l *Lake) Replay(ctx context.Context, q Query, sink Sink) (int, error) {
+	if len(q.Types) == 0 {
+		for eventType := range SourceTopics {
+			q.Types = append(q.Types, eventType)
+		}
+		sort.Strings(q.Types)
+	}
+
+	replayed := 0
+	err := l.Stream(ctx, q, func(ctx context.Context, e *event.Event) error {
+		delete(e.Extensions(), "traceparent")
+		delete(e.Extensions(), "tracestate")
+		e.SetExtension(REPLAYED_FROM_EXTENSION, l.fundraiserID)
+
+		if err := sink(ctx, e); err != nil {
+			return fmt.Errorf("replaying event %s: %w", e.ID(), err)
+		}
+		replayed++
+		return nil
+	})
+	return replayed, err
+}
+
+// Publisher is a Sink which publishes events to the topics of a fundraiser, named <fundraiser id>-<suffix> as
+// declared in terraform/fundraisers/*/pubsub.tf
+type Publisher struct {
+	client       *pubsub.Client
+	fundraiserID string
+	topics       map[string]*pubsub.Topic
+}
+
+// NewPublisher returns a Publisher into the fundraiser's topics, which must already exist
+func NewPublisher(client *pubsub.Client, fundraiserID string) *Publisher {
+	return &Publisher{
+		client:       client,
+		fundraiserID: fundraiserID,
+		topics:       make(map[string]*pubsub.Topic),
+	}
+}
+
+// Publish publishes e to the topic its type is originally published to, as an event of p's fundraiser so that the
+// controllers write it into that fundraiser's documents rather than those it was read from; it is not safe for
+// concurrent use
+func (p *Publisher) Publish(ctx context.Context, e *event.Event) error {
+	suffix, ok := SourceTopics[e.Type()]
+	if !ok {
+		return fmt.Errorf("no topic to replay %q events to", e.Type())
+	}
+	fundraiser.Set(e, p.fundraiserID)
+
+	topic, ok := p.topics[suffix]
+	if !ok {
+		topic = p.client.Topic(fmt.Sprintf("%s-%s", p.fundraiserID, suffix))
+		p.topics[suffix] = topic
+	}
+
+	_, err := controller.Publish(ctx, topic, e)
+	return err
+}
+
+// Stop flushes and stops the topics used by p
+func (p *Publisher) Stop() {
+	for _, topic := range p.topics {
+		topic.Stop()
+	}
+}
@@ -0,0 +1,68 @@
+package eventlake
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	eventschemas "github.com/kofc7186/fundraiser-manager/pkg/event/schemas"
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
+	"github.com/kofc7186/fundraiser-manager/pkg/square/types/webhooks"
+	"github.com/kofc7186/fundraiser-manager/pkg/square/webhooks/webhookstest"
+)
+
+func TestPublisherRoutesToTarget(t *testing.T) {
+	t.Setenv("K_SERVICE", "eventlake-test")
+	t.Setenv("EXPIRATION_TIME", "2024-03-16T00:00:00Z")
+	ctx := context.Background()
+	srv := pstest.NewServer()
+	defer srv.Close()
+
+	client, err := pubsub.NewClient(ctx, "test-project",
+		option.WithEndpoint(srv.Addr),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.CreateTopic(ctx, "fishfry-sandbox-square-customer-webhook"); err != nil {
+		t.Fatal(err)
+	}
+
+	squareEvent := &webhooks.CustomerCreated{}
+	if err := json.Unmarshal(webhookstest.Webhook(t, "customer.created"), squareEvent); err != nil {
+		t.Fatal(err)
+	}
+	e, err := eventschemas.NewCustomerCreatedFromSquare(squareEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the event was read from the lake of the original fundraiser
+	fundraiser.Set(e, "fishfry-030824")
+
+	publisher := NewPublisher(client, "fishfry-sandbox")
+	if err := publisher.Publish(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	publisher.Stop()
+
+	messages := srv.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("published %d messages, want 1", len(messages))
+	}
+	published := event.New()
+	if err := json.Unmarshal(messages[0].Data, &published); err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := fundraiser.Get(&published); id != "fishfry-sandbox" {
+		t.Errorf("republished event belongs to fundraiser %q, want the target fishfry-sandbox", id)
+	}
+}
@@ -0,0 +1,174 @@
+package eventlake
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// eventTypePrefix prefixes the type of every event published by fundraiser-manager
+const eventTypePrefix = "org.kofc7186.fundraiserManager."
+
+// MaxTimelineEvents bounds the number of events in a timeline
+const MaxTimelineEvents = 1000
+
+// bookkeepingFields change on every write, so are left out of the changes in a timeline
+var bookkeepingFields = map[string]bool{
+	"idempotencyKeys": true,
+	"traceparent":     true,
+}
+
+// Page is a chronologically ordered page of events; Next is the cursor for the following page (Query.After),
+// and is empty on the last page
+type Page struct {
+	Events []*event.Event `json:"events"`
+	Next   string         `json:"next,omitempty"`
+}
+
+// Timeline is the events about a set of subjects, oldest first; Truncated reports whether there were more than
+// MaxTimelineEvents of them, in which case only the first MaxTimelineEvents are included
+type Timeline struct {
+	Entries   []TimelineEntry `json:"entries"`
+	Truncated bool            `json:"truncated,omitempty"`
+}
+
+// TimelineEntry is an event about an entity, along with the changes it records to the entity's document
+type TimelineEntry struct {
+	Event   *event.Event `json:"event"`
+	Changes []Change     `json:"changes,omitempty"`
+}
+
+// Change is a field of a document which was added (Old is nil), removed (New is nil) or modified
+type Change struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old,omitempty"`
+	New   interface{} `json:"new,omitempty"`
+}
+
+var errPageFull = errors.New("page full")
+
+// List returns a page of the events matching q
+//
+// Events are ordered by ID; as the IDs are UUIDv7s assigned when each event is created, and an event is only
+// created once the event which caused it has been handled, this is also causal order
+func (l *Lake) List(ctx context.Context, q Query) (*Page, error) {
+	limit := q.Limit
+	if limit == 0 {
+		limit = DefaultLimit
+	}
+
+	page := &Page{Events: []*event.Event{}}
+	err := l.Stream(ctx, q, func(ctx context.Context, e *event.Event) error {
+		page.Events = append(page.Events, e)
+		if len(page.Events) == limit {
+			page.Next = e.ID()
+			return errPageFull
+		}
+		return nil
+	})
+	if err != nil && err != errPageFull {
+		return nil, err
+	}
+	return page, nil
+}
+
+// Timeline returns the events about the given subjects in causal order (see List), along with the changes each
+// recorded to the documents of those subjects; a timeline holds at most MaxTimelineEvents events
+func (l *Lake) Timeline(ctx context.Context, subjects []string) (*Timeline, error) {
+	// one more event than is kept reveals whether the timeline was truncated
+	page, err := l.List(ctx, Query{Subjects: subjects, Limit: MaxTimelineEvents + 1})
+	if err != nil {
+		return nil, err
+	}
+	return timeline(page.Events)
+}
+
+// timeline returns the timeline of the events, truncated to MaxTimelineEvents
+func timeline(events []*event.Event) (*Timeline, error) {
+	t := &Timeline{Entries: make([]TimelineEntry, 0, min(len(events), MaxTimelineEvents))}
+	if len(events) > MaxTimelineEvents {
+		events, t.Truncated = events[:MaxTimelineEvents], true
+	}
+	for _, e := range events {
+		changes, err := documentChanges(e)
+		if err != nil {
+			return nil, err
+		}
+		t.Entries = append(t.Entries, TimelineEntry{Event: e, Changes: changes})
+	}
+	return t, nil
+}
+
+// documentChanges returns the changes recorded by the created, updated and deleted events which the controllers
+// publish for each Firestore document change; other events do not change documents themselves
+func documentChanges(e *event.Event) ([]Change, error) {
+	// e.g. org.kofc7186.fundraiserManager.payment.updated, as opposed to ...square.payment.updated
+	entity, verb, ok := strings.Cut(strings.TrimPrefix(e.Type(), eventTypePrefix), ".")
+	if !ok || strings.Contains(verb, ".") || !strings.HasPrefix(e.Type(), eventTypePrefix) {
+		return nil, nil
+	}
+
+	var data map[string]interface{}
+	switch verb {
+	case "created", "updated", "deleted":
+		if err := e.DataAs(&data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+
+	document, _ := data[entity].(map[string]interface{})
+	switch verb {
+	case "created":
+		return diff(nil, document), nil
+	case "updated":
+		// e.g. oldPayment
+		oldDocument, _ := data["old"+strings.ToUpper(entity[:1])+entity[1:]].(map[string]interface{})
+		return diff(oldDocument, document), nil
+	}
+	return diff(document, nil), nil
+}
+
+// diff compares two JSON documents field by field, descending into nested objects
+func diff(oldDocument, newDocument map[string]interface{}) []Change {
+	oldFields := make(map[string]interface{})
+	flatten("", oldDocument, oldFields)
+	newFields := make(map[string]interface{})
+	flatten("", newDocument, newFields)
+
+	var changes []Change
+	for field, oldValue := range oldFields {
+		if newValue, ok := newFields[field]; !ok || !reflect.DeepEqual(oldValue, newValue) {
+			changes = append(changes, Change{Field: field, Old: oldValue, New: newValue})
+		}
+	}
+	for field, newValue := range newFields {
+		if _, ok := oldFields[field]; !ok {
+			changes = append(changes, Change{Field: field, New: newValue})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+func flatten(prefix string, document map[string]interface{}, fields map[string]interface{}) {
+	for key, value := range document {
+		if prefix == "" && bookkeepingFields[key] {
+			continue
+		}
+		field := prefix + key
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) != 0 {
+			flatten(field+".", nested, fields)
+		} else {
+			fields[field] = value
+		}
+	}
+}
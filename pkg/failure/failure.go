@@ -0,0 +1,86 @@
+// Package failure classifies the errors returned by fundraiser-manager handlers, so that only failures which may
+// succeed on a later attempt are retried by Pub/Sub and Eventarc
+package failure
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Class string
+
+const (
+	// CLASS_RETRYABLE is a transient failure (e.g. Firestore contention, a timeout) which may succeed if retried;
+	// errors which have not been classified are assumed to be retryable
+	CLASS_RETRYABLE Class = "RETRYABLE"
+	// CLASS_PERMANENT is a failure which will recur on every attempt (e.g. a well-formed event carrying a value
+	// we do not understand) until the code or the data is changed
+	CLASS_PERMANENT Class = "PERMANENT"
+	// CLASS_POISON is a message which cannot be decoded at all, so the handler never got as far as acting on it
+	CLASS_POISON Class = "POISON"
+)
+
+// classified is an error annotated with its class
+type classified struct {
+	err   error
+	class Class
+}
+
+func (c *classified) Error() string {
+	return c.err.Error()
+}
+
+func (c *classified) Unwrap() error {
+	return c.err
+}
+
+// Retryable marks err as a transient failure; this is only needed to override the class of a wrapped error
+func Retryable(err error) error {
+	return classify(err, CLASS_RETRYABLE)
+}
+
+// Permanent marks err as a failure which retrying will not resolve
+func Permanent(err error) error {
+	return classify(err, CLASS_PERMANENT)
+}
+
+// Poison marks err as a failure to decode the message being handled
+func Poison(err error) error {
+	return classify(err, CLASS_POISON)
+}
+
+func classify(err error, class Class) error {
+	if err == nil {
+		return nil
+	}
+	return &classified{err: err, class: class}
+}
+
+// ClassOf returns the class of err; the outermost explicit classification wins, otherwise the class is inferred
+// from the gRPC status of the error (as returned by the Google Cloud clients), defaulting to CLASS_RETRYABLE
+func ClassOf(err error) Class {
+	var c *classified
+	if errors.As(err, &c) {
+		return c.class
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return CLASS_RETRYABLE
+	}
+
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.InvalidArgument, codes.OutOfRange, codes.Unimplemented:
+			return CLASS_PERMANENT
+		}
+	}
+	return CLASS_RETRYABLE
+}
+
+// IsRetryable reports whether err may succeed if the operation is attempted again
+func IsRetryable(err error) bool {
+	return ClassOf(err) == CLASS_RETRYABLE
+}
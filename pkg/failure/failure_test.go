@@ -0,0 +1,52 @@
+package failure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClassOf(t *testing.T) {
+	base := errors.New("base")
+
+	tests := []struct {
+		name string
+		err  error
+		want Class
+	}{
+		{"unclassified", base, CLASS_RETRYABLE},
+		{"permanent", Permanent(base), CLASS_PERMANENT},
+		{"poison", Poison(base), CLASS_POISON},
+		{"wrapped permanent", fmt.Errorf("writing payment: %w", Permanent(base)), CLASS_PERMANENT},
+		{"outermost classification wins", Retryable(Permanent(base)), CLASS_RETRYABLE},
+		{"deadline", fmt.Errorf("publishing: %w", context.DeadlineExceeded), CLASS_RETRYABLE},
+		{"contention", status.Error(codes.Aborted, "too much contention"), CLASS_RETRYABLE},
+		{"unavailable", status.Error(codes.Unavailable, "try again"), CLASS_RETRYABLE},
+		{"invalid argument", status.Error(codes.InvalidArgument, "bad field path"), CLASS_PERMANENT},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassOf(tt.err); got != tt.want {
+				t.Errorf("ClassOf() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifiedErrorsUnwrap(t *testing.T) {
+	base := errors.New("base")
+	err := Poison(base)
+	if !errors.Is(err, base) {
+		t.Error("classified error does not unwrap to its cause")
+	}
+	if err.Error() != base.Error() {
+		t.Errorf("Error() = %q, want %q", err.Error(), base.Error())
+	}
+	if Permanent(nil) != nil {
+		t.Error("classifying a nil error should return nil")
+	}
+}
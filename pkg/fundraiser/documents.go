@@ -0,0 +1,115 @@
+package fundraiser
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	fundraiserType "github.com/kofc7186/fundraiser-manager/pkg/types/fundraiser"
+)
+
+// watchRetry is how long Watch waits before listening again after the listener failed
+const watchRetry = 30 * time.Second
+
+// documentRoutes are the routes derived from the fundraiser documents managed through the admin API, which are tried
+// after those of ROUTES_ENV
+type documentRoutes struct {
+	// rules match the Square locations and window of each fundraiser which sets either
+	rules []Rule
+	// ids are the fundraisers which are not archived
+	ids []string
+	// expirations are those set by the retention policies of the fundraisers
+	expirations map[string]time.Time
+}
+
+// routesOf derives the routes of the fundraisers; archived fundraisers take no more objects, but keep their retention
+func routesOf(fundraisers []*fundraiserType.Fundraiser) documentRoutes {
+	routes := documentRoutes{expirations: map[string]time.Time{}}
+	for _, f := range fundraisers {
+		if !f.Retention.ExpirationTime.IsZero() {
+			routes.expirations[f.ID] = f.Retention.ExpirationTime
+		}
+		if f.Status == fundraiserType.STATUS_ARCHIVED {
+			continue
+		}
+		routes.ids = append(routes.ids, f.ID)
+		// a rule without criteria would match every object, taking them from the default fundraiser
+		if len(f.SquareLocationIDs) == 0 && f.StartTime.IsZero() && f.EndTime.IsZero() {
+			continue
+		}
+		routes.rules = append(routes.rules, Rule{
+			ID:             f.ID,
+			Locations:      slices.Clone(f.SquareLocationIDs),
+			Start:          f.StartTime,
+			End:            f.EndTime,
+			ExpirationTime: f.Retention.ExpirationTime,
+		})
+	}
+	slices.Sort(routes.ids)
+	return routes
+}
+
+// Watch reads the routes of the fundraiser documents, and keeps them current until ctx is done; Cloud Functions
+// throttle instances between requests, so a change may not be seen until the request after it arrives
+func (r *Router) Watch(ctx context.Context, client *firestore.Client) error {
+	it := client.Collection("fundraisers").Snapshots(ctx)
+	if err := r.update(it); err != nil {
+		it.Stop()
+		return fmt.Errorf("reading fundraiser routes: %w", err)
+	}
+
+	go func() {
+		for {
+			err := r.update(it)
+			if ctx.Err() != nil {
+				it.Stop()
+				return
+			} else if err != nil {
+				it.Stop()
+				slog.ErrorContext(ctx, fmt.Sprintf("watching fundraiser routes, retrying in %s: %v", watchRetry, err))
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(watchRetry):
+				}
+				it = client.Collection("fundraisers").Snapshots(ctx)
+			}
+		}
+	}()
+	return nil
+}
+
+// update replaces the routes of the fundraiser documents with those of the next snapshot of it
+func (r *Router) update(it *firestore.QuerySnapshotIterator) error {
+	snapshot, err := it.Next()
+	if err != nil {
+		return err
+	}
+	docSnaps, err := snapshot.Documents.GetAll()
+	if err != nil {
+		return err
+	}
+
+	fundraisers := make([]*fundraiserType.Fundraiser, 0, len(docSnaps))
+	for _, docSnap := range docSnaps {
+		f, err := decode(docSnap)
+		if err != nil {
+			// the other fundraisers are still routed
+			slog.Error(fmt.Sprintf("decoding fundraiser %s: %v", docSnap.Ref.ID, err))
+			continue
+		}
+		fundraisers = append(fundraisers, f)
+	}
+	r.setDocuments(routesOf(fundraisers))
+	return nil
+}
+
+func (r *Router) setDocuments(routes documentRoutes) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.documents = routes
+}
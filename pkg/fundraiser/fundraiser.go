@@ -0,0 +1,250 @@
+// Package fundraiser routes events to the fundraiser they belong to, so that a single deployment can serve several
+// fundraisers (e.g. each Friday's fish fry)
+//
+// Every event carries the ID of its fundraiser in the EXTENSION extension, set where Square objects enter the
+// system by resolving them against the Routes of a Router: first any explicit mapping of the object's ID, then the
+// first Rule matching its Square location, order source and creation time, then the Square locations and window of
+// the fundraiser documents (see Router.Watch). Controllers derive the Firestore paths of the fundraiser from the
+// extension (see Path). Objects which match no rule, and events published before they were routed, belong to the
+// fundraiser the deployment was created for (FUNDRAISER_ID)
+package fundraiser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	"gopkg.in/yaml.v3"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/config"
+	"github.com/kofc7186/fundraiser-manager/pkg/logging"
+)
+
+// EXTENSION is the CloudEvent extension carrying the ID of the fundraiser an event belongs to
+const EXTENSION = "fundraiserid"
+
+// ROUTES_ENV is the environment variable holding the Routes of the deployment as YAML; if it is not set, every
+// event belongs to FUNDRAISER_ID
+const ROUTES_ENV = "FUNDRAISERS"
+
+// Rule matches the Square objects of a fundraiser; criteria which are not set match every object
+type Rule struct {
+	ID string `yaml:"id"`
+	// Locations are the IDs of the Square locations the fundraiser sells from
+	Locations []string `yaml:"locations,omitempty"`
+	// Sources are the names of the sources of its orders, e.g. the name of an online ordering site
+	Sources []string `yaml:"sources,omitempty"`
+	// Start and End bound when its objects were created in Square
+	Start time.Time `yaml:"start,omitempty"`
+	End   time.Time `yaml:"end,omitempty"`
+	// ExpirationTime, if set, replaces EXPIRATION_TIME for the documents of the fundraiser
+	ExpirationTime time.Time `yaml:"expiration_time,omitempty"`
+}
+
+// Routes are how a deployment routes Square objects to its fundraisers
+type Routes struct {
+	// Objects explicitly maps the IDs of Square objects (orders, payments, refunds or customers) to fundraisers,
+	// taking precedence over Rules
+	Objects map[string]string `yaml:"objects,omitempty"`
+	// Rules are tried in order
+	Rules []Rule `yaml:"rules,omitempty"`
+}
+
+// Attributes are what a Square object is routed by
+type Attributes struct {
+	// ObjectIDs are the IDs of the object and those it refers to, e.g. the order of a payment
+	ObjectIDs   []string
+	LocationID  string
+	Source      string
+	CreatedTime time.Time
+}
+
+// Router resolves the fundraisers of Square objects
+type Router struct {
+	Routes
+	// Default is the fundraiser of objects which match no rule
+	Default string
+	// ExpirationTime is that of the documents of fundraisers whose rule or retention policy sets none
+	ExpirationTime time.Time
+
+	mu sync.RWMutex
+	// documents are the routes of the fundraiser documents, kept current by Watch
+	documents documentRoutes
+}
+
+// Load returns the Router of the deployment, from FUNDRAISER_ID, EXPIRATION_TIME and ROUTES_ENV
+func Load() (*Router, error) {
+	var cfg struct {
+		config.Fundraiser
+		Routes string `env:"FUNDRAISERS"`
+	}
+	if err := config.Load(&cfg); err != nil {
+		return nil, err
+	}
+
+	r := &Router{Default: cfg.FundraiserID, ExpirationTime: cfg.ExpirationTime}
+	if err := yaml.Unmarshal([]byte(cfg.Routes), &r.Routes); err != nil {
+		return nil, &config.Error{Name: ROUTES_ENV, Err: err}
+	}
+	if err := r.validate(); err != nil {
+		return nil, &config.Error{Name: ROUTES_ENV, Err: err}
+	}
+	return r, nil
+}
+
+// validate reports every problem with the routes
+func (r *Router) validate() error {
+	var errs []error
+	for objectID, id := range r.Objects {
+		if err := config.Check("fundraiserID", id); err != nil {
+			errs = append(errs, fmt.Errorf("object %s: %w", objectID, err))
+		}
+	}
+	for i, rule := range r.Rules {
+		if err := config.Check("fundraiserID", rule.ID); err != nil {
+			errs = append(errs, fmt.Errorf("rule %d: %w", i, err))
+		}
+		if !rule.Start.IsZero() && !rule.End.IsZero() && !rule.Start.Before(rule.End) {
+			errs = append(errs, fmt.Errorf("rule %d: start %s is not before end %s", i, rule.Start, rule.End))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Resolve returns the fundraiser of the object with the given attributes
+func (r *Router) Resolve(a Attributes) string {
+	for _, objectID := range a.ObjectIDs {
+		if id, ok := r.Objects[objectID]; objectID != "" && ok {
+			return id
+		}
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, rules := range [][]Rule{r.Rules, r.documents.rules} {
+		for _, rule := range rules {
+			if rule.matches(a) {
+				return rule.ID
+			}
+		}
+	}
+	return r.Default
+}
+
+func (rule *Rule) matches(a Attributes) bool {
+	if len(rule.Locations) > 0 && !slices.Contains(rule.Locations, a.LocationID) {
+		return false
+	}
+	if len(rule.Sources) > 0 && !slices.Contains(rule.Sources, a.Source) {
+		return false
+	}
+	if !rule.Start.IsZero() && (a.CreatedTime.IsZero() || a.CreatedTime.Before(rule.Start)) {
+		return false
+	}
+	if !rule.End.IsZero() && (a.CreatedTime.IsZero() || !a.CreatedTime.Before(rule.End)) {
+		return false
+	}
+	return true
+}
+
+// IDs returns every fundraiser the router may resolve objects to, the default first, followed by those of the rules,
+// the explicit mappings and the fundraiser documents
+func (r *Router) IDs() []string {
+	ids := []string{r.Default}
+	for _, rule := range r.Rules {
+		if !slices.Contains(ids, rule.ID) {
+			ids = append(ids, rule.ID)
+		}
+	}
+	objectIDs := make([]string, 0, len(r.Objects))
+	for _, id := range r.Objects {
+		objectIDs = append(objectIDs, id)
+	}
+	slices.Sort(objectIDs)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, id := range append(objectIDs, r.documents.ids...) {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Expiration returns the expiration of the documents of the fundraiser; the retention policy of its document takes
+// precedence over its rule
+func (r *Router) Expiration(id string) time.Time {
+	r.mu.RLock()
+	expiration, ok := r.documents.expirations[id]
+	r.mu.RUnlock()
+	if ok {
+		return expiration
+	}
+	for _, rule := range r.Rules {
+		if rule.ID == id && !rule.ExpirationTime.IsZero() {
+			return rule.ExpirationTime
+		}
+	}
+	return r.ExpirationTime
+}
+
+// Of returns the fundraiser e belongs to
+func (r *Router) Of(e *event.Event) string {
+	if id, ok := Get(e); ok {
+		return id
+	}
+	return r.Default
+}
+
+// Get returns the fundraiser recorded on e
+func Get(e *event.Event) (string, bool) {
+	id, ok := e.Extensions()[EXTENSION].(string)
+	return id, ok && id != ""
+}
+
+// Set records the fundraiser e belongs to
+func Set(e *event.Event, id string) {
+	if id != "" {
+		e.SetExtension(EXTENSION, id)
+	}
+}
+
+// Path returns the path of the Firestore document or collection of the fundraiser with the given elements, e.g.
+// Path("fishfry-030824", "orders") is fundraisers/fishfry-030824/orders
+func Path(id string, elems ...string) string {
+	return path.Join(append([]string{"fundraisers", id}, elems...)...)
+}
+
+// FromDocument returns the fundraiser of the Firestore document with the given resource name, e.g.
+// projects/p/databases/(default)/documents/fundraisers/fishfry-030824/orders/1
+func FromDocument(name string) (string, bool) {
+	_, documentPath, ok := strings.Cut(name, "/documents/")
+	if !ok {
+		documentPath = name
+	}
+	segments := strings.Split(documentPath, "/")
+	if len(segments) < 2 || segments[0] != "fundraisers" || segments[1] == "" {
+		return "", false
+	}
+	return segments[1], true
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the fundraiser being handled, which is also labelled on log entries
+func NewContext(ctx context.Context, id string) context.Context {
+	ctx = logging.WithLabel(ctx, logging.LabelFundraiserID, id)
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the fundraiser carried by ctx
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok
+}
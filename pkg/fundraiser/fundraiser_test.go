@@ -0,0 +1,175 @@
+package fundraiser
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	fundraiserType "github.com/kofc7186/fundraiser-manager/pkg/types/fundraiser"
+)
+
+const testRoutes = `
+objects:
+  ORDER-PLACED-LATE: fishfry-022324
+rules:
+  - id: fishfry-online
+    sources: [Fish Fry Online Ordering]
+  - id: fishfry-022324
+    locations: [LOCATION-HALL]
+    start: 2024-02-23T00:00:00-05:00
+    end: 2024-02-24T00:00:00-05:00
+    expiration_time: 2024-03-01T00:00:00Z
+  - id: fishfry-030824
+    locations: [LOCATION-HALL]
+    start: 2024-03-08T00:00:00-05:00
+    end: 2024-03-09T00:00:00-05:00
+`
+
+func testRouter(t *testing.T, routes string) (*Router, error) {
+	t.Setenv("GCP_PROJECT", "test-project")
+	t.Setenv("FUNDRAISER_ID", "fishfry-default")
+	t.Setenv("EXPIRATION_TIME", "2024-04-01T00:00:00Z")
+	t.Setenv(ROUTES_ENV, routes)
+	return Load()
+}
+
+func TestResolve(t *testing.T) {
+	r, err := testRouter(t, testRoutes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feb23 := time.Date(2024, 2, 23, 18, 0, 0, 0, time.UTC)
+	mar8 := time.Date(2024, 3, 8, 18, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name       string
+		attributes Attributes
+		want       string
+	}{
+		{"location and window", Attributes{ObjectIDs: []string{"O1"}, LocationID: "LOCATION-HALL", CreatedTime: feb23}, "fishfry-022324"},
+		{"another window", Attributes{ObjectIDs: []string{"O2"}, LocationID: "LOCATION-HALL", CreatedTime: mar8}, "fishfry-030824"},
+		{"source", Attributes{ObjectIDs: []string{"O3"}, LocationID: "LOCATION-HALL", Source: "Fish Fry Online Ordering", CreatedTime: mar8}, "fishfry-online"},
+		{"explicit mapping", Attributes{ObjectIDs: []string{"P1", "ORDER-PLACED-LATE"}, LocationID: "LOCATION-HALL", CreatedTime: mar8}, "fishfry-022324"},
+		{"outside every window", Attributes{LocationID: "LOCATION-HALL", CreatedTime: feb23.Add(24 * time.Hour)}, "fishfry-default"},
+		{"unknown time", Attributes{LocationID: "LOCATION-HALL"}, "fishfry-default"},
+		{"another location", Attributes{LocationID: "LOCATION-RECTORY", CreatedTime: feb23}, "fishfry-default"},
+	} {
+		if got := r.Resolve(test.attributes); got != test.want {
+			t.Errorf("%s: resolved %s, want %s", test.name, got, test.want)
+		}
+	}
+
+	if got := r.Expiration("fishfry-022324"); !got.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expiration of fishfry-022324 is %s", got)
+	}
+	if got := r.Expiration("fishfry-030824"); !got.Equal(r.ExpirationTime) {
+		t.Errorf("expiration of fishfry-030824 is %s, want EXPIRATION_TIME", got)
+	}
+
+	want := []string{"fishfry-default", "fishfry-online", "fishfry-022324", "fishfry-030824"}
+	if got := r.IDs(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got IDs %v, want %v", got, want)
+	}
+}
+
+func TestDocumentRoutes(t *testing.T) {
+	r, err := testRouter(t, testRoutes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mar15 := time.Date(2024, 3, 15, 18, 0, 0, 0, time.UTC)
+	retained := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	r.setDocuments(routesOf([]*fundraiserType.Fundraiser{
+		{ID: "fishfry-031524", SquareLocationIDs: []string{"LOCATION-HALL"}, StartTime: mar15.Add(-time.Hour), EndTime: mar15.Add(time.Hour), Status: fundraiserType.STATUS_PLANNED},
+		{ID: "fishfry-022324", Status: fundraiserType.STATUS_CLOSED, Retention: fundraiserType.Retention{ExpirationTime: retained}},
+		{ID: "fishfry-lent-2023", SquareLocationIDs: []string{"LOCATION-HALL"}, Status: fundraiserType.STATUS_ARCHIVED},
+		// without criteria, the fundraiser isn't routed to, so it doesn't take every object from the default
+		{ID: "fishfry-planning", Status: fundraiserType.STATUS_PLANNED},
+	}))
+
+	for _, test := range []struct {
+		name       string
+		attributes Attributes
+		want       string
+	}{
+		{"location and window", Attributes{LocationID: "LOCATION-HALL", CreatedTime: mar15}, "fishfry-031524"},
+		{"outside the window", Attributes{LocationID: "LOCATION-HALL", CreatedTime: mar15.Add(24 * time.Hour)}, "fishfry-default"},
+		{"the rules of FUNDRAISERS first", Attributes{LocationID: "LOCATION-HALL", Source: "Fish Fry Online Ordering", CreatedTime: mar15}, "fishfry-online"},
+	} {
+		if got := r.Resolve(test.attributes); got != test.want {
+			t.Errorf("%s: resolved %s, want %s", test.name, got, test.want)
+		}
+	}
+
+	// the retention policy replaces the expiration of the rule
+	if got := r.Expiration("fishfry-022324"); !got.Equal(retained) {
+		t.Errorf("expiration of fishfry-022324 is %s, want %s", got, retained)
+	}
+	if got := r.Expiration("fishfry-031524"); !got.Equal(r.ExpirationTime) {
+		t.Errorf("expiration of fishfry-031524 is %s, want EXPIRATION_TIME", got)
+	}
+
+	want := []string{"fishfry-default", "fishfry-online", "fishfry-022324", "fishfry-030824", "fishfry-031524", "fishfry-planning"}
+	if got := r.IDs(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got IDs %v, want %v", got, want)
+	}
+}
+
+func TestLoadWithoutRoutes(t *testing.T) {
+	r, err := testRouter(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Resolve(Attributes{LocationID: "LOCATION-HALL", CreatedTime: time.Now()}); got != "fishfry-default" {
+		t.Errorf("resolved %s without any routes", got)
+	}
+	if got := r.IDs(); len(got) != 1 || got[0] != "fishfry-default" {
+		t.Errorf("got IDs %v without any routes", got)
+	}
+}
+
+func TestLoadInvalidRoutes(t *testing.T) {
+	_, err := testRouter(t, `
+objects:
+  O1: Fish Fry
+rules:
+  - id: fishfry-030824
+    start: 2024-03-09T00:00:00Z
+    end: 2024-03-08T00:00:00Z
+`)
+	if err == nil {
+		t.Fatal("invalid routes loaded")
+	}
+	for _, problem := range []string{"object O1", "rule 0: start"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("%q was not reported in: %v", problem, err)
+		}
+	}
+}
+
+func TestEventRouting(t *testing.T) {
+	r := &Router{Default: "fishfry-default"}
+	e := event.New()
+	if got := r.Of(&e); got != "fishfry-default" {
+		t.Errorf("an event which was never routed belongs to %s", got)
+	}
+	Set(&e, "fishfry-030824")
+	if got := r.Of(&e); got != "fishfry-030824" {
+		t.Errorf("a routed event belongs to %s", got)
+	}
+
+	if got := Path("fishfry-030824", "orders", "1"); got != "fundraisers/fishfry-030824/orders/1" {
+		t.Errorf("Path returned %s", got)
+	}
+	for name, want := range map[string]string{
+		"projects/p/databases/(default)/documents/fundraisers/fishfry-030824/orders/1": "fishfry-030824",
+		"fundraisers/fishfry-022324/payments/P1":                                       "fishfry-022324",
+		"projects/p/databases/(default)/documents/events/1":                            "",
+	} {
+		if got, _ := FromDocument(name); got != want {
+			t.Errorf("FromDocument(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Package fundraisertest fakes the Firestore listener used by fundraiser.Router.Watch, so that functions whose init
+// watches the fundraiser documents can be tested without the Firestore emulator
+package fundraisertest
+
+import (
+	"net"
+
+	firestorepb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// StartFirestore starts a fake Firestore in which there are no fundraiser documents, and returns the address to set
+// as FIRESTORE_EMULATOR_HOST; it runs until the test binary exits
+func StartFirestore() string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	srv := grpc.NewServer()
+	firestorepb.RegisterFirestoreServer(srv, &emptyFirestore{})
+	go srv.Serve(lis)
+	return lis.Addr().String()
+}
+
+type emptyFirestore struct {
+	firestorepb.UnimplementedFirestoreServer
+}
+
+// Listen answers the listen request with a consistent, empty snapshot, then waits for the listener to stop
+func (*emptyFirestore) Listen(stream firestorepb.Firestore_ListenServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	targetID := req.GetAddTarget().GetTargetId()
+	for _, change := range []*firestorepb.TargetChange{
+		{TargetChangeType: firestorepb.TargetChange_ADD, TargetIds: []int32{targetID}},
+		{TargetChangeType: firestorepb.TargetChange_CURRENT, TargetIds: []int32{targetID}},
+		{TargetChangeType: firestorepb.TargetChange_NO_CHANGE, ReadTime: timestamppb.Now()},
+	} {
+		if err := stream.Send(&firestorepb.ListenResponse{ResponseType: &firestorepb.ListenResponse_TargetChange{TargetChange: change}}); err != nil {
+			return err
+		}
+	}
+	<-stream.Context().Done()
+	return nil
+}
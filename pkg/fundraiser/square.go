@@ -0,0 +1,52 @@
+package fundraiser
+
+import (
+	"time"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/square/types/models"
+)
+
+// createdTime parses the creation time of a Square object, which Square formats in RFC 3339
+func createdTime(createdAt string) time.Time {
+	t, _ := time.Parse(time.RFC3339, createdAt)
+	return t
+}
+
+func PaymentAttributes(p *models.Payment) Attributes {
+	return Attributes{
+		ObjectIDs:   []string{p.Id, p.OrderId},
+		LocationID:  p.LocationId,
+		CreatedTime: createdTime(p.CreatedAt),
+	}
+}
+
+func RefundAttributes(r *models.PaymentRefund) Attributes {
+	return Attributes{
+		ObjectIDs:   []string{r.Id, r.PaymentId, r.OrderId},
+		LocationID:  r.LocationId,
+		CreatedTime: createdTime(r.CreatedAt),
+	}
+}
+
+// OrderAttributes are those of an order retrieved from Square; order webhooks lack its source, so orders are routed
+// once they have been retrieved
+func OrderAttributes(o *models.Order) Attributes {
+	a := Attributes{
+		ObjectIDs:   []string{o.Id},
+		LocationID:  o.LocationId,
+		CreatedTime: createdTime(o.CreatedAt),
+	}
+	if o.Source != nil {
+		a.Source = o.Source.Name
+	}
+	return a
+}
+
+// CustomerAttributes are those of a customer, which belongs to no location, so can only be routed by its ID or the
+// time it was created
+func CustomerAttributes(c *models.Customer) Attributes {
+	return Attributes{
+		ObjectIDs:   []string{c.Id},
+		CreatedTime: createdTime(c.CreatedAt),
+	}
+}
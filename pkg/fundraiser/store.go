@@ -0,0 +1,194 @@
+package fundraiser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	fundraiserType "github.com/kofc7186/fundraiser-manager/pkg/types/fundraiser"
+	paymentType "github.com/kofc7186/fundraiser-manager/pkg/types/payment"
+)
+
+var (
+	// ErrNotFound is returned when no fundraiser document exists with the requested ID
+	ErrNotFound = errors.New("fundraiser not found")
+	// ErrInvalid is returned when the metadata of a fundraiser fails validation
+	ErrInvalid = errors.New("invalid fundraiser")
+)
+
+// Store persists the metadata of fundraisers in their fundraisers/<id> documents
+type Store struct {
+	client *firestore.Client
+}
+
+func NewStore(client *firestore.Client) *Store {
+	return &Store{client: client}
+}
+
+// Get returns the fundraiser with the given ID
+func (s *Store) Get(ctx context.Context, id string) (*fundraiserType.Fundraiser, error) {
+	docSnap, err := s.client.Doc(Path(id)).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return decode(docSnap)
+}
+
+// List returns every fundraiser, most recently started first
+func (s *Store) List(ctx context.Context) ([]*fundraiserType.Fundraiser, error) {
+	docSnaps, err := s.client.Collection("fundraisers").Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	fundraisers := make([]*fundraiserType.Fundraiser, 0, len(docSnaps))
+	for _, docSnap := range docSnaps {
+		f, err := decode(docSnap)
+		if err != nil {
+			return nil, err
+		}
+		fundraisers = append(fundraisers, f)
+	}
+
+	sort.Slice(fundraisers, func(i, j int) bool {
+		return fundraisers[i].StartTime.After(fundraisers[j].StartTime)
+	})
+	return fundraisers, nil
+}
+
+// Put creates or replaces the metadata of a fundraiser; a new fundraiser is PLANNED, and the status and order numbers
+// of an existing one are kept, as they are only changed by Transition and the order-controller respectively
+func (s *Store) Put(ctx context.Context, f *fundraiserType.Fundraiser) (*fundraiserType.Fundraiser, error) {
+	// the status is not set by the caller, so is not validated
+	proposed := *f
+	proposed.Status = fundraiserType.STATUS_PLANNED
+	if err := proposed.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	docRef := s.client.Doc(Path(f.ID))
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		proposed = *f
+		proposed.Status = fundraiserType.STATUS_PLANNED
+		proposed.StatusTransitions = nil
+		proposed.OrderNumbers = nil
+
+		docSnap, err := tx.Get(docRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if docSnap.Exists() {
+			persisted := &fundraiserType.Fundraiser{}
+			if err := docSnap.DataTo(persisted); err != nil {
+				return err
+			}
+			persisted.MigrateOrderNumbers()
+			proposed.OrderNumbers = persisted.OrderNumbers
+			// documents written before fundraisers had metadata hold only their order numbers
+			if persisted.Status != "" {
+				proposed.Status = persisted.Status
+				proposed.StatusTransitions = persisted.StatusTransitions
+			}
+		}
+
+		proposed.UpdatedTime = time.Now()
+		return tx.Set(docRef, &proposed)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &proposed, nil
+}
+
+// Transition moves the fundraiser with the given ID to the next status (see fundraiserType.Status)
+func (s *Store) Transition(ctx context.Context, id string, next fundraiserType.Status) (*fundraiserType.Fundraiser, error) {
+	docRef := s.client.Doc(Path(id))
+
+	f := &fundraiserType.Fundraiser{}
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(docRef)
+		if status.Code(err) == codes.NotFound {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+
+		*f = fundraiserType.Fundraiser{}
+		if err := docSnap.DataTo(f); err != nil {
+			return err
+		}
+		f.ID = id
+		f.MigrateOrderNumbers()
+		if f.Status == "" {
+			f.Status = fundraiserType.STATUS_PLANNED
+		}
+		if err := f.Transition(next, time.Now()); err != nil {
+			return err
+		}
+		return tx.Set(docRef, f)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fundraiser %s: %w", id, err)
+	}
+	return f, nil
+}
+
+// LeaseOrderNumbers leases up to size order numbers (see fundraiserType.Fundraiser.LeaseOrderNumbers) from the
+// fundraiser with the given ID, returning the fundraiser as of the lease; a fundraiser which wasn't set up through the
+// admin API is created as taking orders
+func (s *Store) LeaseOrderNumbers(ctx context.Context, id string, source paymentType.PaymentSource, t time.Time, size uint16) (*fundraiserType.Fundraiser, fundraiserType.OrderNumberBlock, error) {
+	docRef := s.client.Doc(Path(id))
+
+	f := &fundraiserType.Fundraiser{}
+	var block fundraiserType.OrderNumberBlock
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		*f = fundraiserType.Fundraiser{ID: id}
+		docSnap, err := tx.Get(docRef)
+		if status.Code(err) == codes.NotFound {
+			f.Status = fundraiserType.STATUS_OPEN
+			f.UpdatedTime = time.Now()
+			if block, err = f.LeaseOrderNumbers(source, t, size); err != nil {
+				return err
+			}
+			return tx.Set(docRef, f)
+		} else if err != nil {
+			return err
+		}
+
+		if err := docSnap.DataTo(f); err != nil {
+			return err
+		}
+		f.ID = id
+		// documents written before orders were numbered from counters continue from their last number
+		f.MigrateOrderNumbers()
+		if block, err = f.LeaseOrderNumbers(source, t, size); err != nil {
+			return err
+		}
+		// only the counter is written, so that the lease doesn't race with changes to the metadata
+		return tx.Update(docRef, []firestore.Update{{FieldPath: []string{"orderNumbers", block.Counter}, Value: f.OrderNumbers[block.Counter]}})
+	})
+	if err != nil {
+		return nil, fundraiserType.OrderNumberBlock{}, fmt.Errorf("fundraiser %s: %w", id, err)
+	}
+	return f, block, nil
+}
+
+// decode returns the fundraiser of its document
+func decode(docSnap *firestore.DocumentSnapshot) (*fundraiserType.Fundraiser, error) {
+	f := &fundraiserType.Fundraiser{}
+	if err := docSnap.DataTo(f); err != nil {
+		return nil, err
+	}
+	f.ID = docSnap.Ref.ID
+	f.MigrateOrderNumbers()
+	return f, nil
+}
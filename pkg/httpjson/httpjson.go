@@ -0,0 +1,29 @@
+// Package httpjson writes the JSON responses of the HTTP functions and APIs of fundraiser-manager
+package httpjson
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// Write responds with status and v encoded as JSON
+func Write(ctx context.Context, w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+	}
+}
+
+// Error responds with status and err as {"error": "..."}, logging err as an error if status is a server error and as
+// a warning otherwise
+func Error(ctx context.Context, w http.ResponseWriter, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		slog.ErrorContext(ctx, err.Error())
+	} else {
+		slog.WarnContext(ctx, err.Error())
+	}
+	Write(ctx, w, status, map[string]string{"error": err.Error()})
+}
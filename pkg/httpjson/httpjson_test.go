@@ -0,0 +1,24 @@
+package httpjson
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	w := httptest.NewRecorder()
+	Error(context.Background(), w, http.StatusNotFound, errors.New("order not found"))
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got, want := w.Body.String(), `{"error":"order not found"}`+"\n"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+}
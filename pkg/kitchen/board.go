@@ -0,0 +1,94 @@
+// Package kitchen serves the kitchen display of a fundraiser, a live board of its open orders which staff bump along
+// as they are prepared
+package kitchen
+
+import (
+	"sort"
+	"time"
+
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+// OPEN_STATUSES are the statuses of the orders shown on the board, in the order of its columns
+var OPEN_STATUSES = []orderType.OrderStatus{
+	orderType.ORDER_STATUS_ONLINE,
+	orderType.ORDER_STATUS_PRESENT,
+	orderType.ORDER_STATUS_LABELED,
+	orderType.ORDER_STATUS_READY,
+}
+
+// Ticket is an order as shown on the board
+type Ticket struct {
+	Code        string                `json:"code"`
+	CreatedTime time.Time             `json:"createdTime"`
+	DisplayName string                `json:"displayName"`
+	Expedite    bool                  `json:"expedite"`
+	ID          string                `json:"id"`
+	Items       []orderType.OrderItem `json:"items"`
+	Note        string                `json:"note"`
+	Number      uint16                `json:"number"`
+	StaffNotes  []orderType.StaffNote `json:"staffNotes"`
+	Status      orderType.OrderStatus `json:"status"`
+	// StatusTime is when the order reached its status, or was created if no transition was recorded
+	StatusTime time.Time `json:"statusTime"`
+}
+
+// Column holds the tickets of the orders in a status; expedited orders come first, then those longest in the status
+type Column struct {
+	Status  orderType.OrderStatus `json:"status"`
+	Tickets []Ticket              `json:"tickets"`
+}
+
+// Board is every open order of a fundraiser, by status
+type Board struct {
+	Columns     []Column  `json:"columns"`
+	UpdatedTime time.Time `json:"updatedTime"`
+}
+
+// NewBoard returns the board of the orders as of now; orders which aren't open are left off
+func NewBoard(orders []*orderType.Order, now time.Time) *Board {
+	columns := make(map[orderType.OrderStatus]*Column, len(OPEN_STATUSES))
+	board := &Board{Columns: make([]Column, len(OPEN_STATUSES)), UpdatedTime: now}
+	for i, status := range OPEN_STATUSES {
+		board.Columns[i] = Column{Status: status, Tickets: []Ticket{}}
+		columns[status] = &board.Columns[i]
+	}
+
+	for _, o := range orders {
+		column, ok := columns[o.Status]
+		if !ok {
+			continue
+		}
+		statusTime := o.CreatedTime
+		if n := len(o.StatusTransitions); n > 0 && o.StatusTransitions[n-1].Status == o.Status {
+			statusTime = o.StatusTransitions[n-1].Timestamp
+		}
+		column.Tickets = append(column.Tickets, Ticket{
+			Code:        o.Code,
+			CreatedTime: o.CreatedTime,
+			DisplayName: o.DisplayName,
+			Expedite:    o.Expedite,
+			ID:          o.ID,
+			Items:       o.Items,
+			Note:        o.Note,
+			Number:      o.Number,
+			StaffNotes:  o.StaffNotes,
+			Status:      o.Status,
+			StatusTime:  statusTime,
+		})
+	}
+
+	for _, column := range board.Columns {
+		tickets := column.Tickets
+		sort.SliceStable(tickets, func(i, j int) bool {
+			if tickets[i].Expedite != tickets[j].Expedite {
+				return tickets[i].Expedite
+			}
+			if !tickets[i].StatusTime.Equal(tickets[j].StatusTime) {
+				return tickets[i].StatusTime.Before(tickets[j].StatusTime)
+			}
+			return tickets[i].Number < tickets[j].Number
+		})
+	}
+	return board
+}
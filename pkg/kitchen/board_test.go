@@ -0,0 +1,67 @@
+package kitchen
+
+import (
+	"testing"
+	"time"
+
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+func TestNewBoard(t *testing.T) {
+	now := time.Date(2024, 3, 15, 17, 30, 0, 0, time.UTC)
+	labeled := func(id string, number uint16, expedite bool, labeledAt time.Time) *orderType.Order {
+		return &orderType.Order{
+			ID:                id,
+			Number:            number,
+			Expedite:          expedite,
+			CreatedTime:       now.Add(-time.Hour),
+			Status:            orderType.ORDER_STATUS_LABELED,
+			StatusTransitions: []orderType.OrderStatusTransition{{Status: orderType.ORDER_STATUS_LABELED, Timestamp: labeledAt}},
+		}
+	}
+	orders := []*orderType.Order{
+		labeled("newest", 3, false, now.Add(-time.Minute)),
+		labeled("oldest", 4, false, now.Add(-10*time.Minute)),
+		labeled("expedited", 5, true, now.Add(-time.Minute)),
+		{ID: "online", Number: 1, CreatedTime: now.Add(-5 * time.Minute), Status: orderType.ORDER_STATUS_ONLINE},
+		{ID: "closed", Number: 2, CreatedTime: now.Add(-time.Hour), Status: orderType.ORDER_STATUS_CLOSED},
+	}
+
+	board := NewBoard(orders, now)
+	if len(board.Columns) != len(OPEN_STATUSES) {
+		t.Fatalf("got %d columns, want %d", len(board.Columns), len(OPEN_STATUSES))
+	}
+
+	want := map[orderType.OrderStatus][]string{
+		orderType.ORDER_STATUS_ONLINE:  {"online"},
+		orderType.ORDER_STATUS_PRESENT: {},
+		orderType.ORDER_STATUS_LABELED: {"expedited", "oldest", "newest"},
+		orderType.ORDER_STATUS_READY:   {},
+	}
+	for i, column := range board.Columns {
+		if column.Status != OPEN_STATUSES[i] {
+			t.Errorf("column %d is %s, want %s", i, column.Status, OPEN_STATUSES[i])
+		}
+		var got []string
+		for _, ticket := range column.Tickets {
+			got = append(got, ticket.ID)
+		}
+		if len(got) != len(want[column.Status]) {
+			t.Errorf("%s: got tickets %v, want %v", column.Status, got, want[column.Status])
+			continue
+		}
+		for j := range got {
+			if got[j] != want[column.Status][j] {
+				t.Errorf("%s: got tickets %v, want %v", column.Status, got, want[column.Status])
+				break
+			}
+		}
+	}
+
+	if ticket := board.Columns[0].Tickets[0]; !ticket.StatusTime.Equal(ticket.CreatedTime) {
+		t.Errorf("ticket without transitions has status time %s, want its created time %s", ticket.StatusTime, ticket.CreatedTime)
+	}
+	if ticket := board.Columns[2].Tickets[1]; !ticket.StatusTime.Equal(now.Add(-10 * time.Minute)) {
+		t.Errorf("ticket has status time %s, want the time of its transition", ticket.StatusTime)
+	}
+}
@@ -0,0 +1,147 @@
+package kitchen
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/httpjson"
+	"github.com/kofc7186/fundraiser-manager/pkg/logging"
+	"github.com/kofc7186/fundraiser-manager/pkg/orders"
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+// KeepAliveInterval is how often a comment is sent on an idle stream, so that proxies don't close it
+var KeepAliveInterval = 15 * time.Second
+
+//go:embed page.html
+var page []byte
+
+// Bumper moves an order along once the kitchen has prepared it
+type Bumper interface {
+	Bump(ctx context.Context, id string) error
+}
+
+// ServiceBumper bumps orders by moving them to READY through an orders.Service, which records the transition on the
+// order; the write is published as an order.updated event like any other change of status
+type ServiceBumper struct {
+	Service orders.Service
+}
+
+// Bump moves the order to READY
+func (b ServiceBumper) Bump(ctx context.Context, id string) error {
+	_, err := b.Service.Transition(ctx, id, orderType.ORDER_STATUS_READY)
+	return err
+}
+
+// Handler serves the kitchen display:
+//
+//	GET  /                    the display, which renders the board streamed from /events
+//	GET  /events              the Board, streamed as Server-Sent Events each time an open order changes
+//	POST /orders/{id}/bump    bump a LABELED order to READY
+//
+// the query of each request (e.g. ?fundraiser=) is carried by the display to the requests it makes
+func Handler(source Source, bumper Bumper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		path := strings.Trim(r.URL.Path, "/")
+		switch {
+		case path == "" && r.Method == http.MethodGet:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			if _, err := w.Write(page); err != nil {
+				slog.ErrorContext(ctx, err.Error())
+			}
+		case path == "events" && r.Method == http.MethodGet:
+			Stream(w, r, source, "board", func(orders []*orderType.Order, now time.Time) interface{} {
+				return NewBoard(orders, now)
+			})
+		case strings.HasPrefix(path, "orders/") && strings.HasSuffix(path, "/bump") && r.Method == http.MethodPost:
+			id := strings.TrimSuffix(strings.TrimPrefix(path, "orders/"), "/bump")
+			if id == "" || strings.Contains(id, "/") {
+				httpjson.Error(ctx, w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+				return
+			}
+			ctx = logging.WithLabel(ctx, logging.LabelOrderID, id)
+			if err := bumper.Bump(ctx, id); errors.Is(err, orders.ErrNotFound) {
+				httpjson.Error(ctx, w, http.StatusNotFound, err)
+				return
+			} else if errors.Is(err, orderType.ErrInvalidTransition) {
+				// e.g. bumped from another display first
+				httpjson.Error(ctx, w, http.StatusConflict, err)
+				return
+			} else if err != nil {
+				httpjson.Error(ctx, w, http.StatusInternalServerError, err)
+				return
+			}
+			slog.InfoContext(ctx, "order bumped")
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			httpjson.Error(ctx, w, http.StatusNotFound, fmt.Errorf("%s %s not found", r.Method, r.URL.Path))
+		}
+	}
+}
+
+// Stream writes Server-Sent Events named event, with the JSON of what render makes of the open orders as their data,
+// each time the open orders of source change, until the client goes away
+func Stream(w http.ResponseWriter, r *http.Request, source Source, event string, render func(orders []*orderType.Order, now time.Time) interface{}) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	boards := make(chan interface{})
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- source.Watch(ctx, func(orders []*orderType.Order) error {
+			select {
+			case boards <- render(orders, time.Now()):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		slog.ErrorContext(ctx, fmt.Sprintf("response can't be streamed: %v", err))
+		return
+	}
+
+	keepAlive := time.NewTicker(KeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case board := <-boards:
+			var data []byte
+			if data, err = json.Marshal(board); err == nil {
+				_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+			}
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		case err = <-watchErr:
+			if err != nil {
+				slog.ErrorContext(ctx, err.Error())
+			}
+			// the display reconnects, resuming the stream
+			return
+		case <-ctx.Done():
+			return
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			slog.WarnContext(ctx, fmt.Sprintf("stream ended: %v", err))
+			return
+		}
+	}
+}
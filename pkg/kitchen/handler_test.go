@@ -0,0 +1,129 @@
+package kitchen
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/orders"
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+// fakeSource sends each of its snapshots, then waits for the stream to end
+type fakeSource struct {
+	snapshots [][]*orderType.Order
+}
+
+func (f *fakeSource) Watch(ctx context.Context, fn func([]*orderType.Order) error) error {
+	for _, orders := range f.snapshots {
+		if err := fn(orders); err != nil {
+			return err
+		}
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// fakeService transitions the orders it holds; the other methods of orders.Service are not used by the display
+type fakeService struct {
+	orders.Service
+	orders map[string]*orderType.Order
+}
+
+func (f *fakeService) Transition(ctx context.Context, id string, next orderType.OrderStatus) (*orderType.Order, error) {
+	o, ok := f.orders[id]
+	if !ok {
+		return nil, orders.ErrNotFound
+	}
+	if err := o.Transition(next, time.Now()); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func TestHandlerBump(t *testing.T) {
+	service := &fakeService{orders: map[string]*orderType.Order{
+		"labeled": {ID: "labeled", Number: 12, Status: orderType.ORDER_STATUS_LABELED},
+		"online":  {ID: "online", Number: 13, Status: orderType.ORDER_STATUS_ONLINE},
+	}}
+	handler := Handler(&fakeSource{}, ServiceBumper{Service: service})
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		want   int
+	}{
+		{"bump", http.MethodPost, "/orders/labeled/bump", http.StatusAccepted},
+		{"bump again", http.MethodPost, "/orders/labeled/bump", http.StatusConflict},
+		{"not labeled", http.MethodPost, "/orders/online/bump", http.StatusConflict},
+		{"missing", http.MethodPost, "/orders/missing/bump", http.StatusNotFound},
+		{"no id", http.MethodPost, "/orders//bump", http.StatusNotFound},
+		{"wrong method", http.MethodGet, "/orders/labeled/bump", http.StatusNotFound},
+		{"page", http.MethodGet, "/", http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+		if w.Code != tt.want {
+			t.Errorf("%s: got %d, want %d: %s", tt.name, w.Code, tt.want, w.Body.String())
+		}
+	}
+
+	if o := service.orders["labeled"]; o.Status != orderType.ORDER_STATUS_READY || len(o.StatusTransitions) != 1 {
+		t.Errorf("bumped order was not moved to READY with a transition: %+v", o)
+	}
+}
+
+func TestHandlerEvents(t *testing.T) {
+	source := &fakeSource{snapshots: [][]*orderType.Order{
+		{{ID: "a", Number: 1, Status: orderType.ORDER_STATUS_PRESENT}},
+		{{ID: "a", Number: 1, Status: orderType.ORDER_STATUS_LABELED}, {ID: "b", Number: 2, Expedite: true, Status: orderType.ORDER_STATUS_LABELED}},
+	}}
+	server := httptest.NewServer(Handler(source, ServiceBumper{}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("got content type %q", contentType)
+	}
+
+	var boards []Board
+	scanner := bufio.NewScanner(resp.Body)
+	for len(boards) < len(source.snapshots) && scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var board Board
+		if err := json.Unmarshal([]byte(data), &board); err != nil {
+			t.Fatal(err)
+		}
+		boards = append(boards, board)
+	}
+	if len(boards) != len(source.snapshots) {
+		t.Fatalf("got %d boards, want %d: %v", len(boards), len(source.snapshots), scanner.Err())
+	}
+
+	if tickets := boards[0].Columns[1].Tickets; len(tickets) != 1 || tickets[0].ID != "a" {
+		t.Errorf("first board has present tickets %+v", tickets)
+	}
+	if tickets := boards[1].Columns[2].Tickets; len(tickets) != 2 || tickets[0].ID != "b" || !tickets[0].Expedite {
+		t.Errorf("second board has labeled tickets %+v, want the expedited order first", tickets)
+	}
+}
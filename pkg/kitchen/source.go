@@ -0,0 +1,61 @@
+package kitchen
+
+import (
+	"context"
+
+	"cloud.google.com/go/firestore"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+// Source provides the open orders of a fundraiser as they change
+type Source interface {
+	// Watch calls fn with every open order each time any of them changes, until ctx is done (returning nil) or fn
+	// returns an error
+	Watch(ctx context.Context, fn func([]*orderType.Order) error) error
+}
+
+var _ Source = (*FirestoreSource)(nil)
+
+// FirestoreSource watches the orders of a fundraiser with a Firestore listener
+type FirestoreSource struct {
+	client       *firestore.Client
+	fundraiserID string
+}
+
+// NewSource returns the Source of the fundraiser's orders
+func NewSource(client *firestore.Client, fundraiserID string) *FirestoreSource {
+	return &FirestoreSource{client: client, fundraiserID: fundraiserID}
+}
+
+// Watch calls fn with the open orders in each snapshot of the orders collection
+func (s *FirestoreSource) Watch(ctx context.Context, fn func([]*orderType.Order) error) error {
+	snapshots := s.client.Collection(fundraiser.Path(s.fundraiserID, "orders")).Where("status", "in", OPEN_STATUSES).Snapshots(ctx)
+	defer snapshots.Stop()
+
+	for {
+		snapshot, err := snapshots.Next()
+		if ctx.Err() != nil {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		docSnaps, err := snapshot.Documents.GetAll()
+		if err != nil {
+			return err
+		}
+		orders := make([]*orderType.Order, 0, len(docSnaps))
+		for _, docSnap := range docSnaps {
+			o := &orderType.Order{}
+			if err := docSnap.DataTo(o); err != nil {
+				return err
+			}
+			orders = append(orders, o)
+		}
+		if err := fn(orders); err != nil {
+			return err
+		}
+	}
+}
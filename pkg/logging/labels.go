@@ -0,0 +1,143 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// structured log fields that Cloud Logging promotes into the log entry
+const (
+	LabelsKey       = "logging.googleapis.com/labels"
+	SpanIDKey       = "logging.googleapis.com/spanId"
+	TraceKey        = "logging.googleapis.com/trace"
+	TraceSampledKey = "logging.googleapis.com/trace_sampled"
+)
+
+// well-known label keys; use these so the same entity is filterable the same way across every function
+const (
+	LabelFunction     = "function"
+	LabelCustomerID   = "customerID"
+	LabelDeadLetterID = "deadLetterID"
+	LabelFundraiserID = "fundraiserID"
+	LabelLabelID      = "labelID"
+	LabelOrderID      = "orderID"
+	LabelOrderNumber  = "orderNumber"
+	LabelPaymentID    = "paymentID"
+	LabelRefundID     = "refundID"
+)
+
+type labelsContextKey struct{}
+
+// WithLabel returns a copy of ctx carrying the label; any log entry written with the returned context (or one
+// derived from it) through a LabelHandler includes the label. Setting a key that is already present replaces it.
+// Empty values are ignored so callers need not check for missing IDs.
+func WithLabel(ctx context.Context, key, value string) context.Context {
+	if value == "" {
+		return ctx
+	}
+
+	existing := labelsFromContext(ctx)
+	labels := make([]slog.Attr, 0, len(existing)+1)
+	for _, label := range existing {
+		if label.Key != key {
+			labels = append(labels, label)
+		}
+	}
+	labels = append(labels, slog.String(key, value))
+	return context.WithValue(ctx, labelsContextKey{}, labels)
+}
+
+// WithOrderNumber returns a copy of ctx carrying the order number label
+func WithOrderNumber(ctx context.Context, orderNumber uint16) context.Context {
+	if orderNumber == 0 {
+		return ctx
+	}
+	return WithLabel(ctx, LabelOrderNumber, strconv.FormatUint(uint64(orderNumber), 10))
+}
+
+// Labels returns the labels carried by ctx
+func Labels(ctx context.Context) map[string]string {
+	labels := labelsFromContext(ctx)
+	result := make(map[string]string, len(labels))
+	for _, label := range labels {
+		result[label.Key] = label.Value.String()
+	}
+	return result
+}
+
+func labelsFromContext(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	labels, _ := ctx.Value(labelsContextKey{}).([]slog.Attr)
+	return labels
+}
+
+// LabelHandler is a slog.Handler which emits its static labels and those carried by the context of each
+// record as a single Cloud Logging labels group
+//
+// Records written within a span also carry the Cloud Logging trace fields, linking them to the trace
+type LabelHandler struct {
+	handler   slog.Handler
+	labels    []slog.Attr
+	projectID string
+}
+
+// NewLabelHandler wraps handler so that every record includes the given static labels
+func NewLabelHandler(handler slog.Handler, labels ...slog.Attr) *LabelHandler {
+	return &LabelHandler{handler: handler, labels: labels, projectID: os.Getenv("GCP_PROJECT")}
+}
+
+func (h *LabelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *LabelHandler) Handle(ctx context.Context, r slog.Record) error {
+	labels := slices.Clip(h.labels)
+	for _, label := range labelsFromContext(ctx) {
+		// static labels (e.g. function name) take precedence over those from the context
+		if !slices.ContainsFunc(h.labels, func(a slog.Attr) bool { return a.Key == label.Key }) {
+			labels = append(labels, label)
+		}
+	}
+
+	spanContext := trace.SpanContextFromContext(ctx)
+	if len(labels) > 0 || spanContext.IsValid() {
+		r = r.Clone()
+	}
+	if len(labels) > 0 {
+		r.AddAttrs(slog.Attr{Key: LabelsKey, Value: slog.GroupValue(labels...)})
+	}
+	if spanContext.IsValid() {
+		r.AddAttrs(
+			slog.String(TraceKey, h.traceName(spanContext.TraceID())),
+			slog.String(SpanIDKey, spanContext.SpanID().String()),
+			slog.Bool(TraceSampledKey, spanContext.IsSampled()),
+		)
+	}
+	return h.handler.Handle(ctx, r)
+}
+
+// traceName returns the trace resource name Cloud Logging expects in TraceKey
+func (h *LabelHandler) traceName(traceID trace.TraceID) string {
+	if h.projectID == "" {
+		return traceID.String()
+	}
+	return fmt.Sprintf("projects/%s/traces/%s", h.projectID, traceID)
+}
+
+func (h *LabelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &LabelHandler{handler: h.handler.WithAttrs(attrs), labels: h.labels, projectID: h.projectID}
+}
+
+// WithGroup qualifies subsequent attributes, which would include the labels; as Cloud Logging only
+// recognizes labels at the top level of the entry, avoid groups on loggers whose labels matter
+func (h *LabelHandler) WithGroup(name string) slog.Handler {
+	return &LabelHandler{handler: h.handler.WithGroup(name), labels: h.labels, projectID: h.projectID}
+}
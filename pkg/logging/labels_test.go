@@ -0,0 +1,94 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func logLine(t *testing.T, logger func(*slog.Logger)) map[string]interface{} {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	handler := NewLabelHandler(slog.NewJSONHandler(buf, nil), slog.String(LabelFunction, "test-function"))
+	logger(slog.New(handler))
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("unmarshalling %q: %v", buf.String(), err)
+	}
+	return line
+}
+
+func TestLabelsFromContext(t *testing.T) {
+	ctx := WithLabel(context.Background(), LabelOrderID, "order-1")
+	ctx = WithOrderNumber(ctx, 1042)
+	ctx = WithLabel(ctx, LabelPaymentID, "")
+	ctx = WithLabel(ctx, LabelOrderID, "order-2")
+	ctx = WithLabel(ctx, LabelFunction, "overridden")
+
+	line := logLine(t, func(l *slog.Logger) { l.With("attr", "value").InfoContext(ctx, "hello") })
+
+	labels, ok := line[LabelsKey].(map[string]interface{})
+	if !ok {
+		t.Fatalf("no labels in %v", line)
+	}
+	want := map[string]interface{}{
+		LabelFunction:    "test-function",
+		LabelOrderID:     "order-2",
+		LabelOrderNumber: "1042",
+	}
+	if len(labels) != len(want) {
+		t.Errorf("labels = %v, want %v", labels, want)
+	}
+	for k, v := range want {
+		if labels[k] != v {
+			t.Errorf("label %q = %v, want %v", k, labels[k], v)
+		}
+	}
+	if line["attr"] != "value" {
+		t.Errorf("attr = %v, want value", line["attr"])
+	}
+}
+
+func TestLabelsWithoutContext(t *testing.T) {
+	line := logLine(t, func(l *slog.Logger) { l.Info("hello") })
+
+	labels, ok := line[LabelsKey].(map[string]interface{})
+	if !ok || len(labels) != 1 || labels[LabelFunction] != "test-function" {
+		t.Errorf("labels = %v, want only function", line[LabelsKey])
+	}
+}
+
+func TestLabelsCopyOnWrite(t *testing.T) {
+	parent := WithLabel(context.Background(), LabelOrderID, "order-1")
+	_ = WithLabel(parent, LabelPaymentID, "payment-1")
+
+	if got := Labels(parent); len(got) != 1 || got[LabelOrderID] != "order-1" {
+		t.Errorf("parent labels mutated: %v", got)
+	}
+}
+
+func TestTraceFields(t *testing.T) {
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x0a, 0xf7, 0x65, 0x19, 0x16, 0xcd, 0x43, 0xdd, 0x84, 0x48, 0xeb, 0x21, 0x1c, 0x80, 0x31, 0x9c},
+		SpanID:     trace.SpanID{0xb7, 0xad, 0x6b, 0x71, 0x69, 0x20, 0x33, 0x31},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), spanContext)
+
+	line := logLine(t, func(l *slog.Logger) { l.InfoContext(ctx, "hello") })
+
+	if line[TraceKey] != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("trace = %v", line[TraceKey])
+	}
+	if line[SpanIDKey] != "b7ad6b7169203331" {
+		t.Errorf("spanId = %v", line[SpanIDKey])
+	}
+	if line[TraceSampledKey] != true {
+		t.Errorf("trace_sampled = %v", line[TraceSampledKey])
+	}
+}
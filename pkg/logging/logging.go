@@ -5,7 +5,7 @@ import (
 	"os"
 )
 
-var Logger = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
+var jsonHandler = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
 	AddSource: true,
 	Level:     slog.LevelDebug,
 	ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
@@ -21,10 +21,12 @@ var Logger = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
 			}
 		}
 		return a
-	}}))
+	}})
+
+var Logger = slog.New(NewLabelHandler(jsonHandler))
 
 const LevelCritical = slog.Level(12)
 
 func FunctionLogger(functionName string) *slog.Logger {
-	return Logger.With(slog.Group("logging.googleapis.com/labels", "function", functionName))
+	return slog.New(NewLabelHandler(jsonHandler, slog.String(LabelFunction, functionName)))
 }
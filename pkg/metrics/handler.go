@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// CloudEvent wraps a CloudEvent function to record each invocation, flushing metrics before it returns
+func CloudEvent(name string, fn func(context.Context, event.Event) error) func(context.Context, event.Event) error {
+	return func(ctx context.Context, e event.Event) error {
+		start := time.Now()
+		err := fn(ctx, e)
+		RecordInvocation(ctx, name, time.Since(start), err)
+		Flush(ctx)
+		return err
+	}
+}
+
+// HTTP wraps an HTTP function to record each request, flushing metrics before it returns; responses with an
+// error status are recorded as failed invocations
+func HTTP(name string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		fn(recorder, r)
+
+		var err error
+		if recorder.status >= http.StatusBadRequest {
+			err = fmt.Errorf("responded with HTTP status %d", recorder.status)
+		}
+		RecordInvocation(r.Context(), name, time.Since(start), err)
+		Flush(r.Context())
+	}
+}
+
+// statusRecorder captures the status code written to the wrapped http.ResponseWriter
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, so that http.ResponseController can reach its optional interfaces,
+// e.g. http.Flusher for streamed responses
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
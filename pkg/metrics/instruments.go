@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// instruments are created against the global meter provider, which forwards to the provider installed by Init
+var (
+	functionInvocations, _ = meter().Int64Counter("fundraiser.function.invocations",
+		metric.WithDescription("Function invocations, by function and outcome"))
+	functionDuration, _ = meter().Float64Histogram("fundraiser.function.duration", metric.WithUnit("s"),
+		metric.WithDescription("Duration of function invocations"))
+
+	webhooksReceived, _ = meter().Int64Counter("fundraiser.webhooks.received",
+		metric.WithDescription("Verified Square webhooks received, by type"))
+	webhookSignatureFailures, _ = meter().Int64Counter("fundraiser.webhooks.signature_failures",
+		metric.WithDescription("Inbound webhooks rejected because their Square signature did not validate"))
+
+	squareAPIDuration, _ = meter().Float64Histogram("fundraiser.square.api.duration", metric.WithUnit("s"),
+		metric.WithDescription("Latency of Square API calls, by operation and status code"))
+	squareAPIErrors, _ = meter().Int64Counter("fundraiser.square.api.errors",
+		metric.WithDescription("Failed Square API calls, by operation"))
+
+	publishDuration, _ = meter().Float64Histogram("fundraiser.pubsub.publish.duration", metric.WithUnit("s"),
+		metric.WithDescription("Time for Pub/Sub to acknowledge a published event, by topic and outcome"),
+		// publishes are abandoned after 2s, so resolve the range leading up to it
+		metric.WithExplicitBucketBoundaries(0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 1.5, 2))
+
+	transactionRetries, _ = meter().Int64Counter("fundraiser.firestore.transaction.retries",
+		metric.WithDescription("Firestore transaction attempts beyond the first, by entity"))
+	writes, _ = meter().Int64Counter("fundraiser.writes",
+		metric.WithDescription("Square-sourced events applied to Firestore, by entity and outcome (including squelches)"))
+
+	deadLetters, _ = meter().Int64Counter("fundraiser.dead_letters",
+		metric.WithDescription("Pub/Sub messages acknowledged without being handled and captured for operators, by function"))
+
+	orderTimeToStatus, _ = meter().Float64Histogram("fundraiser.orders.time_to_status", metric.WithUnit("s"),
+		metric.WithDescription("Time from order creation until it reaches a status, by status"),
+		metric.WithExplicitBucketBoundaries(60, 120, 300, 600, 900, 1200, 1800, 2700, 3600, 7200))
+)
+
+// RecordInvocation records the outcome and duration of a function invocation
+func RecordInvocation(ctx context.Context, function string, duration time.Duration, err error) {
+	attrs := metric.WithAttributes(attribute.String("function", function), attribute.String("outcome", outcome(err)))
+	functionInvocations.Add(ctx, 1, attrs)
+	functionDuration.Record(ctx, duration.Seconds(), attrs)
+}
+
+// RecordWebhookReceived records a verified Square webhook of the given type
+func RecordWebhookReceived(ctx context.Context, webhookType string) {
+	webhooksReceived.Add(ctx, 1, metric.WithAttributes(attribute.String("type", webhookType)))
+}
+
+// RecordSignatureFailure records a webhook which failed signature validation
+func RecordSignatureFailure(ctx context.Context) {
+	webhookSignatureFailures.Add(ctx, 1)
+}
+
+// RecordSquareAPICall records the latency of a Square API call; statusCode is 0 if no response was received
+func RecordSquareAPICall(ctx context.Context, operation string, duration time.Duration, statusCode int, err error) {
+	squareAPIDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(
+		attribute.String("operation", operation),
+		attribute.String("status_code", strconv.Itoa(statusCode)),
+	))
+	if err != nil {
+		squareAPIErrors.Add(ctx, 1, metric.WithAttributes(attribute.String("operation", operation)))
+	}
+}
+
+// RecordPublish records how long a publish to the topic took to be acknowledged (or to fail)
+func RecordPublish(ctx context.Context, topic string, duration time.Duration, err error) {
+	publishDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(
+		attribute.String("topic", topic),
+		attribute.String("outcome", outcome(err)),
+	))
+}
+
+// RecordDeadLetter records a message the function gave up on and captured as a dead letter
+func RecordDeadLetter(ctx context.Context, function string) {
+	deadLetters.Add(ctx, 1, metric.WithAttributes(attribute.String("function", function)))
+}
+
+// RecordTransactionAttempts records the retries of a Firestore transaction which took the given number of attempts
+func RecordTransactionAttempts(ctx context.Context, entity string, attempts int) {
+	if attempts > 1 {
+		transactionRetries.Add(ctx, int64(attempts-1), metric.WithAttributes(attribute.String("entity", entity)))
+	}
+}
+
+// RecordWrite records the outcome of applying a Square-sourced event to an entity
+func RecordWrite(ctx context.Context, entity, outcome string) {
+	writes.Add(ctx, 1, metric.WithAttributes(attribute.String("entity", entity), attribute.String("outcome", outcome)))
+}
+
+// OrderCounter returns the number of orders of the fundraiser in each status
+type OrderCounter func(ctx context.Context, fundraiserID string) (map[string]int64, error)
+
+// ObserveOrdersByStatus reports the number of orders of each fundraiser in each status, as counted by count whenever
+// metrics are collected; being counted rather than tracked from changes, the series don't drift, but every instance
+// reports the same counts, so they should be aggregated by their maximum rather than summed
+func ObserveOrdersByStatus(fundraiserIDs []string, count OrderCounter) error {
+	_, err := meter().Int64ObservableGauge("fundraiser.orders.by_status",
+		metric.WithDescription("Orders currently in each status, by fundraiser"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			var errs []error
+			for _, fundraiserID := range fundraiserIDs {
+				counts, err := count(ctx, fundraiserID)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("fundraiser %s: %w", fundraiserID, err))
+					continue
+				}
+				for status, n := range counts {
+					o.Observe(n, metric.WithAttributes(attribute.String("fundraiser", fundraiserID), attribute.String("status", status)))
+				}
+			}
+			return errors.Join(errs...)
+		}))
+	return err
+}
+
+// RecordOrderTimeToStatus records how long an order took to reach the status
+func RecordOrderTimeToStatus(ctx context.Context, status string, elapsed time.Duration) {
+	orderTimeToStatus.Record(ctx, elapsed.Seconds(), metric.WithAttributes(attribute.String("status", status)))
+}
@@ -0,0 +1,134 @@
+// Package metrics records operational and business metrics for fundraiser-manager functions with OpenTelemetry,
+// so backlogs and failures show up on a dashboard rather than in customer complaints
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	mexporter "github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/metric"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// EXPORTER_ENV selects where metrics are exported:
+//   - "gcp" (the default) exports to Cloud Monitoring in GCP_PROJECT
+//   - "prometheus" serves them in Prometheus format on METRICS_ADDR_ENV, for local runs
+//   - "none" disables export
+const EXPORTER_ENV = "OTEL_METRICS_EXPORTER"
+
+// METRICS_ADDR_ENV is the listen address of the Prometheus endpoint; it defaults to DEFAULT_METRICS_ADDR
+const METRICS_ADDR_ENV = "METRICS_ADDR"
+
+const DEFAULT_METRICS_ADDR = ":9464"
+
+const (
+	instrumentationName = "github.com/kofc7186/fundraiser-manager"
+
+	// Cloud Monitoring rejects points written more often than every 5s for the same time series
+	exportInterval   = time.Minute
+	minFlushInterval = 10 * time.Second
+)
+
+var provider *sdkmetric.MeterProvider
+
+var flushMutex sync.Mutex
+var lastFlush time.Time
+
+// Init installs the global meter provider for the named function; it should be called from init() before
+// any handler is registered
+func Init(functionName string) error {
+	var reader sdkmetric.Reader
+	switch exporterName := os.Getenv(EXPORTER_ENV); exporterName {
+	case "none":
+		return nil
+	case "prometheus":
+		exporter, err := prometheus.New()
+		if err != nil {
+			return err
+		}
+		reader = exporter
+		servePrometheus()
+	case "", "gcp":
+		projectID, ok := os.LookupEnv("GCP_PROJECT")
+		if !ok {
+			return fmt.Errorf("GCP_PROJECT must be set to export metrics to Cloud Monitoring")
+		}
+		exporter, err := mexporter.New(mexporter.WithProjectID(projectID))
+		if err != nil {
+			return err
+		}
+		reader = sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(exportInterval))
+	default:
+		return fmt.Errorf("unsupported %s value %q", EXPORTER_ENV, exporterName)
+	}
+
+	provider = sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(reader),
+		sdkmetric.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", functionName),
+			// each function instance reports its own cumulative time series
+			attribute.String("service.instance.id", uuid.NewString()),
+		)),
+	)
+	otel.SetMeterProvider(provider)
+	return nil
+}
+
+func servePrometheus() {
+	addr := DEFAULT_METRICS_ADDR
+	if envAddr := os.Getenv(METRICS_ADDR_ENV); envAddr != "" {
+		addr = envAddr
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error(fmt.Sprintf("serving metrics on %s: %v", addr, err))
+		}
+	}()
+}
+
+// Flush exports recorded metrics; functions may be frozen between invocations, so handlers flush before
+// returning, though no more often than Cloud Monitoring accepts
+func Flush(ctx context.Context) {
+	if provider == nil {
+		return
+	}
+
+	flushMutex.Lock()
+	defer flushMutex.Unlock()
+	if time.Since(lastFlush) < minFlushInterval {
+		return
+	}
+	lastFlush = time.Now()
+	_ = provider.ForceFlush(ctx)
+}
+
+func meter() metric.Meter {
+	return otel.Meter(instrumentationName)
+}
+
+// outcome summarizes err as an attribute value; timeouts are distinguished from other errors
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	}
+	return "error"
+}
@@ -0,0 +1,187 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+var reader = sdkmetric.NewManualReader()
+
+func TestMain(m *testing.M) {
+	// the instruments forward to the first meter provider installed globally
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	os.Exit(m.Run())
+}
+
+// sumValue returns the value of the named sum for the data point with exactly the given attributes
+func sumValue(t *testing.T, name string, attrs ...attribute.KeyValue) int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	want := attribute.NewSet(attrs...)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			sum, ok := m.Data.(metricdata.Sum[int64])
+			if !ok {
+				t.Fatalf("%s is a %T, not an int64 sum", name, m.Data)
+			}
+			for _, dp := range sum.DataPoints {
+				if dp.Attributes.Equals(&want) {
+					return dp.Value
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// gaugeValue returns the value of the named gauge for the data point with exactly the given attributes
+func gaugeValue(t *testing.T, name string, attrs ...attribute.KeyValue) int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	want := attribute.NewSet(attrs...)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			gauge, ok := m.Data.(metricdata.Gauge[int64])
+			if !ok {
+				t.Fatalf("%s is a %T, not an int64 gauge", name, m.Data)
+			}
+			for _, dp := range gauge.DataPoints {
+				if dp.Attributes.Equals(&want) {
+					return dp.Value
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// histogramCount returns the number of observations of the named histogram with exactly the given attributes
+func histogramCount(t *testing.T, name string, attrs ...attribute.KeyValue) uint64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	want := attribute.NewSet(attrs...)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			histogram, ok := m.Data.(metricdata.Histogram[float64])
+			if !ok {
+				t.Fatalf("%s is a %T, not a float64 histogram", name, m.Data)
+			}
+			for _, dp := range histogram.DataPoints {
+				if dp.Attributes.Equals(&want) {
+					return dp.Count
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestRecordWrite(t *testing.T) {
+	ctx := context.Background()
+	RecordWrite(ctx, "payment", "squelched_duplicate")
+	RecordWrite(ctx, "payment", "squelched_duplicate")
+	RecordWrite(ctx, "payment", "created")
+
+	if got := sumValue(t, "fundraiser.writes", attribute.String("entity", "payment"), attribute.String("outcome", "squelched_duplicate")); got != 2 {
+		t.Errorf("duplicate squelches = %d, want 2", got)
+	}
+}
+
+func TestRecordPublishOutcome(t *testing.T) {
+	ctx := context.Background()
+	RecordPublish(ctx, "publish-test", 2*time.Second, fmt.Errorf("publishing: %w", context.DeadlineExceeded))
+	RecordPublish(ctx, "publish-test", 10*time.Millisecond, nil)
+
+	if got := histogramCount(t, "fundraiser.pubsub.publish.duration", attribute.String("topic", "publish-test"), attribute.String("outcome", "timeout")); got != 1 {
+		t.Errorf("timeouts = %d, want 1", got)
+	}
+	if got := histogramCount(t, "fundraiser.pubsub.publish.duration", attribute.String("topic", "publish-test"), attribute.String("outcome", "ok")); got != 1 {
+		t.Errorf("successes = %d, want 1", got)
+	}
+}
+
+func TestRecordTransactionAttempts(t *testing.T) {
+	ctx := context.Background()
+	RecordTransactionAttempts(ctx, "refund", 1)
+	RecordTransactionAttempts(ctx, "refund", 3)
+
+	if got := sumValue(t, "fundraiser.firestore.transaction.retries", attribute.String("entity", "refund")); got != 2 {
+		t.Errorf("retries = %d, want 2", got)
+	}
+}
+
+func TestObserveOrdersByStatus(t *testing.T) {
+	counts := map[string]map[string]int64{
+		"fishfry-022324": {"ONLINE": 3, "LABELED": 1},
+		"fishfry-030824": {"ONLINE": 5},
+	}
+	err := ObserveOrdersByStatus([]string{"fishfry-022324", "fishfry-030824"}, func(ctx context.Context, fundraiserID string) (map[string]int64, error) {
+		return counts[fundraiserID], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := gaugeValue(t, "fundraiser.orders.by_status", attribute.String("fundraiser", "fishfry-022324"), attribute.String("status", "ONLINE")); got != 3 {
+		t.Errorf("ONLINE orders = %d, want 3", got)
+	}
+	// the counts are observed afresh on each collection, rather than accumulated
+	counts["fishfry-030824"]["ONLINE"] = 4
+	if got := gaugeValue(t, "fundraiser.orders.by_status", attribute.String("fundraiser", "fishfry-030824"), attribute.String("status", "ONLINE")); got != 4 {
+		t.Errorf("ONLINE orders = %d, want 4", got)
+	}
+}
+
+func TestCloudEventRecordsInvocations(t *testing.T) {
+	failing := CloudEvent("FailingHandler", func(ctx context.Context, e event.Event) error {
+		return errors.New("failed")
+	})
+	if err := failing(context.Background(), event.New()); err == nil {
+		t.Fatal("expected the handler error to be returned")
+	}
+
+	if got := sumValue(t, "fundraiser.function.invocations", attribute.String("function", "FailingHandler"), attribute.String("outcome", "error")); got != 1 {
+		t.Errorf("failed invocations = %d, want 1", got)
+	}
+}
+
+func TestHTTPRecordsErrorStatus(t *testing.T) {
+	handler := HTTP("RejectingHandler", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+
+	if got := sumValue(t, "fundraiser.function.invocations", attribute.String("function", "RejectingHandler"), attribute.String("outcome", "error")); got != 1 {
+		t.Errorf("failed invocations = %d, want 1", got)
+	}
+}
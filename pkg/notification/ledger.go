@@ -0,0 +1,203 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
+)
+
+// DefaultClaimTTL is how long a claim is held before another attempt may take it over, such as when the instance which
+// claimed it stopped before it could complete or release it
+const DefaultClaimTTL = 2 * time.Minute
+
+// ErrInFlight is returned by Claim when the message is being sent by another attempt, which should be retried later
+var ErrInFlight = errors.New("notification is being sent by another attempt")
+
+// Ledger records the messages which were sent, so that each is sent only once however often the event causing it is
+// delivered; messages are keyed by order, kind and channel (see Key)
+type Ledger interface {
+	// Claim reserves the message of key to be sent, returning false if it was already sent, or ErrInFlight if it is
+	// claimed by another attempt; once that claim lapses it is taken over if takeOver and it wasn't abandoned,
+	// otherwise the message is reported as sent, as the attempt may have sent it without being able to record so
+	Claim(ctx context.Context, key string, takeOver bool) (bool, error)
+	// Complete records that the message of key was sent
+	Complete(ctx context.Context, key string) error
+	// Release gives up the claim of a message which wasn't sent, so that it may be sent by a later attempt
+	Release(ctx context.Context, key string) error
+	// Abandon gives up the claim of a message which may have been sent, leaving it to lapse without being taken over
+	Abandon(ctx context.Context, key string) error
+}
+
+// Key returns the key of the message of the kind about the order over the channel
+func Key(orderID string, kind Kind, channel Channel) string {
+	return fmt.Sprintf("%s-%s-%s", orderID, kind, channel)
+}
+
+const (
+	stateSending   = "SENDING"
+	stateSent      = "SENT"
+	stateAbandoned = "ABANDONED"
+)
+
+// record is the Firestore document of a message in the ledger
+type record struct {
+	ClaimedTime time.Time `firestore:"claimedTime"`
+	Expiration  time.Time `firestore:"expiration"`
+	SentTime    time.Time `firestore:"sentTime,omitempty"`
+	State       string    `firestore:"state"`
+}
+
+// claimable reports whether an attempt at now may claim the message recorded by r, which is nil if it was never
+// claimed; see Ledger.Claim
+func (r *record) claimable(now time.Time, claimTTL time.Duration, takeOver bool) (bool, error) {
+	switch {
+	case r == nil:
+		return true, nil
+	case r.State == stateSent:
+		return false, nil
+	case now.Sub(r.ClaimedTime) < claimTTL:
+		return false, ErrInFlight
+	}
+	return takeOver && r.State != stateAbandoned, nil
+}
+
+var _ Ledger = (*FirestoreLedger)(nil)
+
+// FirestoreLedger keeps the ledger of a fundraiser at fundraisers/<id>/notifications/<key>
+type FirestoreLedger struct {
+	client       *firestore.Client
+	fundraiserID string
+	expiration   time.Time
+
+	// ClaimTTL is how long a claim is held
+	ClaimTTL time.Duration
+}
+
+// NewFirestoreLedger returns the ledger of the fundraiser, whose documents expire (per the Firestore TTL policy) at
+// expiration like the rest of the fundraiser's documents
+func NewFirestoreLedger(client *firestore.Client, fundraiserID string, expiration time.Time) *FirestoreLedger {
+	return &FirestoreLedger{client: client, fundraiserID: fundraiserID, expiration: expiration, ClaimTTL: DefaultClaimTTL}
+}
+
+func (l *FirestoreLedger) doc(key string) *firestore.DocumentRef {
+	return l.client.Doc(fundraiser.Path(l.fundraiserID, "notifications", key))
+}
+
+func (l *FirestoreLedger) Claim(ctx context.Context, key string, takeOver bool) (bool, error) {
+	docRef := l.doc(key)
+	claimed := false
+	err := l.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		now := time.Now()
+		docSnap, err := tx.Get(docRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		var r *record
+		if docSnap.Exists() {
+			r = &record{}
+			if err := docSnap.DataTo(r); err != nil {
+				return err
+			}
+		}
+		if claimed, err = r.claimable(now, l.ClaimTTL, takeOver); err != nil || !claimed {
+			return err
+		}
+		return tx.Set(docRef, record{ClaimedTime: now, Expiration: l.expiration, State: stateSending})
+	})
+	if err != nil {
+		return false, fmt.Errorf("notification %s: %w", key, err)
+	}
+	return claimed, nil
+}
+
+func (l *FirestoreLedger) Complete(ctx context.Context, key string) error {
+	_, err := l.doc(key).Update(ctx, []firestore.Update{
+		{Path: "state", Value: stateSent},
+		{Path: "sentTime", Value: time.Now()},
+	})
+	if err != nil {
+		return fmt.Errorf("notification %s: %w", key, err)
+	}
+	return nil
+}
+
+func (l *FirestoreLedger) Abandon(ctx context.Context, key string) error {
+	if _, err := l.doc(key).Update(ctx, []firestore.Update{{Path: "state", Value: stateAbandoned}}); err != nil {
+		return fmt.Errorf("notification %s: %w", key, err)
+	}
+	return nil
+}
+
+func (l *FirestoreLedger) Release(ctx context.Context, key string) error {
+	if _, err := l.doc(key).Delete(ctx, firestore.Exists); err != nil && status.Code(err) != codes.NotFound {
+		return fmt.Errorf("notification %s: %w", key, err)
+	}
+	return nil
+}
+
+var _ Ledger = (*MemoryLedger)(nil)
+
+// MemoryLedger keeps the ledger in memory, for tests and local development; its claims lapse like those of
+// FirestoreLedger
+type MemoryLedger struct {
+	// ClaimTTL is how long a claim is held, DefaultClaimTTL if not set
+	ClaimTTL time.Duration
+
+	mu      sync.Mutex
+	records map[string]*record
+}
+
+func (l *MemoryLedger) Claim(ctx context.Context, key string, takeOver bool) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	claimTTL := l.ClaimTTL
+	if claimTTL == 0 {
+		claimTTL = DefaultClaimTTL
+	}
+
+	now := time.Now()
+	claimed, err := l.records[key].claimable(now, claimTTL, takeOver)
+	if err != nil {
+		return false, fmt.Errorf("notification %s: %w", key, err)
+	} else if !claimed {
+		return false, nil
+	}
+	if l.records == nil {
+		l.records = map[string]*record{}
+	}
+	l.records[key] = &record{ClaimedTime: now, State: stateSending}
+	return true, nil
+}
+
+func (l *MemoryLedger) Complete(ctx context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r, ok := l.records[key]; ok {
+		r.State, r.SentTime = stateSent, time.Now()
+	}
+	return nil
+}
+
+func (l *MemoryLedger) Release(ctx context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.records, key)
+	return nil
+}
+
+func (l *MemoryLedger) Abandon(ctx context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r, ok := l.records[key]; ok {
+		r.State = stateAbandoned
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Package notification tells customers about their orders: when an order is ready to be picked up, is taking longer
+// than expected, or was canceled, a message is rendered from a template and sent to the customer's email address and
+// phone number through the Provider of each Channel, at most once per order, kind and channel
+package notification
+
+import (
+	"time"
+
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+// Kind is what a customer is told about their order
+type Kind string
+
+const (
+	KIND_READY    Kind = "ready"
+	KIND_DELAYED  Kind = "delayed"
+	KIND_CANCELED Kind = "canceled"
+)
+
+// Channel is how a message reaches a customer
+type Channel string
+
+const (
+	CHANNEL_EMAIL Channel = "email"
+	CHANNEL_SMS   Channel = "sms"
+)
+
+// Message is a notification rendered for a single recipient
+type Message struct {
+	Channel Channel `json:"channel"`
+	// To is the email address or phone number of the recipient
+	To string `json:"to"`
+	// Subject is only used by CHANNEL_EMAIL
+	Subject string `json:"subject,omitempty"`
+	Body    string `json:"body"`
+}
+
+// KindOf returns what the customer is told about a change of the order from oldOrder to newOrder, or false if the
+// change doesn't concern them (e.g. the status didn't change)
+func KindOf(oldOrder, newOrder *orderType.Order) (Kind, bool) {
+	if newOrder == nil || (oldOrder != nil && oldOrder.Status == newOrder.Status) {
+		return "", false
+	}
+	switch newOrder.Status {
+	case orderType.ORDER_STATUS_READY:
+		return KIND_READY, true
+	case orderType.ORDER_STATUS_CANCELED:
+		return KIND_CANCELED, true
+	}
+	return "", false
+}
+
+// WaitingSince returns when the customer started waiting for the order: when it was first marked PRESENT (i.e. the
+// customer arrived) or LABELED (i.e. the kitchen started on it), or when it was created if neither was recorded
+func WaitingSince(o *orderType.Order) time.Time {
+	for _, transition := range o.StatusTransitions {
+		if transition.Status == orderType.ORDER_STATUS_PRESENT || transition.Status == orderType.ORDER_STATUS_LABELED {
+			return transition.Timestamp
+		}
+	}
+	return o.CreatedTime
+}
+
+// IsDelayed reports whether a customer has been waiting for o for longer than after as of now
+func IsDelayed(o *orderType.Order, now time.Time, after time.Duration) bool {
+	switch o.Status {
+	case orderType.ORDER_STATUS_PRESENT, orderType.ORDER_STATUS_LABELED:
+		return now.Sub(WaitingSince(o)) > after
+	}
+	return false
+}
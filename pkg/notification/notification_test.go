@@ -0,0 +1,64 @@
+package notification
+
+import (
+	"testing"
+	"time"
+
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+func TestKindOf(t *testing.T) {
+	tests := []struct {
+		old, new orderType.OrderStatus
+		want     Kind
+		ok       bool
+	}{
+		{orderType.ORDER_STATUS_LABELED, orderType.ORDER_STATUS_READY, KIND_READY, true},
+		{orderType.ORDER_STATUS_PRESENT, orderType.ORDER_STATUS_CANCELED, KIND_CANCELED, true},
+		{orderType.ORDER_STATUS_READY, orderType.ORDER_STATUS_READY, "", false},
+		{orderType.ORDER_STATUS_READY, orderType.ORDER_STATUS_CLOSED, "", false},
+		{orderType.ORDER_STATUS_PRESENT, orderType.ORDER_STATUS_LABELED, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := KindOf(&orderType.Order{Status: tt.old}, &orderType.Order{Status: tt.new})
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s to %s: got %q, %v; want %q, %v", tt.old, tt.new, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	if got, ok := KindOf(nil, &orderType.Order{Status: orderType.ORDER_STATUS_READY}); !ok || got != KIND_READY {
+		t.Errorf("created READY: got %q, %v", got, ok)
+	}
+	if _, ok := KindOf(&orderType.Order{Status: orderType.ORDER_STATUS_READY}, nil); ok {
+		t.Error("deleted order should not be notified")
+	}
+}
+
+func TestIsDelayed(t *testing.T) {
+	now := time.Date(2024, 3, 15, 17, 30, 0, 0, time.UTC)
+	online := &orderType.Order{
+		CreatedTime: now.Add(-2 * time.Hour),
+		Status:      orderType.ORDER_STATUS_LABELED,
+		StatusTransitions: []orderType.OrderStatusTransition{
+			{PreviousStatus: orderType.ORDER_STATUS_ONLINE, Status: orderType.ORDER_STATUS_PRESENT, Timestamp: now.Add(-10 * time.Minute)},
+			{PreviousStatus: orderType.ORDER_STATUS_PRESENT, Status: orderType.ORDER_STATUS_LABELED, Timestamp: now.Add(-5 * time.Minute)},
+		},
+	}
+	if !WaitingSince(online).Equal(now.Add(-10 * time.Minute)) {
+		t.Errorf("online order waiting since %s, want when the customer arrived", WaitingSince(online))
+	}
+	if IsDelayed(online, now, 15*time.Minute) {
+		t.Error("order waiting for 10m is not delayed after 15m")
+	}
+	if !IsDelayed(online, now, 5*time.Minute) {
+		t.Error("order waiting for 10m is delayed after 5m")
+	}
+
+	walkIn := &orderType.Order{CreatedTime: now.Add(-20 * time.Minute), Status: orderType.ORDER_STATUS_PRESENT}
+	if !IsDelayed(walkIn, now, 15*time.Minute) {
+		t.Error("walk-in order waiting since it was created for 20m is delayed after 15m")
+	}
+	if IsDelayed(&orderType.Order{CreatedTime: now.Add(-time.Hour), Status: orderType.ORDER_STATUS_ONLINE}, now, 15*time.Minute) {
+		t.Error("online order whose customer hasn't arrived is not delayed")
+	}
+}
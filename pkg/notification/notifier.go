@@ -0,0 +1,111 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// completeAttempts is how many times a sent message is recorded in the ledger before giving up, after which its claim
+// lapses (see Ledger.Claim)
+const completeAttempts = 3
+
+// completeBackoff is how long is waited after the first failed attempt to record a sent message, doubling after each
+const completeBackoff = 50 * time.Millisecond
+
+// Notifier sends the messages about orders to their customers
+type Notifier struct {
+	Templates *Templates
+	// Providers send the messages of each channel; channels without a provider are not used
+	Providers map[Channel]Provider
+	Ledger    Ledger
+}
+
+// Notify sends the message of the kind about the order of data over every channel with a provider for which the
+// customer left an address, unless the ledger records it as already sent; every channel is attempted, and the errors
+// of those which failed are returned together, so that a retry only sends the messages which weren't sent; sent
+// reports whether any message was sent by this call
+func (n *Notifier) Notify(ctx context.Context, kind Kind, data Data) (sent bool, err error) {
+	o := data.Order
+	addresses := map[Channel]string{CHANNEL_EMAIL: o.EmailAddress, CHANNEL_SMS: o.PhoneNumber}
+
+	channels := make([]Channel, 0, len(n.Providers))
+	for channel := range n.Providers {
+		channels = append(channels, channel)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+
+	var errs []error
+	for _, channel := range channels {
+		to := addresses[channel]
+		if to == "" {
+			slog.DebugContext(ctx, fmt.Sprintf("no %s address to send %s notification to", channel, kind))
+			continue
+		}
+		ok, err := n.send(ctx, kind, channel, to, data)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		sent = sent || ok
+	}
+	return sent, errors.Join(errs...)
+}
+
+// send sends a single message, recording it in the ledger; sent reports whether it was sent by this call
+func (n *Notifier) send(ctx context.Context, kind Kind, channel Channel, to string, data Data) (sent bool, err error) {
+	key := Key(data.Order.ID, kind, channel)
+	message, err := n.Templates.Render(kind, channel, to, data)
+	if err != nil {
+		return false, err
+	}
+
+	// a delayed message is looked for every few minutes, so a lapsed claim of one is taken to have been sent rather
+	// than risk texting the customer twice; the other kinds are only sent again on a redelivery of their event, and
+	// are worth the risk
+	claimed, err := n.Ledger.Claim(ctx, key, kind != KIND_DELAYED)
+	if err != nil {
+		return false, err
+	} else if !claimed {
+		slog.DebugContext(ctx, fmt.Sprintf("%s notification was already sent by %s", kind, channel))
+		return false, nil
+	}
+
+	if err := n.Providers[channel].Send(ctx, message); err != nil {
+		if errors.Is(err, ErrNotDelivered) {
+			if releaseErr := n.Ledger.Release(ctx, key); releaseErr != nil {
+				// the claim will lapse after its TTL instead
+				slog.WarnContext(ctx, releaseErr.Error())
+			}
+		} else if abandonErr := n.Ledger.Abandon(ctx, key); abandonErr != nil {
+			// the message may have been delivered, so it mustn't be sent again; if the claim can't be abandoned it
+			// lapses like that of an attempt which stopped
+			slog.WarnContext(ctx, abandonErr.Error())
+		}
+		return false, fmt.Errorf("%s notification by %s: %w", kind, channel, err)
+	}
+	slog.InfoContext(ctx, fmt.Sprintf("sent %s notification by %s", kind, channel))
+	if err := n.complete(ctx, key); err != nil {
+		// the message was sent, so the event isn't retried; the claim stays in place until its TTL lapses
+		slog.ErrorContext(ctx, err.Error())
+	}
+	return true, nil
+}
+
+// complete records the message of key as sent, retrying as a claim left in place may later be taken over
+func (n *Notifier) complete(ctx context.Context, key string) (err error) {
+	backoff := completeBackoff
+	for attempt := 1; ; attempt++ {
+		if err = n.Ledger.Complete(ctx, key); err == nil || attempt == completeAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,211 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+// failingProvider fails the first failures sends, then passes messages on to the sink; unless delivered, the failures
+// are known not to have delivered the message
+type failingProvider struct {
+	Sink
+	failures  int
+	delivered bool
+}
+
+func (p *failingProvider) Send(ctx context.Context, message Message) error {
+	if p.failures > 0 {
+		p.failures--
+		if p.delivered {
+			p.Sink.Send(ctx, message)
+			return errors.New("gateway timed out")
+		}
+		return NotDelivered(errors.New("gateway unavailable"))
+	}
+	return p.Sink.Send(ctx, message)
+}
+
+// failingLedger fails to record the first failures sent messages
+type failingLedger struct {
+	*MemoryLedger
+	failures int
+}
+
+func (l *failingLedger) Complete(ctx context.Context, key string) error {
+	if l.failures > 0 {
+		l.failures--
+		return errors.New("firestore unavailable")
+	}
+	return l.MemoryLedger.Complete(ctx, key)
+}
+
+func TestNotifyOnce(t *testing.T) {
+	templates, err := ParseTemplates(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	email, sms := &Sink{}, &failingProvider{failures: 1}
+	notifier := &Notifier{
+		Templates: templates,
+		Providers: map[Channel]Provider{CHANNEL_EMAIL: email, CHANNEL_SMS: sms},
+		Ledger:    &MemoryLedger{},
+	}
+	data := NewData(&orderType.Order{ID: "CAISENgvlJ6jLWAzERDzjyHVybY", Code: "W-1042", EmailAddress: "jane@example.com", PhoneNumber: "+15555551234"}, "", "")
+	ctx := context.Background()
+
+	// the text fails, so the event is retried; the retry only sends the text
+	if _, err := notifier.Notify(ctx, KIND_READY, data); err == nil || !failure.IsRetryable(err) {
+		t.Fatalf("expected a retryable error, got %v", err)
+	}
+	if sent, err := notifier.Notify(ctx, KIND_READY, data); !sent || err != nil {
+		t.Fatalf("got %v, %v; want the text sent", sent, err)
+	}
+	// a redelivery of the event sends nothing
+	if sent, err := notifier.Notify(ctx, KIND_READY, data); sent || err != nil {
+		t.Fatalf("got %v, %v; want nothing sent", sent, err)
+	}
+	if got := len(email.Messages()); got != 1 {
+		t.Errorf("sent %d emails, want 1", got)
+	}
+	if got := len(sms.Messages()); got != 1 {
+		t.Errorf("sent %d texts, want 1", got)
+	}
+
+	// another kind of notification is sent, but only where there's an address
+	data.Order.PhoneNumber = ""
+	if _, err := notifier.Notify(ctx, KIND_CANCELED, data); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(email.Messages()); got != 2 {
+		t.Errorf("sent %d emails, want 2", got)
+	}
+	if got := len(sms.Messages()); got != 1 {
+		t.Errorf("sent %d texts, want 1", got)
+	}
+}
+
+func TestMemoryLedgerClaim(t *testing.T) {
+	ledger := &MemoryLedger{ClaimTTL: 20 * time.Millisecond}
+	ctx := context.Background()
+	if claimed, err := ledger.Claim(ctx, "a", true); !claimed || err != nil {
+		t.Fatalf("got %v, %v", claimed, err)
+	}
+	if _, err := ledger.Claim(ctx, "a", true); !errors.Is(err, ErrInFlight) {
+		t.Errorf("got %v, want %v", err, ErrInFlight)
+	}
+
+	// once the claim lapses, e.g. because the attempt holding it stopped, it is taken over or taken as sent
+	time.Sleep(ledger.ClaimTTL)
+	if claimed, err := ledger.Claim(ctx, "a", false); claimed || err != nil {
+		t.Errorf("got %v, %v; want the lapsed claim taken as sent", claimed, err)
+	}
+	if claimed, err := ledger.Claim(ctx, "a", true); !claimed || err != nil {
+		t.Errorf("got %v, %v; want the lapsed claim taken over", claimed, err)
+	}
+
+	if err := ledger.Complete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(ledger.ClaimTTL)
+	if claimed, err := ledger.Claim(ctx, "a", true); claimed || err != nil {
+		t.Errorf("got %v, %v; want a sent message never claimed again", claimed, err)
+	}
+}
+
+func TestNotifyTakesOverLapsedClaim(t *testing.T) {
+	templates, err := ParseTemplates(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	email := &Sink{}
+	ledger := &MemoryLedger{ClaimTTL: 20 * time.Millisecond}
+	notifier := &Notifier{Templates: templates, Providers: map[Channel]Provider{CHANNEL_EMAIL: email}, Ledger: ledger}
+	data := NewData(&orderType.Order{ID: "CAISENgvlJ6jLWAzERDzjyHVybY", EmailAddress: "jane@example.com"}, "", "")
+	ctx := context.Background()
+
+	// an attempt claimed the message, then stopped before sending it
+	if _, err := ledger.Claim(ctx, Key(data.Order.ID, KIND_READY, CHANNEL_EMAIL), true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := notifier.Notify(ctx, KIND_READY, data); !errors.Is(err, ErrInFlight) {
+		t.Fatalf("got %v, want %v", err, ErrInFlight)
+	}
+	time.Sleep(ledger.ClaimTTL)
+	if _, err := notifier.Notify(ctx, KIND_READY, data); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(email.Messages()); got != 1 {
+		t.Errorf("sent %d emails, want 1", got)
+	}
+}
+
+func TestNotifyDelayedWhenCompleteFails(t *testing.T) {
+	templates, err := ParseTemplates(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sms := &Sink{}
+	ledger := &failingLedger{MemoryLedger: &MemoryLedger{ClaimTTL: 20 * time.Millisecond}, failures: completeAttempts}
+	notifier := &Notifier{Templates: templates, Providers: map[Channel]Provider{CHANNEL_SMS: sms}, Ledger: ledger}
+	data := NewData(&orderType.Order{ID: "CAISENgvlJ6jLWAzERDzjyHVybY", PhoneNumber: "+15555551234"}, "", "")
+	ctx := context.Background()
+
+	// the text is sent, but every attempt to record it fails
+	if _, err := notifier.Notify(ctx, KIND_DELAYED, data); err != nil {
+		t.Fatal(err)
+	}
+	// the next scheduled run, after the claim lapsed, doesn't text the customer again
+	time.Sleep(ledger.ClaimTTL)
+	if _, err := notifier.Notify(ctx, KIND_DELAYED, data); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(sms.Messages()); got != 1 {
+		t.Errorf("sent %d texts, want 1", got)
+	}
+
+	// recording a sent message is retried, so a passing failure leaves nothing to lapse
+	ledger.failures = completeAttempts - 1
+	if _, err := notifier.Notify(ctx, KIND_READY, data); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(ledger.ClaimTTL)
+	if _, err := notifier.Notify(ctx, KIND_READY, data); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(sms.Messages()); got != 2 {
+		t.Errorf("sent %d texts, want 2", got)
+	}
+}
+
+func TestNotifyAbandonsUncertainSend(t *testing.T) {
+	templates, err := ParseTemplates(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the gateway times out after sending the text
+	sms := &failingProvider{failures: 1, delivered: true}
+	ledger := &MemoryLedger{ClaimTTL: 20 * time.Millisecond}
+	notifier := &Notifier{Templates: templates, Providers: map[Channel]Provider{CHANNEL_SMS: sms}, Ledger: ledger}
+	data := NewData(&orderType.Order{ID: "CAISENgvlJ6jLWAzERDzjyHVybY", PhoneNumber: "+15555551234"}, "", "")
+	ctx := context.Background()
+
+	if _, err := notifier.Notify(ctx, KIND_READY, data); err == nil {
+		t.Fatal("expected an error")
+	}
+	// the claim isn't released, and isn't taken over once it lapses
+	if _, err := notifier.Notify(ctx, KIND_READY, data); !errors.Is(err, ErrInFlight) {
+		t.Fatalf("got %v, want %v", err, ErrInFlight)
+	}
+	time.Sleep(ledger.ClaimTTL)
+	if sent, err := notifier.Notify(ctx, KIND_READY, data); sent || err != nil {
+		t.Fatalf("got %v, %v; want nothing sent", sent, err)
+	}
+	if got := len(sms.Messages()); got != 1 {
+		t.Errorf("sent %d texts, want 1", got)
+	}
+}
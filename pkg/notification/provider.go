@@ -0,0 +1,70 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// Provider sends messages over a channel; an error which retrying won't resolve (e.g. a rejected recipient) should be
+// marked with failure.Permanent, and one after which the provider knows the message wasn't delivered (e.g. it failed
+// before connecting, or the request was rejected) with NotDelivered
+type Provider interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// ErrNotDelivered marks the errors of providers which know the message wasn't delivered, so that it may be sent again;
+// after any other error (e.g. a timeout) the message may have reached the customer
+var ErrNotDelivered = errors.New("notification not delivered")
+
+// NotDelivered marks err as a failure after which the message wasn't delivered
+func NotDelivered(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &notDelivered{err: err}
+}
+
+// notDelivered is an error which is ErrNotDelivered, keeping the message of the error it wraps
+type notDelivered struct {
+	err error
+}
+
+func (e *notDelivered) Error() string {
+	return e.err.Error()
+}
+
+func (e *notDelivered) Unwrap() error {
+	return e.err
+}
+
+func (e *notDelivered) Is(target error) bool {
+	return target == ErrNotDelivered
+}
+
+var _ Provider = (*Sink)(nil)
+
+// Sink is a Provider which keeps the messages sent to it rather than sending them, standing in for the real providers
+// in tests and local development
+type Sink struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// Send logs and keeps the message
+func (s *Sink) Send(ctx context.Context, message Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, message)
+	slog.InfoContext(ctx, fmt.Sprintf("notification sink received %s message to %s", message.Channel, message.To), "subject", message.Subject, "body", message.Body)
+	return nil
+}
+
+// Messages returns the messages sent to the sink so far
+func (s *Sink) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
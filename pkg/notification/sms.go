@@ -0,0 +1,79 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+	"github.com/kofc7186/fundraiser-manager/pkg/secrets"
+)
+
+var _ Provider = (*HTTPSMSProvider)(nil)
+
+// HTTPSMSProvider sends text messages by posting them to the HTTP API of an SMS gateway (or a relay in front of one)
+// as {"from": "...", "to": "...", "body": "..."}, authenticated with a bearer token
+type HTTPSMSProvider struct {
+	// URL is where each message is posted
+	URL string
+	// From is the number (or sender ID) the messages are sent from, if the gateway requires one
+	From  string
+	Token *secrets.Value
+	// Client sends the requests; if nil, http.DefaultClient is used
+	Client *http.Client
+}
+
+// Send posts the message to the gateway; rejections (4xx other than 429) are permanent failures, and every response
+// other than a success means the message wasn't delivered
+func (p *HTTPSMSProvider) Send(ctx context.Context, message Message) error {
+	body, err := json.Marshal(map[string]string{"from": p.From, "to": message.To, "body": message.Body})
+	if err != nil {
+		return failure.Permanent(NotDelivered(err))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return failure.Permanent(NotDelivered(fmt.Errorf("sms: %w", err)))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.Token != nil {
+		token, err := p.Token.Get(ctx)
+		if err != nil {
+			return NotDelivered(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return NotDelivered(fmt.Errorf("sms: %w", err))
+	} else if err != nil {
+		// e.g. a timeout, after which the gateway may have sent the message
+		return fmt.Errorf("sms: %w", err)
+	}
+	defer resp.Body.Close()
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusUnauthorized && p.Token != nil:
+		// the token may have been rotated, so it is re-read before the message is retried
+		if _, refreshErr := p.Token.Refresh(ctx); refreshErr != nil {
+			return NotDelivered(fmt.Errorf("sms: %s: %w", resp.Status, refreshErr))
+		}
+		return NotDelivered(fmt.Errorf("sms: %s: %s", resp.Status, detail))
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return NotDelivered(fmt.Errorf("sms: %s: %s", resp.Status, detail))
+	}
+	return failure.Permanent(NotDelivered(fmt.Errorf("sms: %s: %s", resp.Status, detail)))
+}
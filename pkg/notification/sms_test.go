@@ -0,0 +1,85 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/config"
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+	"github.com/kofc7186/fundraiser-manager/pkg/secrets"
+)
+
+func TestHTTPSMSProvider(t *testing.T) {
+	var status int
+	var received map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	provider := &HTTPSMSProvider{
+		URL:   server.URL,
+		From:  "+15555550000",
+		Token: secrets.NewValue(config.SecretsFile{}, "SMS_TOKEN", "token"),
+	}
+	message := Message{Channel: CHANNEL_SMS, To: "+15555551234", Body: "order W-1042 is ready for pickup."}
+	ctx := context.Background()
+
+	status = http.StatusAccepted
+	if err := provider.Send(ctx, message); err != nil {
+		t.Fatal(err)
+	}
+	if received["to"] != message.To || received["from"] != provider.From || received["body"] != message.Body {
+		t.Errorf("gateway received %v", received)
+	}
+
+	tests := map[int]failure.Class{
+		http.StatusBadRequest:          failure.CLASS_PERMANENT,
+		http.StatusTooManyRequests:     failure.CLASS_RETRYABLE,
+		http.StatusServiceUnavailable:  failure.CLASS_RETRYABLE,
+		http.StatusUnprocessableEntity: failure.CLASS_PERMANENT,
+	}
+	for code, want := range tests {
+		status = code
+		if err := provider.Send(ctx, message); err == nil || failure.ClassOf(err) != want || !errors.Is(err, ErrNotDelivered) {
+			t.Errorf("%d: got %v, want an undelivered %s error", code, err, want)
+		}
+	}
+}
+
+func TestHTTPSMSProviderTimeout(t *testing.T) {
+	// the gateway doesn't answer before the client gives up
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	provider := &HTTPSMSProvider{URL: server.URL, Client: &http.Client{Timeout: 20 * time.Millisecond}}
+	message := Message{Channel: CHANNEL_SMS, To: "+15555551234"}
+	if err := provider.Send(context.Background(), message); err == nil || errors.Is(err, ErrNotDelivered) {
+		t.Errorf("got %v, want an error which may have delivered the text", err)
+	}
+
+	// the gateway can't be reached
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	provider.URL = closed.URL
+	if err := provider.Send(context.Background(), message); !errors.Is(err, ErrNotDelivered) {
+		t.Errorf("got %v, want an undelivered error", err)
+	}
+}
@@ -0,0 +1,153 @@
+package notification
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+	"github.com/kofc7186/fundraiser-manager/pkg/secrets"
+)
+
+var _ Provider = (*SMTPProvider)(nil)
+
+// SMTPProvider sends email through an SMTP relay, upgrading the connection with STARTTLS where the relay offers it
+type SMTPProvider struct {
+	// Addr is the host:port of the relay, e.g. smtp.example.com:587
+	Addr string
+	// From is the sender of each email, e.g. "Fish Fry <fishfry@example.com>"
+	From string
+	// Username authenticates with Password (using PLAIN auth) if it is set
+	Username string
+	Password *secrets.Value
+}
+
+// Send sends the message as a plain text email
+func (p *SMTPProvider) Send(ctx context.Context, message Message) error {
+	from, err := mail.ParseAddress(p.From)
+	if err != nil {
+		return failure.Permanent(NotDelivered(fmt.Errorf("smtp: from: %w", err)))
+	}
+	to, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return failure.Permanent(NotDelivered(fmt.Errorf("smtp: to: %w", err)))
+	}
+
+	var auth smtp.Auth
+	if p.Username != "" {
+		password, err := p.Password.Get(ctx)
+		if err != nil {
+			return NotDelivered(err)
+		}
+		host, _, _ := net.SplitHostPort(p.Addr)
+		auth = smtp.PlainAuth("", p.Username, password, host)
+	}
+
+	// the SMTP client takes no context, so the send is abandoned (but not interrupted) if ctx is done first, leaving
+	// the message possibly delivered
+	sent := make(chan error, 1)
+	go func() {
+		sent <- p.deliver(auth, from.Address, to.Address, email(from, to, message))
+	}()
+	select {
+	case err = <-sent:
+	case <-ctx.Done():
+		return fmt.Errorf("smtp: %w", ctx.Err())
+	}
+
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code >= 500 {
+		// e.g. 550 mailbox unavailable, which retrying won't resolve
+		return failure.Permanent(fmt.Errorf("smtp: %w", err))
+	} else if err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+	return nil
+}
+
+// deliver sends the email through the relay like smtp.SendMail, except that the errors before the relay has the
+// whole message, or which are its rejection of the message, are marked with NotDelivered, and that the session
+// failing to end once the relay accepted the message isn't an error
+func (p *SMTPProvider) deliver(auth smtp.Auth, from, to string, msg []byte) error {
+	c, err := smtp.Dial(p.Addr)
+	if err != nil {
+		return NotDelivered(err)
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		host, _, _ := net.SplitHostPort(p.Addr)
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return NotDelivered(err)
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return NotDelivered(errors.New("server doesn't support AUTH"))
+		}
+		if err := c.Auth(auth); err != nil {
+			return NotDelivered(err)
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return NotDelivered(err)
+	}
+	if err := c.Rcpt(to); err != nil {
+		return NotDelivered(err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return NotDelivered(err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return NotDelivered(err)
+	}
+	if err := w.Close(); err != nil {
+		var protoErr *textproto.Error
+		if errors.As(err, &protoErr) {
+			return NotDelivered(err)
+		}
+		// the relay may have accepted the message before its reply was lost
+		return err
+	}
+	_ = c.Quit()
+	return nil
+}
+
+// email formats the message as an RFC 5322 email
+func email(from, to *mail.Address, message Message) []byte {
+	var b strings.Builder
+	headers := [][2]string{
+		{"From", from.String()},
+		{"To", to.String()},
+		{"Subject", mimeHeader(message.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", `text/plain; charset="utf-8"`},
+		{"Content-Transfer-Encoding", "8bit"},
+	}
+	for _, header := range headers {
+		fmt.Fprintf(&b, "%s: %s\r\n", header[0], header[1])
+	}
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(message.Body, "\r\n", "\n"), "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// mimeHeader encodes a header value which isn't ASCII
+func mimeHeader(value string) string {
+	for _, r := range value {
+		if r > 127 {
+			return mime.QEncoding.Encode("utf-8", value)
+		}
+	}
+	return value
+}
@@ -0,0 +1,101 @@
+package notification
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+// Data is what the templates of a message are rendered with
+type Data struct {
+	// Code is how the order is called at the pickup table: its short code, or its number if it has none
+	Code               string
+	FundraiserName     string
+	Order              *orderType.Order
+	PickupInstructions string
+}
+
+// NewData returns the Data of a message about o
+func NewData(o *orderType.Order, fundraiserName, pickupInstructions string) Data {
+	code := o.Code
+	if code == "" {
+		code = strconv.FormatUint(uint64(o.Number), 10)
+	}
+	return Data{Code: code, FundraiserName: fundraiserName, Order: o, PickupInstructions: pickupInstructions}
+}
+
+// DEFAULT_TEMPLATES are the text/templates of each message, keyed by "<kind>.<channel>"; the first line of an email's
+// template is its subject
+var DEFAULT_TEMPLATES = map[string]string{
+	"ready.email": `Order {{.Code}} is ready for pickup
+Hi {{with .Order.FirstName}}{{.}}{{else}}there{{end}},
+
+Your {{with .FundraiserName}}{{.}} {{end}}order {{.Code}} is ready.
+{{with .PickupInstructions}}
+{{.}}
+{{end}}
+Thank you!`,
+	"ready.sms": `{{with .FundraiserName}}{{.}}: {{end}}order {{.Code}} is ready for pickup.{{with .PickupInstructions}} {{.}}{{end}}`,
+	"delayed.email": `Order {{.Code}} is taking longer than expected
+Hi {{with .Order.FirstName}}{{.}}{{else}}there{{end}},
+
+Your {{with .FundraiserName}}{{.}} {{end}}order {{.Code}} is taking longer than expected. We're sorry for the wait, and will let you know as soon as it is ready.
+
+Thank you for your patience!`,
+	"delayed.sms": `{{with .FundraiserName}}{{.}}: {{end}}sorry, order {{.Code}} is taking longer than expected. We'll text you when it is ready.`,
+	"canceled.email": `Order {{.Code}} was canceled
+Hi {{with .Order.FirstName}}{{.}}{{else}}there{{end}},
+
+Your {{with .FundraiserName}}{{.}} {{end}}order {{.Code}} was canceled. Please see us at the pickup table if you have any questions.`,
+	"canceled.sms": `{{with .FundraiserName}}{{.}}: {{end}}order {{.Code}} was canceled. Please see us at the pickup table with any questions.`,
+}
+
+// Templates renders the messages of each kind and channel
+type Templates struct {
+	templates map[string]*template.Template
+}
+
+// ParseTemplates parses DEFAULT_TEMPLATES, replaced by any of the same key in overrides
+func ParseTemplates(overrides map[string]string) (*Templates, error) {
+	t := &Templates{templates: map[string]*template.Template{}}
+	for key, text := range DEFAULT_TEMPLATES {
+		if override, ok := overrides[key]; ok {
+			text = override
+		}
+		parsed, err := template.New(key).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("notification: template %s: %w", key, err)
+		}
+		t.templates[key] = parsed
+	}
+	for key := range overrides {
+		if _, ok := DEFAULT_TEMPLATES[key]; !ok {
+			return nil, fmt.Errorf("notification: %q is not a template, e.g. %q", key, "ready.sms")
+		}
+	}
+	return t, nil
+}
+
+// Render returns the message of the kind for the channel, addressed to to
+func (t *Templates) Render(kind Kind, channel Channel, to string, data Data) (Message, error) {
+	key := string(kind) + "." + string(channel)
+	tmpl, ok := t.templates[key]
+	if !ok {
+		return Message{}, fmt.Errorf("notification: no template %s", key)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return Message{}, fmt.Errorf("notification: template %s: %w", key, err)
+	}
+
+	message := Message{Channel: channel, To: to, Body: strings.TrimSpace(buf.String())}
+	if channel == CHANNEL_EMAIL {
+		message.Subject, message.Body, _ = strings.Cut(message.Body, "\n")
+		message.Body = strings.TrimSpace(message.Body)
+	}
+	return message, nil
+}
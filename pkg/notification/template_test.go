@@ -0,0 +1,55 @@
+package notification
+
+import (
+	"strings"
+	"testing"
+
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+func TestRender(t *testing.T) {
+	templates, err := ParseTemplates(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &orderType.Order{ID: "CAISENgvlJ6jLWAzERDzjyHVybY", Number: 1042, Code: "W-1042", FirstName: "Jane"}
+	data := NewData(o, "Fish Fry", "Please come to the pickup table in the gym.")
+
+	email, err := templates.Render(KIND_READY, CHANNEL_EMAIL, "jane@example.com", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email.Subject != "Order W-1042 is ready for pickup" {
+		t.Errorf("got subject %q", email.Subject)
+	}
+	for _, want := range []string{"Hi Jane,", "Your Fish Fry order W-1042 is ready.", "pickup table in the gym"} {
+		if !strings.Contains(email.Body, want) {
+			t.Errorf("email body %q does not contain %q", email.Body, want)
+		}
+	}
+
+	sms, err := templates.Render(KIND_READY, CHANNEL_SMS, "+15555551234", NewData(&orderType.Order{Number: 7}, "", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sms.Body != "order 7 is ready for pickup." || sms.Subject != "" || sms.To != "+15555551234" {
+		t.Errorf("got %+v", sms)
+	}
+}
+
+func TestParseTemplatesOverrides(t *testing.T) {
+	templates, err := ParseTemplates(map[string]string{"canceled.sms": "{{.Code}} canceled"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sms, err := templates.Render(KIND_CANCELED, CHANNEL_SMS, "+15555551234", NewData(&orderType.Order{Code: "W-1"}, "", ""))
+	if err != nil || sms.Body != "W-1 canceled" {
+		t.Errorf("got %+v, %v", sms, err)
+	}
+
+	for _, overrides := range []map[string]string{{"picked-up.sms": "x"}, {"ready.sms": "{{.Code"}} {
+		if _, err := ParseTemplates(overrides); err == nil {
+			t.Errorf("%v: expected an error", overrides)
+		}
+	}
+}
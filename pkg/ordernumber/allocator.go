@@ -0,0 +1,99 @@
+// Package ordernumber hands out order numbers without every order contending on the document of its fundraiser
+//
+// Each function instance leases a block of consecutive numbers from the counter of a fundraiser, then numbers orders
+// from the block in memory, so only one order in every block touches the document. Numbers are unique, and roughly
+// monotonic: instances number their orders from different blocks at the same time, and the numbers left in a block
+// when its lease expires (or the instance is shut down) are never handed out.
+package ordernumber
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	fundraiserType "github.com/kofc7186/fundraiser-manager/pkg/types/fundraiser"
+	paymentType "github.com/kofc7186/fundraiser-manager/pkg/types/payment"
+)
+
+// Counters is where the order number counters of fundraisers are kept, e.g. fundraiser.Store
+type Counters interface {
+	// LeaseOrderNumbers leases up to size order numbers for orders from source created at t from the fundraiser with
+	// the given ID, returning the fundraiser as of the lease
+	LeaseOrderNumbers(ctx context.Context, id string, source paymentType.PaymentSource, t time.Time, size uint16) (*fundraiserType.Fundraiser, fundraiserType.OrderNumberBlock, error)
+}
+
+// lease is a block of numbers held by an Allocator, along with the fundraiser it was leased from
+type lease struct {
+	block      fundraiserType.OrderNumberBlock
+	expires    time.Time
+	fundraiser *fundraiserType.Fundraiser
+}
+
+// slot holds the lease of a fundraiser and source
+type slot struct {
+	// mu is held while leasing, as the orders waiting on it would otherwise each lease a block of their own; orders of
+	// other fundraisers and sources don't wait on it
+	mu    sync.Mutex
+	lease *lease
+}
+
+// Allocator numbers orders from blocks leased from Counters
+type Allocator struct {
+	counters  Counters
+	leaseTTL  time.Duration
+	blockSize uint16
+
+	// mu guards slots, and is only held to find the slot of an order
+	mu    sync.Mutex
+	slots map[string]*slot
+	now   func() time.Time
+}
+
+// NewAllocator returns an Allocator leasing blocks of blockSize numbers from counters, which are given up after
+// leaseTTL so that changes to a fundraiser (e.g. closing it) are seen by the instance
+func NewAllocator(counters Counters, blockSize int, leaseTTL time.Duration) *Allocator {
+	if blockSize < 1 {
+		blockSize = 1
+	} else if blockSize > math.MaxUint16 {
+		blockSize = math.MaxUint16
+	}
+	return &Allocator{
+		counters:  counters,
+		leaseTTL:  leaseTTL,
+		blockSize: uint16(blockSize),
+		slots:     make(map[string]*slot),
+		now:       time.Now,
+	}
+}
+
+// Allocate returns the number of an order from source created at t, along with the fundraiser with the given ID as
+// of when the block the number was taken from was leased, which may be up to the lease TTL old
+func (a *Allocator) Allocate(ctx context.Context, id string, source paymentType.PaymentSource, t time.Time) (*fundraiserType.Fundraiser, fundraiserType.OrderNumber, error) {
+	key := id + "/" + string(source)
+	a.mu.Lock()
+	s, ok := a.slots[key]
+	if !ok {
+		s = &slot{}
+		a.slots[key] = s
+	}
+	a.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l := s.lease; l != nil && a.now().Before(l.expires) && l.fundraiser.OrderNumberCounter(source, t) == l.block.Counter {
+		if orderNumber, ok := l.block.Take(); ok {
+			return l.fundraiser, orderNumber, nil
+		}
+	}
+	s.lease = nil
+
+	f, block, err := a.counters.LeaseOrderNumbers(ctx, id, source, t, a.blockSize)
+	if err != nil {
+		return nil, fundraiserType.OrderNumber{}, err
+	}
+	l := &lease{block: block, expires: a.now().Add(a.leaseTTL), fundraiser: f}
+	orderNumber, _ := l.block.Take()
+	s.lease = l
+	return f, orderNumber, nil
+}
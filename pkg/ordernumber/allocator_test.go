@@ -0,0 +1,186 @@
+package ordernumber
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	fundraiserType "github.com/kofc7186/fundraiser-manager/pkg/types/fundraiser"
+	paymentType "github.com/kofc7186/fundraiser-manager/pkg/types/payment"
+)
+
+// memCounters keeps fundraisers in memory, serializing leases as a Firestore transaction on the document would
+type memCounters struct {
+	mu          sync.Mutex
+	fundraisers map[string]*fundraiserType.Fundraiser
+	leases      int
+}
+
+func (m *memCounters) LeaseOrderNumbers(ctx context.Context, id string, source paymentType.PaymentSource, t time.Time, size uint16) (*fundraiserType.Fundraiser, fundraiserType.OrderNumberBlock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.leases++
+
+	f, ok := m.fundraisers[id]
+	if !ok {
+		f = &fundraiserType.Fundraiser{ID: id, Status: fundraiserType.STATUS_OPEN}
+		m.fundraisers[id] = f
+	}
+	block, err := f.LeaseOrderNumbers(source, t, size)
+	if err != nil {
+		return nil, fundraiserType.OrderNumberBlock{}, err
+	}
+	snapshot := *f
+	return &snapshot, block, nil
+}
+
+var friday = time.Date(2024, 3, 8, 23, 0, 0, 0, time.UTC)
+
+func TestAllocateConcurrently(t *testing.T) {
+	const instances, ordersPerInstance, workers, blockSize = 4, 500, 25, 10
+	counters := &memCounters{fundraisers: map[string]*fundraiserType.Fundraiser{}}
+
+	var mu sync.Mutex
+	seen := make(map[uint16]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < instances; i++ {
+		allocator := NewAllocator(counters, blockSize, time.Hour)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var previous uint16
+				for n := 0; n < ordersPerInstance/workers; n++ {
+					_, orderNumber, err := allocator.Allocate(context.Background(), "fishfry-030824", paymentType.PAYMENT_SOURCE_ONLINE, friday)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					// each instance hands out its numbers in order
+					if orderNumber.Number <= previous {
+						t.Errorf("%d allocated after %d", orderNumber.Number, previous)
+					}
+					previous = orderNumber.Number
+
+					mu.Lock()
+					if seen[orderNumber.Number] {
+						t.Errorf("%d allocated twice", orderNumber.Number)
+					}
+					seen[orderNumber.Number] = true
+					mu.Unlock()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	const orders = instances * ordersPerInstance
+	if len(seen) != orders {
+		t.Fatalf("allocated %d numbers, want %d", len(seen), orders)
+	}
+	// no numbers are lost while leases are held
+	for n := uint16(fundraiserType.DefaultOrderNumberStart); n < fundraiserType.DefaultOrderNumberStart+orders; n++ {
+		if !seen[n] {
+			t.Errorf("%d not allocated", n)
+		}
+	}
+	if counters.leases != orders/blockSize {
+		t.Errorf("leased %d blocks, want %d", counters.leases, orders/blockSize)
+	}
+}
+
+func TestAllocateRenewsLease(t *testing.T) {
+	counters := &memCounters{fundraisers: map[string]*fundraiserType.Fundraiser{
+		"fishfry-030824": {
+			ID:             "fishfry-030824",
+			Status:         fundraiserType.STATUS_OPEN,
+			TimeZone:       "America/New_York",
+			OrderNumbering: fundraiserType.OrderNumbering{Default: fundraiserType.NumberRange{Start: 1, End: 25}, ResetDaily: true},
+		},
+	}}
+	now := friday
+	allocator := NewAllocator(counters, 10, time.Minute)
+	allocator.now = func() time.Time { return now }
+
+	allocate := func(t *testing.T, orderTime time.Time, want uint16) {
+		t.Helper()
+		_, orderNumber, err := allocator.Allocate(context.Background(), "fishfry-030824", paymentType.PAYMENT_SOURCE_IN_PERSON, orderTime)
+		if err != nil || orderNumber.Number != want {
+			t.Fatalf("Allocate = %+v, %v; want %d", orderNumber, err, want)
+		}
+	}
+
+	allocate(t, friday, 1)
+	allocate(t, friday, 2)
+
+	// an expired lease is given up, along with the numbers left in it
+	now = now.Add(2 * time.Minute)
+	allocate(t, friday, 11)
+
+	// the numbering restarts on the next day of the fundraiser
+	saturday := friday.Add(24 * time.Hour)
+	allocate(t, saturday, 1)
+
+	// the last lease of friday is cut short at the end of the range, after which numbers are exhausted
+	allocate(t, friday, 21)
+	for want := uint16(22); want <= 25; want++ {
+		allocate(t, friday, want)
+	}
+	if _, _, err := allocator.Allocate(context.Background(), "fishfry-030824", paymentType.PAYMENT_SOURCE_IN_PERSON, friday); !errors.Is(err, fundraiserType.ErrOrderNumbersExhausted) {
+		t.Errorf("Allocate = %v, want ErrOrderNumbersExhausted", err)
+	}
+	if counters.leases != 5 {
+		t.Errorf("leased %d blocks, want 5", counters.leases)
+	}
+}
+
+// blockingCounters holds the leases of one fundraiser until released
+type blockingCounters struct {
+	*memCounters
+	blocked string
+	release chan struct{}
+}
+
+func (b *blockingCounters) LeaseOrderNumbers(ctx context.Context, id string, source paymentType.PaymentSource, t time.Time, size uint16) (*fundraiserType.Fundraiser, fundraiserType.OrderNumberBlock, error) {
+	if id == b.blocked {
+		<-b.release
+	}
+	return b.memCounters.LeaseOrderNumbers(ctx, id, source, t, size)
+}
+
+func TestAllocateDoesNotWaitOnOtherFundraisers(t *testing.T) {
+	counters := &blockingCounters{
+		memCounters: &memCounters{fundraisers: map[string]*fundraiserType.Fundraiser{}},
+		blocked:     "fishfry-022324",
+		release:     make(chan struct{}),
+	}
+	allocator := NewAllocator(counters, 10, time.Minute)
+
+	slow := make(chan error, 1)
+	go func() {
+		_, _, err := allocator.Allocate(context.Background(), "fishfry-022324", paymentType.PAYMENT_SOURCE_IN_PERSON, friday)
+		slow <- err
+	}()
+
+	// the lease of the other fundraiser is slow, but doesn't hold up this one's
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := allocator.Allocate(context.Background(), "fishfry-030824", paymentType.PAYMENT_SOURCE_IN_PERSON, friday)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Allocate waited on the lease of another fundraiser")
+	}
+
+	close(counters.release)
+	if err := <-slow; err != nil {
+		t.Error(err)
+	}
+}
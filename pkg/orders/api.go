@@ -0,0 +1,138 @@
+package orders
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/httpjson"
+	"github.com/kofc7186/fundraiser-manager/pkg/logging"
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+// maxBodyBytes bounds the size of the body of a request
+const maxBodyBytes = 16 << 10
+
+// Handler serves the API over the orders of a fundraiser:
+//
+//	GET  /orders?status=&number=&name=&phone=    list the orders matching the Query
+//	GET  /orders/{id}                            the order with its payments, refunds and customer (see Details)
+//	POST /orders/{id}/expedite                   set whether the order is expedited: {"expedite": true}
+//	POST /orders/{id}/status                     move the order to {"status": "READY"}, per the order state machine
+//	POST /orders/{id}/notes                      add a note from the staff: {"author": "...", "text": "..."}
+//	POST /orders/{id}/labels                     reprint the label of the order
+func Handler(service Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if segments[0] != "orders" || len(segments) > 3 {
+			httpjson.Error(ctx, w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+			return
+		}
+
+		if len(segments) == 1 {
+			if r.Method != http.MethodGet {
+				httpjson.Error(ctx, w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed", r.Method))
+				return
+			}
+			q, err := ParseQuery(r.URL.Query())
+			if err != nil {
+				httpjson.Error(ctx, w, http.StatusBadRequest, err)
+				return
+			}
+			orders, err := service.List(ctx, q)
+			if err != nil {
+				writeServiceError(ctx, w, err)
+				return
+			}
+			httpjson.Write(ctx, w, http.StatusOK, orders)
+			return
+		}
+
+		id := segments[1]
+		ctx = logging.WithLabel(ctx, logging.LabelOrderID, id)
+		action := ""
+		if len(segments) == 3 {
+			action = segments[2]
+		}
+
+		var o *orderType.Order
+		var err error
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+			details, err := service.Get(ctx, id)
+			if err != nil {
+				writeServiceError(ctx, w, err)
+				return
+			}
+			httpjson.Write(ctx, w, http.StatusOK, details)
+			return
+		case action == "expedite" && r.Method == http.MethodPost:
+			var body struct {
+				Expedite *bool `json:"expedite"`
+			}
+			if err := decode(w, r, &body); err != nil {
+				httpjson.Error(ctx, w, http.StatusBadRequest, fmt.Errorf("body is not valid: %w", err))
+				return
+			} else if body.Expedite == nil {
+				httpjson.Error(ctx, w, http.StatusBadRequest, errors.New("expedite is required"))
+				return
+			}
+			o, err = service.SetExpedite(ctx, id, *body.Expedite)
+		case action == "status" && r.Method == http.MethodPost:
+			var body struct {
+				Status string `json:"status"`
+			}
+			if err := decode(w, r, &body); err != nil {
+				httpjson.Error(ctx, w, http.StatusBadRequest, fmt.Errorf("body is not a valid status: %w", err))
+				return
+			}
+			next, parseErr := orderType.ParseOrderStatus(body.Status)
+			if parseErr != nil {
+				httpjson.Error(ctx, w, http.StatusBadRequest, parseErr)
+				return
+			}
+			o, err = service.Transition(ctx, id, next)
+		case action == "notes" && r.Method == http.MethodPost:
+			var note orderType.StaffNote
+			if err := decode(w, r, &note); err != nil {
+				httpjson.Error(ctx, w, http.StatusBadRequest, fmt.Errorf("body is not a valid note: %w", err))
+				return
+			} else if strings.TrimSpace(note.Text) == "" {
+				httpjson.Error(ctx, w, http.StatusBadRequest, errors.New("text is required"))
+				return
+			}
+			o, err = service.AddNote(ctx, id, note)
+		case action == "labels" && r.Method == http.MethodPost:
+			o, err = service.RequestLabel(ctx, id)
+		default:
+			httpjson.Error(ctx, w, http.StatusNotFound, fmt.Errorf("%s %s not found", r.Method, r.URL.Path))
+			return
+		}
+		if err != nil {
+			writeServiceError(ctx, w, err)
+			return
+		}
+		slog.InfoContext(logging.WithOrderNumber(ctx, o.Number), fmt.Sprintf("order %s %s", id, action))
+		httpjson.Write(ctx, w, http.StatusOK, o)
+	}
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	return json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(v)
+}
+
+func writeServiceError(ctx context.Context, w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		httpjson.Error(ctx, w, http.StatusNotFound, err)
+	case errors.Is(err, orderType.ErrInvalidTransition):
+		httpjson.Error(ctx, w, http.StatusConflict, err)
+	default:
+		httpjson.Error(ctx, w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,138 @@
+package orders
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+// fakeService keeps orders in memory, changing them as Store does
+type fakeService struct {
+	orders map[string]*orderType.Order
+	query  Query
+}
+
+func (f *fakeService) List(ctx context.Context, q Query) ([]*orderType.Order, error) {
+	f.query = q
+	var orders []*orderType.Order
+	for _, o := range f.orders {
+		if q.Matches(o) {
+			orders = append(orders, o)
+		}
+	}
+	return orders, nil
+}
+
+func (f *fakeService) Get(ctx context.Context, id string) (*Details, error) {
+	o, ok := f.orders[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &Details{Order: o}, nil
+}
+
+func (f *fakeService) update(id string, change func(o *orderType.Order) error) (*orderType.Order, error) {
+	o, ok := f.orders[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := change(o); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (f *fakeService) SetExpedite(ctx context.Context, id string, expedite bool) (*orderType.Order, error) {
+	return f.update(id, func(o *orderType.Order) error { o.Expedite = expedite; return nil })
+}
+
+func (f *fakeService) Transition(ctx context.Context, id string, next orderType.OrderStatus) (*orderType.Order, error) {
+	return f.update(id, func(o *orderType.Order) error { return o.Transition(next, time.Now()) })
+}
+
+func (f *fakeService) AddNote(ctx context.Context, id string, note orderType.StaffNote) (*orderType.Order, error) {
+	return f.update(id, func(o *orderType.Order) error { o.StaffNotes = append(o.StaffNotes, note); return nil })
+}
+
+func (f *fakeService) RequestLabel(ctx context.Context, id string) (*orderType.Order, error) {
+	return f.update(id, func(o *orderType.Order) error { o.LabelRequestTime = time.Now(); return nil })
+}
+
+func newFakeService() *fakeService {
+	return &fakeService{orders: map[string]*orderType.Order{
+		"CAISENgvlJ6jLWAzERDzjyHVybY":   {ID: "CAISENgvlJ6jLWAzERDzjyHVybY", Number: 1042, Code: "W-1042", DisplayName: "Jane Doe", PhoneNumber: "+1 (555) 555-1234", Status: orderType.ORDER_STATUS_LABELED},
+		"R2B3Z8WMVt3EAmzYWLZvz7Y69EbZY": {ID: "R2B3Z8WMVt3EAmzYWLZvz7Y69EbZY", Number: 7, Code: "7", DisplayName: "John Smith", Status: orderType.ORDER_STATUS_CLOSED},
+	}}
+}
+
+func do(t *testing.T, handler http.Handler, method, target, body string) (int, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return w.Code, w.Body.String()
+}
+
+func TestHandlerList(t *testing.T) {
+	service := newFakeService()
+	handler := Handler(service)
+
+	code, body := do(t, handler, http.MethodGet, "/orders?status=LABELED&name=jane&phone=555-1234&number=w-1042", "")
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %s", code, body)
+	}
+	want := Query{Name: "jane", Number: "w-1042", Phone: "5551234", Status: orderType.ORDER_STATUS_LABELED}
+	if service.query != want {
+		t.Errorf("service received query %+v, want %+v", service.query, want)
+	}
+	var orders []*orderType.Order
+	if err := json.Unmarshal([]byte(body), &orders); err != nil || len(orders) != 1 || orders[0].Number != 1042 {
+		t.Errorf("unexpected orders %s", body)
+	}
+
+	if code, body := do(t, handler, http.MethodGet, "/orders?status=SERVED", ""); code != http.StatusBadRequest {
+		t.Errorf("invalid status: got %d: %s", code, body)
+	}
+}
+
+func TestHandlerActions(t *testing.T) {
+	service := newFakeService()
+	handler := Handler(service)
+	const id = "CAISENgvlJ6jLWAzERDzjyHVybY"
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		want   int
+	}{
+		{"get", http.MethodGet, "/orders/" + id, "", http.StatusOK},
+		{"get missing", http.MethodGet, "/orders/missing", "", http.StatusNotFound},
+		{"expedite", http.MethodPost, "/orders/" + id + "/expedite", `{"expedite": true}`, http.StatusOK},
+		{"expedite without value", http.MethodPost, "/orders/" + id + "/expedite", `{}`, http.StatusBadRequest},
+		{"note", http.MethodPost, "/orders/" + id + "/notes", `{"author": "Bob", "text": "no tartar sauce"}`, http.StatusOK},
+		{"empty note", http.MethodPost, "/orders/" + id + "/notes", `{"text": " "}`, http.StatusBadRequest},
+		{"reprint", http.MethodPost, "/orders/" + id + "/labels", "", http.StatusOK},
+		{"ready", http.MethodPost, "/orders/" + id + "/status", `{"status": "READY"}`, http.StatusOK},
+		{"back to labeled", http.MethodPost, "/orders/" + id + "/status", `{"status": "LABELED"}`, http.StatusConflict},
+		{"unknown status", http.MethodPost, "/orders/" + id + "/status", `{"status": "SERVED"}`, http.StatusBadRequest},
+		{"unknown action", http.MethodPost, "/orders/" + id + "/refund", "", http.StatusNotFound},
+		{"wrong method", http.MethodDelete, "/orders/" + id, "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if code, body := do(t, handler, tt.method, tt.target, tt.body); code != tt.want {
+			t.Errorf("%s: got %d, want %d: %s", tt.name, code, tt.want, body)
+		}
+	}
+
+	o := service.orders[id]
+	if !o.Expedite || len(o.StaffNotes) != 1 || o.StaffNotes[0].Author != "Bob" || o.LabelRequestTime.IsZero() || o.Status != orderType.ORDER_STATUS_READY {
+		t.Errorf("order not changed as requested: %+v", o)
+	}
+}
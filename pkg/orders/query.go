@@ -0,0 +1,81 @@
+package orders
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+// Query selects orders; every field which is set must match
+type Query struct {
+	// Name matches orders whose display, first or last name contains it, ignoring case
+	Name string
+	// Number matches orders with the number or short code, e.g. "1042" or "W-1042"
+	Number string
+	// Phone matches orders whose phone number ends with its digits, e.g. "1234" or "(555) 555-1234"
+	Phone  string
+	Status orderType.OrderStatus
+}
+
+// ParseQuery parses a Query from the parameters of a request to the API served by Handler
+func ParseQuery(values url.Values) (Query, error) {
+	q := Query{
+		Name:   strings.TrimSpace(values.Get("name")),
+		Number: strings.TrimSpace(values.Get("number")),
+		Phone:  digits(values.Get("phone")),
+	}
+	if status := values.Get("status"); status != "" {
+		var err error
+		if q.Status, err = orderType.ParseOrderStatus(status); err != nil {
+			return Query{}, err
+		}
+	}
+	return q, nil
+}
+
+// Values encodes q as the parameters of a request to the API served by Handler
+func (q Query) Values() url.Values {
+	values := url.Values{}
+	for key, value := range map[string]string{"name": q.Name, "number": q.Number, "phone": q.Phone, "status": string(q.Status)} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	return values
+}
+
+// Matches reports whether o is selected by q
+func (q Query) Matches(o *orderType.Order) bool {
+	if q.Status != orderType.ORDER_STATUS_UNKNOWN && o.Status != q.Status {
+		return false
+	}
+	if q.Number != "" && !strings.EqualFold(o.Code, q.Number) && strconv.FormatUint(uint64(o.Number), 10) != q.Number {
+		return false
+	}
+	if phone := digits(q.Phone); phone != "" && !strings.HasSuffix(digits(o.PhoneNumber), phone) {
+		return false
+	}
+	if q.Name != "" {
+		name := strings.ToLower(q.Name)
+		found := false
+		for _, candidate := range []string{o.DisplayName, o.FirstName, o.LastName} {
+			found = found || strings.Contains(strings.ToLower(candidate), name)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// digits returns only the digits of a phone number
+func digits(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if r < '0' || r > '9' {
+			return -1
+		}
+		return r
+	}, phone)
+}
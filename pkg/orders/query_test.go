@@ -0,0 +1,54 @@
+package orders
+
+import (
+	"net/url"
+	"testing"
+
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+func TestQueryMatches(t *testing.T) {
+	o := &orderType.Order{
+		Code:        "W-1042",
+		DisplayName: "Jane Doe",
+		FirstName:   "Jane",
+		LastName:    "Doe",
+		Number:      1042,
+		PhoneNumber: "+15555551234",
+		Status:      orderType.ORDER_STATUS_READY,
+	}
+	tests := []struct {
+		name string
+		q    Query
+		want bool
+	}{
+		{"everything", Query{}, true},
+		{"status", Query{Status: orderType.ORDER_STATUS_READY}, true},
+		{"other status", Query{Status: orderType.ORDER_STATUS_CLOSED}, false},
+		{"number", Query{Number: "1042"}, true},
+		{"code", Query{Number: "w-1042"}, true},
+		{"other number", Query{Number: "1043"}, false},
+		{"name", Query{Name: "DOE"}, true},
+		{"other name", Query{Name: "Smith"}, false},
+		{"last four", Query{Phone: "1234"}, true},
+		{"formatted phone", Query{Phone: "(555) 555-1234"}, true},
+		{"other phone", Query{Phone: "4321"}, false},
+		{"all", Query{Name: "jane", Number: "1042", Phone: "1234", Status: orderType.ORDER_STATUS_READY}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.q.Matches(o); got != tt.want {
+			t.Errorf("%s: Matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseQueryValues(t *testing.T) {
+	q := Query{Name: "jane", Number: "W-1042", Phone: "1234", Status: orderType.ORDER_STATUS_READY}
+	parsed, err := ParseQuery(q.Values())
+	if err != nil || parsed != q {
+		t.Errorf("ParseQuery(%v) = %+v, %v; want %+v", q.Values(), parsed, err, q)
+	}
+	if _, err := ParseQuery(url.Values{"status": {"SERVED"}}); err == nil {
+		t.Error("expected error for an invalid status")
+	}
+}
@@ -0,0 +1,252 @@
+// Package orders is how the staff of a fundraiser look up and act on its orders, which are kept at
+// fundraisers/<id>/orders/<order id> by the order-controller
+package orders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	firestorepb "cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
+	"github.com/kofc7186/fundraiser-manager/pkg/tracing"
+	customerType "github.com/kofc7186/fundraiser-manager/pkg/types/customer"
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+	paymentType "github.com/kofc7186/fundraiser-manager/pkg/types/payment"
+	refundType "github.com/kofc7186/fundraiser-manager/pkg/types/refund"
+)
+
+// ErrNotFound is returned when no order exists with the requested ID
+var ErrNotFound = errors.New("order not found")
+
+// Details is an order along with the payments, refunds and customer associated with it, and the label printed for it
+type Details struct {
+	Customer *customerType.Customer `json:"customer"`
+	Order    *orderType.Order       `json:"order"`
+	Payments []*paymentType.Payment `json:"payments"`
+	Refunds  []*refundType.Refund   `json:"refunds"`
+	// Label is the LabelTemplate of the fundraiser rendered with the order; it is omitted if the fundraiser has none
+	Label string `json:"label,omitempty"`
+}
+
+// Service looks up and acts on the orders of a fundraiser; it is implemented by Store, and served over HTTP by Handler
+type Service interface {
+	List(ctx context.Context, q Query) ([]*orderType.Order, error)
+	Get(ctx context.Context, id string) (*Details, error)
+	SetExpedite(ctx context.Context, id string, expedite bool) (*orderType.Order, error)
+	Transition(ctx context.Context, id string, next orderType.OrderStatus) (*orderType.Order, error)
+	AddNote(ctx context.Context, id string, note orderType.StaffNote) (*orderType.Order, error)
+	RequestLabel(ctx context.Context, id string) (*orderType.Order, error)
+}
+
+var _ Service = (*Store)(nil)
+
+// Store is the Service over the Firestore documents of a fundraiser; like the order-controller, it changes orders
+// within transactions, updating only the fields it changes, so the changes are published as order.updated events
+type Store struct {
+	client       *firestore.Client
+	fundraiserID string
+}
+
+// NewStore returns the Store of the fundraiser
+func NewStore(client *firestore.Client, fundraiserID string) *Store {
+	return &Store{client: client, fundraiserID: fundraiserID}
+}
+
+// List returns the orders matching q, in order of their numbers
+func (s *Store) List(ctx context.Context, q Query) ([]*orderType.Order, error) {
+	// only the status is filtered in Firestore, so that no composite index is required
+	query := s.client.Collection(fundraiser.Path(s.fundraiserID, "orders")).Query
+	if q.Status != orderType.ORDER_STATUS_UNKNOWN {
+		query = query.Where("status", "==", q.Status)
+	}
+	docSnaps, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	orders := []*orderType.Order{}
+	for _, docSnap := range docSnaps {
+		o := &orderType.Order{}
+		if err := docSnap.DataTo(o); err != nil {
+			return nil, err
+		}
+		if q.Matches(o) {
+			orders = append(orders, o)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].Number < orders[j].Number
+	})
+	return orders, nil
+}
+
+// CountByStatus returns the number of orders in each status, counted by Firestore rather than read
+func (s *Store) CountByStatus(ctx context.Context) (map[orderType.OrderStatus]int64, error) {
+	counts := map[orderType.OrderStatus]int64{}
+	for _, orderStatus := range orderType.ORDER_STATUSES {
+		query := s.client.Collection(fundraiser.Path(s.fundraiserID, "orders")).Where("status", "==", orderStatus)
+		result, err := query.NewAggregationQuery().WithCount("count").Get(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("counting %s orders: %w", orderStatus, err)
+		}
+		count, ok := result["count"].(*firestorepb.Value)
+		if !ok {
+			return nil, fmt.Errorf("counting %s orders: unexpected result %v", orderStatus, result["count"])
+		}
+		counts[orderStatus] = count.GetIntegerValue()
+	}
+	return counts, nil
+}
+
+// Get returns the order with the given ID along with its payments, refunds and customer
+func (s *Store) Get(ctx context.Context, id string) (*Details, error) {
+	docSnap, err := s.client.Doc(fundraiser.Path(s.fundraiserID, "orders", id)).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	details := &Details{Order: &orderType.Order{}, Payments: []*paymentType.Payment{}, Refunds: []*refundType.Refund{}}
+	if err := docSnap.DataTo(details.Order); err != nil {
+		return nil, err
+	}
+
+	paymentSnaps, err := s.client.Collection(fundraiser.Path(s.fundraiserID, "payments")).Where("squareOrderID", "==", id).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, paymentSnap := range paymentSnaps {
+		p := &paymentType.Payment{}
+		if err := paymentSnap.DataTo(p); err != nil {
+			return nil, err
+		}
+		details.Payments = append(details.Payments, p)
+	}
+
+	refundSnaps, err := s.client.Collection(fundraiser.Path(s.fundraiserID, "refunds")).Where("squareOrderID", "==", id).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, refundSnap := range refundSnaps {
+		r := &refundType.Refund{}
+		if err := refundSnap.DataTo(r); err != nil {
+			return nil, err
+		}
+		details.Refunds = append(details.Refunds, r)
+	}
+
+	if customerID := details.Order.SquareCustomerID; customerID != "" {
+		customerSnap, err := s.client.Doc(fundraiser.Path(s.fundraiserID, "customers", customerID)).Get(ctx)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return nil, err
+		}
+		if customerSnap.Exists() {
+			details.Customer = &customerType.Customer{}
+			if err := customerSnap.DataTo(details.Customer); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if details.Label, err = s.label(ctx, details.Order); err != nil {
+		return nil, err
+	}
+	return details, nil
+}
+
+// label renders the LabelTemplate of the fundraiser with o; fundraisers configured only through ROUTES_ENV have no
+// document, and so no label
+func (s *Store) label(ctx context.Context, o *orderType.Order) (string, error) {
+	f, err := fundraiser.NewStore(s.client).Get(ctx, s.fundraiserID)
+	if errors.Is(err, fundraiser.ErrNotFound) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	label, err := f.RenderLabel(o)
+	if err != nil {
+		// a template which doesn't suit the order must not hide the order from the staff
+		slog.WarnContext(ctx, fmt.Sprintf("rendering label of order %s: %v", o.ID, err))
+		return "", nil
+	}
+	return label, nil
+}
+
+// SetExpedite sets whether the order is expedited
+func (s *Store) SetExpedite(ctx context.Context, id string, expedite bool) (*orderType.Order, error) {
+	return s.update(ctx, id, func(o *orderType.Order, now time.Time) ([]firestore.Update, error) {
+		o.Expedite = expedite
+		return []firestore.Update{{Path: "expedite", Value: expedite}}, nil
+	})
+}
+
+// Transition moves the order to the next status (see orderType.Order.Transition)
+func (s *Store) Transition(ctx context.Context, id string, next orderType.OrderStatus) (*orderType.Order, error) {
+	return s.update(ctx, id, func(o *orderType.Order, now time.Time) ([]firestore.Update, error) {
+		if err := o.Transition(next, now); err != nil {
+			return nil, err
+		}
+		return []firestore.Update{
+			{Path: "status", Value: o.Status},
+			{Path: "statusTransitions", Value: o.StatusTransitions},
+		}, nil
+	})
+}
+
+// AddNote adds a note from the staff to the order
+func (s *Store) AddNote(ctx context.Context, id string, note orderType.StaffNote) (*orderType.Order, error) {
+	return s.update(ctx, id, func(o *orderType.Order, now time.Time) ([]firestore.Update, error) {
+		note.Timestamp = now
+		o.StaffNotes = append(o.StaffNotes, note)
+		return []firestore.Update{{Path: "staffNotes", Value: o.StaffNotes}}, nil
+	})
+}
+
+// RequestLabel requests that a label is printed (again) for the order
+func (s *Store) RequestLabel(ctx context.Context, id string) (*orderType.Order, error) {
+	return s.update(ctx, id, func(o *orderType.Order, now time.Time) ([]firestore.Update, error) {
+		o.LabelRequestTime = now
+		return []firestore.Update{{Path: "labelRequestTime", Value: now}}, nil
+	})
+}
+
+// update applies change to the order within a transaction, writing the fields it returns
+func (s *Store) update(ctx context.Context, id string, change func(o *orderType.Order, now time.Time) ([]firestore.Update, error)) (*orderType.Order, error) {
+	docRef := s.client.Doc(fundraiser.Path(s.fundraiserID, "orders", id))
+
+	o := &orderType.Order{}
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docSnap, err := tx.Get(docRef)
+		if status.Code(err) == codes.NotFound {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+
+		*o = orderType.Order{}
+		if err := docSnap.DataTo(o); err != nil {
+			return err
+		}
+		updates, err := change(o, time.Now())
+		if err != nil {
+			return err
+		}
+
+		// carry the trace through to the CDC event caused by this write
+		o.SetTraceParent(tracing.TraceParent(ctx))
+		updates = append(updates, firestore.Update{Path: "traceparent", Value: o.TraceParent})
+		return tx.Update(docRef, updates)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("order %s: %w", id, err)
+	}
+	return o, nil
+}
@@ -0,0 +1,47 @@
+// Package pickup serves the pickup table of a fundraiser: a public "now serving" board of the orders which are ready,
+// and the confirmation of each pickup, which closes the order
+package pickup
+
+import (
+	"sort"
+	"time"
+
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+// Called is an order as shown on the board; as the board is public, it carries nothing about the customer
+type Called struct {
+	Code   string `json:"code"`
+	Number uint16 `json:"number"`
+	// ReadyTime is when the order became READY, or was created if no transition was recorded
+	ReadyTime time.Time `json:"readyTime"`
+}
+
+// Board is every READY order of a fundraiser, those longest waiting first
+type Board struct {
+	Ready       []Called  `json:"ready"`
+	UpdatedTime time.Time `json:"updatedTime"`
+}
+
+// NewBoard returns the board of the orders as of now; orders which aren't READY are left off
+func NewBoard(orders []*orderType.Order, now time.Time) *Board {
+	board := &Board{Ready: []Called{}, UpdatedTime: now}
+	for _, o := range orders {
+		if o.Status != orderType.ORDER_STATUS_READY {
+			continue
+		}
+		readyTime := o.CreatedTime
+		if n := len(o.StatusTransitions); n > 0 && o.StatusTransitions[n-1].Status == o.Status {
+			readyTime = o.StatusTransitions[n-1].Timestamp
+		}
+		board.Ready = append(board.Ready, Called{Code: o.Code, Number: o.Number, ReadyTime: readyTime})
+	}
+
+	sort.SliceStable(board.Ready, func(i, j int) bool {
+		if !board.Ready[i].ReadyTime.Equal(board.Ready[j].ReadyTime) {
+			return board.Ready[i].ReadyTime.Before(board.Ready[j].ReadyTime)
+		}
+		return board.Ready[i].Number < board.Ready[j].Number
+	})
+	return board
+}
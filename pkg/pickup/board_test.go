@@ -0,0 +1,37 @@
+package pickup
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+func TestNewBoard(t *testing.T) {
+	now := time.Date(2024, 3, 15, 17, 30, 0, 0, time.UTC)
+	ready := func(number uint16, readyAt time.Time) *orderType.Order {
+		return &orderType.Order{
+			Number:            number,
+			Code:              fmt.Sprintf("W-%d", number),
+			DisplayName:       "Jane Doe",
+			CreatedTime:       now.Add(-time.Hour),
+			Status:            orderType.ORDER_STATUS_READY,
+			StatusTransitions: []orderType.OrderStatusTransition{{PreviousStatus: orderType.ORDER_STATUS_LABELED, Status: orderType.ORDER_STATUS_READY, Timestamp: readyAt}},
+		}
+	}
+	orders := []*orderType.Order{
+		ready(3, now.Add(-time.Minute)),
+		{Number: 4, CreatedTime: now, Status: orderType.ORDER_STATUS_LABELED},
+		ready(1, now.Add(-5*time.Minute)),
+		{Number: 2, CreatedTime: now, Status: orderType.ORDER_STATUS_CLOSED},
+	}
+
+	board := NewBoard(orders, now)
+	if len(board.Ready) != 2 || board.Ready[0].Number != 1 || board.Ready[1].Number != 3 {
+		t.Fatalf("got ready %+v, want 1 then 3", board.Ready)
+	}
+	if !board.Ready[0].ReadyTime.Equal(now.Add(-5*time.Minute)) || board.Ready[0].Code != "W-1" {
+		t.Errorf("got %+v", board.Ready[0])
+	}
+}
@@ -0,0 +1,105 @@
+package pickup
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/orders"
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+var (
+	// ErrMismatch is returned when the phone number given doesn't match that of the order
+	ErrMismatch = errors.New("phone number does not match the order")
+	// ErrAlreadyPickedUp is returned when the order has already been picked up
+	ErrAlreadyPickedUp = errors.New("order was already picked up")
+	// ErrNotReady is returned when the order can't be picked up, as it isn't READY
+	ErrNotReady = errors.New("order is not ready")
+)
+
+// Claim is what is presented to pick up an order: either the order ID, which is encoded in the QR code on its label
+// (e.g. by {{.ID}} in the fundraiser's LabelTemplate), or the order's number and the last 4 digits of the customer's
+// phone number
+type Claim struct {
+	OrderID    string `json:"orderID"`
+	Number     string `json:"number"`
+	PhoneLast4 string `json:"phoneLast4"`
+}
+
+// Validate returns an error if c is neither an order ID, nor a number with the last 4 digits of a phone number
+func (c Claim) Validate() error {
+	if c.OrderID != "" {
+		if c.Number != "" || c.PhoneLast4 != "" {
+			return errors.New("orderID can't be given with number or phoneLast4")
+		}
+		return nil
+	}
+	if _, err := c.number(); err != nil {
+		return err
+	}
+	if len(c.PhoneLast4) != 4 || strings.Trim(c.PhoneLast4, "0123456789") != "" {
+		return errors.New("phoneLast4 must be 4 digits")
+	}
+	return nil
+}
+
+// number returns the number of the order claimed, e.g. 1042 for "1042" or "W-1042"
+func (c Claim) number() (uint16, error) {
+	number := strings.TrimSpace(c.Number)
+	digits := strings.TrimLeft(number[strings.LastIndexFunc(number, func(r rune) bool { return r < '0' || r > '9' })+1:], "0")
+	n, err := strconv.ParseUint(digits, 10, 16)
+	if err != nil || n == 0 {
+		return 0, errors.New("number must be an order number, e.g. 1042 or W-1042")
+	}
+	return uint16(n), nil
+}
+
+// Select returns which of the candidates, the orders with the number of a claim by number, is being picked up; as
+// numbers may be reused (e.g. by a daily reset), the READY order matching the claim is preferred
+func (c Claim) Select(candidates []*orderType.Order) (*orderType.Order, error) {
+	byNumber := orders.Query{Number: strings.TrimSpace(c.Number)}
+	byPhone := orders.Query{Number: byNumber.Number, Phone: c.PhoneLast4}
+
+	var numbered, matched []*orderType.Order
+	for _, o := range candidates {
+		if byPhone.Matches(o) {
+			matched = append(matched, o)
+		} else if byNumber.Matches(o) {
+			numbered = append(numbered, o)
+		}
+	}
+	if len(matched) == 0 {
+		if len(numbered) == 0 {
+			return nil, orders.ErrNotFound
+		}
+		return nil, ErrMismatch
+	}
+
+	// the most recent READY order, then the most recent order, so that Check explains why it can't be picked up
+	var selected *orderType.Order
+	for _, o := range matched {
+		switch {
+		case selected == nil:
+			selected = o
+		case (o.Status == orderType.ORDER_STATUS_READY) != (selected.Status == orderType.ORDER_STATUS_READY):
+			if o.Status == orderType.ORDER_STATUS_READY {
+				selected = o
+			}
+		case o.CreatedTime.After(selected.CreatedTime):
+			selected = o
+		}
+	}
+	return selected, nil
+}
+
+// Check returns an error if o can't be picked up
+func Check(o *orderType.Order) error {
+	switch o.Status {
+	case orderType.ORDER_STATUS_READY:
+		return nil
+	case orderType.ORDER_STATUS_CLOSED:
+		return ErrAlreadyPickedUp
+	}
+	return ErrNotReady
+}
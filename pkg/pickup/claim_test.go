@@ -0,0 +1,87 @@
+package pickup
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/orders"
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+func TestClaimValidate(t *testing.T) {
+	tests := []struct {
+		claim Claim
+		valid bool
+	}{
+		{Claim{OrderID: "CAISENgvlJ6jLWAzERDzjyHVybY"}, true},
+		{Claim{Number: "1042", PhoneLast4: "1234"}, true},
+		{Claim{Number: "W-1042", PhoneLast4: "0042"}, true},
+		{Claim{OrderID: "CAISENgvlJ6jLWAzERDzjyHVybY", Number: "1042"}, false},
+		{Claim{Number: "W-1042"}, false},
+		{Claim{Number: "W-1042", PhoneLast4: "12a4"}, false},
+		{Claim{Number: "W-1042", PhoneLast4: "01234"}, false},
+		{Claim{Number: "W-", PhoneLast4: "1234"}, false},
+		{Claim{Number: "99999", PhoneLast4: "1234"}, false},
+		{Claim{}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.claim.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%+v: got %v, want valid %v", tt.claim, err, tt.valid)
+		}
+	}
+
+	if n, err := (Claim{Number: "W-0042"}).number(); err != nil || n != 42 {
+		t.Errorf("number of W-0042: got %d, %v", n, err)
+	}
+}
+
+func TestClaimSelect(t *testing.T) {
+	now := time.Date(2024, 3, 15, 17, 30, 0, 0, time.UTC)
+	yesterday := &orderType.Order{ID: "yesterday", Number: 1042, Code: "W-1042", PhoneNumber: "+1 (555) 555-1234", CreatedTime: now.Add(-24 * time.Hour), Status: orderType.ORDER_STATUS_CLOSED}
+	today := &orderType.Order{ID: "today", Number: 1042, Code: "W-1042", PhoneNumber: "+1 (555) 555-1234", CreatedTime: now.Add(-time.Hour), Status: orderType.ORDER_STATUS_READY}
+	walkIn := &orderType.Order{ID: "walk-in", Number: 1042, Code: "1042", PhoneNumber: "+1 (555) 555-9876", CreatedTime: now, Status: orderType.ORDER_STATUS_READY}
+	candidates := []*orderType.Order{yesterday, today, walkIn}
+
+	tests := []struct {
+		name    string
+		claim   Claim
+		want    *orderType.Order
+		wantErr error
+	}{
+		{"ready order preferred", Claim{Number: "W-1042", PhoneLast4: "1234"}, today, nil},
+		{"by number", Claim{Number: "1042", PhoneLast4: "9876"}, walkIn, nil},
+		{"phone mismatch", Claim{Number: "W-1042", PhoneLast4: "0000"}, nil, ErrMismatch},
+		{"other code", Claim{Number: "P-1042", PhoneLast4: "1234"}, nil, orders.ErrNotFound},
+	}
+	for _, tt := range tests {
+		got, err := tt.claim.Select(candidates)
+		if !errors.Is(err, tt.wantErr) || got != tt.want {
+			t.Errorf("%s: got %v, %v; want %v, %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	// once today's order is picked up, the most recent order is selected, so that Check rejects the double pickup
+	today.Status = orderType.ORDER_STATUS_CLOSED
+	got, err := Claim{Number: "W-1042", PhoneLast4: "1234"}.Select(candidates)
+	if err != nil || got != today {
+		t.Fatalf("got %v, %v; want %v", got, err, today)
+	}
+	if err := Check(got); !errors.Is(err, ErrAlreadyPickedUp) {
+		t.Errorf("check of closed order: got %v, want %v", err, ErrAlreadyPickedUp)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := map[orderType.OrderStatus]error{
+		orderType.ORDER_STATUS_LABELED:  ErrNotReady,
+		orderType.ORDER_STATUS_READY:    nil,
+		orderType.ORDER_STATUS_CLOSED:   ErrAlreadyPickedUp,
+		orderType.ORDER_STATUS_CANCELED: ErrNotReady,
+	}
+	for status, want := range tests {
+		if err := Check(&orderType.Order{Status: status}); !errors.Is(err, want) {
+			t.Errorf("%s: got %v, want %v", status, err, want)
+		}
+	}
+}
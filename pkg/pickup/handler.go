@@ -0,0 +1,110 @@
+package pickup
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/httpjson"
+	"github.com/kofc7186/fundraiser-manager/pkg/kitchen"
+	"github.com/kofc7186/fundraiser-manager/pkg/logging"
+	"github.com/kofc7186/fundraiser-manager/pkg/orders"
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+// maxBodyBytes bounds the size of the body of a request
+const maxBodyBytes = 4 << 10
+
+//go:embed board.html
+var boardPage []byte
+
+//go:embed confirm.html
+var confirmPage []byte
+
+// BoardHandler serves the public "now serving" board:
+//
+//	GET  /          the board, which renders the Board streamed from /events
+//	GET  /events    the Board, streamed as Server-Sent Events each time an open order changes
+//
+// the query of each request (e.g. ?fundraiser=) is carried by the board to the requests it makes
+func BoardHandler(source kitchen.Source) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		path := strings.Trim(r.URL.Path, "/")
+		switch {
+		case path == "" && r.Method == http.MethodGet:
+			writePage(ctx, w, boardPage)
+		case path == "events" && r.Method == http.MethodGet:
+			kitchen.Stream(w, r, source, "board", func(orders []*orderType.Order, now time.Time) interface{} {
+				return NewBoard(orders, now)
+			})
+		default:
+			httpjson.Error(ctx, w, http.StatusNotFound, fmt.Errorf("%s %s not found", r.Method, r.URL.Path))
+		}
+	}
+}
+
+// Handler serves the confirmation of pickups at the pickup table:
+//
+//	GET  /           a form which confirms pickups, from a scanned label or a number with a phone number
+//	POST /pickups    close the order of the Claim, e.g. {"orderID": "..."} or {"number": "W-1042", "phoneLast4": "1234"}
+//
+// a claim which doesn't match its order, or of an order which isn't READY (e.g. already picked up), is rejected
+func Handler(confirmer Confirmer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		path := strings.Trim(r.URL.Path, "/")
+		switch {
+		case path == "" && r.Method == http.MethodGet:
+			writePage(ctx, w, confirmPage)
+		case path == "pickups" && r.Method == http.MethodPost:
+			var claim Claim
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&claim); err != nil {
+				httpjson.Error(ctx, w, http.StatusBadRequest, fmt.Errorf("body is not a valid claim: %w", err))
+				return
+			}
+			claim.OrderID, claim.Number, claim.PhoneLast4 = strings.TrimSpace(claim.OrderID), strings.TrimSpace(claim.Number), strings.TrimSpace(claim.PhoneLast4)
+			if err := claim.Validate(); err != nil {
+				httpjson.Error(ctx, w, http.StatusBadRequest, err)
+				return
+			}
+			if claim.OrderID != "" {
+				ctx = logging.WithLabel(ctx, logging.LabelOrderID, claim.OrderID)
+			}
+
+			o, err := confirmer.Confirm(ctx, claim)
+			switch {
+			case errors.Is(err, orders.ErrNotFound):
+				httpjson.Error(ctx, w, http.StatusNotFound, err)
+				return
+			case errors.Is(err, ErrMismatch):
+				httpjson.Error(ctx, w, http.StatusForbidden, err)
+				return
+			case errors.Is(err, ErrAlreadyPickedUp), errors.Is(err, ErrNotReady), errors.Is(err, orderType.ErrInvalidTransition):
+				httpjson.Error(ctx, w, http.StatusConflict, err)
+				return
+			case err != nil:
+				httpjson.Error(ctx, w, http.StatusInternalServerError, err)
+				return
+			}
+			ctx = logging.WithOrderNumber(logging.WithLabel(ctx, logging.LabelOrderID, o.ID), o.Number)
+			slog.InfoContext(ctx, "order picked up")
+			httpjson.Write(ctx, w, http.StatusOK, map[string]interface{}{"code": o.Code, "number": o.Number, "displayName": o.DisplayName})
+		default:
+			httpjson.Error(ctx, w, http.StatusNotFound, fmt.Errorf("%s %s not found", r.Method, r.URL.Path))
+		}
+	}
+}
+
+func writePage(ctx context.Context, w http.ResponseWriter, page []byte) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := w.Write(page); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+	}
+}
@@ -0,0 +1,86 @@
+package pickup
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/orders"
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+// fakeConfirmer keeps orders in memory, closing them as Store does
+type fakeConfirmer struct {
+	orders map[string]*orderType.Order
+}
+
+func (f *fakeConfirmer) Confirm(ctx context.Context, claim Claim) (*orderType.Order, error) {
+	var o *orderType.Order
+	if claim.OrderID != "" {
+		var ok bool
+		if o, ok = f.orders[claim.OrderID]; !ok {
+			return nil, orders.ErrNotFound
+		}
+	} else {
+		var candidates []*orderType.Order
+		for _, candidate := range f.orders {
+			candidates = append(candidates, candidate)
+		}
+		var err error
+		if o, err = claim.Select(candidates); err != nil {
+			return nil, err
+		}
+	}
+	if err := Check(o); err != nil {
+		return nil, err
+	}
+	return o, o.Transition(orderType.ORDER_STATUS_CLOSED, time.Now())
+}
+
+func TestHandlerPickups(t *testing.T) {
+	confirmer := &fakeConfirmer{orders: map[string]*orderType.Order{
+		"CAISENgvlJ6jLWAzERDzjyHVybY":   {ID: "CAISENgvlJ6jLWAzERDzjyHVybY", Number: 1042, Code: "W-1042", PhoneNumber: "+1 (555) 555-1234", Status: orderType.ORDER_STATUS_READY},
+		"R2B3Z8WMVt3EAmzYWLZvz7Y69EbZY": {ID: "R2B3Z8WMVt3EAmzYWLZvz7Y69EbZY", Number: 7, Code: "7", PhoneNumber: "555-555-9876", Status: orderType.ORDER_STATUS_READY},
+		"labeled":                       {ID: "labeled", Number: 8, Code: "8", Status: orderType.ORDER_STATUS_LABELED},
+	}}
+	handler := Handler(confirmer)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"scanned", `{"orderID": "CAISENgvlJ6jLWAzERDzjyHVybY"}`, http.StatusOK},
+		{"scanned twice", `{"orderID": "CAISENgvlJ6jLWAzERDzjyHVybY"}`, http.StatusConflict},
+		{"unknown label", `{"orderID": "missing"}`, http.StatusNotFound},
+		{"wrong phone", `{"number": "7", "phoneLast4": "1234"}`, http.StatusForbidden},
+		{"typed", `{"number": " 7 ", "phoneLast4": "9876"}`, http.StatusOK},
+		{"typed twice", `{"number": "7", "phoneLast4": "9876"}`, http.StatusConflict},
+		{"not ready", `{"orderID": "labeled"}`, http.StatusConflict},
+		{"no phone", `{"number": "7"}`, http.StatusBadRequest},
+		{"not json", `7`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/pickups", strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("%s: got %d, want %d: %s", tt.name, w.Code, tt.want, w.Body.String())
+		}
+		if tt.name == "scanned" {
+			var body struct{ Code string }
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != "W-1042" {
+				t.Errorf("%s: unexpected body %s", tt.name, w.Body.String())
+			}
+		}
+	}
+
+	for _, id := range []string{"CAISENgvlJ6jLWAzERDzjyHVybY", "R2B3Z8WMVt3EAmzYWLZvz7Y69EbZY"} {
+		if o := confirmer.orders[id]; o.Status != orderType.ORDER_STATUS_CLOSED || len(o.StatusTransitions) != 1 {
+			t.Errorf("picked up order was not closed with a transition: %+v", o)
+		}
+	}
+}
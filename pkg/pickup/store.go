@@ -0,0 +1,102 @@
+package pickup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/fundraiser"
+	"github.com/kofc7186/fundraiser-manager/pkg/orders"
+	"github.com/kofc7186/fundraiser-manager/pkg/tracing"
+	orderType "github.com/kofc7186/fundraiser-manager/pkg/types/order"
+)
+
+// Confirmer closes the orders which are picked up; it is implemented by Store, and served over HTTP by Handler
+type Confirmer interface {
+	Confirm(ctx context.Context, claim Claim) (*orderType.Order, error)
+}
+
+var _ Confirmer = (*Store)(nil)
+
+// Store is the Confirmer over the Firestore documents of a fundraiser
+type Store struct {
+	client       *firestore.Client
+	fundraiserID string
+}
+
+// NewStore returns the Store of the fundraiser
+func NewStore(client *firestore.Client, fundraiserID string) *Store {
+	return &Store{client: client, fundraiserID: fundraiserID}
+}
+
+// Confirm moves the claimed order from READY to CLOSED, recording the transition; the order is read and written within
+// a transaction, so that an order can't be picked up twice
+func (s *Store) Confirm(ctx context.Context, claim Claim) (*orderType.Order, error) {
+	if err := claim.Validate(); err != nil {
+		return nil, err
+	}
+
+	o := &orderType.Order{}
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var docSnap *firestore.DocumentSnapshot
+		var err error
+		if claim.OrderID != "" {
+			docSnap, err = tx.Get(s.client.Doc(fundraiser.Path(s.fundraiserID, "orders", claim.OrderID)))
+			if status.Code(err) == codes.NotFound {
+				return orders.ErrNotFound
+			} else if err != nil {
+				return err
+			}
+			*o = orderType.Order{}
+			if err := docSnap.DataTo(o); err != nil {
+				return err
+			}
+		} else {
+			number, _ := claim.number()
+			docSnaps, err := tx.Documents(s.client.Collection(fundraiser.Path(s.fundraiserID, "orders")).Where("number", "==", number)).GetAll()
+			if err != nil {
+				return err
+			}
+			candidates := make([]*orderType.Order, len(docSnaps))
+			for i, candidateSnap := range docSnaps {
+				candidates[i] = &orderType.Order{}
+				if err := candidateSnap.DataTo(candidates[i]); err != nil {
+					return err
+				}
+			}
+			selected, err := claim.Select(candidates)
+			if err != nil {
+				return err
+			}
+			for i, candidate := range candidates {
+				if candidate == selected {
+					docSnap = docSnaps[i]
+				}
+			}
+			*o = *selected
+		}
+
+		if err := Check(o); err != nil {
+			return err
+		}
+		if err := o.Transition(orderType.ORDER_STATUS_CLOSED, time.Now()); err != nil {
+			return err
+		}
+
+		// carry the trace through to the CDC event caused by this write
+		o.SetTraceParent(tracing.TraceParent(ctx))
+		return tx.Update(docSnap.Ref, []firestore.Update{
+			{Path: "status", Value: o.Status},
+			{Path: "statusTransitions", Value: o.StatusTransitions},
+			{Path: "traceparent", Value: o.TraceParent},
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pickup: %w", err)
+	}
+	return o, nil
+}
@@ -0,0 +1,39 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/config"
+)
+
+// File provides secrets from a config.SecretsFile, such as one mounted from Secret Manager or written for local
+// development, re-reading it whenever it is modified
+type File struct {
+	Path string
+
+	mu       sync.Mutex
+	modified time.Time
+	secrets  config.SecretsFile
+}
+
+func (f *File) Secret(ctx context.Context, name string) (string, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return "", false, fmt.Errorf("secrets: %w", err)
+	}
+	if f.secrets == nil || !info.ModTime().Equal(f.modified) {
+		secrets, err := config.ReadSecretsFile(f.Path)
+		if err != nil {
+			return "", false, err
+		}
+		f.secrets, f.modified = secrets, info.ModTime()
+	}
+	return f.secrets.Secret(ctx, name)
+}
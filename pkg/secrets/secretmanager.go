@@ -0,0 +1,59 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/option"
+	secretmanager "google.golang.org/api/secretmanager/v1"
+)
+
+// RESOURCE_SUFFIX is appended to the name of a secret to name the environment variable holding the resource name
+// of the Secret Manager secret it is read from, e.g. SQUARE_ACCESS_TOKEN_SECRET=projects/p/secrets/s
+const RESOURCE_SUFFIX = "_SECRET"
+
+// SecretManager provides the latest version of secrets from Secret Manager, or the version named by their resource
+// environment variable (see RESOURCE_SUFFIX); it does not hold secrets without one
+type SecretManager struct {
+	// Options configure the Secret Manager client, which is created when a secret is first read
+	Options []option.ClientOption
+
+	once    sync.Once
+	service *secretmanager.Service
+	err     error
+}
+
+func (s *SecretManager) Secret(ctx context.Context, name string) (string, bool, error) {
+	resource, ok := os.LookupEnv(name + RESOURCE_SUFFIX)
+	if !ok || resource == "" {
+		return "", false, nil
+	}
+	if !strings.Contains(resource, "/versions/") {
+		resource += "/versions/latest"
+	}
+
+	s.once.Do(func() {
+		// the function need not be able to reach Secret Manager unless it has secrets there
+		s.service, s.err = secretmanager.NewService(context.WithoutCancel(ctx), s.Options...)
+	})
+	if s.err != nil {
+		return "", false, s.err
+	}
+
+	response, err := s.service.Projects.Secrets.Versions.Access(resource).Context(ctx).Do()
+	if err != nil {
+		return "", false, fmt.Errorf("accessing %s: %w", resource, err)
+	}
+	if response.Payload == nil {
+		return "", false, fmt.Errorf("accessing %s: no payload", resource)
+	}
+	data, err := base64.StdEncoding.DecodeString(response.Payload.Data)
+	if err != nil {
+		return "", false, fmt.Errorf("accessing %s: %w", resource, err)
+	}
+	return string(data), true, nil
+}
@@ -0,0 +1,96 @@
+// Package secrets provides the secrets of a function, such as Square credentials, from Secret Manager or a local
+// file, and keeps them current as they are rotated so that functions need not be redeployed
+//
+// Every provider is a config.SecretProvider, so that secrets are loaded and validated with the rest of the
+// configuration of a function; a Value then re-reads a secret from the provider as the function runs
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/config"
+)
+
+// DefaultRefreshInterval is how long a Value uses a secret before re-reading it
+const DefaultRefreshInterval = 5 * time.Minute
+
+// MIN_REFRESH_INTERVAL bounds how often Value.Refresh re-reads a secret, so that requests carrying bad credentials
+// cannot flood the provider
+const MIN_REFRESH_INTERVAL = 10 * time.Second
+
+// Open returns the provider of the function's secrets: the File named by config.SECRETS_FILE_ENV if it is set, and
+// otherwise Secret Manager
+func Open() config.SecretProvider {
+	if path, ok := os.LookupEnv(config.SECRETS_FILE_ENV); ok {
+		return &File{Path: path}
+	}
+	return &SecretManager{}
+}
+
+// Value is the current value of a secret, re-read from its provider every RefreshInterval or when Refresh is
+// called; if re-reading fails the last value is kept, and if the provider does not hold the secret, the value it
+// was created with is used throughout
+type Value struct {
+	provider config.SecretProvider
+	name     string
+
+	// RefreshInterval is how long a value is used before it is re-read
+	RefreshInterval time.Duration
+
+	mu      sync.Mutex
+	value   string
+	fetched time.Time
+}
+
+// NewValue returns the Value of the named secret, starting from value, which was typically loaded with the
+// configuration of the function from the same provider
+func NewValue(provider config.SecretProvider, name, value string) *Value {
+	return &Value{provider: provider, name: name, RefreshInterval: DefaultRefreshInterval, value: value, fetched: time.Now()}
+}
+
+// Get returns the current value of the secret, re-reading it if it is older than RefreshInterval
+func (v *Value) Get(ctx context.Context) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if time.Since(v.fetched) >= v.RefreshInterval {
+		if _, err := v.read(ctx); err != nil {
+			slog.WarnContext(ctx, fmt.Sprintf("using the last value of secret %s: %v", v.name, err))
+		}
+	}
+	if v.value == "" {
+		return "", fmt.Errorf("secret %s: %w", v.name, config.ErrMissing)
+	}
+	return v.value, nil
+}
+
+// Refresh re-reads the secret, such as after a credential was rejected, reporting whether its value changed; it is
+// not re-read more than once every MIN_REFRESH_INTERVAL
+func (v *Value) Refresh(ctx context.Context) (bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if time.Since(v.fetched) < MIN_REFRESH_INTERVAL {
+		return false, nil
+	}
+	return v.read(ctx)
+}
+
+// read re-reads the secret from the provider; v.mu must be held
+func (v *Value) read(ctx context.Context) (bool, error) {
+	// whether or not it succeeds, the next attempt waits for another interval
+	v.fetched = time.Now()
+	value, ok, err := v.provider.Secret(ctx, v.name)
+	if err != nil {
+		return false, fmt.Errorf("secret %s: %w", v.name, err)
+	}
+	if !ok || value == v.value {
+		return false, nil
+	}
+	v.value = value
+	slog.InfoContext(ctx, fmt.Sprintf("secret %s was rotated", v.name))
+	return true, nil
+}
@@ -0,0 +1,127 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/api/option"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/config"
+)
+
+// rotating is a provider whose secret is changed by the test, counting how often it is read
+type rotating struct {
+	value string
+	err   error
+	reads int
+}
+
+func (r *rotating) Secret(ctx context.Context, name string) (string, bool, error) {
+	r.reads++
+	return r.value, r.value != "", r.err
+}
+
+func TestValue(t *testing.T) {
+	ctx := context.Background()
+	provider := &rotating{value: "v1"}
+	v := NewValue(provider, "SQUARE_ACCESS_TOKEN", "v1")
+
+	provider.value = "v2"
+	if value, _ := v.Get(ctx); value != "v1" || provider.reads != 0 {
+		t.Errorf("got %q after %d reads, want the initial value without reading", value, provider.reads)
+	}
+	if changed, _ := v.Refresh(ctx); changed || provider.reads != 0 {
+		t.Errorf("refreshed within MIN_REFRESH_INTERVAL of loading")
+	}
+
+	// as if the secret was loaded long ago
+	v.fetched = time.Now().Add(-DefaultRefreshInterval)
+	if value, _ := v.Get(ctx); value != "v2" || provider.reads != 1 {
+		t.Errorf("got %q after %d reads, want the rotated value", value, provider.reads)
+	}
+
+	v.fetched = time.Now().Add(-MIN_REFRESH_INTERVAL)
+	provider.value = "v3"
+	if changed, err := v.Refresh(ctx); !changed || err != nil {
+		t.Errorf("refresh after rotation: changed=%t, %v", changed, err)
+	}
+
+	v.fetched = time.Now().Add(-DefaultRefreshInterval)
+	provider.err = errors.New("unavailable")
+	if value, err := v.Get(ctx); value != "v3" || err != nil {
+		t.Errorf("got %q, %v; want the last value to be kept when the provider fails", value, err)
+	}
+
+	v.fetched = time.Now().Add(-DefaultRefreshInterval)
+	provider.value, provider.err = "", nil
+	if value, _ := v.Get(ctx); value != "v3" {
+		t.Errorf("got %q; want the last value to be kept when the provider does not hold the secret", value)
+	}
+
+	if _, err := NewValue(provider, "SQUARE_ACCESS_TOKEN", "").Get(ctx); !errors.Is(err, config.ErrMissing) {
+		t.Errorf("got %v for a secret which was never set", err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "secrets.yaml")
+	write := func(content string, modified time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := &File{Path: path}
+	write("SQUARE_SIGNATURE_KEY: old\n", time.Now().Add(-time.Hour))
+	if value, ok, err := f.Secret(ctx, "SQUARE_SIGNATURE_KEY"); value != "old" || !ok || err != nil {
+		t.Fatalf("got %q, %t, %v", value, ok, err)
+	}
+	if _, ok, _ := f.Secret(ctx, "SQUARE_ACCESS_TOKEN"); ok {
+		t.Error("provided a secret which is not in the file")
+	}
+
+	write("SQUARE_SIGNATURE_KEY: new\n", time.Now())
+	if value, _, _ := f.Secret(ctx, "SQUARE_SIGNATURE_KEY"); value != "new" {
+		t.Errorf("got %q after the file was rewritten", value)
+	}
+}
+
+func TestSecretManager(t *testing.T) {
+	var accessed string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accessed = r.URL.Path
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"name":    "projects/p/secrets/fishfry-030824-square_access_token/versions/3",
+			"payload": map[string]string{"data": base64.StdEncoding.EncodeToString([]byte("token"))},
+		})
+	}))
+	defer srv.Close()
+
+	s := &SecretManager{Options: []option.ClientOption{option.WithEndpoint(srv.URL), option.WithoutAuthentication()}}
+	ctx := context.Background()
+
+	if _, ok, err := s.Secret(ctx, "SQUARE_ACCESS_TOKEN"); ok || err != nil {
+		t.Errorf("provided a secret without %s%s: %t, %v", "SQUARE_ACCESS_TOKEN", RESOURCE_SUFFIX, ok, err)
+	}
+
+	t.Setenv("SQUARE_ACCESS_TOKEN"+RESOURCE_SUFFIX, "projects/p/secrets/fishfry-030824-square_access_token")
+	value, ok, err := s.Secret(ctx, "SQUARE_ACCESS_TOKEN")
+	if value != "token" || !ok || err != nil {
+		t.Fatalf("got %q, %t, %v", value, ok, err)
+	}
+	if want := "/v1/projects/p/secrets/fishfry-030824-square_access_token/versions/latest:access"; accessed != want {
+		t.Errorf("accessed %s, want %s", accessed, want)
+	}
+}
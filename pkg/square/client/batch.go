@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/square/types/models"
+)
+
+// MAX_BATCH_ORDERS is the most orders BatchRetrieveOrders will retrieve at once
+const MAX_BATCH_ORDERS = 100
+
+// orderBatch is a BatchRetrieveOrders call gathering the orders of concurrent RetrieveOrder calls
+type orderBatch struct {
+	ctx      context.Context
+	orderIDs []string
+	// done is closed once orders and err are set
+	done   chan struct{}
+	orders map[string]models.Order
+	err    error
+}
+
+// orderBatcher gathers RetrieveOrder calls into batches, each sent once Options.OrderBatchWindow has passed since
+// its first order was requested or it has MAX_BATCH_ORDERS orders
+type orderBatcher struct {
+	mu      sync.Mutex
+	pending *orderBatch
+}
+
+// retrieveOrder adds orderID to the pending batch (starting one if need be) and waits for the batch to be sent
+func (c *Client) retrieveOrder(ctx context.Context, orderID string) (models.RetrieveOrderResponse, error) {
+	b := &c.orders
+	b.mu.Lock()
+	batch := b.pending
+	if batch == nil {
+		// the batch outlives the call which started it, but keeps its trace
+		batch = &orderBatch{ctx: context.WithoutCancel(ctx), done: make(chan struct{})}
+		b.pending = batch
+		time.AfterFunc(c.options.OrderBatchWindow, func() { c.sendOrderBatch(batch) })
+	}
+	if !slices.Contains(batch.orderIDs, orderID) {
+		batch.orderIDs = append(batch.orderIDs, orderID)
+	}
+	full := len(batch.orderIDs) >= MAX_BATCH_ORDERS
+	b.mu.Unlock()
+	if full {
+		c.sendOrderBatch(batch)
+	}
+
+	select {
+	case <-ctx.Done():
+		return models.RetrieveOrderResponse{}, ctx.Err()
+	case <-batch.done:
+	}
+	if batch.err != nil {
+		return models.RetrieveOrderResponse{}, batch.err
+	}
+	order, ok := batch.orders[orderID]
+	if !ok {
+		// BatchRetrieveOrders omits orders which do not exist, where RetrieveOrder would have failed
+		return models.RetrieveOrderResponse{}, classify(&Error{
+			Operation: "BatchRetrieveOrders",
+			Errors: []models.ModelError{{
+				Category: CATEGORY_INVALID_REQUEST_ERROR,
+				Code:     CODE_NOT_FOUND,
+				Detail:   fmt.Sprintf("order %s not found", orderID),
+			}},
+		})
+	}
+	return models.RetrieveOrderResponse{Order: &order}, nil
+}
+
+// sendOrderBatch sends batch unless it has already been sent
+func (c *Client) sendOrderBatch(batch *orderBatch) {
+	b := &c.orders
+	b.mu.Lock()
+	if b.pending != batch {
+		b.mu.Unlock()
+		return
+	}
+	b.pending = nil
+	b.mu.Unlock()
+
+	response, err := call(batch.ctx, c, "BatchRetrieveOrders", func(ctx context.Context) (models.BatchRetrieveOrdersResponse, *http.Response, error) {
+		return c.api.OrdersApi.BatchRetrieveOrders(ctx, models.BatchRetrieveOrdersRequest{OrderIds: batch.orderIDs})
+	}, func(r models.BatchRetrieveOrdersResponse) []models.ModelError { return r.Errors })
+
+	batch.orders = make(map[string]models.Order, len(response.Orders))
+	for _, order := range response.Orders {
+		batch.orders[order.Id] = order
+	}
+	batch.err = err
+	close(batch.done)
+}
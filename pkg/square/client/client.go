@@ -0,0 +1,77 @@
+// Package client wraps the generated Square API client: requests which fail transiently are retried by a
+// Transport aware of Square's rate limits, within the time the caller allows, and failed calls are returned as an
+// Error classifying the errors Square reported, marked retryable or permanent (see package failure)
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/metrics"
+	"github.com/kofc7186/fundraiser-manager/pkg/square/api"
+	"github.com/kofc7186/fundraiser-manager/pkg/square/types/models"
+)
+
+// Client makes the Square API calls of the egress gateway
+type Client struct {
+	api     *api.APIClient
+	options Options
+	orders  orderBatcher
+}
+
+// New returns a Client for configuration, replacing its HTTP client with one retrying through a Transport; if
+// credentials is not nil, they authorize its requests in place of any Authorization header of configuration
+func New(configuration *api.Configuration, credentials Credentials, options Options) *Client {
+	configuration.HTTPClient = &http.Client{Transport: &Transport{Options: options, Credentials: credentials}}
+	return &Client{api: api.NewAPIClient(configuration), options: options}
+}
+
+// call invokes fn within Options.MaxElapsed, recording its metrics and turning any failure, including errors
+// Square reported in an otherwise successful response, into an Error
+func call[T any](ctx context.Context, c *Client, operation string, fn func(context.Context) (T, *http.Response, error), modelErrors func(T) []models.ModelError) (T, error) {
+	if c.options.MaxElapsed > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.options.MaxElapsed)
+		defer cancel()
+	}
+
+	start := time.Now()
+	response, httpResponse, err := fn(ctx)
+	metrics.RecordSquareAPICall(ctx, operation, time.Since(start), status(httpResponse), err)
+	if err != nil {
+		return response, classify(newError(operation, httpResponse, err, nil))
+	}
+	if errs := modelErrors(response); len(errs) != 0 {
+		return response, classify(newError(operation, httpResponse, nil, errs))
+	}
+	return response, nil
+}
+
+func (c *Client) GetPayment(ctx context.Context, paymentID string) (models.GetPaymentResponse, error) {
+	return call(ctx, c, "GetPayment", func(ctx context.Context) (models.GetPaymentResponse, *http.Response, error) {
+		return c.api.PaymentsApi.GetPayment(ctx, paymentID)
+	}, func(r models.GetPaymentResponse) []models.ModelError { return r.Errors })
+}
+
+func (c *Client) ListPayments(ctx context.Context, opts *api.PaymentsApiListPaymentsOpts) (models.ListPaymentsResponse, error) {
+	return call(ctx, c, "ListPayments", func(ctx context.Context) (models.ListPaymentsResponse, *http.Response, error) {
+		return c.api.PaymentsApi.ListPayments(ctx, opts)
+	}, func(r models.ListPaymentsResponse) []models.ModelError { return r.Errors })
+}
+
+// RetrieveOrder retrieves the order, batched with those of concurrent calls if Options.OrderBatchWindow is set
+func (c *Client) RetrieveOrder(ctx context.Context, orderID string) (models.RetrieveOrderResponse, error) {
+	if c.options.OrderBatchWindow > 0 {
+		return c.retrieveOrder(ctx, orderID)
+	}
+	return call(ctx, c, "RetrieveOrder", func(ctx context.Context) (models.RetrieveOrderResponse, *http.Response, error) {
+		return c.api.OrdersApi.RetrieveOrder(ctx, orderID)
+	}, func(r models.RetrieveOrderResponse) []models.ModelError { return r.Errors })
+}
+
+func (c *Client) RetrieveCustomer(ctx context.Context, customerID string) (models.RetrieveCustomerResponse, error) {
+	return call(ctx, c, "RetrieveCustomer", func(ctx context.Context) (models.RetrieveCustomerResponse, *http.Response, error) {
+		return c.api.CustomersApi.RetrieveCustomer(ctx, customerID)
+	}, func(r models.RetrieveCustomerResponse) []models.ModelError { return r.Errors })
+}
@@ -0,0 +1,276 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+	"github.com/kofc7186/fundraiser-manager/pkg/square/api"
+)
+
+var testOptions = Options{
+	MaxAttempts: 5,
+	BaseDelay:   time.Millisecond,
+	MaxDelay:    10 * time.Millisecond,
+	MaxElapsed:  5 * time.Second,
+}
+
+// newTestClient returns a Client of a server answering every request with handler, and the number of requests
+// the server has received
+func newTestClient(t *testing.T, options Options, handler func(w http.ResponseWriter, attempt int64)) (*Client, *atomic.Int64) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		handler(w, requests.Add(1))
+	}))
+	t.Cleanup(server.Close)
+
+	configuration := api.NewConfiguration()
+	configuration.BasePath = server.URL
+	return New(configuration, nil, options), &requests
+}
+
+func TestRateLimited(t *testing.T) {
+	c, requests := newTestClient(t, testOptions, func(w http.ResponseWriter, attempt int64) {
+		if attempt < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"errors": [{"category": "RATE_LIMIT_ERROR", "code": "RATE_LIMITED"}]}`))
+			return
+		}
+		w.Write([]byte(`{"payment": {"id": "KkAkhdMsgzn59SM8A89WgKwekxLZY"}}`))
+	})
+
+	payment, err := c.GetPayment(context.Background(), "KkAkhdMsgzn59SM8A89WgKwekxLZY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Payment == nil || payment.Payment.Id != "KkAkhdMsgzn59SM8A89WgKwekxLZY" {
+		t.Errorf("GetPayment returned %+v", payment)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("made %d requests, want 3", got)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for name, test := range map[string]struct {
+		status int
+		body   string
+		kind   error
+		class  failure.Class
+	}{
+		"not found": {http.StatusNotFound,
+			`{"errors": [{"category": "INVALID_REQUEST_ERROR", "code": "NOT_FOUND", "detail": "Could not find payment"}]}`,
+			ErrNotFound, failure.CLASS_PERMANENT},
+		"invalid": {http.StatusBadRequest,
+			`{"errors": [{"category": "INVALID_REQUEST_ERROR", "code": "INVALID_VALUE", "field": "payment_id"}]}`,
+			ErrInvalidRequest, failure.CLASS_PERMANENT},
+		"unauthorized": {http.StatusUnauthorized,
+			`{"errors": [{"category": "AUTHENTICATION_ERROR", "code": "ACCESS_TOKEN_EXPIRED"}]}`,
+			ErrUnauthorized, failure.CLASS_PERMANENT},
+		"unavailable": {http.StatusServiceUnavailable,
+			`{"errors": [{"category": "API_ERROR", "code": "SERVICE_UNAVAILABLE"}]}`,
+			ErrUnavailable, failure.CLASS_RETRYABLE},
+		// errors reported in a successful response are classified all the same
+		"reported": {http.StatusOK,
+			`{"errors": [{"category": "INVALID_REQUEST_ERROR", "code": "NOT_FOUND"}]}`,
+			ErrNotFound, failure.CLASS_PERMANENT},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, _ := newTestClient(t, testOptions, func(w http.ResponseWriter, attempt int64) {
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.body))
+			})
+
+			_, err := c.RetrieveOrder(context.Background(), "CAISENgvlJ6jLWAzERDzjyHVybY")
+			var squareError *Error
+			if !errors.As(err, &squareError) || len(squareError.Errors) != 1 {
+				t.Fatalf("RetrieveOrder returned %v, want an Error carrying Square's errors", err)
+			}
+			if !errors.Is(err, test.kind) {
+				t.Errorf("%v is not %v", err, test.kind)
+			}
+			if class := failure.ClassOf(err); class != test.class {
+				t.Errorf("%v is %s, want %s", err, class, test.class)
+			}
+		})
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	options := testOptions
+	options.MaxElapsed = 100 * time.Millisecond
+	c, requests := newTestClient(t, options, func(w http.ResponseWriter, attempt int64) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	start := time.Now()
+	_, err := c.RetrieveCustomer(context.Background(), "JDKYHBWT1D4F8MFH63DBMEN8Y4")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("RetrieveCustomer took %v, beyond its deadline", elapsed)
+	}
+	if !errors.Is(err, ErrRateLimited) || !failure.IsRetryable(err) {
+		t.Errorf("RetrieveCustomer returned %v, want a retryable ErrRateLimited", err)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("made %d requests, want 1", got)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	options := testOptions
+	options.MaxAttempts = 1
+	options.BreakerThreshold = 2
+	options.BreakerCooldown = 50 * time.Millisecond
+	var healthy atomic.Bool
+	c, requests := newTestClient(t, options, func(w http.ResponseWriter, attempt int64) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(`{}`))
+	})
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetPayment(ctx, "KkAkhdMsgzn59SM8A89WgKwekxLZY"); !errors.Is(err, ErrUnavailable) {
+			t.Fatalf("GetPayment returned %v, want ErrUnavailable", err)
+		}
+	}
+	if _, err := c.GetPayment(ctx, "KkAkhdMsgzn59SM8A89WgKwekxLZY"); !errors.Is(err, ErrCircuitOpen) || !failure.IsRetryable(err) {
+		t.Errorf("GetPayment returned %v, want a retryable ErrCircuitOpen", err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("made %d requests while the breaker was open, want 2", got)
+	}
+
+	// once the cooldown has passed, a successful probe closes the breaker
+	healthy.Store(true)
+	time.Sleep(options.BreakerCooldown)
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetPayment(ctx, "KkAkhdMsgzn59SM8A89WgKwekxLZY"); err != nil {
+			t.Errorf("GetPayment returned %v after the cooldown", err)
+		}
+	}
+}
+
+// rotatedCredentials hold the token the test rotates to, which the Transport only learns of on Refresh
+type rotatedCredentials struct {
+	mu             sync.Mutex
+	token, rotated string
+}
+
+func (c *rotatedCredentials) Get(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token, nil
+}
+
+func (c *rotatedCredentials) Refresh(ctx context.Context) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changed := c.token != c.rotated
+	c.token = c.rotated
+	return changed, nil
+}
+
+func TestRotatedCredentials(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") != "Bearer new" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"errors": [{"category": "AUTHENTICATION_ERROR", "code": "UNAUTHORIZED"}]}`))
+			return
+		}
+		w.Write([]byte(`{"payment": {"id": "KkAkhdMsgzn59SM8A89WgKwekxLZY"}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	configuration := api.NewConfiguration()
+	configuration.BasePath = server.URL
+	configuration.AddDefaultHeader("Authorization", "Bearer stale")
+	credentials := &rotatedCredentials{token: "old", rotated: "new"}
+	c := New(configuration, credentials, testOptions)
+
+	if _, err := c.GetPayment(context.Background(), "KkAkhdMsgzn59SM8A89WgKwekxLZY"); err != nil {
+		t.Fatal(err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("made %d requests, want 2", got)
+	}
+
+	// a token which is rejected without having been rotated is not retried
+	credentials.rotated = "revoked"
+	credentials.token = "revoked"
+	requests.Store(0)
+	if _, err := c.GetPayment(context.Background(), "KkAkhdMsgzn59SM8A89WgKwekxLZY"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("GetPayment returned %v, want ErrUnauthorized", err)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("made %d requests, want 1", got)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"0":  0,
+		"12": 12 * time.Second,
+		time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat): 0,
+	} {
+		if got, ok := retryAfter(value); !ok || got != want {
+			t.Errorf("retryAfter(%q) = %v, %v; want %v", value, got, ok, want)
+		}
+	}
+	for _, value := range []string{"", "-1", "soon"} {
+		if got, ok := retryAfter(value); ok {
+			t.Errorf("retryAfter(%q) = %v", value, got)
+		}
+	}
+}
+
+func TestOrderBatching(t *testing.T) {
+	options := testOptions
+	options.OrderBatchWindow = 20 * time.Millisecond
+	c, requests := newTestClient(t, options, func(w http.ResponseWriter, attempt int64) {
+		// the order which does not exist is omitted
+		w.Write([]byte(`{"orders": [{"id": "CAISENgvlJ6jLWAzERDzjyHVybY"}, {"id": "Fg9bAqLd1HNH3gWJpwbRzHMvWM4F"}]}`))
+	})
+
+	orderIDs := []string{"CAISENgvlJ6jLWAzERDzjyHVybY", "Fg9bAqLd1HNH3gWJpwbRzHMvWM4F", "CAISENgvlJ6jLWAzERDzjyHVybY", "missing"}
+	errs := make([]error, len(orderIDs))
+	var wg sync.WaitGroup
+	for i, orderID := range orderIDs {
+		wg.Add(1)
+		go func(i int, orderID string) {
+			defer wg.Done()
+			order, err := c.RetrieveOrder(context.Background(), orderID)
+			if err == nil && (order.Order == nil || order.Order.Id != orderID) {
+				err = fmt.Errorf("RetrieveOrder(%s) returned %+v", orderID, order.Order)
+			}
+			errs[i] = err
+		}(i, orderID)
+	}
+	wg.Wait()
+
+	for i, err := range errs[:3] {
+		if err != nil {
+			t.Errorf("%s: %v", orderIDs[i], err)
+		}
+	}
+	if err := errs[3]; !errors.Is(err, ErrNotFound) || failure.ClassOf(err) != failure.CLASS_PERMANENT {
+		t.Errorf("RetrieveOrder of a missing order returned %v, want a permanent ErrNotFound", err)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("made %d requests, want 1", got)
+	}
+}
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+	"github.com/kofc7186/fundraiser-manager/pkg/square/api"
+	"github.com/kofc7186/fundraiser-manager/pkg/square/types/models"
+)
+
+// the categories of models.ModelError, see https://developer.squareup.com/reference/square/enums/ErrorCategory
+const (
+	CATEGORY_API_ERROR                   = "API_ERROR"
+	CATEGORY_AUTHENTICATION_ERROR        = "AUTHENTICATION_ERROR"
+	CATEGORY_INVALID_REQUEST_ERROR       = "INVALID_REQUEST_ERROR"
+	CATEGORY_RATE_LIMIT_ERROR            = "RATE_LIMIT_ERROR"
+	CATEGORY_PAYMENT_METHOD_ERROR        = "PAYMENT_METHOD_ERROR"
+	CATEGORY_REFUND_ERROR                = "REFUND_ERROR"
+	CATEGORY_MERCHANT_SUBSCRIPTION_ERROR = "MERCHANT_SUBSCRIPTION_ERROR"
+	CATEGORY_EXTERNAL_VENDOR_ERROR       = "EXTERNAL_VENDOR_ERROR"
+)
+
+// the codes of models.ModelError the client distinguishes, see https://developer.squareup.com/reference/square/enums/ErrorCode
+const (
+	CODE_NOT_FOUND            = "NOT_FOUND"
+	CODE_RATE_LIMITED         = "RATE_LIMITED"
+	CODE_UNAUTHORIZED         = "UNAUTHORIZED"
+	CODE_ACCESS_TOKEN_EXPIRED = "ACCESS_TOKEN_EXPIRED"
+	CODE_ACCESS_TOKEN_REVOKED = "ACCESS_TOKEN_REVOKED"
+	CODE_FORBIDDEN            = "FORBIDDEN"
+	CODE_SERVICE_UNAVAILABLE  = "SERVICE_UNAVAILABLE"
+	CODE_GATEWAY_TIMEOUT      = "GATEWAY_TIMEOUT"
+	CODE_REQUEST_TIMEOUT      = "REQUEST_TIMEOUT"
+)
+
+// the kinds of Error, for use with errors.Is
+var (
+	ErrRateLimited    = errors.New("rate limited by Square")
+	ErrUnauthorized   = errors.New("not authorized by Square")
+	ErrNotFound       = errors.New("not found in Square")
+	ErrInvalidRequest = errors.New("invalid Square request")
+	ErrUnavailable    = errors.New("Square unavailable")
+	// ErrCircuitOpen is returned without calling Square while the circuit breaker is open
+	ErrCircuitOpen = errors.New("Square circuit breaker open")
+)
+
+// Error is a failed Square API call, carrying the errors Square reported (if it responded at all)
+type Error struct {
+	Operation string
+	// StatusCode is the HTTP status of Square's response, or 0 if none was received
+	StatusCode int
+	Errors     []models.ModelError
+	// Err is the underlying error, if the call failed before Square reported any errors
+	Err error
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "calling Square %s", e.Operation)
+	if e.StatusCode != 0 {
+		fmt.Fprintf(&b, ": %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	for _, modelError := range e.Errors {
+		fmt.Fprintf(&b, ": %s/%s", modelError.Category, modelError.Code)
+		if modelError.Field != "" {
+			fmt.Fprintf(&b, " (%s)", modelError.Field)
+		}
+		if modelError.Detail != "" {
+			fmt.Fprintf(&b, " %s", modelError.Detail)
+		}
+	}
+	if e.Err != nil {
+		fmt.Fprintf(&b, ": %v", e.Err)
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether e is of the kind target, as judged from its status code and the errors Square reported
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests || e.has(CATEGORY_RATE_LIMIT_ERROR, CODE_RATE_LIMITED)
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden ||
+			e.has(CATEGORY_AUTHENTICATION_ERROR, CODE_UNAUTHORIZED, CODE_ACCESS_TOKEN_EXPIRED, CODE_ACCESS_TOKEN_REVOKED, CODE_FORBIDDEN)
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound || e.has(CODE_NOT_FOUND)
+	case ErrInvalidRequest:
+		return e.has(CATEGORY_INVALID_REQUEST_ERROR) && !e.has(CODE_NOT_FOUND)
+	case ErrUnavailable:
+		return e.StatusCode == http.StatusRequestTimeout || e.StatusCode >= http.StatusInternalServerError ||
+			e.has(CATEGORY_API_ERROR, CODE_SERVICE_UNAVAILABLE, CODE_GATEWAY_TIMEOUT, CODE_REQUEST_TIMEOUT)
+	}
+	return false
+}
+
+// has reports whether any of the errors Square reported has one of the given categories or codes
+func (e *Error) has(categoriesOrCodes ...string) bool {
+	for _, modelError := range e.Errors {
+		for _, s := range categoriesOrCodes {
+			if modelError.Category == s || modelError.Code == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Temporary reports whether the call may succeed if attempted again: Square was unreachable, unavailable or
+// rate limiting, whereas any other error Square reported will recur on every attempt
+func (e *Error) Temporary() bool {
+	if e.StatusCode == 0 && len(e.Errors) == 0 {
+		return true
+	}
+	return errors.Is(e, ErrRateLimited) || errors.Is(e, ErrUnavailable)
+}
+
+// classify marks e as retryable or permanent (see package failure) according to Temporary
+func classify(e *Error) error {
+	if e.Temporary() {
+		return failure.Retryable(e)
+	}
+	return failure.Permanent(e)
+}
+
+// newError builds the Error for a call to operation which returned err (or reported modelErrors)
+func newError(operation string, httpResponse *http.Response, err error, modelErrors []models.ModelError) *Error {
+	e := &Error{Operation: operation, Errors: modelErrors}
+	if httpResponse != nil {
+		e.StatusCode = httpResponse.StatusCode
+	}
+
+	var swaggerError api.GenericSwaggerError
+	if errors.As(err, &swaggerError) {
+		// the generated client leaves the errors in the body of the response
+		var body struct {
+			Errors []models.ModelError `json:"errors"`
+		}
+		if json.Unmarshal(swaggerError.Body(), &body) == nil && len(body.Errors) != 0 {
+			e.Errors = body.Errors
+			return e
+		}
+	}
+	e.Err = err
+	return e
+}
@@ -0,0 +1,257 @@
+package client
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Transport retries requests to Square which failed transiently, honoring the Retry-After of rate-limited
+// responses and otherwise backing off exponentially with jitter, without waiting past the deadline of the
+// request's context; a circuit breaker fails requests fast once Square has failed repeatedly
+type Transport struct {
+	Base    http.RoundTripper
+	Options Options
+	// Credentials, if set, authorize each request, which is repeated once if Square rejects a token which had
+	// since been rotated
+	Credentials Credentials
+
+	breaker breaker
+}
+
+// Credentials provide the access token of requests to Square, which may change as it is rotated
+type Credentials interface {
+	Get(ctx context.Context) (string, error)
+	// Refresh re-reads a token which Square rejected, reporting whether it changed
+	Refresh(ctx context.Context) (bool, error)
+}
+
+// Options tunes the retries of a Transport, and the time a Client allows for a call and how it batches them
+type Options struct {
+	// MaxAttempts bounds the attempts made at each request
+	MaxAttempts int
+	// BaseDelay is the backoff before the first retry, doubling on each retry up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxElapsed bounds the time a Client spends on a call, including retries, if its context has no earlier
+	// deadline
+	MaxElapsed time.Duration
+
+	// BreakerThreshold is the number of consecutive failed attempts after which the circuit breaker opens
+	BreakerThreshold int
+	// BreakerCooldown is how long the breaker stays open before letting a request through to probe Square
+	BreakerCooldown time.Duration
+
+	// OrderBatchWindow is how long a Client waits for concurrent RetrieveOrder calls to batch into a single
+	// BatchRetrieveOrders call; if 0, each order is retrieved on its own
+	OrderBatchWindow time.Duration
+}
+
+// DefaultOptions suits a function with the 60s timeout of the egress gateway
+var DefaultOptions = Options{
+	MaxAttempts:      5,
+	BaseDelay:        250 * time.Millisecond,
+	MaxDelay:         10 * time.Second,
+	MaxElapsed:       45 * time.Second,
+	BreakerThreshold: 10,
+	BreakerCooldown:  30 * time.Second,
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+	return t.Base
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	reauthorized := false
+	for attempt := 1; ; attempt++ {
+		if !t.breaker.allow(t.Options) {
+			return nil, ErrCircuitOpen
+		}
+
+		if attempt > 1 && req.Body != nil && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+		if t.Credentials != nil {
+			token, err := t.Credentials.Get(ctx)
+			if err != nil {
+				t.breaker.release()
+				return nil, err
+			}
+			// a RoundTripper must not modify the request it was given
+			req = req.Clone(ctx)
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := t.base().RoundTrip(req)
+		if err == nil && resp.StatusCode == http.StatusUnauthorized && t.Credentials != nil && !reauthorized &&
+			(req.Body == nil || req.GetBody != nil) {
+			reauthorized = true
+			changed, refreshErr := t.Credentials.Refresh(ctx)
+			if refreshErr != nil {
+				slog.WarnContext(ctx, "refreshing Square credentials failed", "error", refreshErr)
+			}
+			if changed {
+				slog.InfoContext(ctx, "repeating Square request with rotated credentials", "method", req.Method, "url", req.URL.Path)
+				t.breaker.release()
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				continue
+			}
+		}
+		switch {
+		case err != nil && ctx.Err() != nil:
+			// the caller gave up, which says nothing of Square either
+			t.breaker.release()
+			return nil, err
+		case err == nil && !retryableStatus(resp.StatusCode):
+			t.breaker.record(t.Options, true)
+			return resp, nil
+		case err == nil && resp.StatusCode == http.StatusTooManyRequests:
+			// rate limiting is not a sign that Square is unhealthy
+			t.breaker.release()
+		default:
+			t.breaker.record(t.Options, false)
+		}
+
+		delay := t.backoff(attempt, resp)
+		if attempt >= t.Options.MaxAttempts || (req.Body != nil && req.GetBody == nil) || !waitable(ctx, delay) {
+			return resp, err
+		}
+		slog.WarnContext(ctx, "retrying Square request", "method", req.Method, "url", req.URL.Path, "attempt", attempt,
+			"delay", delay, "status", status(resp), "error", err)
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryableStatus reports whether a response with the given status may succeed if the request is repeated
+func retryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns how long to wait before the next attempt: as long as the response asks, or else an exponential
+// backoff jittered between half and all of it
+func (t *Transport) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if delay, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			return delay
+		}
+	}
+	delay := t.Options.BaseDelay << (attempt - 1)
+	if delay > t.Options.MaxDelay || delay <= 0 {
+		delay = t.Options.MaxDelay
+	}
+	if delay <= 1 {
+		return delay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+}
+
+// retryAfter parses a Retry-After header, which is either a number of seconds or an HTTP date
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
+
+// waitable reports whether a retry after delay would begin before the deadline of ctx
+func waitable(ctx context.Context, delay time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Now().Add(delay).Before(deadline)
+}
+
+func status(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+// breaker opens after Options.BreakerThreshold consecutive failures; once Options.BreakerCooldown has passed it
+// lets a single request through, closing if it succeeds and opening again if it fails
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *breaker) allow(options Options) bool {
+	if options.BreakerThreshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < options.BreakerThreshold {
+		return true
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record the outcome of a request allowed through
+func (b *breaker) record(options Options, succeeded bool) {
+	if options.BreakerThreshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	probed := b.probing
+	b.probing = false
+	if succeeded {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= options.BreakerThreshold {
+		b.openUntil = time.Now().Add(options.BreakerCooldown)
+		if b.failures == options.BreakerThreshold || probed {
+			slog.Warn("Square circuit breaker open", "failures", b.failures, "cooldown", options.BreakerCooldown)
+		}
+	}
+}
+
+// release records a request allowed through whose outcome says nothing of the health of Square
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
@@ -13,6 +13,11 @@ func (wb *WebhookBase) ID() string {
 	return wb.EventID
 }
 
+func (wb *WebhookBase) EventType() string {
+	return wb.Type
+}
+
 type SquareWebhookEvent interface {
 	ID() string
+	EventType() string
 }
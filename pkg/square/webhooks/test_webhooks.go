@@ -1,3 +0,0 @@
-package webhooks
-
-// TODO: write unit tests
@@ -7,12 +7,16 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 
 	"github.com/kofc7186/fundraiser-manager/pkg/square/types/webhooks"
 )
 
+// ErrInvalidSignature is returned when a webhook's signature does not match the one computed with the signature key
+var ErrInvalidSignature = errors.New("square webhook signature could not be validated")
+
 // verifySquareWebhookSignature validates the event contained in the http.Request originated from a known
 // issuer, as cryptographically verified through the provided signature
 //
@@ -39,7 +43,7 @@ func verifySquareWebhookSignature(r *http.Request, signatureKey, notificationURL
 
 	signature := r.Header.Get("x-square-hmacsha256-signature")
 	if signature != base64.StdEncoding.EncodeToString(hash.Sum(nil)) {
-		return nil, errors.New("square webhook signature could not be validated")
+		return nil, ErrInvalidSignature
 	}
 	return payload, nil
 }
@@ -73,6 +77,8 @@ func VerifySquareWebhook(r *http.Request, signatureKey, notificationURL string)
 		typedEventPointer = &webhooks.RefundCreated{}
 	case webhooks.SQUARE_WEBHOOK_REFUND_UPDATED:
 		typedEventPointer = &webhooks.RefundUpdated{}
+	default:
+		return nil, fmt.Errorf("unsupported square webhook type %q", baseWebhookEvent.Type)
 	}
 
 	if err := json.Unmarshal(payload.Bytes(), typedEventPointer); err != nil {
@@ -0,0 +1,141 @@
+package webhooks
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/square/types/webhooks"
+	"github.com/kofc7186/fundraiser-manager/pkg/square/webhooks/webhookstest"
+)
+
+func TestVerifySquareWebhook(t *testing.T) {
+	expectedTypes := map[string]webhooks.SquareWebhookEvent{
+		webhooks.SQUARE_WEBHOOK_CUSTOMER_CREATED: &webhooks.CustomerCreated{},
+		webhooks.SQUARE_WEBHOOK_CUSTOMER_UPDATED: &webhooks.CustomerUpdated{},
+		webhooks.SQUARE_WEBHOOK_ORDER_CREATED:    &webhooks.OrderCreated{},
+		webhooks.SQUARE_WEBHOOK_ORDER_UPDATED:    &webhooks.OrderUpdated{},
+		webhooks.SQUARE_WEBHOOK_PAYMENT_CREATED:  &webhooks.PaymentCreated{},
+		webhooks.SQUARE_WEBHOOK_PAYMENT_UPDATED:  &webhooks.PaymentUpdated{},
+		webhooks.SQUARE_WEBHOOK_REFUND_CREATED:   &webhooks.RefundCreated{},
+		webhooks.SQUARE_WEBHOOK_REFUND_UPDATED:   &webhooks.RefundUpdated{},
+	}
+
+	for _, name := range webhookstest.WebhookNames() {
+		t.Run(name, func(t *testing.T) {
+			body := webhookstest.Webhook(t, name)
+			r := webhookstest.NewSignedRequest(t, body)
+
+			event, err := VerifySquareWebhook(r, webhookstest.SignatureKey, webhookstest.NotificationURL)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// fixture names are prefixed with the Square webhook type, e.g. "payment.created.pos"
+			parts := strings.SplitN(name, ".", 3)
+			expected, ok := expectedTypes[parts[0]+"."+parts[1]]
+			if !ok {
+				t.Fatalf("no expected type registered for fixture %q", name)
+			}
+			if reflect.TypeOf(event) != reflect.TypeOf(expected) {
+				t.Errorf("got %T, want %T", event, expected)
+			}
+			if event.ID() == "" {
+				t.Error("event ID was not populated")
+			}
+		})
+	}
+}
+
+func TestVerifySquareWebhookIgnoresWhitespace(t *testing.T) {
+	body := webhookstest.Webhook(t, "payment.created.online")
+	signature := webhookstest.Sign(t, body, webhookstest.SignatureKey, webhookstest.NotificationURL)
+
+	// Square computes the signature over the compacted payload, so reformatting must not invalidate it
+	r := httptest.NewRequest(http.MethodPost, webhookstest.NotificationURL, bytes.NewReader(bytes.ReplaceAll(body, []byte("\n"), []byte("\r\n\t"))))
+	r.Header.Set("x-square-hmacsha256-signature", signature)
+
+	if _, err := VerifySquareWebhook(r, webhookstest.SignatureKey, webhookstest.NotificationURL); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestVerifySquareWebhookRejects(t *testing.T) {
+	body := webhookstest.Webhook(t, "payment.created.online")
+	unsupportedBody := []byte(`{"merchant_id":"6SSW7HV8K2ST5","type":"inventory.count.updated","event_id":"x","created_at":"2024-03-08T22:15:03.719Z","data":{}}`)
+
+	tests := []struct {
+		name            string
+		body            []byte
+		signature       string
+		signatureKey    string
+		notificationURL string
+		badSignature    bool
+	}{
+		{
+			name:            "missing signature",
+			body:            body,
+			signatureKey:    webhookstest.SignatureKey,
+			notificationURL: webhookstest.NotificationURL,
+			badSignature:    true,
+		},
+		{
+			name:            "wrong signature key",
+			body:            body,
+			signature:       webhookstest.Sign(t, body, "some-other-key", webhookstest.NotificationURL),
+			signatureKey:    webhookstest.SignatureKey,
+			notificationURL: webhookstest.NotificationURL,
+			badSignature:    true,
+		},
+		{
+			name:            "wrong notification URL",
+			body:            body,
+			signature:       webhookstest.Sign(t, body, webhookstest.SignatureKey, "https://example.com/elsewhere"),
+			signatureKey:    webhookstest.SignatureKey,
+			notificationURL: webhookstest.NotificationURL,
+			badSignature:    true,
+		},
+		{
+			name:            "tampered body",
+			body:            bytes.Replace(body, []byte(`"amount": 2400`), []byte(`"amount": 1`), 1),
+			signature:       webhookstest.Sign(t, body, webhookstest.SignatureKey, webhookstest.NotificationURL),
+			signatureKey:    webhookstest.SignatureKey,
+			notificationURL: webhookstest.NotificationURL,
+			badSignature:    true,
+		},
+		{
+			name:            "invalid JSON",
+			body:            []byte(`{"type": "payment.created"`),
+			signatureKey:    webhookstest.SignatureKey,
+			notificationURL: webhookstest.NotificationURL,
+		},
+		{
+			name:            "unsupported webhook type",
+			body:            unsupportedBody,
+			signature:       webhookstest.Sign(t, unsupportedBody, webhookstest.SignatureKey, webhookstest.NotificationURL),
+			signatureKey:    webhookstest.SignatureKey,
+			notificationURL: webhookstest.NotificationURL,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, webhookstest.NotificationURL, bytes.NewReader(tt.body))
+			if tt.signature != "" {
+				r.Header.Set("x-square-hmacsha256-signature", tt.signature)
+			}
+
+			event, err := VerifySquareWebhook(r, tt.signatureKey, tt.notificationURL)
+			if err == nil {
+				t.Fatalf("expected error, got %T", event)
+			}
+			if errors.Is(err, ErrInvalidSignature) != tt.badSignature {
+				t.Errorf("errors.Is(%v, ErrInvalidSignature) = %t, want %t", err, !tt.badSignature, tt.badSignature)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+// Package webhookstest provides a corpus of recorded Square webhook and API payloads, along with helpers
+// to sign them the same way Square does, for use in tests across fundraiser-manager
+package webhookstest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+)
+
+const (
+	// SignatureKey is the webhook signature key used to sign the fixtures in tests
+	SignatureKey = "test-signature-key"
+	// NotificationURL is the webhook notification URL used to sign the fixtures in tests
+	NotificationURL = "https://example.com/WebhookRouter"
+
+	webhooksDir = "fixtures/webhooks"
+	apiDir      = "fixtures/api"
+)
+
+//go:embed fixtures
+var fixtures embed.FS
+
+// WebhookNames returns the names of all recorded webhook payloads, sorted lexically
+//
+// Names are of the form <square webhook type>.<variant>, e.g. "payment.created.pos"
+func WebhookNames() []string {
+	return names(webhooksDir)
+}
+
+// APIResponseNames returns the names of all recorded Square API responses, sorted lexically
+func APIResponseNames() []string {
+	return names(apiDir)
+}
+
+func names(dir string) []string {
+	entries, err := fs.ReadDir(fixtures, dir)
+	if err != nil {
+		panic(err)
+	}
+
+	var result []string
+	for _, entry := range entries {
+		result = append(result, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+	return result
+}
+
+// Webhook returns the raw bytes of the named webhook payload, failing the test if it does not exist
+func Webhook(t testing.TB, name string) []byte {
+	t.Helper()
+	return load(t, path.Join(webhooksDir, name+".json"))
+}
+
+// APIResponse returns the raw bytes of the named Square API response, failing the test if it does not exist
+func APIResponse(t testing.TB, name string) []byte {
+	t.Helper()
+	return load(t, path.Join(apiDir, name+".json"))
+}
+
+// UnmarshalAPIResponse decodes the named Square API response into v, failing the test upon any error
+func UnmarshalAPIResponse(t testing.TB, name string, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(APIResponse(t, name), v); err != nil {
+		t.Fatalf("unmarshalling API response %q: %v", name, err)
+	}
+}
+
+func load(t testing.TB, name string) []byte {
+	t.Helper()
+	payload, err := fixtures.ReadFile(name)
+	if err != nil {
+		t.Fatalf("loading fixture: %v", err)
+	}
+	return payload
+}
+
+// Sign computes the value Square would send in the x-square-hmacsha256-signature header for the body
+//
+// Square signs the compacted JSON body, so whitespace in the recorded fixtures does not affect the result
+func Sign(t testing.TB, body []byte, signatureKey, notificationURL string) string {
+	t.Helper()
+	compacted := new(bytes.Buffer)
+	if err := json.Compact(compacted, body); err != nil {
+		t.Fatalf("compacting body: %v", err)
+	}
+
+	hash := hmac.New(sha256.New, []byte(signatureKey))
+	hash.Write([]byte(notificationURL))
+	hash.Write(compacted.Bytes())
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil))
+}
+
+// NewSignedRequest returns an inbound webhook request for the body, signed with SignatureKey and NotificationURL
+func NewSignedRequest(t testing.TB, body []byte) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, NotificationURL, bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("x-square-hmacsha256-signature", Sign(t, body, SignatureKey, NotificationURL))
+	return r
+}
@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	eventschemas "github.com/kofc7186/fundraiser-manager/pkg/event/schemas"
+)
+
+// PubSubMessagePublishedType is the type of the CloudEvent Eventarc delivers for each Pub/Sub message
+const PubSubMessagePublishedType = "google.cloud.pubsub.topic.v1.messagePublished"
+
+// CloudEvent wraps a CloudEvent function so each invocation runs within a span named after the function; the
+// span continues the trace recorded on the event (or, for Pub/Sub deliveries, on the event nested within)
+func CloudEvent(name string, fn func(context.Context, event.Event) error) func(context.Context, event.Event) error {
+	return func(ctx context.Context, e event.Event) error {
+		attrs := []attribute.KeyValue{attribute.String("cloudevents.event_type", e.Type())}
+
+		parent := &e
+		if e.Type() == PubSubMessagePublishedType {
+			if nestedEvent, err := eventschemas.NestedEvent(e); err == nil {
+				parent = nestedEvent
+				attrs = append(attrs,
+					attribute.String("cloudevents.nested_event_type", nestedEvent.Type()),
+					attribute.String("cloudevents.nested_event_id", nestedEvent.ID()),
+				)
+			}
+		}
+		ctx = Extract(ctx, parent)
+
+		ctx, span := Tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(attrs...))
+		err := fn(ctx, e)
+		End(span, err)
+		Flush(ctx)
+		return err
+	}
+}
+
+// HTTP wraps an HTTP function so each request runs within a span named after the function
+func HTTP(name string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := Tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+		fn(w, r.WithContext(ctx))
+		span.End()
+		Flush(ctx)
+	}
+}
+
+// End records err (if any) on span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
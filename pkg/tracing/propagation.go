@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/cloudevents/sdk-go/v2/types"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// the trace context is carried in the "traceparent" and "tracestate" attributes defined by the CloudEvents
+// distributed tracing extension
+var propagator = propagation.TraceContext{}
+
+// eventCarrier adapts the extensions of a CloudEvent to a propagation.TextMapCarrier
+type eventCarrier struct {
+	e *event.Event
+}
+
+func (c eventCarrier) Get(key string) string {
+	value, ok := c.e.Extensions()[key]
+	if !ok {
+		return ""
+	}
+	s, _ := types.ToString(value)
+	return s
+}
+
+func (c eventCarrier) Set(key, value string) {
+	c.e.SetExtension(key, value)
+}
+
+func (c eventCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.e.Extensions()))
+	for key := range c.e.Extensions() {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Inject records the span context of ctx on e, if there is one
+func Inject(ctx context.Context, e *event.Event) {
+	propagator.Inject(ctx, eventCarrier{e})
+}
+
+// Extract returns a copy of ctx whose remote parent span is the one recorded on e, if there is one
+func Extract(ctx context.Context, e *event.Event) context.Context {
+	return propagator.Extract(ctx, eventCarrier{e})
+}
+
+// TraceParent returns the W3C traceparent header for the span context of ctx, or "" if there is none
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// ContextWithTraceParent returns a copy of ctx whose remote parent span is described by the W3C traceparent header
+func ContextWithTraceParent(ctx context.Context, traceParent string) context.Context {
+	if traceParent == "" {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier{"traceparent": traceParent})
+}
+
+// LinkToTraceParent returns a link to the span described by the W3C traceparent header, for spans that are
+// caused by more than one upstream operation
+func LinkToTraceParent(traceParent string) trace.Link {
+	return trace.LinkFromContext(ContextWithTraceParent(context.Background(), traceParent))
+}
@@ -0,0 +1,71 @@
+// Package tracing sets up OpenTelemetry tracing for fundraiser-manager functions, and propagates W3C trace
+// context between them within CloudEvents so a single order can be followed from the Square webhook through
+// every function it touches
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	texporter "github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/trace"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// EXPORTER_ENV selects where spans are exported:
+//   - "gcp" (the default) exports to Cloud Trace in GCP_PROJECT
+//   - "stdout" writes spans to stdout, for local runs
+//   - "none" disables tracing
+const EXPORTER_ENV = "OTEL_TRACES_EXPORTER"
+
+const instrumentationName = "github.com/kofc7186/fundraiser-manager"
+
+var provider *sdktrace.TracerProvider
+
+// Init installs the global tracer provider for the named function; it should be called from init() before
+// any handler is registered
+func Init(functionName string) error {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch exporterName := os.Getenv(EXPORTER_ENV); exporterName {
+	case "none":
+		return nil
+	case "stdout", "console":
+		exporter, err = stdouttrace.New()
+	case "", "gcp":
+		projectID, ok := os.LookupEnv("GCP_PROJECT")
+		if !ok {
+			return fmt.Errorf("GCP_PROJECT must be set to export traces to Cloud Trace")
+		}
+		exporter, err = texporter.New(texporter.WithProjectID(projectID))
+	default:
+		return fmt.Errorf("unsupported %s value %q", EXPORTER_ENV, exporterName)
+	}
+	if err != nil {
+		return err
+	}
+
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", functionName))),
+	)
+	otel.SetTracerProvider(provider)
+	return nil
+}
+
+// Tracer returns the tracer used for all fundraiser-manager spans
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Flush exports any buffered spans; functions may be frozen between invocations, so handlers flush before returning
+func Flush(ctx context.Context) {
+	if provider != nil {
+		_ = provider.ForceFlush(ctx)
+	}
+}
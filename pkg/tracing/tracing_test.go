@@ -0,0 +1,104 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	eventschemas "github.com/kofc7186/fundraiser-manager/pkg/event/schemas"
+)
+
+const traceParent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+func TestInjectExtract(t *testing.T) {
+	ctx := ContextWithTraceParent(context.Background(), traceParent)
+
+	e := event.New()
+	e.SetID("id")
+	e.SetType("test")
+	e.SetSource("test")
+	Inject(ctx, &e)
+	if got := e.Extensions()["traceparent"]; got != traceParent {
+		t.Fatalf("traceparent extension = %v, want %s", got, traceParent)
+	}
+
+	// round trip through JSON as happens when the event is published
+	eventJSON, err := e.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := event.New()
+	if err := received.UnmarshalJSON(eventJSON); err != nil {
+		t.Fatal(err)
+	}
+	if got := TraceParent(Extract(context.Background(), &received)); got != traceParent {
+		t.Errorf("extracted traceparent = %q, want %q", got, traceParent)
+	}
+}
+
+func TestInjectWithoutSpan(t *testing.T) {
+	e := event.New()
+	Inject(context.Background(), &e)
+	if _, ok := e.Extensions()["traceparent"]; ok {
+		t.Error("traceparent extension set without a span in the context")
+	}
+	if got := TraceParent(context.Background()); got != "" {
+		t.Errorf("TraceParent() = %q, want empty", got)
+	}
+}
+
+func TestCloudEventContinuesNestedTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	nested := event.New()
+	nested.SetID("nested")
+	nested.SetType("test.nested")
+	nested.SetSource("test")
+	Inject(ContextWithTraceParent(context.Background(), traceParent), &nested)
+	nestedJSON, err := nested.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delivery := event.New()
+	delivery.SetID("delivery")
+	delivery.SetType(PubSubMessagePublishedType)
+	delivery.SetSource("//pubsub.googleapis.com/projects/test/topics/test")
+	msg := eventschemas.MessagePublishedData{Message: eventschemas.PubSubMessage{Data: nestedJSON}}
+	msgJSON, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := delivery.SetData("application/json", msgJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	var handlerSpan trace.SpanContext
+	handler := CloudEvent("TestHandler", func(ctx context.Context, e event.Event) error {
+		handlerSpan = trace.SpanContextFromContext(ctx)
+		return nil
+	})
+	if err := handler(context.Background(), delivery); err != nil {
+		t.Fatal(err)
+	}
+
+	if handlerSpan.TraceID().String() != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("handler trace ID = %s, want that of the nested event", handlerSpan.TraceID())
+	}
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "TestHandler" {
+		t.Fatalf("unexpected spans: %v", spans)
+	}
+	if spans[0].Parent().SpanID().String() != "b7ad6b7169203331" {
+		t.Errorf("parent span ID = %s", spans[0].Parent().SpanID())
+	}
+}
@@ -16,9 +16,41 @@ type Customer struct {
 	PhoneNumber       string          `json:"phoneNumber" firestore:"phoneNumber"`
 	KnightOfColumbus  bool            `json:"isKnight" firestore:"isKnight"`
 	SquareUpdatedTime time.Time       `json:"squareUpdatedTime" firestore:"squareUpdatedTime"`
+	TraceParent       string          `json:"traceparent,omitempty" firestore:"traceparent,omitempty"`
 	Version           int64           `json:"version" firestore:"version"`
 }
 
+// GetID returns the ID of the customer, which is also the ID of its Firestore document
+func (c *Customer) GetID() string {
+	return c.ID
+}
+
+// GetIdempotencyKeys returns the set of event idempotency keys already applied to the customer
+func (c *Customer) GetIdempotencyKeys() map[string]bool {
+	return c.IdempotencyKeys
+}
+
+// SetIdempotencyKeys replaces the set of event idempotency keys applied to the customer
+func (c *Customer) SetIdempotencyKeys(keys map[string]bool) {
+	c.IdempotencyKeys = keys
+}
+
+// SetExpiration sets the timestamp used by the Firestore TTL policy to delete the customer
+func (c *Customer) SetExpiration(expiration time.Time) {
+	c.Expiration = expiration
+}
+
+// SetTraceParent records the W3C traceparent of the operation writing the customer, so the trace continues
+// through the Firestore change it causes
+func (c *Customer) SetTraceParent(traceParent string) {
+	c.TraceParent = traceParent
+}
+
+// IsOutOfOrder reports whether c reflects an older revision from Square than persisted
+func (c *Customer) IsOutOfOrder(persisted *Customer) bool {
+	return persisted.SquareUpdatedTime.After(c.SquareUpdatedTime) || persisted.Version >= c.Version
+}
+
 func CreateInternalCustomerFromSquareCustomer(squareCustomer models.Customer) (*Customer, error) {
 	r := &Customer{
 		EmailAddress: squareCustomer.EmailAddress,
@@ -0,0 +1,392 @@
+// Package fundraiser is the model of a fundraiser, kept in its fundraisers/<id> document
+package fundraiser
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	paymentType "github.com/kofc7186/fundraiser-manager/pkg/types/payment"
+)
+
+// This is where the fundraiser is in its lifecycle
+type Status string
+
+const (
+	// STATUS_PLANNED is a fundraiser being set up, which is not yet taking orders
+	STATUS_PLANNED Status = "PLANNED"
+	// STATUS_OPEN is a fundraiser taking orders
+	STATUS_OPEN Status = "OPEN"
+	// STATUS_CLOSED is a fundraiser no longer taking orders, whose documents are kept until they expire
+	STATUS_CLOSED Status = "CLOSED"
+	// STATUS_ARCHIVED is a closed fundraiser whose documents have been exported to the archive bucket
+	STATUS_ARCHIVED Status = "ARCHIVED"
+)
+
+func ParseStatus(status string) (Status, error) {
+	switch Status(status) {
+	case STATUS_PLANNED:
+		return STATUS_PLANNED, nil
+	case STATUS_OPEN:
+		return STATUS_OPEN, nil
+	case STATUS_CLOSED:
+		return STATUS_CLOSED, nil
+	case STATUS_ARCHIVED:
+		return STATUS_ARCHIVED, nil
+	}
+	return "", fmt.Errorf("%q is not a valid Status", status)
+}
+
+// statusTransitions are the statuses each status may move to; a closed fundraiser may be reopened, e.g. to take a
+// forgotten order, until it is archived
+var statusTransitions = map[Status][]Status{
+	STATUS_PLANNED:  {STATUS_OPEN, STATUS_CLOSED},
+	STATUS_OPEN:     {STATUS_CLOSED},
+	STATUS_CLOSED:   {STATUS_OPEN, STATUS_ARCHIVED},
+	STATUS_ARCHIVED: {},
+}
+
+// ErrInvalidTransition is returned when a fundraiser cannot move to the requested status from its current one
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// This is what happens to orders created outside the window of the fundraiser
+type WindowPolicy string
+
+const (
+	// WINDOW_POLICY_FLAG accepts the order, flagging it for review
+	WINDOW_POLICY_FLAG WindowPolicy = "FLAG"
+	// WINDOW_POLICY_REFUSE refuses the order, which is dead-lettered so that it can be replayed should the window
+	// be widened
+	WINDOW_POLICY_REFUSE WindowPolicy = "REFUSE"
+)
+
+func ParseWindowPolicy(policy string) (WindowPolicy, error) {
+	switch WindowPolicy(policy) {
+	case WINDOW_POLICY_FLAG:
+		return WINDOW_POLICY_FLAG, nil
+	case WINDOW_POLICY_REFUSE:
+		return WINDOW_POLICY_REFUSE, nil
+	}
+	return "", fmt.Errorf("%q is not a valid WindowPolicy", policy)
+}
+
+// DefaultOrderNumberStart is the number of the first order of a fundraiser which does not set one
+const DefaultOrderNumberStart = 1000
+
+// ErrOrderNumbersExhausted is returned when every number of the range an order is numbered from has been allocated;
+// numbers never wrap, so the range must be widened (and the order replayed) instead
+var ErrOrderNumbersExhausted = errors.New("order numbers exhausted")
+
+// NumberRange is a range of order numbers
+type NumberRange struct {
+	// End is the last number of the range; if not set, the range runs to the largest order number
+	End uint16 `json:"end,omitempty" firestore:"end,omitempty"`
+	// Prefix begins the short code of each order numbered from the range, e.g. "W-" for online orders
+	Prefix string `json:"prefix,omitempty" firestore:"prefix,omitempty"`
+	// Start is the first number of the range
+	Start uint16 `json:"start" firestore:"start"`
+}
+
+// last returns the last number of the range
+func (r NumberRange) last() uint16 {
+	if r.End == 0 {
+		return math.MaxUint16
+	}
+	return r.End
+}
+
+// OrderNumbering is how the orders of the fundraiser are numbered; online and in-person orders are numbered from the
+// default range unless given one of their own, so that the source of an order can be told from its number or code
+type OrderNumbering struct {
+	Default  NumberRange `json:"default" firestore:"default"`
+	InPerson NumberRange `json:"inPerson,omitempty" firestore:"inPerson,omitempty"`
+	Online   NumberRange `json:"online,omitempty" firestore:"online,omitempty"`
+	// ResetDaily restarts every range each day (in the fundraiser's time zone), e.g. for a fundraiser held over a
+	// weekend; numbers and codes are then only unique within a day
+	ResetDaily bool `json:"resetDaily" firestore:"resetDaily"`
+
+	// LegacyStart is the number of the first order of documents written before orders were numbered from ranges;
+	// it is only read, by MigrateOrderNumbers
+	LegacyStart uint16 `json:"-" firestore:"start,omitempty"`
+}
+
+// Range returns the range orders from source are numbered from, along with the name of its counter
+func (n *OrderNumbering) Range(source paymentType.PaymentSource) (string, NumberRange) {
+	switch {
+	case source == paymentType.PAYMENT_SOURCE_ONLINE && n.Online.Start != 0:
+		return string(paymentType.PAYMENT_SOURCE_ONLINE), n.Online
+	case source == paymentType.PAYMENT_SOURCE_IN_PERSON && n.InPerson.Start != 0:
+		return string(paymentType.PAYMENT_SOURCE_IN_PERSON), n.InPerson
+	}
+	r := n.Default
+	if r.Start == 0 {
+		r.Start = DefaultOrderNumberStart
+	}
+	return "DEFAULT", r
+}
+
+// validate reports every range which is empty, or overlaps another range with the same prefix
+func (n *OrderNumbering) validate() error {
+	ranges := map[string]NumberRange{}
+	for _, source := range []paymentType.PaymentSource{paymentType.PAYMENT_SOURCE_ONLINE, paymentType.PAYMENT_SOURCE_IN_PERSON, paymentType.PAYMENT_SOURCE_UNKNOWN} {
+		name, r := n.Range(source)
+		ranges[name] = r
+	}
+
+	var errs []error
+	names := []string{"DEFAULT", string(paymentType.PAYMENT_SOURCE_ONLINE), string(paymentType.PAYMENT_SOURCE_IN_PERSON)}
+	for i, name := range names {
+		r, ok := ranges[name]
+		if !ok {
+			continue
+		}
+		if r.Start > r.last() {
+			errs = append(errs, fmt.Errorf("orderNumbering: %s range ends at %d before it starts at %d", name, r.End, r.Start))
+		}
+		for _, other := range names[i+1:] {
+			o, ok := ranges[other]
+			if ok && r.Prefix == o.Prefix && r.Start <= o.last() && o.Start <= r.last() {
+				errs = append(errs, fmt.Errorf("orderNumbering: %s and %s ranges overlap without distinct prefixes", name, other))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// OrderNumber is a number allocated to an order
+type OrderNumber struct {
+	// Code is the short code of the order, its number preceded by the prefix of its range, e.g. "W-1042"
+	Code string
+	// Counter is the key within OrderNumbers of the counter the number was drawn from
+	Counter string
+	Number  uint16
+}
+
+// OrderNumberBlock is a run of consecutive order numbers leased from a counter, so that they can be handed out
+// without touching the document of the fundraiser
+type OrderNumberBlock struct {
+	Counter string
+	Prefix  string
+	Size    uint16
+	Start   uint16
+	taken   uint16
+}
+
+// Take hands out the next number of the block, reporting false once every number has been taken
+func (b *OrderNumberBlock) Take() (OrderNumber, bool) {
+	if b.taken >= b.Size {
+		return OrderNumber{}, false
+	}
+	number := b.Start + b.taken
+	b.taken++
+	return OrderNumber{Code: b.Prefix + strconv.Itoa(int(number)), Counter: b.Counter, Number: number}, true
+}
+
+// Retention is how long the documents of the fundraiser are kept
+type Retention struct {
+	// ExpirationTime, if set, replaces EXPIRATION_TIME as when the documents are deleted by the Firestore TTL policy
+	ExpirationTime time.Time `json:"expirationTime,omitempty" firestore:"expirationTime,omitempty"`
+	// SkipArchive is whether the documents expire without being exported to the archive bucket
+	SkipArchive bool `json:"skipArchive" firestore:"skipArchive"`
+}
+
+type StatusTransition struct {
+	PreviousStatus Status    `json:"previousStatus" firestore:"previousStatus"`
+	Status         Status    `json:"status" firestore:"status"`
+	Timestamp      time.Time `json:"timestamp" firestore:"timestamp"`
+}
+
+type Fundraiser struct {
+	// EndTime bounds, with StartTime, when orders are expected to be created; either may be left unset
+	EndTime time.Time `json:"endTime,omitempty" firestore:"endTime,omitempty"`
+	ID      string    `json:"id" firestore:"id"`
+	// LabelTemplate is the text/template rendered onto the label of each order, with the order as its data
+	LabelTemplate  string         `json:"labelTemplate,omitempty" firestore:"labelTemplate,omitempty"`
+	Name           string         `json:"name" firestore:"name"`
+	OrderNumbering OrderNumbering `json:"orderNumbering" firestore:"orderNumbering"`
+	// OrderNumbers are the last numbers allocated from each counter (see OrderNumber), maintained by the order-controller
+	OrderNumbers map[string]uint16 `json:"orderNumbers" firestore:"orderNumbers"`
+	// LegacyOrderNumber is the last number allocated to documents written before orders were numbered from counters;
+	// it is only read, by MigrateOrderNumbers
+	LegacyOrderNumber uint16             `json:"-" firestore:"orderNumber,omitempty"`
+	Retention         Retention          `json:"retention" firestore:"retention"`
+	SquareLocationIDs []string           `json:"squareLocationIDs" firestore:"squareLocationIDs"`
+	StartTime         time.Time          `json:"startTime,omitempty" firestore:"startTime,omitempty"`
+	Status            Status             `json:"status" firestore:"status"`
+	StatusTransitions []StatusTransition `json:"statusTransitions" firestore:"statusTransitions"`
+	// TimeZone is the IANA name of the time zone the fundraiser is held in, e.g. America/New_York
+	TimeZone     string       `json:"timeZone" firestore:"timeZone"`
+	UpdatedTime  time.Time    `json:"updatedTime" firestore:"updatedTime"`
+	WindowPolicy WindowPolicy `json:"windowPolicy" firestore:"windowPolicy"`
+}
+
+// Validate reports every problem with the metadata of the fundraiser
+func (f *Fundraiser) Validate() error {
+	var errs []error
+	if f.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+	if !f.StartTime.IsZero() && !f.EndTime.IsZero() && !f.StartTime.Before(f.EndTime) {
+		errs = append(errs, fmt.Errorf("startTime %s is not before endTime %s", f.StartTime, f.EndTime))
+	}
+	if _, err := time.LoadLocation(f.TimeZone); err != nil {
+		errs = append(errs, fmt.Errorf("timeZone: %w", err))
+	}
+	if _, err := ParseStatus(string(f.Status)); err != nil {
+		errs = append(errs, err)
+	}
+	if f.WindowPolicy != "" {
+		if _, err := ParseWindowPolicy(string(f.WindowPolicy)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := f.OrderNumbering.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := template.New("label").Parse(f.LabelTemplate); err != nil {
+		errs = append(errs, fmt.Errorf("labelTemplate: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// Location returns the time zone the fundraiser is held in, or UTC if it is not set
+func (f *Fundraiser) Location() *time.Location {
+	location, err := time.LoadLocation(f.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// RenderLabel renders the LabelTemplate of the fundraiser with data (the order being labeled); it returns "" if the
+// fundraiser has no template
+func (f *Fundraiser) RenderLabel(data any) (string, error) {
+	if f.LabelTemplate == "" {
+		return "", nil
+	}
+	tmpl, err := template.New("label").Parse(f.LabelTemplate)
+	if err != nil {
+		return "", fmt.Errorf("labelTemplate: %w", err)
+	}
+	var label strings.Builder
+	if err := tmpl.Execute(&label, data); err != nil {
+		return "", fmt.Errorf("labelTemplate: %w", err)
+	}
+	return label.String(), nil
+}
+
+// InWindow reports whether an order created at t falls within the window of the fundraiser, which also ends when the
+// fundraiser is closed
+func (f *Fundraiser) InWindow(t time.Time) bool {
+	if !f.StartTime.IsZero() && t.Before(f.StartTime) {
+		return false
+	}
+	if !f.EndTime.IsZero() && !t.Before(f.EndTime) {
+		return false
+	}
+	if closedTime, ok := f.closedTime(); ok && !t.Before(closedTime) {
+		return false
+	}
+	return true
+}
+
+// closedTime returns when a closed (or archived) fundraiser was last closed
+func (f *Fundraiser) closedTime() (time.Time, bool) {
+	if f.Status != STATUS_CLOSED && f.Status != STATUS_ARCHIVED {
+		return time.Time{}, false
+	}
+	for i := len(f.StatusTransitions) - 1; i >= 0; i-- {
+		if f.StatusTransitions[i].Status == STATUS_CLOSED {
+			return f.StatusTransitions[i].Timestamp, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// RefusesOutsideWindow reports whether orders created outside the window are refused rather than flagged
+func (f *Fundraiser) RefusesOutsideWindow() bool {
+	return f.WindowPolicy == WINDOW_POLICY_REFUSE
+}
+
+// OrderNumberCounter returns the key within OrderNumbers of the counter an order from source created at t is numbered
+// from
+func (f *Fundraiser) OrderNumberCounter(source paymentType.PaymentSource, t time.Time) string {
+	counter, _ := f.OrderNumbering.Range(source)
+	if f.OrderNumbering.ResetDaily {
+		counter = t.In(f.Location()).Format(time.DateOnly) + "/" + counter
+	}
+	return counter
+}
+
+// MigrateOrderNumbers carries the numbering of a document written before orders were numbered from ranges over to
+// its DEFAULT range and counter, so that its numbering continues rather than restarting and reissuing numbers
+// customers already hold
+func (f *Fundraiser) MigrateOrderNumbers() {
+	if f.OrderNumbering.Default.Start == 0 && f.OrderNumbering.LegacyStart != 0 {
+		f.OrderNumbering.Default.Start = f.OrderNumbering.LegacyStart
+	}
+	if len(f.OrderNumbers) == 0 && f.LegacyOrderNumber != 0 {
+		f.OrderNumbers = map[string]uint16{"DEFAULT": f.LegacyOrderNumber}
+	}
+}
+
+// LeaseOrderNumbers leases up to size numbers for orders from source created at t, advancing the counter in
+// OrderNumbers they are drawn from; fewer are leased when the range is nearly exhausted
+func (f *Fundraiser) LeaseOrderNumbers(source paymentType.PaymentSource, t time.Time, size uint16) (OrderNumberBlock, error) {
+	counter := f.OrderNumberCounter(source, t)
+	_, r := f.OrderNumbering.Range(source)
+
+	start := r.Start
+	// a counter below the start of its range is left over from before the range was moved
+	if last, ok := f.OrderNumbers[counter]; ok && last >= r.Start {
+		if last >= r.last() {
+			return OrderNumberBlock{}, fmt.Errorf("%w: %s range ends at %d", ErrOrderNumbersExhausted, counter, r.last())
+		}
+		start = last + 1
+	}
+	if size == 0 {
+		size = 1
+	}
+	if remaining := r.last() - start; size-1 > remaining {
+		size = remaining + 1
+	}
+
+	if f.OrderNumbers == nil {
+		f.OrderNumbers = make(map[string]uint16)
+	}
+	f.OrderNumbers[counter] = start + size - 1
+	return OrderNumberBlock{Counter: counter, Prefix: r.Prefix, Size: size, Start: start}, nil
+}
+
+// NextOrderNumber allocates the number of an order from source created at t, advancing the counter in OrderNumbers
+// it is drawn from
+func (f *Fundraiser) NextOrderNumber(source paymentType.PaymentSource, t time.Time) (OrderNumber, error) {
+	block, err := f.LeaseOrderNumbers(source, t, 1)
+	if err != nil {
+		return OrderNumber{}, err
+	}
+	orderNumber, _ := block.Take()
+	return orderNumber, nil
+}
+
+// Transition moves the fundraiser to next at now, recording the transition
+func (f *Fundraiser) Transition(next Status, now time.Time) error {
+	if _, err := ParseStatus(string(next)); err != nil {
+		return err
+	}
+	allowed := false
+	for _, status := range statusTransitions[f.Status] {
+		allowed = allowed || status == next
+	}
+	if !allowed {
+		return fmt.Errorf("%w from %q to %q", ErrInvalidTransition, f.Status, next)
+	}
+	f.StatusTransitions = append(f.StatusTransitions, StatusTransition{PreviousStatus: f.Status, Status: next, Timestamp: now})
+	f.Status = next
+	f.UpdatedTime = now
+	return nil
+}
@@ -0,0 +1,257 @@
+package fundraiser
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	paymentType "github.com/kofc7186/fundraiser-manager/pkg/types/payment"
+)
+
+var (
+	start = time.Date(2024, 3, 8, 21, 0, 0, 0, time.UTC)
+	end   = time.Date(2024, 3, 9, 1, 0, 0, 0, time.UTC)
+)
+
+func TestValidate(t *testing.T) {
+	valid := Fundraiser{
+		Name:          "Fish Fry 3/8",
+		StartTime:     start,
+		EndTime:       end,
+		TimeZone:      "America/New_York",
+		Status:        STATUS_PLANNED,
+		WindowPolicy:  WINDOW_POLICY_FLAG,
+		LabelTemplate: "{{.Number}} {{.DisplayName}}",
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	invalid := valid
+	invalid.Name = ""
+	invalid.StartTime, invalid.EndTime = end, start
+	invalid.TimeZone = "America/Nowhere"
+	invalid.WindowPolicy = "IGNORE"
+	invalid.LabelTemplate = "{{.Number"
+	err := invalid.Validate()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	// every problem is reported at once
+	for _, want := range []string{"name", "startTime", "timeZone", "WindowPolicy", "labelTemplate"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s: %v", want, err)
+		}
+	}
+}
+
+func TestRenderLabel(t *testing.T) {
+	order := struct {
+		Number      uint16
+		DisplayName string
+	}{Number: 42, DisplayName: "Jane Doe"}
+
+	f := Fundraiser{}
+	if label, err := f.RenderLabel(order); err != nil || label != "" {
+		t.Errorf("label without template = %q, %v", label, err)
+	}
+
+	f.LabelTemplate = "{{.Number}} {{.DisplayName}}"
+	if label, err := f.RenderLabel(order); err != nil || label != "42 Jane Doe" {
+		t.Errorf("label = %q, %v", label, err)
+	}
+
+	f.LabelTemplate = "{{.Missing}}"
+	if _, err := f.RenderLabel(order); err == nil {
+		t.Error("expected error for a field the order does not have")
+	}
+}
+
+func TestTransition(t *testing.T) {
+	f := &Fundraiser{Status: STATUS_PLANNED}
+	now := start
+	for _, next := range []Status{STATUS_OPEN, STATUS_CLOSED, STATUS_OPEN, STATUS_CLOSED, STATUS_ARCHIVED} {
+		now = now.Add(time.Hour)
+		if err := f.Transition(next, now); err != nil {
+			t.Fatalf("transition to %s: %v", next, err)
+		}
+	}
+	if len(f.StatusTransitions) != 5 || f.StatusTransitions[4].PreviousStatus != STATUS_CLOSED || !f.UpdatedTime.Equal(now) {
+		t.Errorf("transitions not recorded: %+v", f)
+	}
+
+	for _, next := range []Status{STATUS_OPEN, STATUS_CLOSED, STATUS_PLANNED} {
+		if err := f.Transition(next, now); !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("transition from ARCHIVED to %s: got %v, want ErrInvalidTransition", next, err)
+		}
+	}
+	if err := (&Fundraiser{Status: STATUS_OPEN}).Transition("DONE", now); err == nil || errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("transition to unknown status: got %v", err)
+	}
+}
+
+func TestInWindow(t *testing.T) {
+	f := &Fundraiser{StartTime: start, EndTime: end, Status: STATUS_OPEN}
+	tests := []struct {
+		name string
+		t    time.Time
+		want bool
+	}{
+		{"before start", start.Add(-time.Minute), false},
+		{"at start", start, true},
+		{"during", start.Add(time.Hour), true},
+		{"at end", end, false},
+	}
+	for _, tt := range tests {
+		if got := f.InWindow(tt.t); got != tt.want {
+			t.Errorf("%s: InWindow = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if !(&Fundraiser{}).InWindow(start) {
+		t.Error("a fundraiser without a window should accept every order")
+	}
+
+	// closing early ends the window, but orders created before then are still accepted
+	closedAt := start.Add(2 * time.Hour)
+	if err := f.Transition(STATUS_CLOSED, closedAt); err != nil {
+		t.Fatal(err)
+	}
+	if !f.InWindow(closedAt.Add(-time.Minute)) {
+		t.Error("order created before the fundraiser closed should be in its window")
+	}
+	if f.InWindow(closedAt.Add(time.Minute)) {
+		t.Error("order created after the fundraiser closed should not be in its window")
+	}
+}
+
+func TestValidateOrderNumbering(t *testing.T) {
+	f := Fundraiser{Name: "Fish Fry 3/8", Status: STATUS_PLANNED}
+	tests := []struct {
+		name      string
+		numbering OrderNumbering
+		wantErr   bool
+	}{
+		{"default", OrderNumbering{}, false},
+		{"separate ranges", OrderNumbering{Default: NumberRange{Start: 1, End: 999}, Online: NumberRange{Start: 5000}}, false},
+		{"distinct prefixes", OrderNumbering{Online: NumberRange{Start: 1, Prefix: "W-"}, InPerson: NumberRange{Start: 1, Prefix: "P-"}}, false},
+		{"overlapping default", OrderNumbering{Online: NumberRange{Start: 5000}}, true},
+		{"overlapping sources", OrderNumbering{Default: NumberRange{Start: 1, End: 99}, Online: NumberRange{Start: 100, End: 200}, InPerson: NumberRange{Start: 200}}, true},
+		{"backwards", OrderNumbering{Default: NumberRange{Start: 100, End: 1}}, true},
+	}
+	for _, tt := range tests {
+		f.OrderNumbering = tt.numbering
+		if err := f.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNextOrderNumber(t *testing.T) {
+	f := &Fundraiser{
+		TimeZone: "America/New_York",
+		OrderNumbering: OrderNumbering{
+			Default: NumberRange{Start: 1, End: 999},
+			Online:  NumberRange{Start: 5000, End: 5001, Prefix: "W-"},
+		},
+	}
+	tests := []struct {
+		source  paymentType.PaymentSource
+		want    OrderNumber
+		wantErr error
+	}{
+		{paymentType.PAYMENT_SOURCE_IN_PERSON, OrderNumber{Code: "1", Counter: "DEFAULT", Number: 1}, nil},
+		{paymentType.PAYMENT_SOURCE_ONLINE, OrderNumber{Code: "W-5000", Counter: "ONLINE", Number: 5000}, nil},
+		{paymentType.PAYMENT_SOURCE_UNKNOWN, OrderNumber{Code: "2", Counter: "DEFAULT", Number: 2}, nil},
+		{paymentType.PAYMENT_SOURCE_ONLINE, OrderNumber{Code: "W-5001", Counter: "ONLINE", Number: 5001}, nil},
+		// numbers never wrap
+		{paymentType.PAYMENT_SOURCE_ONLINE, OrderNumber{}, ErrOrderNumbersExhausted},
+	}
+	for i, tt := range tests {
+		got, err := f.NextOrderNumber(tt.source, start)
+		if !errors.Is(err, tt.wantErr) || got != tt.want {
+			t.Errorf("%d: NextOrderNumber(%s) = %+v, %v; want %+v, %v", i, tt.source, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	if got, err := (&Fundraiser{}).NextOrderNumber(paymentType.PAYMENT_SOURCE_ONLINE, start); err != nil || got.Number != DefaultOrderNumberStart {
+		t.Errorf("NextOrderNumber = %+v, %v; want %d", got, err, DefaultOrderNumberStart)
+	}
+
+	// moving a range restarts its numbering
+	f.OrderNumbering.Default.Start, f.OrderNumbering.Default.End = 500, 0
+	if got, _ := f.NextOrderNumber(paymentType.PAYMENT_SOURCE_IN_PERSON, start); got.Number != 500 {
+		t.Errorf("NextOrderNumber after moving the range = %d, want 500", got.Number)
+	}
+}
+
+func TestNextOrderNumberResetDaily(t *testing.T) {
+	f := &Fundraiser{TimeZone: "America/New_York", OrderNumbering: OrderNumbering{Default: NumberRange{Start: 1}, ResetDaily: true}}
+	friday := time.Date(2024, 3, 8, 23, 30, 0, 0, time.UTC) // 18:30 in New York
+	saturday := friday.Add(6 * time.Hour)                   // 00:30 in New York
+
+	for _, want := range []OrderNumber{
+		{Code: "1", Counter: "2024-03-08/DEFAULT", Number: 1},
+		{Code: "2", Counter: "2024-03-08/DEFAULT", Number: 2},
+	} {
+		if got, err := f.NextOrderNumber(paymentType.PAYMENT_SOURCE_IN_PERSON, friday); err != nil || got != want {
+			t.Errorf("NextOrderNumber = %+v, %v; want %+v", got, err, want)
+		}
+	}
+	want := OrderNumber{Code: "1", Counter: "2024-03-09/DEFAULT", Number: 1}
+	if got, err := f.NextOrderNumber(paymentType.PAYMENT_SOURCE_IN_PERSON, saturday); err != nil || got != want {
+		t.Errorf("NextOrderNumber = %+v, %v; want %+v", got, err, want)
+	}
+}
+
+func TestLeaseOrderNumbers(t *testing.T) {
+	f := &Fundraiser{OrderNumbering: OrderNumbering{Default: NumberRange{Start: 1000, End: 1014, Prefix: "P-"}}}
+
+	block, err := f.LeaseOrderNumbers(paymentType.PAYMENT_SOURCE_IN_PERSON, start, 10)
+	if err != nil || block.Start != 1000 || block.Size != 10 || f.OrderNumbers["DEFAULT"] != 1009 {
+		t.Fatalf("LeaseOrderNumbers = %+v, %v; counter %d", block, err, f.OrderNumbers["DEFAULT"])
+	}
+	for want := uint16(1000); want < 1010; want++ {
+		if got, ok := block.Take(); !ok || got.Number != want || got.Code != "P-"+strconv.Itoa(int(want)) {
+			t.Fatalf("Take = %+v, %v; want %d", got, ok, want)
+		}
+	}
+	if _, ok := block.Take(); ok {
+		t.Error("Take should report an exhausted block")
+	}
+
+	// the lease is cut short at the end of the range
+	if block, err := f.LeaseOrderNumbers(paymentType.PAYMENT_SOURCE_IN_PERSON, start, 10); err != nil || block.Start != 1010 || block.Size != 5 {
+		t.Errorf("LeaseOrderNumbers = %+v, %v; want 5 numbers from 1010", block, err)
+	}
+	if _, err := f.LeaseOrderNumbers(paymentType.PAYMENT_SOURCE_IN_PERSON, start, 10); !errors.Is(err, ErrOrderNumbersExhausted) {
+		t.Errorf("LeaseOrderNumbers = %v, want ErrOrderNumbersExhausted", err)
+	}
+}
+
+func TestMigrateOrderNumbers(t *testing.T) {
+	// a document written before orders were numbered from ranges, which has handed out numbers 1 through 57
+	f := &Fundraiser{LegacyOrderNumber: 57, OrderNumbering: OrderNumbering{LegacyStart: 1}}
+	f.MigrateOrderNumbers()
+	if f.OrderNumbering.Default.Start != 1 {
+		t.Errorf("Default.Start = %d, want the legacy start 1", f.OrderNumbering.Default.Start)
+	}
+	if got, err := f.NextOrderNumber(paymentType.PAYMENT_SOURCE_IN_PERSON, start); err != nil || got.Number != 58 {
+		t.Errorf("NextOrderNumber = %+v, %v; want numbering to continue from 58", got, err)
+	}
+
+	// once migrated, the counters are kept rather than reseeded from the legacy number
+	f.MigrateOrderNumbers()
+	if f.OrderNumbers["DEFAULT"] != 58 {
+		t.Errorf("DEFAULT counter = %d after migrating again, want 58", f.OrderNumbers["DEFAULT"])
+	}
+
+	// a range set since is kept over the legacy start
+	f = &Fundraiser{OrderNumbering: OrderNumbering{Default: NumberRange{Start: 500}, LegacyStart: 1}}
+	f.MigrateOrderNumbers()
+	if f.OrderNumbering.Default.Start != 500 {
+		t.Errorf("Default.Start = %d, want 500", f.OrderNumbering.Default.Start)
+	}
+}
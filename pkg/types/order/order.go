@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
 	"github.com/kofc7186/fundraiser-manager/pkg/square/types/models"
 	paymentType "github.com/kofc7186/fundraiser-manager/pkg/types/payment"
 )
@@ -31,7 +32,7 @@ func parseSquareOrderState(state string) (SquareOrderState, error) {
 	case SQUARE_ORDER_STATE_DRAFT:
 		return SQUARE_ORDER_STATE_DRAFT, nil
 	}
-	return SQUARE_ORDER_STATE_UNKNOWN, fmt.Errorf("%q is not a valid SquareOrderState", state)
+	return SQUARE_ORDER_STATE_UNKNOWN, failure.Permanent(fmt.Errorf("%q is not a valid SquareOrderState", state))
 }
 
 // This is the status of the order as it flows through fundraiser-manager
@@ -47,7 +48,17 @@ const (
 	ORDER_STATUS_CANCELED OrderStatus = "CANCELED"
 )
 
-func parseOrderStatus(status string) (OrderStatus, error) {
+// ORDER_STATUSES are the statuses an order may be in
+var ORDER_STATUSES = []OrderStatus{
+	ORDER_STATUS_ONLINE,
+	ORDER_STATUS_PRESENT,
+	ORDER_STATUS_LABELED,
+	ORDER_STATUS_READY,
+	ORDER_STATUS_CLOSED,
+	ORDER_STATUS_CANCELED,
+}
+
+func ParseOrderStatus(status string) (OrderStatus, error) {
 	switch OrderStatus(status) {
 	case ORDER_STATUS_ONLINE:
 		return ORDER_STATUS_ONLINE, nil
@@ -62,9 +73,24 @@ func parseOrderStatus(status string) (OrderStatus, error) {
 	case ORDER_STATUS_CANCELED:
 		return ORDER_STATUS_CANCELED, nil
 	}
-	return ORDER_STATUS_UNKNOWN, fmt.Errorf("%q is not a valid OrderStatus", status)
+	return ORDER_STATUS_UNKNOWN, failure.Permanent(fmt.Errorf("%q is not a valid OrderStatus", status))
+}
+
+// orderStatusTransitions are the statuses each status may move to; orders paid for online may be labeled before the
+// customer is present
+var orderStatusTransitions = map[OrderStatus][]OrderStatus{
+	ORDER_STATUS_UNKNOWN:  {ORDER_STATUS_ONLINE, ORDER_STATUS_PRESENT, ORDER_STATUS_CANCELED},
+	ORDER_STATUS_ONLINE:   {ORDER_STATUS_PRESENT, ORDER_STATUS_LABELED, ORDER_STATUS_CANCELED},
+	ORDER_STATUS_PRESENT:  {ORDER_STATUS_LABELED, ORDER_STATUS_CANCELED},
+	ORDER_STATUS_LABELED:  {ORDER_STATUS_READY, ORDER_STATUS_CANCELED},
+	ORDER_STATUS_READY:    {ORDER_STATUS_CLOSED, ORDER_STATUS_CANCELED},
+	ORDER_STATUS_CLOSED:   {},
+	ORDER_STATUS_CANCELED: {},
 }
 
+// ErrInvalidTransition is returned when an order cannot move to the requested status from its current one
+var ErrInvalidTransition = errors.New("invalid status transition")
+
 // This is the type of the item within an order
 type OrderItemType string
 
@@ -84,7 +110,7 @@ func parseOrderItemType(itemType string) (OrderItemType, error) {
 	case ORDER_ITEM_TYPE_GIFT_CARD:
 		return ORDER_ITEM_TYPE_GIFT_CARD, nil
 	}
-	return ORDER_ITEM_TYPE_UNKNOWN, fmt.Errorf("%q is not a valid OrderItemType", itemType)
+	return ORDER_ITEM_TYPE_UNKNOWN, failure.Permanent(fmt.Errorf("%q is not a valid OrderItemType", itemType))
 }
 
 type OrderStatusTransition struct {
@@ -93,6 +119,13 @@ type OrderStatusTransition struct {
 	Timestamp      time.Time
 }
 
+// StaffNote is a note added to the order by the staff of the fundraiser, kept apart from the note from Square
+type StaffNote struct {
+	Author    string    `json:"author" firestore:"author"`
+	Text      string    `json:"text" firestore:"text"`
+	Timestamp time.Time `json:"timestamp" firestore:"timestamp"`
+}
+
 type OrderItem struct {
 	SquareCatalogObjectID string          `json:"squareCatalogObjectID" firestore:"squareCatalogObjectID"`
 	Modifiers             []OrderModifier `json:"modifiers" firestore:"modifiers"`
@@ -110,6 +143,7 @@ type OrderModifier struct {
 }
 
 type Order struct {
+	Code              string                    `json:"code" firestore:"code"` // the short code of the order, e.g. "W-1042" (see fundraiser.NumberRange)
 	CreatedTime       time.Time                 `json:"createdTime" firestore:"createdTime"`
 	DisplayName       string                    `json:"displayName" firestore:"displayName"`
 	EmailAddress      string                    `json:"emailAddress" firestore:"emailAddress"`
@@ -123,8 +157,10 @@ type Order struct {
 	Items             []OrderItem               `json:"items" firestore:"items"`
 	KnightOfColumbus  bool                      `json:"isKnight" firestore:"isKnight"`
 	LabelIDs          []string                  `json:"labelIDs" firestore:"labelIDs"`
-	Number            uint16                    `json:"number" firestore:"number"` // This should be autogenerated by Firestore upon insert
+	LabelRequestTime  time.Time                 `json:"labelRequestTime" firestore:"labelRequestTime"` // when a label was last requested to be printed (or reprinted)
+	Number            uint16                    `json:"number" firestore:"number"`                     // allocated by the order-controller from the OrderNumbering of the fundraiser
 	Note              string                    `json:"note" firestore:"note"`
+	OutsideWindow     bool                      `json:"outsideWindow" firestore:"outsideWindow"` // created outside the window of its fundraiser
 	PhoneNumber       string                    `json:"phoneNumber" firestore:"phoneNumber"`
 	ReceiptURL        string                    `json:"receiptURL" firestore:"receiptURL"`
 	Source            paymentType.PaymentSource `json:"source" firestore:"source"`
@@ -132,17 +168,68 @@ type Order struct {
 	SquareOrderState  SquareOrderState          `json:"squareOrderState" firestore:"squareOrderState"`
 	SquarePaymentID   string                    `json:"squarePaymentID" firestore:"squarePaymentID"`
 	SquareUpdatedTime time.Time                 `json:"squareUpdatedTime" firestore:"squareUpdatedTime"`
+	StaffNotes        []StaffNote               `json:"staffNotes" firestore:"staffNotes"`
 	Status            OrderStatus               `json:"status" firestore:"status"`
 	StatusTransitions []OrderStatusTransition   `json:"statusTransitions" firestore:"statusTransitions"`
 	TipAmount         float64                   `json:"tipAmount" firestore:"tipAmount"`
 	TotalAmount       float64                   `json:"totalAmount" firestore:"totalAmount"`
+	TraceParent       string                    `json:"traceparent,omitempty" firestore:"traceparent,omitempty"`
 	Version           int32                     `json:"version" firestore:"version"`
 }
 
+// GetID returns the ID of the order, which is also the ID of its Firestore document
+func (o *Order) GetID() string {
+	return o.ID
+}
+
+// GetIdempotencyKeys returns the set of event idempotency keys already applied to the order
+func (o *Order) GetIdempotencyKeys() map[string]bool {
+	return o.IdempotencyKeys
+}
+
+// SetIdempotencyKeys replaces the set of event idempotency keys applied to the order
+func (o *Order) SetIdempotencyKeys(keys map[string]bool) {
+	o.IdempotencyKeys = keys
+}
+
+// SetExpiration sets the timestamp used by the Firestore TTL policy to delete the order
+func (o *Order) SetExpiration(expiration time.Time) {
+	o.Expiration = expiration
+}
+
+// SetTraceParent records the W3C traceparent of the operation writing the order, so the trace continues
+// through the Firestore change it causes
+func (o *Order) SetTraceParent(traceParent string) {
+	o.TraceParent = traceParent
+}
+
+// IsOutOfOrder reports whether o reflects an older revision from Square than persisted
+func (o *Order) IsOutOfOrder(persisted *Order) bool {
+	return persisted.SquareUpdatedTime.After(o.SquareUpdatedTime) || persisted.Version >= o.Version
+}
+
+// Transition moves the order to next at now, recording the transition
+func (o *Order) Transition(next OrderStatus, now time.Time) error {
+	if _, err := ParseOrderStatus(string(next)); err != nil {
+		return err
+	}
+	allowed := false
+	for _, status := range orderStatusTransitions[o.Status] {
+		allowed = allowed || status == next
+	}
+	if !allowed {
+		return fmt.Errorf("%w from %q to %q", ErrInvalidTransition, o.Status, next)
+	}
+	o.StatusTransitions = append(o.StatusTransitions, OrderStatusTransition{PreviousStatus: o.Status, Status: next, Timestamp: now})
+	o.Status = next
+	return nil
+}
+
 func CreateInternalOrderFromSquareOrder(squareOrder models.Order) (*Order, error) {
 	o := &Order{
 		Expedite: false, // make default explicit
 		ID:       squareOrder.Id,
+		Source:   paymentType.PAYMENT_SOURCE_IN_PERSON,
 		Status:   ORDER_STATUS_UNKNOWN,
 		Version:  squareOrder.Version,
 	}
@@ -154,11 +241,13 @@ func CreateInternalOrderFromSquareOrder(squareOrder models.Order) (*Order, error
 
 	if len(squareOrder.Fulfillments) == 1 {
 		if pickupDetails := squareOrder.Fulfillments[0].PickupDetails; pickupDetails != nil {
+			// orders placed through Square Online are picked up, while those rung up at the point of sale are not
+			o.Source = paymentType.PAYMENT_SOURCE_ONLINE
 			o.Note = pickupDetails.Note
 
 			if recipient := pickupDetails.Recipient; recipient != nil {
 				// if customerID wasn't explicitly set before, let's try to get it from the fulfillment details
-				if o.SquareCustomerID != "" {
+				if o.SquareCustomerID == "" {
 					o.SquareCustomerID = recipient.CustomerId
 				}
 				if recipient.DisplayName != "" {
@@ -236,7 +325,7 @@ func CreateInternalOrderFromSquareOrder(squareOrder models.Order) (*Order, error
 func CreateOrderFromPayment(payment paymentType.Payment) (*Order, error) {
 	switch payment.Status {
 	case paymentType.PAYMENT_STATUS_CANCELED, paymentType.PAYMENT_STATUS_FAILED:
-		return nil, errors.New("invalid payment state")
+		return nil, failure.Permanent(errors.New("invalid payment state"))
 	}
 
 	o := &Order{
@@ -0,0 +1,117 @@
+package order
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/event/eventtest"
+	"github.com/kofc7186/fundraiser-manager/pkg/square/types/models"
+	"github.com/kofc7186/fundraiser-manager/pkg/square/webhooks/webhookstest"
+	paymentType "github.com/kofc7186/fundraiser-manager/pkg/types/payment"
+)
+
+func TestCreateInternalOrderFromSquareOrder(t *testing.T) {
+	for _, name := range []string{"retrieve_order.online", "retrieve_order.pos"} {
+		t.Run(name, func(t *testing.T) {
+			var response models.RetrieveOrderResponse
+			webhookstest.UnmarshalAPIResponse(t, name, &response)
+
+			o, err := CreateInternalOrderFromSquareOrder(*response.Order)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			eventtest.AssertGoldenJSON(t, filepath.Join("testdata", name+".golden.json"), o)
+		})
+	}
+}
+
+func TestCreateInternalOrderFromSquareOrderRecipientCustomerID(t *testing.T) {
+	var response models.RetrieveOrderResponse
+	webhookstest.UnmarshalAPIResponse(t, "retrieve_order.online", &response)
+
+	// if the order itself doesn't carry the customer, fall back to the pickup recipient before the tender
+	response.Order.CustomerId = ""
+	response.Order.Fulfillments[0].PickupDetails.Recipient.CustomerId = "RECIPIENTCUSTOMERID"
+	response.Order.Tenders[0].CustomerId = "TENDERCUSTOMERID"
+
+	o, err := CreateInternalOrderFromSquareOrder(*response.Order)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if o.SquareCustomerID != "RECIPIENTCUSTOMERID" {
+		t.Errorf("got SquareCustomerID %q, want %q", o.SquareCustomerID, "RECIPIENTCUSTOMERID")
+	}
+}
+
+func TestCreateInternalOrderFromSquareOrderErrors(t *testing.T) {
+	tests := map[string]func(o *models.Order){
+		"unknown item type":  func(o *models.Order) { o.LineItems[0].ItemType = "SUBSCRIPTION" },
+		"unknown state":      func(o *models.Order) { o.State = "PENDING" },
+		"invalid created_at": func(o *models.Order) { o.CreatedAt = "Friday" },
+		"invalid updated_at": func(o *models.Order) { o.UpdatedAt = "" },
+	}
+
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			var response models.RetrieveOrderResponse
+			webhookstest.UnmarshalAPIResponse(t, "retrieve_order.pos", &response)
+			mutate(response.Order)
+
+			if _, err := CreateInternalOrderFromSquareOrder(*response.Order); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestCreateOrderFromPayment(t *testing.T) {
+	var response models.GetPaymentResponse
+	webhookstest.UnmarshalAPIResponse(t, "get_payment", &response)
+
+	p, err := paymentType.CreateInternalPaymentFromSquarePayment(*response.Payment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o, err := CreateOrderFromPayment(*p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eventtest.AssertGoldenJSON(t, filepath.Join("testdata", "order_from_payment.golden.json"), o)
+
+	for _, status := range []paymentType.PaymentStatus{paymentType.PAYMENT_STATUS_CANCELED, paymentType.PAYMENT_STATUS_FAILED} {
+		p.Status = status
+		if _, err := CreateOrderFromPayment(*p); err == nil {
+			t.Errorf("expected error for payment in %q status", status)
+		}
+	}
+}
+
+func TestTransition(t *testing.T) {
+	now := time.Date(2024, 3, 8, 21, 0, 0, 0, time.UTC)
+	o := &Order{}
+	for _, next := range []OrderStatus{ORDER_STATUS_ONLINE, ORDER_STATUS_PRESENT, ORDER_STATUS_LABELED, ORDER_STATUS_READY, ORDER_STATUS_CLOSED} {
+		now = now.Add(time.Minute)
+		if err := o.Transition(next, now); err != nil {
+			t.Fatalf("transition to %s: %v", next, err)
+		}
+	}
+	if len(o.StatusTransitions) != 5 || o.StatusTransitions[4].PreviousStatus != ORDER_STATUS_READY || !o.StatusTransitions[4].Timestamp.Equal(now) {
+		t.Errorf("transitions not recorded: %+v", o.StatusTransitions)
+	}
+
+	// a closed order can't be picked up again, nor canceled
+	for _, next := range []OrderStatus{ORDER_STATUS_READY, ORDER_STATUS_CLOSED, ORDER_STATUS_CANCELED} {
+		if err := o.Transition(next, now); !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("transition from CLOSED to %s: got %v, want ErrInvalidTransition", next, err)
+		}
+	}
+	if err := (&Order{Status: ORDER_STATUS_PRESENT}).Transition(ORDER_STATUS_READY, now); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("transition from PRESENT to READY: got %v, want ErrInvalidTransition", err)
+	}
+	if err := (&Order{}).Transition("SERVED", now); err == nil || errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("transition to unknown status: got %v", err)
+	}
+}
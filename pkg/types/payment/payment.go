@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
 	"github.com/kofc7186/fundraiser-manager/pkg/square/types/models"
 )
 
@@ -36,7 +37,7 @@ func parsePaymentStatus(status string) (PaymentStatus, error) {
 	case PAYMENT_STATUS_FAILED:
 		return PAYMENT_STATUS_FAILED, nil
 	}
-	return PAYMENT_STATUS_UNKNOWN, fmt.Errorf("%q is not a valid PaymentStatus", status)
+	return PAYMENT_STATUS_UNKNOWN, failure.Permanent(fmt.Errorf("%q is not a valid PaymentStatus", status))
 }
 
 func parsePaymentSource(source string) (PaymentSource, error) {
@@ -46,7 +47,7 @@ func parsePaymentSource(source string) (PaymentSource, error) {
 	case "SQUARE_POS":
 		return PAYMENT_SOURCE_IN_PERSON, nil
 	}
-	return PAYMENT_SOURCE_UNKNOWN, fmt.Errorf("%q is not a valid PaymentSource", source)
+	return PAYMENT_SOURCE_UNKNOWN, failure.Permanent(fmt.Errorf("%q is not a valid PaymentSource", source))
 }
 
 type Payment struct {
@@ -68,6 +69,38 @@ type Payment struct {
 	Status            PaymentStatus   `json:"status" firestore:"status"`
 	TipAmount         float64         `json:"tipAmount" firestore:"tipAmount"`
 	TotalAmount       float64         `json:"totalAmount" firestore:"totalAmount"`
+	TraceParent       string          `json:"traceparent,omitempty" firestore:"traceparent,omitempty"`
+}
+
+// GetID returns the ID of the payment, which is also the ID of its Firestore document
+func (p *Payment) GetID() string {
+	return p.ID
+}
+
+// GetIdempotencyKeys returns the set of event idempotency keys already applied to the payment
+func (p *Payment) GetIdempotencyKeys() map[string]bool {
+	return p.IdempotencyKeys
+}
+
+// SetIdempotencyKeys replaces the set of event idempotency keys applied to the payment
+func (p *Payment) SetIdempotencyKeys(keys map[string]bool) {
+	p.IdempotencyKeys = keys
+}
+
+// SetExpiration sets the timestamp used by the Firestore TTL policy to delete the payment
+func (p *Payment) SetExpiration(expiration time.Time) {
+	p.Expiration = expiration
+}
+
+// SetTraceParent records the W3C traceparent of the operation writing the payment, so the trace continues
+// through the Firestore change it causes
+func (p *Payment) SetTraceParent(traceParent string) {
+	p.TraceParent = traceParent
+}
+
+// IsOutOfOrder reports whether p reflects an older revision from Square than persisted
+func (p *Payment) IsOutOfOrder(persisted *Payment) bool {
+	return persisted.SquareUpdatedTime.After(p.SquareUpdatedTime)
 }
 
 func CreateInternalPaymentFromSquarePayment(squarePayment models.Payment) (*Payment, error) {
@@ -0,0 +1,66 @@
+package payment
+
+import (
+	"testing"
+
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
+	"github.com/kofc7186/fundraiser-manager/pkg/square/types/models"
+	"github.com/kofc7186/fundraiser-manager/pkg/square/webhooks/webhookstest"
+)
+
+func TestCreateInternalPaymentFromSquarePayment(t *testing.T) {
+	var response models.GetPaymentResponse
+	webhookstest.UnmarshalAPIResponse(t, "get_payment", &response)
+
+	p, err := CreateInternalPaymentFromSquarePayment(*response.Payment)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if p.TotalAmount != 28 || p.TipAmount != 4 || p.RefundAmount != 8 || p.FeeAmount != 1.11 {
+		t.Errorf("amounts not converted from cents: total=%v tip=%v refund=%v fee=%v", p.TotalAmount, p.TipAmount, p.RefundAmount, p.FeeAmount)
+	}
+	if p.FirstName != "Jane" || p.LastName != "Doe" {
+		t.Errorf("name not taken from shipping address: %q %q", p.FirstName, p.LastName)
+	}
+	if p.Status != PAYMENT_STATUS_COMPLETED {
+		t.Errorf("got status %q", p.Status)
+	}
+}
+
+func TestCreateInternalPaymentFromSquarePaymentSource(t *testing.T) {
+	tests := []struct {
+		squareProduct string
+		expected      PaymentSource
+		expectError   bool
+	}{
+		{squareProduct: "ONLINE_STORE", expected: PAYMENT_SOURCE_ONLINE},
+		{squareProduct: "ECOMMERCE_API", expected: PAYMENT_SOURCE_ONLINE},
+		{squareProduct: "SQUARE_POS", expected: PAYMENT_SOURCE_IN_PERSON},
+		{squareProduct: "VIRTUAL_TERMINAL", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.squareProduct, func(t *testing.T) {
+			var response models.GetPaymentResponse
+			webhookstest.UnmarshalAPIResponse(t, "get_payment", &response)
+			response.Payment.ApplicationDetails.SquareProduct = tt.squareProduct
+
+			p, err := CreateInternalPaymentFromSquarePayment(*response.Payment)
+			if tt.expectError {
+				if err == nil {
+					t.Error("expected error")
+				} else if failure.IsRetryable(err) {
+					t.Errorf("unknown source should be a permanent failure, got %s", failure.ClassOf(err))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if p.Source != tt.expected {
+				t.Errorf("got source %q, want %q", p.Source, tt.expected)
+			}
+		})
+	}
+}
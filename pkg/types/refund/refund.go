@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/kofc7186/fundraiser-manager/pkg/failure"
 	"github.com/kofc7186/fundraiser-manager/pkg/square/types/models"
 )
 
@@ -28,7 +29,7 @@ func parseRefundStatus(status string) (RefundStatus, error) {
 	case REFUND_STATUS_FAILED:
 		return REFUND_STATUS_FAILED, nil
 	}
-	return REFUND_STATUS_UNKNOWN, fmt.Errorf("%q is not a valid RefundStatus", status)
+	return REFUND_STATUS_UNKNOWN, failure.Permanent(fmt.Errorf("%q is not a valid RefundStatus", status))
 }
 
 type Refund struct {
@@ -42,9 +43,41 @@ type Refund struct {
 	SquareOrderID     string          `json:"squareOrderID" firestore:"squareOrderID"`
 	SquareUpdatedTime time.Time       `json:"squareUpdatedTime" firestore:"squareUpdatedTime"`
 	Status            RefundStatus    `json:"status" firestore:"status"`
+	TraceParent       string          `json:"traceparent,omitempty" firestore:"traceparent,omitempty"`
 	Unlinked          bool            `json:"unlinked" firestore:"unlinked"`
 }
 
+// GetID returns the ID of the refund, which is also the ID of its Firestore document
+func (r *Refund) GetID() string {
+	return r.ID
+}
+
+// GetIdempotencyKeys returns the set of event idempotency keys already applied to the refund
+func (r *Refund) GetIdempotencyKeys() map[string]bool {
+	return r.IdempotencyKeys
+}
+
+// SetIdempotencyKeys replaces the set of event idempotency keys applied to the refund
+func (r *Refund) SetIdempotencyKeys(keys map[string]bool) {
+	r.IdempotencyKeys = keys
+}
+
+// SetExpiration sets the timestamp used by the Firestore TTL policy to delete the refund
+func (r *Refund) SetExpiration(expiration time.Time) {
+	r.Expiration = expiration
+}
+
+// SetTraceParent records the W3C traceparent of the operation writing the refund, so the trace continues
+// through the Firestore change it causes
+func (r *Refund) SetTraceParent(traceParent string) {
+	r.TraceParent = traceParent
+}
+
+// IsOutOfOrder reports whether r reflects an older revision from Square than persisted
+func (r *Refund) IsOutOfOrder(persisted *Refund) bool {
+	return persisted.SquareUpdatedTime.After(r.SquareUpdatedTime)
+}
+
 func CreateInternalRefundFromSquareRefund(squareRefund models.PaymentRefund) (*Refund, error) {
 	r := &Refund{
 		ID:              squareRefund.Id,
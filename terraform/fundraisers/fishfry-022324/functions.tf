@@ -33,8 +33,11 @@ module "square-webhook-ingress" {
 
   fundraiser_id      = var.fundraiser_id
   expiration_time    = var.expiration_time
+  fundraisers        = var.fundraisers
   min_instance_count = var.min_instance_count
 
+  claim_check_bucket = google_storage_bucket.claim_check_bucket.name
+
   square_order_request_topic    = google_pubsub_topic.topic["${var.fundraiser_id}-square-order-request"].name
   square_customer_webhook_topic = google_pubsub_topic.topic["${var.fundraiser_id}-square-customer-webhook"].name
   square_payment_webhook_topic  = google_pubsub_topic.topic["${var.fundraiser_id}-square-payment-webhook"].name
@@ -51,8 +54,11 @@ module "egress-square-gateway" {
 
   fundraiser_id      = var.fundraiser_id
   expiration_time    = var.expiration_time
+  fundraisers        = var.fundraisers
   min_instance_count = var.min_instance_count
 
+  claim_check_bucket = google_storage_bucket.claim_check_bucket.name
+
   square_environment = "production"
 
   square_payment_events_request_topic   = google_pubsub_topic.topic["${var.fundraiser_id}-square-payment-request"].name
@@ -73,6 +79,7 @@ module "event-lake-controller" {
 
   fundraiser_id      = var.fundraiser_id
   expiration_time    = var.expiration_time
+  fundraisers        = var.fundraisers
   min_instance_count = var.min_instance_count
 
   topics_to_monitor = local.topic_list
@@ -88,6 +95,7 @@ module "payment-controller" {
 
   fundraiser_id      = var.fundraiser_id
   expiration_time    = var.expiration_time
+  fundraisers        = var.fundraisers
   min_instance_count = var.min_instance_count
 
   square_payment_webhook_topic  = google_pubsub_topic.topic["${var.fundraiser_id}-square-payment-webhook"].name
@@ -111,6 +119,7 @@ module "refund-controller" {
 
   fundraiser_id      = var.fundraiser_id
   expiration_time    = var.expiration_time
+  fundraisers        = var.fundraisers
   min_instance_count = var.min_instance_count
 
   square_refund_webhook_topic = google_pubsub_topic.topic["${var.fundraiser_id}-square-refund-webhook"].name
@@ -127,6 +136,7 @@ module "customer-controller" {
 
   fundraiser_id      = var.fundraiser_id
   expiration_time    = var.expiration_time
+  fundraisers        = var.fundraisers
   min_instance_count = var.min_instance_count
 
   square_customer_webhook_topic  = google_pubsub_topic.topic["${var.fundraiser_id}-square-customer-webhook"].name
@@ -147,6 +157,7 @@ module "order-controller" {
 
   fundraiser_id      = var.fundraiser_id
   expiration_time    = var.expiration_time
+  fundraisers        = var.fundraisers
   min_instance_count = var.min_instance_count
 
   customer_events_topic       = google_pubsub_topic.topic["${var.fundraiser_id}-customer-events"].name
@@ -155,3 +166,68 @@ module "order-controller" {
   square_order_request_topic  = google_pubsub_topic.topic["${var.fundraiser_id}-square-order-request"].name
   square_order_response_topic = google_pubsub_topic.topic["${var.fundraiser_id}-square-order-response"].name
 }
+
+module "notification-controller" {
+  source = "../../../controllers/notification-controller"
+
+  gcp_project_id = var.gcp_project_id
+  gcp_region     = var.gcp_region
+
+  gcs_function_source_bucket = google_storage_bucket.function-source-bucket.name
+
+  fundraiser_id      = var.fundraiser_id
+  expiration_time    = var.expiration_time
+  fundraisers        = var.fundraisers
+  min_instance_count = var.min_instance_count
+
+  order_events_topic = google_pubsub_topic.topic["${var.fundraiser_id}-order-events"].name
+
+  pickup_instructions = var.pickup_instructions
+  smtp_addr           = var.smtp_addr
+  smtp_from           = var.smtp_from
+  smtp_username       = var.smtp_username
+  sms_url             = var.sms_url
+  sms_from            = var.sms_from
+}
+
+module "dead-letter-controller" {
+  source = "../../../controllers/dead-letter-controller"
+
+  gcp_project_id = var.gcp_project_id
+  gcp_region     = var.gcp_region
+
+  gcs_function_source_bucket = google_storage_bucket.function-source-bucket.name
+
+  fundraiser_id      = var.fundraiser_id
+  expiration_time    = var.expiration_time
+  fundraisers        = var.fundraisers
+  min_instance_count = var.min_instance_count
+}
+
+module "fundraiser-controller" {
+  source = "../../../controllers/fundraiser-controller"
+
+  gcp_project_id = var.gcp_project_id
+  gcp_region     = var.gcp_region
+
+  gcs_function_source_bucket = google_storage_bucket.function-source-bucket.name
+
+  fundraiser_id      = var.fundraiser_id
+  expiration_time    = var.expiration_time
+  min_instance_count = var.min_instance_count
+}
+
+module "archive-controller" {
+  source = "../../../controllers/archive-controller"
+
+  gcp_project_id = var.gcp_project_id
+  gcp_region     = var.gcp_region
+
+  gcs_function_source_bucket = google_storage_bucket.function-source-bucket.name
+
+  fundraiser_id   = var.fundraiser_id
+  expiration_time = var.expiration_time
+  fundraisers     = var.fundraisers
+
+  archive_bucket = google_storage_bucket.archive_bucket.name
+}
@@ -24,3 +24,36 @@ resource "google_storage_bucket_access_control" "public_rule" {
   role   = "READER"
   entity = "allUsers"
 }
+
+# archives of the fundraiser's Firestore documents, written by archive-controller before they expire; unlike the
+# other buckets this is not force_destroy, so that 'terraform destroy' keeps the fundraiser's history
+resource "google_storage_bucket" "archive_bucket" {
+  name     = format("%s-archive", var.fundraiser_id)
+  location = "US"
+
+  uniform_bucket_level_access = true
+
+  versioning {
+    enabled = true
+  }
+}
+
+# oversized event payloads, checked in by the gateways when they publish and checked out by the functions consuming
+# the events (see pkg/claimcheck); like the archive bucket this is not force_destroy, as the event lake refers to it
+resource "google_storage_bucket" "claim_check_bucket" {
+  name     = format("%s-claim-checks", var.fundraiser_id)
+  location = "US"
+
+  uniform_bucket_level_access = true
+}
+
+# every function runs as the default compute service account
+data "google_compute_default_service_account" "default" {
+  project = var.gcp_project_id
+}
+
+resource "google_storage_bucket_iam_member" "claim_check_user" {
+  bucket = google_storage_bucket.claim_check_bucket.name
+  role   = "roles/storage.objectAdmin"
+  member = "serviceAccount:${data.google_compute_default_service_account.default.email}"
+}
@@ -14,6 +14,12 @@ variable "gcp_region" {
 variable "fundraiser_id" {
   description = "The unique ID for the specific fundraiser; example is 'fishfry-022324'"
   type        = string
+
+  # the ID names buckets, topics, functions and Firestore paths, so must be valid for all of them
+  validation {
+    condition     = can(regex("^[a-z][a-z0-9-]{4,28}[a-z0-9]$", var.fundraiser_id))
+    error_message = "The fundraiser_id must be 6 to 30 lowercase letters, digits or hyphens, beginning with a letter and not ending with a hyphen."
+  }
 }
 
 variable "expiration_time" {
@@ -21,6 +27,12 @@ variable "expiration_time" {
   type        = string
 }
 
+variable "fundraisers" {
+  description = "How Square objects are routed to the fundraisers served by this deployment, as YAML (see pkg/fundraiser); if empty, every object belongs to fundraiser_id"
+  type        = string
+  default     = ""
+}
+
 variable "firestore_expiration_field_name" {
   description = "The field in all documents within fundraisers/{fid} which contains a timestamp, after which the document will be deleted"
   type        = string
@@ -52,3 +64,39 @@ variable "pull_payments_end_time" {
   description = "The end time to search before for payments in Square, as expressed in a UTC timestamp string in RFC 3339 format; example is '2024-02-25T00:00:00Z'"
   type        = string
 }
+
+variable "pickup_instructions" {
+  description = "What customers are told about picking up their orders when they are ready"
+  type        = string
+  default     = ""
+}
+
+variable "smtp_addr" {
+  description = "The host:port of the SMTP relay customers are emailed through; if empty, no email is sent"
+  type        = string
+  default     = ""
+}
+
+variable "smtp_from" {
+  description = "The sender of the emails to customers, e.g. 'Fish Fry <fishfry@example.com>'"
+  type        = string
+  default     = ""
+}
+
+variable "smtp_username" {
+  description = "The username to authenticate with the SMTP relay as; if empty, no authentication is used"
+  type        = string
+  default     = ""
+}
+
+variable "sms_url" {
+  description = "The URL of the SMS gateway customers are texted through; if empty, no text is sent"
+  type        = string
+  default     = ""
+}
+
+variable "sms_from" {
+  description = "The number (or sender ID) texts to customers are sent from"
+  type        = string
+  default     = ""
+}